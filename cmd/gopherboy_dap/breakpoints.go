@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// breakpointRequest is a breakpoint that the client asked for, along with
+// the result of adding it to the device.
+type breakpointRequest struct {
+	bp           gameboy.Breakpoint
+	condition    string
+	hitCondition string
+	// result is reported to the client. It's filled in with the
+	// breakpoint's ID once it's added.
+	result breakpoint
+	// err is set if the breakpoint couldn't be created.
+	err error
+}
+
+// replaceBreakpoints replaces a group of the client's breakpoints. Each
+// breakpoint request in the DAP replaces all breakpoints of a kind, or all
+// breakpoints in a source file.
+func (s *session) replaceBreakpoints(group, reason string, requests []breakpointRequest) (interface{}, error) {
+	if s.device == nil {
+		return nil, errNotLaunched
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, id := range s.breakpoints[group] {
+		s.device.RemoveBreakpoint(id)
+		delete(s.stopReasons, id)
+	}
+	delete(s.breakpoints, group)
+
+	body := breakpointsResponseBody{Breakpoints: []breakpoint{}}
+	for _, req := range requests {
+		result := req.result
+		if err := s.addBreakpoint(group, reason, req); err != nil {
+			result.Verified = false
+			result.Message = err.Error()
+		} else {
+			result.ID = s.breakpoints[group][len(s.breakpoints[group])-1]
+			result.Verified = true
+		}
+		body.Breakpoints = append(body.Breakpoints, result)
+	}
+
+	return body, nil
+}
+
+// addBreakpoint adds one of the client's breakpoints to the device. The
+// caller must hold s.mutex.
+func (s *session) addBreakpoint(group, reason string, req breakpointRequest) error {
+	if req.err != nil {
+		return req.err
+	}
+
+	bp := req.bp
+	if req.condition != "" {
+		bp.Condition = s.expandSymbols(req.condition)
+	}
+	if req.hitCondition != "" {
+		hits, err := parseHitCondition(req.hitCondition)
+		if err != nil {
+			return err
+		}
+		bp.IgnoreCount = hits - 1
+	}
+
+	id, err := s.device.AddBreakpoint(bp)
+	if err != nil {
+		return err
+	}
+	s.breakpoints[group] = append(s.breakpoints[group], id)
+	s.stopReasons[id] = reason
+	return nil
+}
+
+// parseHitCondition parses a hit condition, which is the number of hits
+// that the breakpoint stops on, optionally written as ">= N" to make it clear
+// that it keeps stopping after that.
+func parseHitCondition(condition string) (int, error) {
+	text := strings.TrimSpace(condition)
+	text = strings.TrimSpace(strings.TrimPrefix(text, ">="))
+
+	hits, err := strconv.Atoi(text)
+	if err != nil || hits < 1 {
+		return 0, xerrors.Errorf("invalid hit condition %q, expected a number of hits like \"3\" or \">= 3\"", condition)
+	}
+	return hits, nil
+}
+
+func (s *session) setBreakpoints(req request) (interface{}, error) {
+	var args setBreakpointsArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	if s.device == nil {
+		return nil, errNotLaunched
+	}
+
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, xerrors.Errorf("finding source file: %w", err)
+	}
+
+	var requests []breakpointRequest
+	for _, sourceBP := range args.Breakpoints {
+		req := breakpointRequest{
+			condition:    sourceBP.Condition,
+			hitCondition: sourceBP.HitCondition,
+			result: breakpoint{
+				Source: &args.Source,
+				Line:   sourceBP.Line,
+			},
+		}
+
+		line, ok := s.lines.resolve(path, sourceBP.Line-s.lineBase+1)
+		if !ok {
+			req.err = xerrors.Errorf("no code was found for line %v", sourceBP.Line)
+		} else {
+			req.bp = gameboy.Breakpoint{
+				Kind:  gameboy.BreakOnExecute,
+				Start: line.location.addr,
+				End:   line.location.addr,
+				Bank:  line.location.bank,
+			}
+			req.result.Line = line.line + s.lineBase - 1
+			req.result.InstructionReference = formatMemoryReference(line.location.addr)
+		}
+		requests = append(requests, req)
+	}
+
+	return s.replaceBreakpoints("source "+path, "breakpoint", requests)
+}
+
+// setFunctionBreakpoints sets breakpoints on labels. Addresses in the
+// debugger console's syntax are accepted too.
+func (s *session) setFunctionBreakpoints(req request) (interface{}, error) {
+	var args setFunctionBreakpointsArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	if s.device == nil {
+		return nil, errNotLaunched
+	}
+
+	var requests []breakpointRequest
+	for _, functionBP := range args.Breakpoints {
+		req := breakpointRequest{
+			condition:    functionBP.Condition,
+			hitCondition: functionBP.HitCondition,
+		}
+
+		name := strings.TrimSpace(functionBP.Name)
+		if sym, ok := s.symbols.lookup(name); ok {
+			req.bp = gameboy.Breakpoint{
+				Kind:  gameboy.BreakOnExecute,
+				Start: sym.addr,
+				End:   sym.addr,
+				Bank:  sym.bank,
+			}
+		} else {
+			req.bp, req.err = gameboy.ParseBreakpoint(gameboy.BreakOnExecute, name)
+			if req.err != nil {
+				req.err = xerrors.Errorf("%q is not a known label or an address", name)
+			}
+		}
+		if req.err == nil {
+			req.result.InstructionReference = formatMemoryReference(req.bp.Start)
+		}
+		requests = append(requests, req)
+	}
+
+	return s.replaceBreakpoints("function", "function breakpoint", requests)
+}
+
+// setInstructionBreakpoints sets breakpoints from the disassembly view.
+// These stop at the address no matter which ROM bank is mapped.
+func (s *session) setInstructionBreakpoints(req request) (interface{}, error) {
+	var args setInstructionBreakpointsArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	if s.device == nil {
+		return nil, errNotLaunched
+	}
+
+	var requests []breakpointRequest
+	for _, instructionBP := range args.Breakpoints {
+		req := breakpointRequest{
+			condition:    instructionBP.Condition,
+			hitCondition: instructionBP.HitCondition,
+		}
+
+		addr, err := parseMemoryReference(instructionBP.InstructionReference)
+		addr += instructionBP.Offset
+		if err != nil {
+			req.err = err
+		} else if addr < 0 || addr > 0xFFFF {
+			req.err = xerrors.Errorf("address %v is out of range", addr)
+		} else {
+			req.bp = gameboy.Breakpoint{
+				Kind:  gameboy.BreakOnExecute,
+				Start: uint16(addr),
+				End:   uint16(addr),
+				Bank:  gameboy.AnyBank,
+			}
+			req.result.InstructionReference = formatMemoryReference(uint16(addr))
+		}
+		requests = append(requests, req)
+	}
+
+	return s.replaceBreakpoints("instruction", "instruction breakpoint", requests)
+}
+
+// setDataBreakpoints sets watchpoints. The data IDs are address ranges that
+// come from dataBreakpointInfo responses.
+func (s *session) setDataBreakpoints(req request) (interface{}, error) {
+	var args setDataBreakpointsArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	if s.device == nil {
+		return nil, errNotLaunched
+	}
+
+	var requests []breakpointRequest
+	for _, dataBP := range args.Breakpoints {
+		req := breakpointRequest{
+			condition:    dataBP.Condition,
+			hitCondition: dataBP.HitCondition,
+		}
+
+		kind := gameboy.BreakOnWrite
+		switch dataBP.AccessType {
+		case "read":
+			kind = gameboy.BreakOnRead
+		case "readWrite":
+			kind = gameboy.BreakOnAccess
+		}
+		req.bp, req.err = gameboy.ParseBreakpoint(kind, dataBP.DataID)
+		requests = append(requests, req)
+	}
+
+	return s.replaceBreakpoints("data", "data breakpoint", requests)
+}
+
+// setExceptionBreakpoints sets breakpoints on interrupts, which are shown as
+// exceptions by the client.
+func (s *session) setExceptionBreakpoints(req request) (interface{}, error) {
+	var args setExceptionBreakpointsArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	if s.device == nil {
+		return nil, errNotLaunched
+	}
+
+	var requests []breakpointRequest
+	for _, filter := range args.Filters {
+		var req breakpointRequest
+		req.bp, req.err = gameboy.ParseBreakpoint(gameboy.BreakOnInterrupt, filter)
+		requests = append(requests, req)
+	}
+
+	return s.replaceBreakpoints("exception", "exception", requests)
+}
+
+// expandSymbols replaces labels in an expression or debugger command with
+// their addresses, since the debugger doesn't know about labels. The ROM
+// bank of the label is lost, so labels in banked ROM refer to whichever bank
+// is mapped.
+func (s *session) expandSymbols(text string) string {
+	var result strings.Builder
+
+	for i := 0; i < len(text); {
+		if !isIdentifierStart(text[i]) || (i > 0 && isIdentifierPrefix(text[i-1])) {
+			result.WriteByte(text[i])
+			i++
+			continue
+		}
+
+		end := i + 1
+		for end < len(text) && isIdentifierChar(text[end]) {
+			end++
+		}
+		name := text[i:end]
+		if sym, ok := s.symbols.lookup(name); ok {
+			result.WriteString(fmt.Sprintf("$%04X", sym.addr))
+		} else {
+			result.WriteString(name)
+		}
+		i = end
+	}
+
+	return result.String()
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9') || c == '@' || c == '#'
+}
+
+// isIdentifierPrefix returns true if an identifier can't start after the
+// given character, because it's part of a number or another word.
+func isIdentifierPrefix(c byte) bool {
+	return isIdentifierChar(c) || c == '$' || c == '%'
+}
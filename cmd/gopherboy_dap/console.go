@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const consoleHelp = `Debug console commands:
+  print, p EXPR             Print the value of an expression
+  press BUTTON              Hold down a button
+  release BUTTON            Release a button
+  screenshot FILE           Save the screen to a PNG file
+
+Buttons are start, select, b, a, down, up, left and right. Labels may be used
+in place of addresses, but ROM labels refer to whichever bank is mapped.
+
+Other commands are run by the debugger:
+`
+
+// evaluate evaluates an expression from a watch, a hover or the debug
+// console. Debug console commands that aren't handled here are passed on to
+// the debugger, which may resume the device.
+func (s *session) evaluate(req request) string {
+	var args evaluateArguments
+	if err := decodeArguments(req, &args); err != nil {
+		s.conn.respondError(req, err)
+		return ""
+	}
+
+	if args.Context != "repl" {
+		body, err := s.evaluateExpression(args.Expression)
+		if err != nil {
+			s.conn.respondError(req, err)
+		} else {
+			s.conn.respond(req, body)
+		}
+		return ""
+	}
+
+	fields := strings.Fields(args.Expression)
+	if len(fields) == 0 {
+		s.conn.respond(req, evaluateResponseBody{})
+		return ""
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args.Expression), fields[0]))
+
+	var result string
+	var err error
+	switch fields[0] {
+	case "print", "p":
+		var body evaluateResponseBody
+		body, err = s.evaluateExpression(rest)
+		result = body.Result
+	case "press", "release":
+		btn, ok := nameToButton[strings.ToLower(rest)]
+		if !ok {
+			err = xerrors.Errorf("unknown button %q", rest)
+			break
+		}
+		s.input.set(btn, fields[0] == "press")
+	case "screenshot":
+		if rest == "" {
+			err = xerrors.New("expected a file name")
+			break
+		}
+		if err = s.video.screenshot(rest); err == nil {
+			result = "Saved the screen to " + rest
+		}
+	default:
+		// The debugger's messages are collected until the command is done
+		s.evaluation = &req
+		s.output.Reset()
+		if fields[0] == "help" || fields[0] == "h" {
+			s.output.WriteString(consoleHelp)
+		}
+		return s.expandSymbols(args.Expression)
+	}
+
+	if err != nil {
+		s.conn.respondError(req, err)
+	} else {
+		s.conn.respond(req, evaluateResponseBody{Result: result})
+	}
+	return ""
+}
+
+// evaluateExpression evaluates an expression in the syntax of breakpoint
+// conditions.
+func (s *session) evaluateExpression(expr string) (evaluateResponseBody, error) {
+	expr = strings.TrimSpace(expr)
+
+	val, err := s.device.Evaluate(s.expandSymbols(expr))
+	if err != nil {
+		return evaluateResponseBody{}, err
+	}
+
+	body := evaluateResponseBody{Result: formatValue(val)}
+	if sym, ok := s.symbols.lookup(expr); ok {
+		body.MemoryReference = formatMemoryReference(sym.addr)
+	}
+	return body, nil
+}
+
+// finishEvaluation responds to a debug console command with the messages
+// that the debugger printed while running it.
+func (s *session) finishEvaluation() {
+	result := strings.TrimRight(s.output.String(), "\n")
+	s.output.Reset()
+
+	s.conn.respond(*s.evaluation, evaluateResponseBody{Result: result})
+	s.evaluation = nil
+}
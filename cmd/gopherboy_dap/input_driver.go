@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/velovix/gopherboy/gameboy"
+)
+
+var nameToButton = map[string]gameboy.Button{
+	"start":  gameboy.ButtonStart,
+	"select": gameboy.ButtonSelect,
+	"b":      gameboy.ButtonB,
+	"a":      gameboy.ButtonA,
+	"down":   gameboy.ButtonDown,
+	"up":     gameboy.ButtonUp,
+	"left":   gameboy.ButtonLeft,
+	"right":  gameboy.ButtonRight,
+}
+
+// inputDriver holds buttons that are pressed and released from the debug
+// console.
+type inputDriver struct {
+	buttonStates map[gameboy.Button]bool
+	// newPress is true if a button was pressed since the last call to Update.
+	newPress bool
+}
+
+func newInputDriver() *inputDriver {
+	return &inputDriver{
+		buttonStates: make(map[gameboy.Button]bool),
+	}
+}
+
+// set presses or releases a button.
+func (driver *inputDriver) set(btn gameboy.Button, pressed bool) {
+	if pressed && !driver.buttonStates[btn] {
+		driver.newPress = true
+	}
+	driver.buttonStates[btn] = pressed
+}
+
+func (driver *inputDriver) State(btn gameboy.Button) bool {
+	return driver.buttonStates[btn]
+}
+
+// Update returns true if a button was pressed since the last call.
+func (driver *inputDriver) Update() bool {
+	pressed := driver.newPress
+	driver.newPress = false
+
+	return pressed
+}
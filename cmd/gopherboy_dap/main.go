@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+)
+
+func main() {
+	listen := flag.String("listen", "",
+		"An address to wait for a client to connect to, like 'localhost:4711'. "+
+			"If not provided, the client talks to the adapter over stdin and "+
+			"stdout.")
+
+	flag.Parse()
+
+	if *listen == "" {
+		s := newSession(os.Stdin, os.Stdout)
+		if err := s.serve(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: Listening for clients:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "Waiting for a client to connect on", listener.Addr())
+
+	// Clients are served one at a time, since each one runs its own program
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Accepting client:", err)
+			os.Exit(1)
+		}
+
+		s := newSession(conn, conn)
+		if err := s.serve(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		conn.Close()
+	}
+}
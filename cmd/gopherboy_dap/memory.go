@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// formatMemoryReference formats an address as a memory reference.
+func formatMemoryReference(addr uint16) string {
+	return fmt.Sprintf("0x%04X", addr)
+}
+
+// parseMemoryReference parses a memory reference. These are hexadecimal if
+// prefixed with "0x" or "$", and decimal otherwise.
+func parseMemoryReference(ref string) (int, error) {
+	text := strings.TrimSpace(ref)
+	base := 10
+	switch {
+	case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+		text, base = text[2:], 16
+	case strings.HasPrefix(text, "$"):
+		text, base = text[1:], 16
+	}
+
+	addr, err := strconv.ParseUint(text, base, 16)
+	if err != nil {
+		return 0, xerrors.Errorf("invalid memory reference %q", ref)
+	}
+	return int(addr), nil
+}
+
+// readMemory reads memory as the CPU sees it. Bytes past the end of the
+// address space are unreadable.
+func (s *session) readMemory(req request) (interface{}, error) {
+	var args readMemoryArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	addr, err := parseMemoryReference(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+
+	start := addr + args.Offset
+	if start < 0 || start > 0xFFFF {
+		return readMemoryResponseBody{
+			Address:         strconv.Itoa(start),
+			UnreadableBytes: args.Count,
+		}, nil
+	}
+
+	data := make([]uint8, 0, minInt(args.Count, 0x10000-start))
+	for i := 0; i < args.Count && start+i <= 0xFFFF; i++ {
+		data = append(data, s.device.ReadMemory(uint16(start+i)))
+	}
+
+	return readMemoryResponseBody{
+		Address:         formatMemoryReference(uint16(start)),
+		UnreadableBytes: args.Count - len(data),
+		Data:            base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// writeMemory writes to memory as the CPU would.
+func (s *session) writeMemory(req request) (interface{}, error) {
+	var args writeMemoryArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	addr, err := parseMemoryReference(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding data: %w", err)
+	}
+
+	start := addr + args.Offset
+	if start < 0 || start+len(data) > 0x10000 {
+		return nil, xerrors.Errorf("can't write %v bytes to address %v", len(data), start)
+	}
+	for i, val := range data {
+		s.device.WriteMemory(uint16(start+i), val)
+	}
+
+	return writeMemoryResponseBody{BytesWritten: len(data)}, nil
+}
+
+// disassemble disassembles memory as the CPU sees it. Instructions outside of
+// the address space are reported as invalid so that the client always gets
+// the number of instructions that it asked for.
+func (s *session) disassemble(req request) (interface{}, error) {
+	var args disassembleArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	addr, err := parseMemoryReference(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	base := addr + args.Offset
+
+	var instructions []gameboy.Instruction
+	var padding int
+	if args.InstructionOffset < 0 {
+		before := -args.InstructionOffset
+		if base >= 0 && base <= 0x10000 {
+			instructions = s.disassembleBefore(base, before)
+		}
+		padding = before - len(instructions)
+	}
+
+	// Instructions from the base address on, skipping any before the
+	// instructions that were asked for
+	skip := 0
+	if args.InstructionOffset > 0 {
+		skip = args.InstructionOffset
+	}
+	next := base
+	for len(instructions)+padding < args.InstructionCount+skip {
+		if next < 0 || next > 0xFFFF {
+			break
+		}
+		inst := s.device.Disassemble(uint16(next))
+		instructions = append(instructions, inst)
+		next += len(inst.Bytes)
+	}
+	if skip > 0 {
+		instructions = instructions[minInt(skip, len(instructions)):]
+	}
+
+	labels := s.mappedLabels()
+	// Padding goes before the earliest instruction that was found
+	first := base
+	if len(instructions) > 0 {
+		first = int(instructions[0].Addr)
+	}
+	var result []disassembledInstruction
+	for i := 0; i < padding; i++ {
+		result = append(result, invalidInstruction(first-padding+i))
+	}
+	for _, inst := range instructions {
+		result = append(result, s.disassembledInstruction(inst, labels))
+	}
+	for len(result) < args.InstructionCount {
+		result = append(result, invalidInstruction(next))
+		next++
+	}
+	if len(result) > args.InstructionCount {
+		result = result[:args.InstructionCount]
+	}
+
+	return disassembleResponseBody{Instructions: result}, nil
+}
+
+// disassembleBefore returns up to the given number of instructions that end
+// right before an address. Instructions are found by decoding from earlier
+// and earlier addresses until the decoded instructions line up with the
+// address.
+func (s *session) disassembleBefore(addr int, count int) []gameboy.Instruction {
+	// Instructions are at most 3 bytes long
+	for start := addr - count*3; start < addr; start++ {
+		if start < 0 {
+			continue
+		}
+
+		var candidate []gameboy.Instruction
+		next := start
+		for next < addr {
+			inst := s.device.Disassemble(uint16(next))
+			candidate = append(candidate, inst)
+			next += len(inst.Bytes)
+		}
+		if next == addr {
+			if len(candidate) > count {
+				candidate = candidate[len(candidate)-count:]
+			}
+			return candidate
+		}
+	}
+	return nil
+}
+
+// disassembledInstruction describes an instruction to the client, along with
+// its label and line of source if there are any.
+func (s *session) disassembledInstruction(inst gameboy.Instruction, labels map[uint16]string) disassembledInstruction {
+	var bytes []string
+	for _, b := range inst.Bytes {
+		bytes = append(bytes, fmt.Sprintf("%02X", b))
+	}
+
+	result := disassembledInstruction{
+		Address:          formatMemoryReference(inst.Addr),
+		InstructionBytes: strings.Join(bytes, " "),
+		Instruction:      inst.Format(labels),
+	}
+
+	loc := s.locate(inst.Addr)
+	if label, ok := s.symbols.labelAt(loc.bank, loc.addr); ok {
+		result.Symbol = label
+	}
+	if line, ok := s.lines.lineAt(loc); ok {
+		result.Location = &source{Name: filepath.Base(line.path), Path: line.path}
+		result.Line = line.line + s.lineBase - 1
+	}
+	return result
+}
+
+// invalidInstruction is a placeholder for an instruction that couldn't be
+// disassembled, usually because it's outside of the address space.
+func invalidInstruction(addr int) disassembledInstruction {
+	address := strconv.Itoa(addr)
+	if addr >= 0 && addr <= 0xFFFF {
+		address = formatMemoryReference(uint16(addr))
+	}
+	return disassembledInstruction{
+		Address:          address,
+		Instruction:      "??",
+		PresentationHint: "invalid",
+	}
+}
+
+// mappedLabels returns the labels of the memory that's currently mapped, for
+// use as jump targets in disassembly.
+func (s *session) mappedLabels() map[uint16]string {
+	romxBank := s.locate(bankedROMStart).bank
+
+	labels := make(map[uint16]string)
+	for _, sym := range s.symbols.symbols {
+		if sym.bank == -1 || sym.addr < bankedROMStart || sym.bank == romxBank {
+			if _, ok := labels[sym.addr]; !ok {
+				labels[sym.addr], _ = s.symbols.labelAt(sym.bank, sym.addr)
+			}
+		}
+	}
+	return labels
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// This file implements the framing and message types of the Debug Adapter
+// Protocol. Only the parts of the protocol used by this adapter are
+// included. See https://microsoft.github.io/debug-adapter-protocol/ for the
+// specification.
+
+// protocolMessage contains the fields shared by all messages.
+type protocolMessage struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+}
+
+// request is a message from the client asking the adapter to do something.
+type request struct {
+	protocolMessage
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// response is the adapter's reply to a request.
+type response struct {
+	protocolMessage
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// event is a message from the adapter reporting that something happened.
+type event struct {
+	protocolMessage
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// connection reads requests from and writes responses and events to a
+// client. Writes are safe to make from any goroutine.
+type connection struct {
+	reader *bufio.Reader
+
+	// mutex guards the writer and the sequence number.
+	mutex  sync.Mutex
+	writer io.Writer
+	seq    int
+}
+
+func newConnection(r io.Reader, w io.Writer) *connection {
+	return &connection{
+		reader: bufio.NewReader(r),
+		writer: w,
+	}
+}
+
+// readRequest reads the next request from the client.
+func (conn *connection) readRequest() (request, error) {
+	var req request
+
+	headers, err := textproto.NewReader(conn.reader).ReadMIMEHeader()
+	if err != nil {
+		return req, xerrors.Errorf("reading message header: %w", err)
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return req, xerrors.Errorf("invalid content length %q", headers.Get("Content-Length"))
+	}
+
+	content := make([]uint8, length)
+	if _, err := io.ReadFull(conn.reader, content); err != nil {
+		return req, xerrors.Errorf("reading message content: %w", err)
+	}
+
+	if err := json.Unmarshal(content, &req); err != nil {
+		return req, xerrors.Errorf("decoding message: %w", err)
+	}
+	if req.Type != "request" {
+		return req, xerrors.Errorf("expected a request, got a message of type %q", req.Type)
+	}
+	return req, nil
+}
+
+// send writes a response or event, filling in its sequence number.
+func (conn *connection) send(msg interface{}) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.seq++
+	switch msg := msg.(type) {
+	case *response:
+		msg.Seq = conn.seq
+	case *event:
+		msg.Seq = conn.seq
+	default:
+		panic(fmt.Sprintf("unknown message type %T", msg))
+	}
+
+	content, err := json.Marshal(msg)
+	if err != nil {
+		panic(fmt.Sprintf("encoding message: %v", err))
+	}
+	// Errors are ignored, since the client is gone if writing fails and the
+	// next read will fail too
+	fmt.Fprintf(conn.writer, "Content-Length: %v\r\n\r\n%s", len(content), content)
+}
+
+// respond sends a successful response to a request.
+func (conn *connection) respond(req request, body interface{}) {
+	conn.send(&response{
+		protocolMessage: protocolMessage{Type: "response"},
+		RequestSeq:      req.Seq,
+		Success:         true,
+		Command:         req.Command,
+		Body:            body,
+	})
+}
+
+// respondError sends a response reporting that a request failed.
+func (conn *connection) respondError(req request, err error) {
+	conn.send(&response{
+		protocolMessage: protocolMessage{Type: "response"},
+		RequestSeq:      req.Seq,
+		Success:         false,
+		Command:         req.Command,
+		Message:         err.Error(),
+	})
+}
+
+// event sends an event.
+func (conn *connection) event(name string, body interface{}) {
+	conn.send(&event{
+		protocolMessage: protocolMessage{Type: "event"},
+		Event:           name,
+		Body:            body,
+	})
+}
+
+// output sends an output event, which the client shows in its debug console.
+func (conn *connection) output(category, text string) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	conn.event("output", outputEventBody{Category: category, Output: text})
+}
+
+// outputWriter sends each line written to it to the client as an output
+// event.
+type outputWriter struct {
+	conn     *connection
+	category string
+	// line holds text written since the last newline.
+	line []byte
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i == -1 {
+			break
+		}
+		w.conn.output(w.category, string(w.line[:i+1]))
+		w.line = w.line[i+1:]
+	}
+	return len(p), nil
+}
+
+type initializeArguments struct {
+	LinesStartAt1 *bool `json:"linesStartAt1"`
+}
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest  bool                         `json:"supportsConfigurationDoneRequest"`
+	SupportsFunctionBreakpoints       bool                         `json:"supportsFunctionBreakpoints"`
+	SupportsConditionalBreakpoints    bool                         `json:"supportsConditionalBreakpoints"`
+	SupportsHitConditionalBreakpoints bool                         `json:"supportsHitConditionalBreakpoints"`
+	SupportsEvaluateForHovers         bool                         `json:"supportsEvaluateForHovers"`
+	ExceptionBreakpointFilters        []exceptionBreakpointsFilter `json:"exceptionBreakpointFilters"`
+	SupportsSetVariable               bool                         `json:"supportsSetVariable"`
+	SupportsDataBreakpoints           bool                         `json:"supportsDataBreakpoints"`
+	SupportsDisassembleRequest        bool                         `json:"supportsDisassembleRequest"`
+	SupportsSteppingGranularity       bool                         `json:"supportsSteppingGranularity"`
+	SupportsInstructionBreakpoints    bool                         `json:"supportsInstructionBreakpoints"`
+	SupportsReadMemoryRequest         bool                         `json:"supportsReadMemoryRequest"`
+	SupportsWriteMemoryRequest        bool                         `json:"supportsWriteMemoryRequest"`
+	SupportsTerminateRequest          bool                         `json:"supportsTerminateRequest"`
+}
+
+type exceptionBreakpointsFilter struct {
+	Filter string `json:"filter"`
+	Label  string `json:"label"`
+}
+
+// launchArguments are the arguments of the launch request, which come from
+// the client's launch configuration.
+type launchArguments struct {
+	// Program is the path to the ROM to run.
+	Program string `json:"program"`
+	// BootROM is the path to a boot ROM. If not provided, the boot sequence
+	// is skipped.
+	BootROM string `json:"bootROM"`
+	// Symbols is the path to an RGBDS symbol file. If not provided, a file
+	// next to the ROM with the extension .sym is used if there is one.
+	Symbols string `json:"symbols"`
+	// Map is the path to an RGBDS map file. If not provided, a file next to
+	// the ROM with the extension .map is used if there is one.
+	Map string `json:"map"`
+	// SourceDirectories are the directories to search for assembly source
+	// files. If not provided, the working directory is searched.
+	SourceDirectories []string `json:"sourceDirectories"`
+	// StopOnEntry makes the device stop before running its first
+	// instruction.
+	StopOnEntry bool `json:"stopOnEntry"`
+	// Model is the hardware model to emulate, like "dmg" or "cgb". If not
+	// provided, it's picked based on the cartridge header.
+	Model string `json:"model"`
+	// SaveGameDirectory is the directory that game saves are kept in. If not
+	// provided, the ROM's directory is used.
+	SaveGameDirectory string `json:"saveGameDirectory"`
+	// RequireHeaderChecksum refuses to run games with a bad header
+	// checksum, like the boot ROM does.
+	RequireHeaderChecksum bool `json:"requireHeaderChecksum"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type breakpoint struct {
+	ID                   int     `json:"id,omitempty"`
+	Verified             bool    `json:"verified"`
+	Message              string  `json:"message,omitempty"`
+	Source               *source `json:"source,omitempty"`
+	Line                 int     `json:"line,omitempty"`
+	InstructionReference string  `json:"instructionReference,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line         int    `json:"line"`
+	Condition    string `json:"condition"`
+	HitCondition string `json:"hitCondition"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type functionBreakpoint struct {
+	Name         string `json:"name"`
+	Condition    string `json:"condition"`
+	HitCondition string `json:"hitCondition"`
+}
+
+type setFunctionBreakpointsArguments struct {
+	Breakpoints []functionBreakpoint `json:"breakpoints"`
+}
+
+type instructionBreakpoint struct {
+	InstructionReference string `json:"instructionReference"`
+	Offset               int    `json:"offset"`
+	Condition            string `json:"condition"`
+	HitCondition         string `json:"hitCondition"`
+}
+
+type setInstructionBreakpointsArguments struct {
+	Breakpoints []instructionBreakpoint `json:"breakpoints"`
+}
+
+type dataBreakpoint struct {
+	DataID       string `json:"dataId"`
+	AccessType   string `json:"accessType"`
+	Condition    string `json:"condition"`
+	HitCondition string `json:"hitCondition"`
+}
+
+type setDataBreakpointsArguments struct {
+	Breakpoints []dataBreakpoint `json:"breakpoints"`
+}
+
+type dataBreakpointInfoArguments struct {
+	VariablesReference int    `json:"variablesReference"`
+	Name               string `json:"name"`
+}
+
+type dataBreakpointInfoResponseBody struct {
+	// DataID is null if the variable can't be watched.
+	DataID      *string  `json:"dataId"`
+	Description string   `json:"description"`
+	AccessTypes []string `json:"accessTypes,omitempty"`
+}
+
+type setExceptionBreakpointsArguments struct {
+	Filters []string `json:"filters"`
+}
+
+type breakpointsResponseBody struct {
+	Breakpoints []breakpoint `json:"breakpoints"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type threadsResponseBody struct {
+	Threads []thread `json:"threads"`
+}
+
+type stackTraceArguments struct {
+	StartFrame int `json:"startFrame"`
+	Levels     int `json:"levels"`
+}
+
+type stackFrame struct {
+	ID                          int     `json:"id"`
+	Name                        string  `json:"name"`
+	Source                      *source `json:"source,omitempty"`
+	Line                        int     `json:"line"`
+	Column                      int     `json:"column"`
+	InstructionPointerReference string  `json:"instructionPointerReference,omitempty"`
+}
+
+type stackTraceResponseBody struct {
+	StackFrames []stackFrame `json:"stackFrames"`
+	TotalFrames int          `json:"totalFrames"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	PresentationHint   string `json:"presentationHint,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type scopesResponseBody struct {
+	Scopes []scope `json:"scopes"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	EvaluateName       string `json:"evaluateName,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type variablesResponseBody struct {
+	Variables []variable `json:"variables"`
+}
+
+type setVariableArguments struct {
+	VariablesReference int    `json:"variablesReference"`
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+}
+
+type setVariableResponseBody struct {
+	Value string `json:"value"`
+}
+
+type stepArguments struct {
+	Granularity string `json:"granularity"`
+}
+
+type continueResponseBody struct {
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+	Context    string `json:"context"`
+}
+
+type evaluateResponseBody struct {
+	Result             string `json:"result"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type readMemoryArguments struct {
+	MemoryReference string `json:"memoryReference"`
+	Offset          int    `json:"offset"`
+	Count           int    `json:"count"`
+}
+
+type readMemoryResponseBody struct {
+	Address         string `json:"address"`
+	UnreadableBytes int    `json:"unreadableBytes,omitempty"`
+	Data            string `json:"data"`
+}
+
+type writeMemoryArguments struct {
+	MemoryReference string `json:"memoryReference"`
+	Offset          int    `json:"offset"`
+	Data            string `json:"data"`
+}
+
+type writeMemoryResponseBody struct {
+	BytesWritten int `json:"bytesWritten"`
+}
+
+type disassembleArguments struct {
+	MemoryReference   string `json:"memoryReference"`
+	Offset            int    `json:"offset"`
+	InstructionOffset int    `json:"instructionOffset"`
+	InstructionCount  int    `json:"instructionCount"`
+}
+
+type disassembledInstruction struct {
+	Address          string  `json:"address"`
+	InstructionBytes string  `json:"instructionBytes,omitempty"`
+	Instruction      string  `json:"instruction"`
+	Symbol           string  `json:"symbol,omitempty"`
+	Location         *source `json:"location,omitempty"`
+	Line             int     `json:"line,omitempty"`
+	PresentationHint string  `json:"presentationHint,omitempty"`
+}
+
+type disassembleResponseBody struct {
+	Instructions []disassembledInstruction `json:"instructions"`
+}
+
+type stoppedEventBody struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	HitBreakpointIDs  []int  `json:"hitBreakpointIds,omitempty"`
+}
+
+type continuedEventBody struct {
+	ThreadID            int  `json:"threadId"`
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+type outputEventBody struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
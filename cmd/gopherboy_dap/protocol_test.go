@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"strconv"
+	"testing"
+)
+
+// readOutputEvents returns the output of the output events written to a
+// connection.
+func readOutputEvents(t *testing.T, written []uint8) []string {
+	t.Helper()
+
+	var outputs []string
+	reader := bufio.NewReader(bytes.NewReader(written))
+	for {
+		headers, err := textproto.NewReader(reader).ReadMIMEHeader()
+		if err == io.EOF {
+			return outputs
+		} else if err != nil {
+			t.Fatalf("reading message header: %v", err)
+		}
+		length, err := strconv.Atoi(headers.Get("Content-Length"))
+		if err != nil {
+			t.Fatalf("invalid content length: %v", err)
+		}
+		content := make([]uint8, length)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			t.Fatalf("reading message content: %v", err)
+		}
+
+		var msg struct {
+			Event string          `json:"event"`
+			Body  outputEventBody `json:"body"`
+		}
+		if err := json.Unmarshal(content, &msg); err != nil {
+			t.Fatalf("decoding message: %v", err)
+		}
+		if msg.Event != "output" || msg.Body.Category != "stdout" {
+			t.Fatalf("expected a stdout output event, got %s", content)
+		}
+		outputs = append(outputs, msg.Body.Output)
+	}
+}
+
+func TestOutputWriter(t *testing.T) {
+	var written bytes.Buffer
+	w := &outputWriter{conn: newConnection(nil, &written), category: "stdout"}
+
+	for _, text := range []string{"Cartridge", " Header:\n  Title: TEST\n", "Emulating"} {
+		if n, err := w.Write([]uint8(text)); n != len(text) || err != nil {
+			t.Fatalf("writing %q wrote %v bytes: %v", text, n, err)
+		}
+	}
+
+	// Each line is sent once it's finished
+	expected := []string{"Cartridge Header:\n", "  Title: TEST\n"}
+	outputs := readOutputEvents(t, written.Bytes())
+	if len(outputs) != len(expected) {
+		t.Fatalf("expected output %q, got %q", expected, outputs)
+	}
+	for i := range expected {
+		if outputs[i] != expected[i] {
+			t.Errorf("output %v is %q, expected %q", i, outputs[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// threadID is the ID of the only thread, which is the CPU.
+const threadID = 1
+
+// maxLineSteps is the most instructions that are run when stepping by line
+// before giving up on reaching a line of source.
+const maxLineSteps = 100000
+
+var (
+	errNotLaunched = xerrors.New("no program has been launched")
+	errRunning     = xerrors.New("the program is running")
+	errExited      = xerrors.New("the program has exited")
+)
+
+// runState is what the emulation goroutine is doing.
+type runState int
+
+const (
+	// notStarted means that the client hasn't finished configuring the
+	// session, so the device hasn't started running.
+	notStarted runState = iota
+	running
+	// stopped means that the device is stopped in the debugger, which is
+	// waiting for requests from the client.
+	stopped
+	// exited means that the device stopped running for good.
+	exited
+)
+
+// stoppedRequests are the requests that can only be handled while the device
+// is stopped. These are handled by the emulation goroutine.
+var stoppedRequests = map[string]bool{
+	"continue":           true,
+	"next":               true,
+	"stepIn":             true,
+	"stepOut":            true,
+	"stackTrace":         true,
+	"scopes":             true,
+	"variables":          true,
+	"setVariable":        true,
+	"dataBreakpointInfo": true,
+	"evaluate":           true,
+	"readMemory":         true,
+	"writeMemory":        true,
+	"disassemble":        true,
+}
+
+// interrupts lists the interrupts by the names used in breakpoint specs.
+var interrupts = []struct {
+	name   string
+	label  string
+	target uint16
+}{
+	{"vblank", "VBlank interrupt", 0x40},
+	{"stat", "STAT interrupt", 0x48},
+	{"timer", "Timer interrupt", 0x50},
+	{"serial", "Serial interrupt", 0x58},
+	{"joypad", "Joypad interrupt", 0x60},
+}
+
+// session is a debugging session with a client. The client runs a single
+// program, which is started when it sends a launch request. The session acts
+// as the device's debug driver, answering the client's requests while the
+// device is stopped.
+type session struct {
+	conn *connection
+	// lineBase is the number of the first line of a file, as the client
+	// counts them.
+	lineBase int
+
+	// The values below are set when the program is launched or started, and
+	// aren't changed afterwards.
+	device      *gameboy.Device
+	video       *videoDriver
+	input       *inputDriver
+	symbols     *symbolTable
+	lines       *lineTable
+	stopOnEntry bool
+	// done is closed once the emulation goroutine exits.
+	done chan struct{}
+
+	// wake receives a value when a request is queued for the emulation
+	// goroutine.
+	wake chan struct{}
+
+	// mutex guards the values below, which are used by both the emulation
+	// goroutine and the goroutine that reads requests.
+	mutex sync.Mutex
+	state runState
+	// queue contains requests for the emulation goroutine to handle.
+	queue []request
+	// pausing is true if the client asked for the device to pause, and it
+	// hasn't stopped yet.
+	pausing bool
+	// terminating is true once the session is ending.
+	terminating bool
+	// breakpoints maps groups of breakpoints that the client sets all at
+	// once, like the breakpoints in a source file, to the IDs of the
+	// breakpoints.
+	breakpoints map[string][]int
+	// stopReasons maps the IDs of the client's breakpoints to the reason
+	// reported when they're hit.
+	stopReasons map[int]string
+
+	// The values below are only used by the emulation goroutine.
+
+	// entry is true until the device stops for the first time, if it's
+	// stopping on entry.
+	entry bool
+	// lineStep is the debugger command being repeated to step to the next
+	// line of source, or "" if not stepping by line.
+	lineStep  string
+	lineSteps int
+	// command is a debugger command to run without waiting for the client.
+	command string
+	// evaluation is a debug console request whose command is being run by
+	// the debugger, or nil.
+	evaluation *request
+	// output collects messages from the debugger.
+	output  bytes.Buffer
+	console *gameboy.ConsoleDebugDriver
+	// ranges contains the memory ranges that variables can be expanded
+	// into. They're only valid until the device resumes.
+	ranges []memoryRange
+}
+
+func newSession(r io.Reader, w io.Writer) *session {
+	s := &session{
+		conn:        newConnection(r, w),
+		lineBase:    1,
+		wake:        make(chan struct{}, 1),
+		breakpoints: make(map[string][]int),
+		stopReasons: make(map[int]string),
+	}
+	// The console driver is only used to format messages from the debugger
+	s.console = gameboy.NewConsoleDebugDriver(nil, &s.output)
+	return s
+}
+
+// serve handles requests until the client disconnects.
+func (s *session) serve() error {
+	defer s.shutdown()
+
+	for {
+		req, err := s.conn.readRequest()
+		if xerrors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !s.handle(req) {
+			return nil
+		}
+	}
+}
+
+// handle handles a request. Requests that need the device to be stopped are
+// passed on to the emulation goroutine. Returns false once the client has
+// disconnected.
+func (s *session) handle(req request) bool {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "initialize":
+		body, err = s.initialize(req)
+	case "launch":
+		err = s.launch(req)
+		if err == nil {
+			s.conn.respond(req, nil)
+			s.conn.event("initialized", nil)
+			return true
+		}
+	case "configurationDone":
+		err = s.configurationDone()
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req)
+	case "setFunctionBreakpoints":
+		body, err = s.setFunctionBreakpoints(req)
+	case "setInstructionBreakpoints":
+		body, err = s.setInstructionBreakpoints(req)
+	case "setDataBreakpoints":
+		body, err = s.setDataBreakpoints(req)
+	case "setExceptionBreakpoints":
+		body, err = s.setExceptionBreakpoints(req)
+	case "threads":
+		body = threadsResponseBody{Threads: []thread{{ID: threadID, Name: "CPU"}}}
+	case "pause":
+		s.pause()
+	case "terminate":
+		s.terminate()
+	case "disconnect":
+		s.shutdown()
+		s.conn.respond(req, nil)
+		return false
+	default:
+		if stoppedRequests[req.Command] {
+			s.enqueue(req)
+			return true
+		}
+		err = xerrors.Errorf("unsupported request %q", req.Command)
+	}
+
+	if err != nil {
+		s.conn.respondError(req, err)
+	} else {
+		s.conn.respond(req, body)
+	}
+	return true
+}
+
+// decodeArguments decodes the arguments of a request.
+func decodeArguments(req request, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Arguments, args); err != nil {
+		return xerrors.Errorf("decoding %v arguments: %w", req.Command, err)
+	}
+	return nil
+}
+
+func (s *session) initialize(req request) (interface{}, error) {
+	var args initializeArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+	if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+		s.lineBase = 0
+	}
+
+	var filters []exceptionBreakpointsFilter
+	for _, interrupt := range interrupts {
+		filters = append(filters, exceptionBreakpointsFilter{
+			Filter: interrupt.name,
+			Label:  interrupt.label,
+		})
+	}
+
+	return capabilities{
+		SupportsConfigurationDoneRequest:  true,
+		SupportsFunctionBreakpoints:       true,
+		SupportsConditionalBreakpoints:    true,
+		SupportsHitConditionalBreakpoints: true,
+		SupportsEvaluateForHovers:         true,
+		ExceptionBreakpointFilters:        filters,
+		SupportsSetVariable:               true,
+		SupportsDataBreakpoints:           true,
+		SupportsDisassembleRequest:        true,
+		SupportsSteppingGranularity:       true,
+		SupportsInstructionBreakpoints:    true,
+		SupportsReadMemoryRequest:         true,
+		SupportsWriteMemoryRequest:        true,
+		SupportsTerminateRequest:          true,
+	}, nil
+}
+
+// launch creates the device. It doesn't start running until the client is
+// done setting breakpoints.
+func (s *session) launch(req request) error {
+	if s.device != nil {
+		return xerrors.New("a program has already been launched")
+	}
+
+	var args launchArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return xerrors.New("no program was provided")
+	}
+
+	// Information like the cartridge header is shown in the client's debug
+	// console
+	opts := []gameboy.Option{
+		gameboy.WithOutput(&outputWriter{conn: s.conn, category: "stdout"}),
+	}
+	if args.RequireHeaderChecksum {
+		opts = append(opts, gameboy.WithHeaderChecksumValidation())
+	}
+	if args.Model != "" {
+		model, err := parseModel(args.Model)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, gameboy.WithModel(model))
+	}
+
+	var bootROMData []byte
+	if args.BootROM == "" {
+		opts = append(opts, gameboy.WithoutBootROM())
+	} else {
+		var err error
+		bootROMData, err = ioutil.ReadFile(args.BootROM)
+		if err != nil {
+			return xerrors.Errorf("reading boot ROM: %w", err)
+		}
+	}
+
+	cartridgeData, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return xerrors.Errorf("reading cartridge: %w", err)
+	}
+
+	if err := s.loadSymbols(args, cartridgeData); err != nil {
+		return err
+	}
+
+	saveGameDirectory := args.SaveGameDirectory
+	if saveGameDirectory == "" {
+		saveGameDirectory = filepath.Dir(args.Program)
+	}
+
+	s.video = newVideoDriver()
+	s.input = newInputDriver()
+	s.device, err = gameboy.NewDevice(
+		bootROMData,
+		cartridgeData,
+		s.video,
+		s.input,
+		&fileSaveGameDriver{directory: saveGameDirectory},
+		gameboy.DebugConfiguration{Debugging: true, Driver: s},
+		opts...)
+	if err != nil {
+		return xerrors.Errorf("initializing Game Boy: %w", err)
+	}
+	s.stopOnEntry = args.StopOnEntry
+
+	return nil
+}
+
+// parseModel returns the hardware model with the given name, like "cgb".
+func parseModel(name string) (gameboy.Model, error) {
+	for model := gameboy.ModelAuto; model <= gameboy.ModelCGB; model++ {
+		if strings.EqualFold(model.String(), name) {
+			return model, nil
+		}
+	}
+	return 0, xerrors.Errorf("unknown model %q", name)
+}
+
+// loadSymbols loads the RGBDS symbol and map files for the ROM, then matches
+// source files with the ROM.
+func (s *session) loadSymbols(args launchArguments, rom []uint8) error {
+	base := strings.TrimSuffix(args.Program, filepath.Ext(args.Program))
+
+	symPath := args.Symbols
+	if symPath == "" && fileExists(base+".sym") {
+		symPath = base + ".sym"
+	}
+	mapPath := args.Map
+	if mapPath == "" && fileExists(base+".map") {
+		mapPath = base + ".map"
+	}
+
+	var symbols []symbol
+	var sections []section
+
+	if symPath != "" {
+		file, err := os.Open(symPath)
+		if err != nil {
+			return xerrors.Errorf("opening symbol file: %w", err)
+		}
+		symbols, err = parseSymFile(file)
+		file.Close()
+		if err != nil {
+			return xerrors.Errorf("loading symbol file %v: %w", symPath, err)
+		}
+	}
+	if mapPath != "" {
+		file, err := os.Open(mapPath)
+		if err != nil {
+			return xerrors.Errorf("opening map file: %w", err)
+		}
+		mapSymbols, mapSections, err := parseMapFile(file)
+		file.Close()
+		if err != nil {
+			return xerrors.Errorf("loading map file %v: %w", mapPath, err)
+		}
+		symbols = append(symbols, mapSymbols...)
+		sections = mapSections
+	}
+
+	s.symbols = newSymbolTable(symbols, sections)
+	s.lines = &lineTable{
+		files:      make(map[string]*sourceFile),
+		byLocation: make(map[location]sourceLine),
+	}
+	if len(symbols) == 0 {
+		s.conn.output("console", "No symbols were found, so source code can't be "+
+			"matched with the ROM")
+		return nil
+	}
+
+	dirs := args.SourceDirectories
+	if len(dirs) == 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			return xerrors.Errorf("getting working directory: %w", err)
+		}
+		dirs = []string{wd}
+	}
+	paths, err := findSourceFiles(dirs)
+	if err != nil {
+		return err
+	}
+	s.lines, err = buildLineTable(rom, s.symbols, paths)
+	if err != nil {
+		return err
+	}
+
+	s.conn.output("console", fmt.Sprintf("Loaded %v symbols and matched %v lines "+
+		"in %v source files", len(s.symbols.symbols), len(s.lines.byLocation), len(paths)))
+	return nil
+}
+
+// fileExists returns true if there's a file at the given path.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// configurationDone starts running the device.
+func (s *session) configurationDone() error {
+	if s.device == nil {
+		return errNotLaunched
+	}
+	if s.done != nil {
+		return nil
+	}
+
+	if s.stopOnEntry {
+		s.entry = true
+		s.device.Pause("Stopped on entry")
+	}
+
+	s.mutex.Lock()
+	s.state = running
+	s.mutex.Unlock()
+
+	s.done = make(chan struct{})
+	go s.run()
+
+	return nil
+}
+
+// run runs the device until the session ends or an error occurs.
+func (s *session) run() {
+	defer close(s.done)
+
+	for !s.isTerminating() {
+		if err := s.device.RunFrame(); err != nil {
+			s.conn.output("stderr", fmt.Sprintf("Error: %v", err))
+			break
+		}
+	}
+
+	s.mutex.Lock()
+	s.state = exited
+	s.mutex.Unlock()
+
+	if err := s.device.Close(); err != nil {
+		s.conn.output("stderr", fmt.Sprintf("Error: While shutting down: %v", err))
+	}
+	s.conn.event("terminated", nil)
+}
+
+func (s *session) isTerminating() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.terminating
+}
+
+// pause stops the device if it's running.
+func (s *session) pause() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.state == running {
+		s.pausing = true
+		s.device.Pause("Paused")
+	}
+}
+
+// terminate makes the emulation goroutine exit.
+func (s *session) terminate() {
+	s.mutex.Lock()
+	s.terminating = true
+	s.mutex.Unlock()
+
+	s.signal()
+}
+
+// shutdown ends the session, waiting for the emulation goroutine to exit.
+func (s *session) shutdown() {
+	s.terminate()
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// enqueue passes a request on to the emulation goroutine, if the device is
+// stopped.
+func (s *session) enqueue(req request) {
+	s.mutex.Lock()
+	state := s.state
+	if state == stopped {
+		s.queue = append(s.queue, req)
+	}
+	s.mutex.Unlock()
+
+	switch state {
+	case stopped:
+		s.signal()
+	case notStarted:
+		s.conn.respondError(req, errNotLaunched)
+	case running:
+		s.conn.respondError(req, errRunning)
+	case exited:
+		s.conn.respondError(req, errExited)
+	}
+}
+
+// signal wakes the emulation goroutine if it's waiting for requests.
+func (s *session) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NextCommand handles requests while the device is stopped, until one of
+// them resumes the device.
+func (s *session) NextCommand() (string, error) {
+	if s.evaluation != nil {
+		// The debug console command finished without resuming the device
+		s.finishEvaluation()
+	}
+	if s.command != "" {
+		command := s.command
+		s.command = ""
+		return command, nil
+	}
+
+	for {
+		s.mutex.Lock()
+		if s.terminating {
+			s.mutex.Unlock()
+			return "continue", nil
+		}
+		if len(s.queue) == 0 {
+			s.mutex.Unlock()
+			<-s.wake
+			continue
+		}
+		req := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		if command := s.handleStopped(req); command != "" {
+			return command, nil
+		}
+	}
+}
+
+// handleStopped handles a request while the device is stopped. If the
+// request resumes the device, the debugger command that does so is
+// returned.
+func (s *session) handleStopped(req request) string {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "continue":
+		s.conn.respond(req, continueResponseBody{AllThreadsContinued: true})
+		return "continue"
+	case "next", "stepIn", "stepOut":
+		return s.step(req)
+	case "evaluate":
+		return s.evaluate(req)
+	case "stackTrace":
+		body, err = s.stackTrace(req)
+	case "scopes":
+		body = s.scopes()
+	case "variables":
+		body, err = s.variables(req)
+	case "setVariable":
+		body, err = s.setVariable(req)
+	case "dataBreakpointInfo":
+		body, err = s.dataBreakpointInfo(req)
+	case "readMemory":
+		body, err = s.readMemory(req)
+	case "writeMemory":
+		body, err = s.writeMemory(req)
+	case "disassemble":
+		body, err = s.disassemble(req)
+	default:
+		panic(fmt.Sprintf("unexpected request %q", req.Command))
+	}
+
+	if err != nil {
+		s.conn.respondError(req, err)
+	} else {
+		s.conn.respond(req, body)
+	}
+	return ""
+}
+
+// Event reports stops to the client. Messages from the debugger are
+// collected as the result of debug console commands.
+func (s *session) Event(event gameboy.DebugEvent) {
+	switch event := event.(type) {
+	case gameboy.StopEvent:
+		s.stopped(event)
+	case gameboy.ResumeEvent:
+		s.resumed()
+	default:
+		s.console.Event(event)
+		if s.evaluation == nil {
+			s.conn.output("console", s.output.String())
+			s.output.Reset()
+		}
+	}
+}
+
+// stopped reports that the device stopped, unless it's stepping by line and
+// hasn't reached a new line yet.
+func (s *session) stopped(event gameboy.StopEvent) {
+	s.mutex.Lock()
+	pausing := s.pausing
+	s.pausing = false
+	terminating := s.terminating
+	s.mutex.Unlock()
+
+	if terminating {
+		// The device will be resumed so that it can exit
+		return
+	}
+
+	if s.lineStep != "" && event.Breakpoint == nil && !pausing && s.lineSteps < maxLineSteps {
+		if line, ok := s.lineAt(event.Registers.PC); !ok || !line.code {
+			s.lineSteps++
+			s.command = s.lineStep
+			return
+		}
+	}
+
+	body := stoppedEventBody{
+		Reason:            "step",
+		Description:       event.Reason,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	}
+	switch {
+	case event.Breakpoint != nil:
+		s.mutex.Lock()
+		reason, ok := s.stopReasons[event.Breakpoint.ID]
+		s.mutex.Unlock()
+		if ok {
+			body.Reason = reason
+			body.HitBreakpointIDs = []int{event.Breakpoint.ID}
+		} else {
+			// A breakpoint added from the debug console
+			body.Reason = "breakpoint"
+		}
+	case pausing:
+		body.Reason = "pause"
+	case s.entry:
+		body.Reason = "entry"
+	}
+
+	s.entry = false
+	s.lineStep = ""
+
+	s.mutex.Lock()
+	s.state = stopped
+	s.mutex.Unlock()
+
+	s.conn.event("stopped", body)
+}
+
+// resumed records that the device is running again. Requests that were
+// waiting for the emulation goroutine fail, since the device isn't stopped
+// anymore.
+func (s *session) resumed() {
+	s.mutex.Lock()
+	s.state = running
+	leftover := s.queue
+	s.queue = nil
+	s.mutex.Unlock()
+
+	for _, req := range leftover {
+		s.conn.respondError(req, errRunning)
+	}
+	s.ranges = nil
+
+	if s.evaluation != nil {
+		// The client doesn't know that the device resumed, since it was
+		// done with a debug console command
+		s.finishEvaluation()
+		s.conn.event("continued", continuedEventBody{
+			ThreadID:            threadID,
+			AllThreadsContinued: true,
+		})
+	}
+}
+
+// step resumes the device until the next instruction or line is reached.
+func (s *session) step(req request) string {
+	var args stepArguments
+	if err := decodeArguments(req, &args); err != nil {
+		s.conn.respondError(req, err)
+		return ""
+	}
+
+	var command string
+	switch req.Command {
+	case "next":
+		command = "next"
+	case "stepIn":
+		command = "step"
+	case "stepOut":
+		command = "finish"
+	}
+
+	// Stepping starts from a line of source, so keep going until another
+	// line is reached. Stepping out already stops right after a call.
+	if args.Granularity != "instruction" && req.Command != "stepOut" {
+		if line, ok := s.lineAt(s.device.Registers().PC); ok && line.code {
+			s.lineStep = command
+			s.lineSteps = 0
+		}
+	}
+
+	s.conn.respond(req, nil)
+	return command
+}
+
+// stackTrace returns the emulated call stack.
+func (s *session) stackTrace(req request) (interface{}, error) {
+	var args stackTraceArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+
+	backtrace := s.device.Backtrace()
+
+	var frames []stackFrame
+	addr := s.device.Registers().PC
+	for i := 0; i <= len(backtrace); i++ {
+		var frame *gameboy.StackFrame
+		if i < len(backtrace) {
+			frame = &backtrace[i]
+		}
+		frames = append(frames, s.stackFrame(i+1, addr, frame))
+		if frame != nil {
+			addr = frame.CallAddr
+		}
+	}
+
+	start := args.StartFrame
+	if start > len(frames) {
+		start = len(frames)
+	}
+	end := len(frames)
+	if args.Levels > 0 && start+args.Levels < end {
+		end = start + args.Levels
+	}
+
+	return stackTraceResponseBody{
+		StackFrames: frames[start:end],
+		TotalFrames: len(frames),
+	}, nil
+}
+
+// stackFrame describes a frame of the emulated call stack that's at the
+// given address. The frame is nil for the outermost frame, which wasn't
+// called by anything.
+func (s *session) stackFrame(id int, addr uint16, frame *gameboy.StackFrame) stackFrame {
+	loc := s.locate(addr)
+
+	result := stackFrame{
+		ID:                          id,
+		Name:                        s.functionName(loc, frame),
+		InstructionPointerReference: formatMemoryReference(addr),
+	}
+	if line, ok := s.lines.lineAt(loc); ok {
+		result.Source = &source{Name: filepath.Base(line.path), Path: line.path}
+		result.Line = line.line + s.lineBase - 1
+		result.Column = s.lineBase
+	}
+	return result
+}
+
+// functionName returns the name of the function or interrupt handler that a
+// stack frame is in.
+func (s *session) functionName(loc location, frame *gameboy.StackFrame) string {
+	if frame == nil {
+		if sym, ok := s.symbols.function(loc.bank, loc.addr); ok {
+			return sym.name
+		}
+		return fmt.Sprintf("$%04X", loc.addr)
+	}
+
+	target := s.locate(frame.Target)
+	if label, ok := s.symbols.labelAt(target.bank, target.addr); ok {
+		return label
+	}
+	if frame.Interrupt {
+		for _, interrupt := range interrupts {
+			if interrupt.target == frame.Target {
+				return interrupt.label
+			}
+		}
+	}
+	return fmt.Sprintf("$%04X", frame.Target)
+}
+
+// locate returns the location of an address, as it's currently mapped.
+func (s *session) locate(addr uint16) location {
+	bank := s.device.Disassemble(addr).Bank
+	if bank == -1 && addr >= bankedROMStart && addr < romEnd {
+		// Cartridges without banks always have bank 1 mapped
+		bank = 1
+	}
+	return location{bank: bank, addr: addr}
+}
+
+// lineAt returns the line of source for the code at an address, as it's
+// currently mapped.
+func (s *session) lineAt(addr uint16) (sourceLine, bool) {
+	return s.lines.lineAt(s.locate(addr))
+}
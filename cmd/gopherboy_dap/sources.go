@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// RGBDS files don't record which source lines assembled to which addresses,
+// so lines are matched up by reading the source. Starting at each label that
+// appears in the symbol file, the instructions and data on the lines that
+// follow are measured and compared against the ROM. Matching stops at
+// anything that can't be measured, like a macro or an INCLUDE, and picks up
+// again at the next known label.
+
+// sourceExtensions are the extensions of files that are searched for
+// assembly source.
+var sourceExtensions = map[string]bool{
+	".asm":  true,
+	".s":    true,
+	".inc":  true,
+	".z80":  true,
+	".sm83": true,
+}
+
+// mnemonics are the instructions of the SM83 CPU.
+var mnemonics = map[string]bool{
+	"adc": true, "add": true, "and": true, "bit": true, "call": true,
+	"ccf": true, "cp": true, "cpl": true, "daa": true, "dec": true,
+	"di": true, "ei": true, "halt": true, "inc": true, "jp": true,
+	"jr": true, "ld": true, "ldd": true, "ldh": true, "ldi": true,
+	"nop": true, "or": true, "pop": true, "push": true, "res": true,
+	"ret": true, "reti": true, "rl": true, "rla": true, "rlc": true,
+	"rlca": true, "rr": true, "rra": true, "rrc": true, "rrca": true,
+	"rst": true, "sbc": true, "scf": true, "set": true, "sla": true,
+	"sra": true, "srl": true, "stop": true, "sub": true, "swap": true,
+	"xor": true,
+}
+
+// emptyDirectives are directives that don't put any bytes in the ROM, so
+// matching can continue past them.
+var emptyDirectives = map[string]bool{
+	"assert": true, "charmap": true, "def": true, "export": true,
+	"fail": true, "global": true, "newcharmap": true, "opt": true,
+	"popc": true, "popo": true, "print": true, "println": true,
+	"purge": true, "pushc": true, "pusho": true, "redef": true,
+	"rsreset": true, "rsset": true, "setcharmap": true,
+	"static_assert": true, "warn": true,
+}
+
+// constantDirectives define constants when they're the second word on a
+// line, like "SCREEN_WIDTH EQU 160".
+var constantDirectives = map[string]bool{
+	"equ": true, "equs": true, "set": true, "=": true, "rb": true,
+	"rw": true, "rl": true,
+}
+
+// location is an address in a ROM bank.
+type location struct {
+	// bank is the ROM bank, or -1 if the address isn't in ROM.
+	bank int
+	addr uint16
+}
+
+// sourceLine is a line of source that was matched with what it assembled to.
+type sourceLine struct {
+	path string
+	// line is the line number, starting from 1.
+	line int
+	location
+	// code is true if the line is an instruction, as opposed to data.
+	code bool
+}
+
+// sourceFile contains the lines of a source file that were matched.
+type sourceFile struct {
+	lines map[int]sourceLine
+	// statements contains the numbers of lines that put bytes in the ROM, or
+	// might have, whether or not they were matched.
+	statements map[int]bool
+	lineCount  int
+}
+
+// lineTable maps between lines of source and the addresses of the code they
+// assembled to.
+type lineTable struct {
+	files      map[string]*sourceFile
+	byLocation map[location]sourceLine
+}
+
+// statement is a line of source, without comments.
+type statement struct {
+	line int
+	// labels contains the full names of the labels defined on the line.
+	labels []string
+	// keyword is the lowercase first word after the labels, like "ld" or
+	// "db", or "" if the line only has labels.
+	keyword string
+	// args is the rest of the line after the keyword.
+	args string
+}
+
+// findSourceFiles returns the paths of all assembly source files in the
+// given directories and their subdirectories. Hidden directories are
+// skipped.
+func findSourceFiles(dirs []string) ([]string, error) {
+	var paths []string
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != dir && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if sourceExtensions[strings.ToLower(filepath.Ext(path))] {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("searching for source files in %v: %w", dir, err)
+		}
+	}
+
+	return paths, nil
+}
+
+// buildLineTable matches the lines of the given source files with the
+// contents of the ROM.
+func buildLineTable(rom []uint8, symbols *symbolTable, paths []string) (*lineTable, error) {
+	table := &lineTable{
+		files:      make(map[string]*sourceFile),
+		byLocation: make(map[location]sourceLine),
+	}
+
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, xerrors.Errorf("finding path of source file: %w", err)
+		}
+
+		statements, lineCount, err := parseSourceFile(abs)
+		if err != nil {
+			return nil, err
+		}
+
+		file := &sourceFile{
+			lines:      make(map[int]sourceLine),
+			statements: make(map[int]bool),
+			lineCount:  lineCount,
+		}
+		table.files[abs] = file
+
+		for i, stmt := range statements {
+			if stmt.keyword != "" && !isEmptyStatement(stmt) {
+				file.statements[stmt.line] = true
+			}
+			if sym, ok := knownLabel(symbols, stmt); ok {
+				table.match(rom, symbols, abs, file, statements[i:], sym)
+			}
+		}
+	}
+
+	return table, nil
+}
+
+// match matches statements with the ROM, starting at a statement with the
+// given label.
+func (table *lineTable) match(rom []uint8, symbols *symbolTable, path string, file *sourceFile, statements []statement, label symbol) {
+	if label.bank == -1 {
+		// Only code and data in ROM can be matched
+		return
+	}
+
+	// Matching can't continue past the end of the section or bank
+	end := (int(label.addr) | (romBankSize - 1)) + 1
+	if sectionEnd, ok := symbols.sectionEnd(label.bank, label.addr); ok {
+		end = int(sectionEnd) + 1
+	}
+
+	addr := int(label.addr)
+	for i, stmt := range statements {
+		if i > 0 {
+			if _, ok := knownLabel(symbols, stmt); ok {
+				// This label starts its own match
+				return
+			}
+		}
+
+		size, code, ok := measure(rom, label.bank, uint16(addr), stmt)
+		if !ok || addr+size > end {
+			return
+		}
+		if size > 0 {
+			line := sourceLine{
+				path:     path,
+				line:     stmt.line,
+				location: location{bank: label.bank, addr: uint16(addr)},
+				code:     code,
+			}
+			file.lines[stmt.line] = line
+			if _, ok := table.byLocation[line.location]; !ok {
+				table.byLocation[line.location] = line
+			}
+		}
+		addr += size
+	}
+}
+
+// knownLabel returns the first label defined by the statement that's in the
+// symbol table.
+func knownLabel(symbols *symbolTable, stmt statement) (symbol, bool) {
+	for _, label := range stmt.labels {
+		if sym, ok := symbols.lookup(label); ok {
+			return sym, true
+		}
+	}
+	return symbol{}, false
+}
+
+// isEmptyStatement returns true if the statement doesn't put any bytes in the
+// ROM.
+func isEmptyStatement(stmt statement) bool {
+	if emptyDirectives[stmt.keyword] {
+		return true
+	}
+	fields := strings.Fields(stmt.args)
+	return len(fields) > 0 && constantDirectives[strings.ToLower(fields[0])]
+}
+
+// measure returns the number of bytes that the statement assembled to, and
+// whether it's an instruction. Instructions are checked against the ROM.
+// Returns false if the statement can't be measured or doesn't match.
+func measure(rom []uint8, bank int, addr uint16, stmt statement) (int, bool, bool) {
+	switch {
+	case stmt.keyword == "" || isEmptyStatement(stmt):
+		return 0, false, true
+	case mnemonics[stmt.keyword]:
+		inst, err := gameboy.DisassembleROM(rom, bank, addr)
+		if err != nil || mnemonicFamily(inst.Mnemonic) != mnemonicFamily(stmt.keyword) {
+			return 0, false, false
+		}
+		size := len(inst.Bytes)
+		if stmt.keyword == "stop" && len(inst.Bytes) == 1 {
+			// RGBDS follows STOP with a padding byte
+			size++
+		}
+		return size, true, true
+	default:
+		size, ok := dataSize(stmt.keyword, stmt.args)
+		return size, false, ok
+	}
+}
+
+// mnemonicFamily groups together mnemonics that may assemble to the same
+// instruction, like "ldi a, [hl]" and "ld a, [hl+]".
+func mnemonicFamily(mnemonic string) string {
+	switch mnemonic {
+	case "ldi", "ldd", "ldh":
+		return "ld"
+	default:
+		return mnemonic
+	}
+}
+
+// dataSize returns the number of bytes that a data directive reserves, or
+// false if the keyword isn't a data directive or the size isn't known.
+func dataSize(keyword, args string) (int, bool) {
+	items := splitArgs(args)
+
+	var itemSize int
+	switch keyword {
+	case "db":
+		itemSize = 1
+	case "dw":
+		itemSize = 2
+	case "dl":
+		itemSize = 4
+	case "ds":
+		if len(items) == 0 {
+			return 0, false
+		}
+		size, err := parseNumber(items[0])
+		return size, err == nil
+	default:
+		return 0, false
+	}
+
+	if len(items) == 0 {
+		return itemSize, true
+	}
+	size := 0
+	for _, item := range items {
+		if strings.HasPrefix(item, `"`) {
+			size += stringLength(item) * itemSize
+		} else {
+			size += itemSize
+		}
+	}
+	return size, true
+}
+
+// splitArgs splits a statement's arguments on commas that aren't in
+// strings.
+func splitArgs(args string) []string {
+	var items []string
+
+	inString := false
+	start := 0
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == '\\' && inString:
+			i++
+		case args[i] == '"':
+			inString = !inString
+		case args[i] == ',' && !inString:
+			items = append(items, strings.TrimSpace(args[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(args[start:]); last != "" || len(items) > 0 {
+		items = append(items, last)
+	}
+
+	return items
+}
+
+// stringLength returns the number of characters in a quoted string, counting
+// escape sequences as one character.
+func stringLength(quoted string) int {
+	length := 0
+	for i := 1; i < len(quoted) && quoted[i] != '"'; i++ {
+		if quoted[i] == '\\' {
+			i++
+		}
+		length++
+	}
+	return length
+}
+
+// parseNumber parses a number in RGBDS syntax. Numbers are decimal unless
+// prefixed with "$" or "0x" for hexadecimal, or "%" or "0b" for binary.
+func parseNumber(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	base := 10
+	switch {
+	case strings.HasPrefix(text, "$"):
+		text, base = text[1:], 16
+	case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+		text, base = text[2:], 16
+	case strings.HasPrefix(text, "%"):
+		text, base = text[1:], 2
+	case strings.HasPrefix(text, "0b"), strings.HasPrefix(text, "0B"):
+		text, base = text[2:], 2
+	}
+
+	val, err := strconv.ParseInt(strings.ReplaceAll(text, "_", ""), base, 32)
+	if err != nil {
+		return 0, xerrors.Errorf("invalid number %q", text)
+	}
+	return int(val), nil
+}
+
+var (
+	labelPattern      = regexp.MustCompile(`^\s*([A-Za-z_.][A-Za-z0-9_.@#$]*)::?`)
+	localLabelPattern = regexp.MustCompile(`^(\.[A-Za-z0-9_@#$]+)(\s|$)`)
+)
+
+// parseSourceFile splits a source file into statements. Macro definitions
+// are skipped, since they don't put anything in the ROM where they're
+// defined. Returns the number of lines in the file.
+func parseSourceFile(path string) ([]statement, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, xerrors.Errorf("opening source file: %w", err)
+	}
+	defer file.Close()
+
+	var statements []statement
+	// scope is the last global label, which local labels belong to
+	scope := ""
+	inMacro := false
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		text := stripComment(scanner.Text())
+
+		stmt := statement{line: lineNum}
+		for {
+			match := labelPattern.FindStringSubmatch(text)
+			if match == nil && len(stmt.labels) == 0 {
+				// Local labels at the start of a line don't need a colon
+				match = localLabelPattern.FindStringSubmatch(text)
+			}
+			if match == nil {
+				break
+			}
+			text = text[len(match[0]):]
+
+			name := match[1]
+			if strings.HasPrefix(name, ".") {
+				name = scope + name
+			} else if !strings.Contains(name, ".") {
+				scope = name
+			}
+			stmt.labels = append(stmt.labels, name)
+		}
+
+		text = strings.TrimSpace(text)
+		if strings.HasPrefix(text, ":") {
+			// An anonymous label
+			text = strings.TrimSpace(text[1:])
+		}
+		if i := strings.IndexAny(text, " \t"); i != -1 {
+			stmt.keyword = strings.ToLower(text[:i])
+			stmt.args = strings.TrimSpace(text[i:])
+		} else {
+			stmt.keyword = strings.ToLower(text)
+		}
+
+		switch {
+		case inMacro:
+			if stmt.keyword == "endm" {
+				inMacro = false
+			}
+			continue
+		case stmt.keyword == "macro":
+			// Either "MACRO name" or the older "name: MACRO"
+			inMacro = true
+			continue
+		}
+
+		statements = append(statements, stmt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, xerrors.Errorf("reading source file %v: %w", path, err)
+	}
+
+	return statements, lineNum, nil
+}
+
+// stripComment removes a comment from the end of a line of source.
+func stripComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && inString:
+			i++
+		case line[i] == '"':
+			inString = !inString
+		case line[i] == ';' && !inString:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// resolve returns the code that a breakpoint on the given line of a source
+// file should stop at. Breakpoints on lines without code, like labels and
+// blank lines, move to the next line of code. Returns false if there is no
+// code there.
+func (table *lineTable) resolve(path string, line int) (sourceLine, bool) {
+	file, ok := table.files[filepath.Clean(path)]
+	if !ok {
+		return sourceLine{}, false
+	}
+
+	for ; line <= file.lineCount; line++ {
+		if matched, ok := file.lines[line]; ok {
+			return matched, matched.code
+		}
+		if file.statements[line] {
+			// A statement that couldn't be matched
+			return sourceLine{}, false
+		}
+	}
+	return sourceLine{}, false
+}
+
+// lineAt returns the line of source that assembled to the given location.
+func (table *lineTable) lineAt(loc location) (sourceLine, bool) {
+	line, ok := table.byLocation[loc]
+	return line, ok
+}
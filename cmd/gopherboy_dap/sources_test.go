@@ -0,0 +1,260 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSource is assembly source for testROM. Lines are referred to by the
+// text in their comments.
+const testSource = `INCLUDE "hardware.inc"
+
+MACRO add_twice
+	add a, \1 ; macro add
+	add a, \1
+ENDM
+
+SECTION "Entry", ROM0[$0150]
+Main::
+	ld a, 1 ; load
+.loop
+	inc a ; increment
+	jr .loop ; loop
+
+MAX_LIVES EQU 3 ; constant
+Data: ; data label
+	db 1, 2, "a;b" ; bytes
+	dw $1234 ; words
+	add_twice 2 ; macro call
+	nop ; after macro
+
+SECTION "Far", ROMX[$4000], BANK[1]
+Far:
+	ld hl, $C000 ; far load
+	ldi a, [hl] ; load increment
+	stop ; stop
+	ret ; return
+Mismatch:
+	call Far ; mismatch
+`
+
+// testSymbols are the labels in testSource.
+const testSymbols = `00:0150 Main
+00:0152 Main.loop
+00:0155 Data
+01:4000 Far
+01:4007 Mismatch
+`
+
+// testROM returns what testSource assembles to, except for the code after
+// Mismatch.
+func testROM() []uint8 {
+	rom := make([]uint8, 2*romBankSize)
+	copy(rom[0x0150:], []uint8{
+		0x3E, 0x01, // ld a, 1
+		0x3C,       // inc a
+		0x18, 0xFD, // jr .loop
+		0x01, 0x02, 'a', ';', 'b', // db 1, 2, "a;b"
+		0x34, 0x12, // dw $1234
+		0xC6, 0x02, // add_twice 2
+		0xC6, 0x02,
+		0x00, // nop
+	})
+	copy(rom[romBankSize:], []uint8{
+		0x21, 0x00, 0xC0, // ld hl, $C000
+		0x2A,       // ldi a, [hl]
+		0x10, 0x00, // stop
+		0xC9, // ret
+		0x00, // Not "call Far"
+	})
+	return rom
+}
+
+// sourceLineNumber returns the number of the line in testSource with the
+// given comment.
+func sourceLineNumber(t *testing.T, comment string) int {
+	t.Helper()
+
+	for i, line := range strings.Split(testSource, "\n") {
+		if strings.HasSuffix(line, "; "+comment) {
+			return i + 1
+		}
+	}
+	t.Fatalf("no line with the comment %q", comment)
+	return 0
+}
+
+// newTestLineTable matches testSource with testROM.
+func newTestLineTable(t *testing.T, sections []section) (*lineTable, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "main.asm")
+	if err := ioutil.WriteFile(path, []byte(testSource), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+	symbols, err := parseSymFile(strings.NewReader(testSymbols))
+	if err != nil {
+		t.Fatalf("parsing symbols: %v", err)
+	}
+
+	table, err := buildLineTable(testROM(), newSymbolTable(symbols, sections), []string{path})
+	if err != nil {
+		t.Fatalf("building line table: %v", err)
+	}
+	return table, path
+}
+
+func TestBuildLineTable(t *testing.T) {
+	table, path := newTestLineTable(t, nil)
+
+	tests := []struct {
+		comment string
+		bank    int
+		addr    uint16
+		code    bool
+	}{
+		{"load", 0, 0x0150, true},
+		{"increment", 0, 0x0152, true},
+		{"loop", 0, 0x0153, true},
+		// Semicolons in strings don't start comments
+		{"bytes", 0, 0x0155, false},
+		{"words", 0, 0x015A, false},
+		{"far load", 1, 0x4000, true},
+		// "ldi a, [hl]" is disassembled as "ld a, [hl+]"
+		{"load increment", 1, 0x4003, true},
+		{"stop", 1, 0x4004, true},
+		{"return", 1, 0x4006, true},
+	}
+	for _, test := range tests {
+		loc := location{bank: test.bank, addr: test.addr}
+		line, ok := table.lineAt(loc)
+		if !ok {
+			t.Errorf("no line for %+v, expected %q", loc, test.comment)
+			continue
+		}
+		expected := sourceLineNumber(t, test.comment)
+		if line.path != path || line.line != expected || line.code != test.code {
+			t.Errorf("line for %+v is %+v, expected line %v with code %v",
+				loc, line, expected, test.code)
+		}
+	}
+
+	// Matching stops at macros and at code that doesn't match the ROM
+	for _, addr := range []uint16{0x015C, 0x0160} {
+		if line, ok := table.lineAt(location{bank: 0, addr: addr}); ok {
+			t.Errorf("expected nothing at %#04x, got line %v", addr, line.line)
+		}
+	}
+	if line, ok := table.lineAt(location{bank: 1, addr: 0x4007}); ok {
+		t.Errorf("expected the mismatched call not to be matched, got line %v", line.line)
+	}
+}
+
+func TestBuildLineTableSectionEnd(t *testing.T) {
+	// The section ends in the middle of the data
+	table, _ := newTestLineTable(t, []section{
+		{name: "Entry", bank: 0, start: 0x0150, end: 0x0158},
+	})
+
+	if _, ok := table.lineAt(location{bank: 0, addr: 0x0153}); !ok {
+		t.Errorf("expected the code in the section to be matched")
+	}
+	if line, ok := table.lineAt(location{bank: 0, addr: 0x0155}); ok {
+		t.Errorf("expected data past the end of the section not to be matched, got line %v", line.line)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	table, path := newTestLineTable(t, nil)
+
+	tests := []struct {
+		name    string
+		comment string
+		// expected is the comment of the line that the breakpoint moves to,
+		// or "" if it can't be set.
+		expected string
+	}{
+		{"instruction", "increment", "increment"},
+		{"label", "data label", ""},
+		{"constant", "constant", ""},
+		{"data", "bytes", ""},
+		{"unmatched", "after macro", ""},
+		{"mismatched", "mismatch", ""},
+		// Lines in macro definitions are skipped, and the SECTION after this
+		// one can't be matched
+		{"macro definition", "macro add", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, ok := table.resolve(path, sourceLineNumber(t, test.comment))
+			if test.expected == "" {
+				if ok {
+					t.Fatalf("expected no code, got line %v", line.line)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected line %q, got no code", test.expected)
+			}
+			if expected := sourceLineNumber(t, test.expected); line.line != expected {
+				t.Fatalf("resolved to line %v, expected %v", line.line, expected)
+			}
+		})
+	}
+
+	// A breakpoint on a label moves to the code after it
+	line, ok := table.resolve(path, sourceLineNumber(t, "load")-1)
+	if !ok || line.line != sourceLineNumber(t, "load") {
+		t.Fatalf("expected the breakpoint on Main to move to the next line, got %+v", line)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	rom := testROM()
+
+	tests := []struct {
+		name     string
+		addr     uint16
+		stmt     statement
+		size     int
+		code     bool
+		measured bool
+	}{
+		{"label", 0x0150, statement{labels: []string{"Main"}}, 0, false, true},
+		{"instruction", 0x0150, statement{keyword: "ld", args: "a, 1"}, 2, true, true},
+		{"wrong instruction", 0x0150, statement{keyword: "inc", args: "a"}, 0, false, false},
+		{"ldi", 0x4003, statement{keyword: "ldi", args: "a, [hl]"}, 1, true, true},
+		{"constant", 0x0150, statement{keyword: "lives", args: "EQU 3"}, 0, false, true},
+		{"directive", 0x0150, statement{keyword: "export", args: "Main"}, 0, false, true},
+		{"bytes", 0x0150, statement{keyword: "db", args: `1, "a\"b", 2`}, 5, false, true},
+		{"empty byte", 0x0150, statement{keyword: "db"}, 1, false, true},
+		{"words", 0x0150, statement{keyword: "dw", args: "1, 2"}, 4, false, true},
+		{"string of words", 0x0150, statement{keyword: "dw", args: `"ab"`}, 4, false, true},
+		{"longs", 0x0150, statement{keyword: "dl", args: "1"}, 4, false, true},
+		{"hex space", 0x0150, statement{keyword: "ds", args: "$10, $FF"}, 16, false, true},
+		{"binary space", 0x0150, statement{keyword: "ds", args: "%1_0000"}, 16, false, true},
+		{"computed space", 0x0150, statement{keyword: "ds", args: "SIZE * 2"}, 0, false, false},
+		{"macro", 0x0150, statement{keyword: "add_twice", args: "2"}, 0, false, false},
+		{"stop", 0x4004, statement{keyword: "stop"}, 2, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bank := 0
+			if test.addr >= bankedROMStart {
+				bank = 1
+			}
+			size, code, measured := measure(rom, bank, test.addr, test.stmt)
+			if measured != test.measured {
+				t.Fatalf("expected measured to be %v, got %v", test.measured, measured)
+			}
+			if size != test.size || code != test.code {
+				t.Fatalf("expected size %v and code %v, got size %v and code %v",
+					test.size, test.code, size, code)
+			}
+		})
+	}
+}
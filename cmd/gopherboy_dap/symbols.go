@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Address ranges of the memory map that symbols and sections are sorted into.
+const (
+	romBankSize    = 0x4000
+	bankedROMStart = 0x4000
+	romEnd         = 0x8000
+)
+
+// symbol is a label from an RGBDS symbol or map file.
+type symbol struct {
+	name string
+	// bank is the ROM bank that the label is in, or -1 if it isn't in ROM.
+	bank int
+	addr uint16
+}
+
+// section is a section from an RGBDS map file.
+type section struct {
+	name string
+	// bank is the ROM bank that the section is in, or -1 if it isn't in
+	// ROM.
+	bank  int
+	start uint16
+	// end is the last address in the section.
+	end uint16
+}
+
+// symbolTable looks up the labels and sections of a ROM.
+type symbolTable struct {
+	// symbols is sorted by bank, then address.
+	symbols  []symbol
+	byName   map[string]symbol
+	sections []section
+}
+
+func newSymbolTable(symbols []symbol, sections []section) *symbolTable {
+	table := &symbolTable{
+		byName:   make(map[string]symbol),
+		sections: sections,
+	}
+
+	for _, sym := range symbols {
+		if _, ok := table.byName[sym.name]; ok {
+			// Map files and symbol files may list the same labels
+			continue
+		}
+		table.byName[sym.name] = sym
+		table.symbols = append(table.symbols, sym)
+	}
+	sort.SliceStable(table.symbols, func(i, j int) bool {
+		a, b := table.symbols[i], table.symbols[j]
+		if a.bank != b.bank {
+			return a.bank < b.bank
+		}
+		return a.addr < b.addr
+	})
+
+	return table
+}
+
+// lookup returns the symbol with the given name.
+func (table *symbolTable) lookup(name string) (symbol, bool) {
+	sym, ok := table.byName[name]
+	return sym, ok
+}
+
+// labelAt returns the name of a label at the given address, preferring
+// global labels over local ones.
+func (table *symbolTable) labelAt(bank int, addr uint16) (string, bool) {
+	i := table.search(bank, int(addr))
+
+	var found string
+	for ; i < len(table.symbols); i++ {
+		sym := table.symbols[i]
+		if sym.bank != bank || sym.addr != addr {
+			break
+		}
+		if !strings.Contains(sym.name, ".") {
+			return sym.name, true
+		}
+		if found == "" {
+			found = sym.name
+		}
+	}
+	return found, found != ""
+}
+
+// function returns the global label at or before the given address in the
+// same bank, which is usually the function that the address is in.
+func (table *symbolTable) function(bank int, addr uint16) (symbol, bool) {
+	for i := table.search(bank, int(addr)+1) - 1; i >= 0; i-- {
+		sym := table.symbols[i]
+		if sym.bank != bank {
+			break
+		}
+		if !strings.Contains(sym.name, ".") && sameRegion(sym.addr, addr) {
+			return sym, true
+		}
+	}
+	return symbol{}, false
+}
+
+// ramSymbols returns the symbols that aren't in ROM, sorted by address.
+func (table *symbolTable) ramSymbols() []symbol {
+	var symbols []symbol
+	for _, sym := range table.symbols {
+		if sym.addr >= romEnd {
+			symbols = append(symbols, sym)
+		}
+	}
+	return symbols
+}
+
+// sectionEnd returns the last address of the section containing the given
+// address, or false if the address isn't in a known section.
+func (table *symbolTable) sectionEnd(bank int, addr uint16) (uint16, bool) {
+	for _, sect := range table.sections {
+		if sect.bank == bank && addr >= sect.start && addr <= sect.end {
+			return sect.end, true
+		}
+	}
+	return 0, false
+}
+
+// search returns the index of the first symbol at or after the given
+// address.
+func (table *symbolTable) search(bank int, addr int) int {
+	return sort.Search(len(table.symbols), func(i int) bool {
+		sym := table.symbols[i]
+		if sym.bank != bank {
+			return sym.bank > bank
+		}
+		return int(sym.addr) >= addr
+	})
+}
+
+// sameRegion returns true if the addresses are in the same ROM area, or are
+// both outside of ROM.
+func sameRegion(a, b uint16) bool {
+	region := func(addr uint16) int {
+		switch {
+		case addr < bankedROMStart:
+			return 0
+		case addr < romEnd:
+			return 1
+		default:
+			return 2
+		}
+	}
+	return region(a) == region(b)
+}
+
+// romBank returns the bank to record for a label, given the bank number that
+// RGBDS listed it with. Only banks of ROM addresses are kept, since bank
+// numbers of RAM labels don't correspond to ROM banks.
+func romBank(bank int, addr uint16) int {
+	if addr >= romEnd {
+		return -1
+	}
+	if addr < bankedROMStart {
+		return 0
+	}
+	if bank == 0 {
+		// ROMs linked without banks list all of their labels in bank 0, but
+		// the upper half is bank 1 as far as the hardware is concerned
+		return 1
+	}
+	return bank
+}
+
+var symLinePattern = regexp.MustCompile(`^([0-9A-Fa-f]+):([0-9A-Fa-f]+)\s+(\S+)`)
+
+// parseSymFile parses a symbol file created by rgblink's -n flag. Each line
+// has the format:
+//
+//	<bank>:<address> <name>
+//
+// The bank and address are in hexadecimal. Comments start with a semicolon.
+func parseSymFile(r io.Reader) ([]symbol, error) {
+	var symbols []symbol
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		match := symLinePattern.FindStringSubmatch(line)
+		if match == nil {
+			return nil, xerrors.Errorf("line %v: expected a bank, address and name", lineNum)
+		}
+		bank, err := strconv.ParseUint(match[1], 16, 16)
+		if err != nil {
+			return nil, xerrors.Errorf("line %v: invalid bank %q", lineNum, match[1])
+		}
+		addr, err := strconv.ParseUint(match[2], 16, 16)
+		if err != nil {
+			return nil, xerrors.Errorf("line %v: invalid address %q", lineNum, match[2])
+		}
+
+		symbols = append(symbols, symbol{
+			name: match[3],
+			bank: romBank(int(bank), uint16(addr)),
+			addr: uint16(addr),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("reading symbol file: %w", err)
+	}
+
+	return symbols, nil
+}
+
+var (
+	mapBankPattern    = regexp.MustCompile(`^\s*(ROM0|ROMX|VRAM|SRAM|WRAM0|WRAMX|OAM|HRAM) bank #(\d+):`)
+	mapSectionPattern = regexp.MustCompile(`^\s*SECTION: \$([0-9A-Fa-f]+)(?:-\$([0-9A-Fa-f]+))? \(\$[0-9A-Fa-f]+ bytes?\) \["(.*)"\]`)
+	mapSymbolPattern  = regexp.MustCompile(`^\s*\$([0-9A-Fa-f]+) = (\S+)`)
+)
+
+// parseMapFile parses a map file created by rgblink's -m flag. Sections and
+// the symbols in them are listed under a heading for each memory bank, like
+// "ROMX bank #1:". Lines that aren't sections or symbols are ignored.
+func parseMapFile(r io.Reader) ([]symbol, []section, error) {
+	var symbols []symbol
+	var sections []section
+
+	bank := -1
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if match := mapBankPattern.FindStringSubmatch(line); match != nil {
+			bank, _ = strconv.Atoi(match[2])
+			continue
+		}
+		if bank == -1 {
+			// Not in a bank listing, like in the summary
+			continue
+		}
+
+		if match := mapSectionPattern.FindStringSubmatch(line); match != nil {
+			start, _ := strconv.ParseUint(match[1], 16, 16)
+			end := start
+			if match[2] != "" {
+				end, _ = strconv.ParseUint(match[2], 16, 16)
+			}
+			sections = append(sections, section{
+				name:  match[3],
+				bank:  romBank(bank, uint16(start)),
+				start: uint16(start),
+				end:   uint16(end),
+			})
+		} else if match := mapSymbolPattern.FindStringSubmatch(line); match != nil {
+			addr, _ := strconv.ParseUint(match[1], 16, 16)
+			symbols = append(symbols, symbol{
+				name: match[2],
+				bank: romBank(bank, uint16(addr)),
+				addr: uint16(addr),
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, xerrors.Errorf("reading map file: %w", err)
+	}
+
+	return symbols, sections, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// expectSymbols fails the test if the symbols don't match the expected ones.
+func expectSymbols(t *testing.T, actual, expected []symbol) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v symbols, got %v: %+v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("symbol %v is %+v, expected %+v", i, actual[i], expected[i])
+		}
+	}
+}
+
+func TestParseSymFile(t *testing.T) {
+	const symFile = `; File generated by rgblink
+
+00:0150 Main
+00:0152 Main.loop ; a local label
+00:4000 Unbanked
+01:4010 Far
+02:d000 wBanked
+00:FF80 hCounter
+`
+
+	symbols, err := parseSymFile(strings.NewReader(symFile))
+	if err != nil {
+		t.Fatalf("parsing symbol file: %v", err)
+	}
+
+	expectSymbols(t, symbols, []symbol{
+		{name: "Main", bank: 0, addr: 0x0150},
+		{name: "Main.loop", bank: 0, addr: 0x0152},
+		// ROMs without banks list the upper half of ROM as bank 0
+		{name: "Unbanked", bank: 1, addr: 0x4000},
+		{name: "Far", bank: 1, addr: 0x4010},
+		// Bank numbers of RAM labels aren't kept
+		{name: "wBanked", bank: -1, addr: 0xD000},
+		{name: "hCounter", bank: -1, addr: 0xFF80},
+	})
+}
+
+func TestParseSymFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		symFile string
+		line    string
+	}{
+		{"missing name", "00:0150 Main\n00:0152\n", "line 2"},
+		{"missing bank", "0150 Main\n", "line 1"},
+		{"bank too large", "10000:0150 Main\n", "line 1"},
+		{"address too large", "; comment\n00:10000 Main\n", "line 2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseSymFile(strings.NewReader(test.symFile))
+			if err == nil {
+				t.Fatalf("parsing succeeded")
+			}
+			if !strings.Contains(err.Error(), test.line) {
+				t.Fatalf("expected the error to mention %q, got %v", test.line, err)
+			}
+		})
+	}
+}
+
+func TestParseMapFile(t *testing.T) {
+	const mapFile = `SUMMARY:
+	ROM0: 7 bytes used / 16377 free
+	ROMX: 8 bytes used / 16376 free in 1 bank
+
+ROM0 bank #0:
+	SECTION: $0150-$0156 ($0007 bytes) ["Entry"]
+	         $0150 = Main
+	         $0152 = Main.loop
+	EMPTY: $0157-$3fff ($3ea9 bytes)
+	TOTAL EMPTY: $3ea9 bytes
+
+ROMX bank #1:
+	SECTION: $4000-$4007 ($0008 bytes) ["Far code"]
+	         $4000 = Far
+	SECTION: $4008 ($0000 bytes) ["Empty"]
+
+WRAM0 bank #0:
+	SECTION: $c000-$c001 ($0002 bytes) ["Variables"]
+	         $c000 = wCounter
+`
+
+	symbols, sections, err := parseMapFile(strings.NewReader(mapFile))
+	if err != nil {
+		t.Fatalf("parsing map file: %v", err)
+	}
+
+	expectSymbols(t, symbols, []symbol{
+		{name: "Main", bank: 0, addr: 0x0150},
+		{name: "Main.loop", bank: 0, addr: 0x0152},
+		{name: "Far", bank: 1, addr: 0x4000},
+		{name: "wCounter", bank: -1, addr: 0xC000},
+	})
+
+	expectedSections := []section{
+		{name: "Entry", bank: 0, start: 0x0150, end: 0x0156},
+		{name: "Far code", bank: 1, start: 0x4000, end: 0x4007},
+		// Empty sections only list their start address
+		{name: "Empty", bank: 1, start: 0x4008, end: 0x4008},
+		{name: "Variables", bank: -1, start: 0xC000, end: 0xC001},
+	}
+	if len(sections) != len(expectedSections) {
+		t.Fatalf("expected %v sections, got %v: %+v", len(expectedSections), len(sections), sections)
+	}
+	for i := range expectedSections {
+		if sections[i] != expectedSections[i] {
+			t.Errorf("section %v is %+v, expected %+v", i, sections[i], expectedSections[i])
+		}
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	table := newSymbolTable([]symbol{
+		{name: "Main", bank: 0, addr: 0x0150},
+		{name: "Main.loop", bank: 0, addr: 0x0152},
+		{name: "Retry", bank: 0, addr: 0x0152},
+		{name: "Far", bank: 1, addr: 0x4000},
+		{name: "Other", bank: 2, addr: 0x4000},
+		{name: "wCounter", bank: -1, addr: 0xC000},
+		// Duplicates from a map file are ignored
+		{name: "Main", bank: 1, addr: 0x4100},
+	}, []section{
+		{name: "Far code", bank: 1, start: 0x4000, end: 0x40FF},
+	})
+
+	if sym, ok := table.lookup("Main"); !ok || sym.addr != 0x0150 {
+		t.Errorf("expected to find Main at 0x0150, got %+v", sym)
+	}
+
+	labels := []struct {
+		bank     int
+		addr     uint16
+		expected string
+	}{
+		{0, 0x0150, "Main"},
+		// Global labels are preferred over local ones
+		{0, 0x0152, "Retry"},
+		{1, 0x4000, "Far"},
+		{2, 0x4000, "Other"},
+		{0, 0x0151, ""},
+		{3, 0x4000, ""},
+	}
+	for _, label := range labels {
+		actual, ok := table.labelAt(label.bank, label.addr)
+		if actual != label.expected || ok != (label.expected != "") {
+			t.Errorf("label at %v:%#04x is %q, expected %q", label.bank, label.addr, actual, label.expected)
+		}
+	}
+
+	functions := []struct {
+		bank     int
+		addr     uint16
+		expected string
+	}{
+		{0, 0x0151, "Main"},
+		{0, 0x0160, "Retry"},
+		{1, 0x4050, "Far"},
+		{-1, 0xC005, "wCounter"},
+		// Labels in bank 0 don't cover the banked area
+		{0, 0x4000, ""},
+		{0, 0x0100, ""},
+		{3, 0x4000, ""},
+	}
+	for _, function := range functions {
+		actual, ok := table.function(function.bank, function.addr)
+		if actual.name != function.expected || ok != (function.expected != "") {
+			t.Errorf("function at %v:%#04x is %q, expected %q", function.bank, function.addr, actual.name, function.expected)
+		}
+	}
+
+	if end, ok := table.sectionEnd(1, 0x4010); !ok || end != 0x40FF {
+		t.Errorf("expected 0x4010 to be in a section ending at 0x40FF, got %#04x", end)
+	}
+	if _, ok := table.sectionEnd(2, 0x4010); ok {
+		t.Errorf("expected 0x4010 in bank 2 not to be in a section")
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// Variable references of the scopes. References to memory ranges start at
+// firstRangeReference.
+const (
+	registersScope = iota + 1
+	ioRegistersScope
+	symbolsScope
+	memoryScope
+	firstRangeReference = 100
+)
+
+// bytesPerRow is the number of bytes shown in a row of memory. Memory ranges
+// are split into at most this many children when expanded.
+const bytesPerRow = 16
+
+// maxSymbolSize is the most bytes shown for a symbol. Symbols are assumed to
+// span until the next symbol.
+const maxSymbolSize = 0x100
+
+// memoryRegions are the areas of the memory map shown in the memory scope.
+var memoryRegions = []struct {
+	name   string
+	start  int
+	length int
+}{
+	{"ROM0", 0x0000, 0x4000},
+	{"ROMX", 0x4000, 0x4000},
+	{"VRAM", 0x8000, 0x2000},
+	{"SRAM", 0xA000, 0x2000},
+	{"WRAM0", 0xC000, 0x1000},
+	{"WRAMX", 0xD000, 0x1000},
+	{"OAM", 0xFE00, 0xA0},
+	{"HRAM", 0xFF80, 0x7F},
+}
+
+// ioRegister is a hardware register shown in the IO registers scope.
+type ioRegister struct {
+	name string
+	addr uint16
+}
+
+// ioRegisters are the hardware registers, sorted by address.
+var ioRegisters = func() []ioRegister {
+	var registers []ioRegister
+	for addr, name := range gameboy.HardwareRegisterNames() {
+		registers = append(registers, ioRegister{name: name, addr: addr})
+	}
+	sort.Slice(registers, func(i, j int) bool {
+		return registers[i].addr < registers[j].addr
+	})
+	return registers
+}()
+
+// memoryRange is a range of memory that can be expanded into smaller ranges,
+// and eventually single bytes.
+type memoryRange struct {
+	start  int
+	length int
+}
+
+// scopes returns the scopes of the current stack frame. Every frame has the
+// same scopes, since the registers aren't saved by calls.
+func (s *session) scopes() interface{} {
+	scopes := []scope{
+		{Name: "Registers", PresentationHint: "registers", VariablesReference: registersScope},
+		{Name: "IO Registers", VariablesReference: ioRegistersScope},
+	}
+	if len(s.symbols.ramSymbols()) > 0 {
+		scopes = append(scopes, scope{Name: "Symbols", VariablesReference: symbolsScope})
+	}
+	scopes = append(scopes, scope{Name: "Memory", VariablesReference: memoryScope, Expensive: true})
+
+	return scopesResponseBody{Scopes: scopes}
+}
+
+func (s *session) variables(req request) (interface{}, error) {
+	var args variablesArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+
+	var variables []variable
+	switch args.VariablesReference {
+	case registersScope:
+		variables = s.registerVariables()
+	case ioRegistersScope:
+		for _, reg := range ioRegisters {
+			variables = append(variables, variable{
+				Name:            reg.name,
+				Value:           fmt.Sprintf("$%02X", s.device.ReadMemory(reg.addr)),
+				EvaluateName:    fmt.Sprintf("[$%04X]", reg.addr),
+				MemoryReference: formatMemoryReference(reg.addr),
+			})
+		}
+	case symbolsScope:
+		for _, sym := range s.symbolRanges() {
+			variables = append(variables, s.rangeVariable(sym.name, sym.memoryRange))
+		}
+	case memoryScope:
+		for _, region := range memoryRegions {
+			variables = append(variables, s.rangeVariable(region.name,
+				memoryRange{start: region.start, length: region.length}))
+		}
+	default:
+		i := args.VariablesReference - firstRangeReference
+		if i < 0 || i >= len(s.ranges) {
+			return nil, xerrors.Errorf("unknown variables reference %v", args.VariablesReference)
+		}
+		variables = s.expandRange(s.ranges[i])
+	}
+
+	if variables == nil {
+		variables = []variable{}
+	}
+	return variablesResponseBody{Variables: variables}, nil
+}
+
+// registerVariables returns variables for the CPU registers.
+func (s *session) registerVariables() []variable {
+	regs := s.device.Registers()
+
+	var variables []variable
+	for _, name := range []string{"A", "F", "B", "C", "D", "E", "H", "L"} {
+		val, _ := getRegister(regs, name)
+		variables = append(variables, variable{
+			Name:         name,
+			Value:        fmt.Sprintf("$%02X", val),
+			EvaluateName: strings.ToLower(name),
+		})
+	}
+	for _, name := range []string{"BC", "DE", "HL", "SP", "PC"} {
+		val, _ := getRegister(regs, name)
+		variables = append(variables, variable{
+			Name:            name,
+			Value:           fmt.Sprintf("$%04X", val),
+			EvaluateName:    strings.ToLower(name),
+			MemoryReference: formatMemoryReference(uint16(val)),
+		})
+	}
+
+	flags := []byte("ZNHC")
+	for i := range flags {
+		if regs.F&(0x80>>uint(i)) == 0 {
+			flags[i] = '-'
+		}
+	}
+	variables = append(variables, variable{
+		Name:  "Flags",
+		Value: strings.Join(strings.Split(string(flags), ""), " "),
+	})
+
+	return variables
+}
+
+// getRegister returns the value of a register by name, like "A" or "HL".
+func getRegister(regs gameboy.Registers, name string) (int, bool) {
+	switch name {
+	case "A":
+		return int(regs.A), true
+	case "F":
+		return int(regs.F), true
+	case "B":
+		return int(regs.B), true
+	case "C":
+		return int(regs.C), true
+	case "D":
+		return int(regs.D), true
+	case "E":
+		return int(regs.E), true
+	case "H":
+		return int(regs.H), true
+	case "L":
+		return int(regs.L), true
+	case "BC":
+		return int(regs.B)<<8 | int(regs.C), true
+	case "DE":
+		return int(regs.D)<<8 | int(regs.E), true
+	case "HL":
+		return int(regs.H)<<8 | int(regs.L), true
+	case "SP":
+		return int(regs.SP), true
+	case "PC":
+		return int(regs.PC), true
+	default:
+		return 0, false
+	}
+}
+
+// setRegister changes the value of a register by name, like "A" or "HL".
+// The value must fit in the register.
+func setRegister(regs *gameboy.Registers, name string, val int) {
+	switch name {
+	case "A":
+		regs.A = uint8(val)
+	case "F":
+		regs.F = uint8(val)
+	case "B":
+		regs.B = uint8(val)
+	case "C":
+		regs.C = uint8(val)
+	case "D":
+		regs.D = uint8(val)
+	case "E":
+		regs.E = uint8(val)
+	case "H":
+		regs.H = uint8(val)
+	case "L":
+		regs.L = uint8(val)
+	case "BC":
+		regs.B, regs.C = uint8(val>>8), uint8(val)
+	case "DE":
+		regs.D, regs.E = uint8(val>>8), uint8(val)
+	case "HL":
+		regs.H, regs.L = uint8(val>>8), uint8(val)
+	case "SP":
+		regs.SP = uint16(val)
+	case "PC":
+		regs.PC = uint16(val)
+	default:
+		panic(fmt.Sprintf("unknown register %q", name))
+	}
+}
+
+// namedRange is a named range of memory, like a symbol in RAM.
+type namedRange struct {
+	memoryRange
+	name string
+}
+
+// symbolRanges returns the symbols in RAM, each spanning until the next
+// symbol.
+func (s *session) symbolRanges() []namedRange {
+	symbols := s.symbols.ramSymbols()
+
+	var ranges []namedRange
+	for i, sym := range symbols {
+		length := maxSymbolSize
+		for _, next := range symbols[i+1:] {
+			if next.addr != sym.addr {
+				length = minInt(length, int(next.addr)-int(sym.addr))
+				break
+			}
+		}
+		length = minInt(length, 0x10000-int(sym.addr))
+
+		ranges = append(ranges, namedRange{
+			memoryRange: memoryRange{start: int(sym.addr), length: length},
+			name:        sym.name,
+		})
+	}
+	return ranges
+}
+
+// rangeVariable returns a variable for a range of memory, which can be
+// expanded if it's more than a byte.
+func (s *session) rangeVariable(name string, r memoryRange) variable {
+	v := variable{
+		Name:            name,
+		MemoryReference: formatMemoryReference(uint16(r.start)),
+	}
+
+	if r.length == 1 {
+		v.Value = fmt.Sprintf("$%02X", s.device.ReadMemory(uint16(r.start)))
+		v.EvaluateName = fmt.Sprintf("[$%04X]", r.start)
+		return v
+	}
+
+	var bytes []string
+	for i := 0; i < minInt(r.length, bytesPerRow); i++ {
+		bytes = append(bytes, fmt.Sprintf("%02X", s.device.ReadMemory(uint16(r.start+i))))
+	}
+	v.Value = strings.Join(bytes, " ")
+	if r.length > bytesPerRow {
+		v.Value += " ..."
+	}
+
+	s.ranges = append(s.ranges, r)
+	v.VariablesReference = firstRangeReference + len(s.ranges) - 1
+	return v
+}
+
+// expandRange splits a range of memory into at most bytesPerRow smaller
+// ranges.
+func (s *session) expandRange(r memoryRange) []variable {
+	var variables []variable
+	for _, child := range childRanges(r) {
+		variables = append(variables, s.rangeVariable(child.name, child.memoryRange))
+	}
+	return variables
+}
+
+// variableAddress returns the address of the byte that a variable refers
+// to, for variables that aren't registers.
+func (s *session) variableAddress(ref int, name string) (memoryRange, bool) {
+	switch ref {
+	case ioRegistersScope:
+		for _, reg := range ioRegisters {
+			if reg.name == name {
+				return memoryRange{start: int(reg.addr), length: 1}, true
+			}
+		}
+	case symbolsScope, 0:
+		// Watch expressions have no variables reference
+		for _, sym := range s.symbolRanges() {
+			if sym.name == name {
+				return sym.memoryRange, true
+			}
+		}
+	case registersScope, memoryScope:
+	default:
+		i := ref - firstRangeReference
+		if i < 0 || i >= len(s.ranges) {
+			break
+		}
+		for _, child := range childRanges(s.ranges[i]) {
+			if child.name == name {
+				return child.memoryRange, true
+			}
+		}
+	}
+	return memoryRange{}, false
+}
+
+// childRanges splits a range of memory into at most bytesPerRow smaller
+// ranges, named by their addresses.
+func childRanges(r memoryRange) []namedRange {
+	size := 1
+	for size*bytesPerRow < r.length {
+		size *= bytesPerRow
+	}
+
+	var children []namedRange
+	for start := r.start; start < r.start+r.length; start += size {
+		child := memoryRange{
+			start:  start,
+			length: minInt(size, r.start+r.length-start),
+		}
+		name := fmt.Sprintf("%04X", child.start)
+		if child.length > bytesPerRow {
+			name = fmt.Sprintf("%04X-%04X", child.start, child.start+child.length-1)
+		}
+		children = append(children, namedRange{memoryRange: child, name: name})
+	}
+	return children
+}
+
+// setVariable changes a register or the first byte of a variable in memory.
+func (s *session) setVariable(req request) (interface{}, error) {
+	var args setVariableArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+
+	val, err := s.device.Evaluate(s.expandSymbols(args.Value))
+	if err != nil {
+		return nil, err
+	}
+
+	if args.VariablesReference == registersScope {
+		regs := s.device.Registers()
+		if _, ok := getRegister(regs, args.Name); !ok {
+			return nil, xerrors.Errorf("%v can't be changed", args.Name)
+		}
+		size := 0xFF
+		if len(args.Name) == 2 {
+			size = 0xFFFF
+		}
+		if val < 0 || val > size {
+			return nil, xerrors.Errorf("%v doesn't fit in %v", val, args.Name)
+		}
+		setRegister(&regs, args.Name, val)
+		s.device.SetRegisters(regs)
+
+		val, _ = getRegister(s.device.Registers(), args.Name)
+		if size == 0xFF {
+			return setVariableResponseBody{Value: fmt.Sprintf("$%02X", val)}, nil
+		}
+		return setVariableResponseBody{Value: fmt.Sprintf("$%04X", val)}, nil
+	}
+
+	r, ok := s.variableAddress(args.VariablesReference, args.Name)
+	if !ok {
+		return nil, xerrors.Errorf("%v can't be changed", args.Name)
+	}
+	if val < 0 || val > 0xFF {
+		return nil, xerrors.Errorf("%v doesn't fit in a byte", val)
+	}
+	s.device.WriteMemory(uint16(r.start), uint8(val))
+
+	return setVariableResponseBody{
+		Value: fmt.Sprintf("$%02X", s.device.ReadMemory(uint16(r.start))),
+	}, nil
+}
+
+// dataBreakpointInfo reports whether a variable can be watched. Variables in
+// memory can be, and their data IDs are the address range that they span.
+func (s *session) dataBreakpointInfo(req request) (interface{}, error) {
+	var args dataBreakpointInfoArguments
+	if err := decodeArguments(req, &args); err != nil {
+		return nil, err
+	}
+
+	r, ok := s.variableAddress(args.VariablesReference, args.Name)
+	if !ok {
+		return dataBreakpointInfoResponseBody{
+			Description: fmt.Sprintf("%v isn't in memory", args.Name),
+		}, nil
+	}
+
+	dataID := fmt.Sprintf("%04X", r.start)
+	if r.length > 1 {
+		dataID = fmt.Sprintf("%04X-%04X", r.start, r.start+r.length-1)
+	}
+	return dataBreakpointInfoResponseBody{
+		DataID:      &dataID,
+		Description: fmt.Sprintf("%v ($%v)", args.Name, dataID),
+		AccessTypes: []string{"read", "write", "readWrite"},
+	}, nil
+}
+
+// formatValue formats the result of an expression.
+func formatValue(val int) string {
+	switch {
+	case val >= 0 && val <= 0xFF:
+		return fmt.Sprintf("$%02X (%d)", val, val)
+	case val >= 0 && val <= 0xFFFF:
+		return fmt.Sprintf("$%04X (%d)", val, val)
+	default:
+		return fmt.Sprintf("%d", val)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/velovix/gopherboy/gameboy"
+)
+
+// romBankSize is the size of a ROM bank.
+const romBankSize = 0x4000
+
+func main() {
+	output := flag.String("output", "",
+		"Path to write the assembly to. If not provided, it's written to "+
+			"standard output.")
+
+	flag.Parse()
+
+	if len(flag.Args()) < 1 {
+		fmt.Println("Usage: gopherboy_disasm [OPTIONS] rom_file")
+		os.Exit(1)
+	}
+
+	// Load the ROM file
+	cartridgeData, err := ioutil.ReadFile(flag.Args()[0])
+	if err != nil {
+		fmt.Println("Error: While reading cartridge:", err)
+		os.Exit(1)
+	}
+	if len(cartridgeData) == 0 || len(cartridgeData)%romBankSize != 0 {
+		fmt.Printf("Error: ROM size %v is not a multiple of the bank size %v\n",
+			len(cartridgeData), romBankSize)
+		os.Exit(1)
+	}
+
+	header, err := gameboy.ParseROMHeader(cartridgeData)
+	if err != nil {
+		fmt.Println("Error: While reading ROM header:", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			fmt.Println("Error: While creating output file:", err)
+			os.Exit(1)
+		}
+		defer out.Close()
+	}
+
+	if err := disassemble(out, cartridgeData, header); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+// disassemble writes the given ROM to the writer as RGBDS assembly, with one
+// section per ROM bank.
+func disassemble(w io.Writer, cartridgeData []uint8, header gameboy.ROMHeader) error {
+	bankCount := len(cartridgeData) / romBankSize
+
+	banks := make([][]gameboy.Instruction, bankCount)
+	for bank := range banks {
+		var err error
+		banks[bank], err = gameboy.DisassembleROMBank(cartridgeData, bank)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Bank 0 is always mapped, so each bank is labeled along with it. Labels
+	// in bank 0 can come from any bank.
+	labels := make([]map[uint16]string, bankCount)
+	for bank := range labels {
+		labels[bank] = make(map[uint16]string)
+	}
+	for bank := range banks {
+		instructions := banks[0]
+		if bank != 0 {
+			instructions = append(append([]gameboy.Instruction(nil), banks[0]...), banks[bank]...)
+		}
+
+		for addr, label := range gameboy.LabelTargets(instructions) {
+			labelBank := bank
+			if addr < romBankSize {
+				labelBank = 0
+			}
+			addLabel(labels[labelBank], addr, label)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "; Disassembly of %q\n", header.Title)
+	fmt.Fprintln(bw, "; Generated by gopherboy_disasm")
+	fmt.Fprintln(bw)
+
+	registers := gameboy.HardwareRegisterNames()
+	var registerAddrs []int
+	for addr := range registers {
+		registerAddrs = append(registerAddrs, int(addr))
+	}
+	sort.Ints(registerAddrs)
+	for _, addr := range registerAddrs {
+		fmt.Fprintf(bw, "DEF %v EQU $%04X\n", registers[uint16(addr)], addr)
+	}
+
+	for bank, instructions := range banks {
+		fmt.Fprintln(bw)
+		if bank == 0 {
+			fmt.Fprintln(bw, `SECTION "ROM Bank $000", ROM0[$0000]`)
+		} else {
+			fmt.Fprintf(bw, "SECTION \"ROM Bank $%03X\", ROMX[$4000], BANK[$%X]\n", bank, bank)
+		}
+
+		bankLabels := make(map[uint16]string)
+		for addr, label := range labels[0] {
+			bankLabels[addr] = label
+		}
+		for addr, label := range labels[bank] {
+			bankLabels[addr] = label
+		}
+
+		for _, inst := range instructions {
+			if label, ok := labels[bank][inst.Addr]; ok {
+				fmt.Fprintln(bw)
+				fmt.Fprintf(bw, "%v:\n", label)
+			}
+			fmt.Fprintf(bw, "    %-32v ; $%04X: %v\n",
+				inst.Format(bankLabels), inst.Addr, formatBytes(inst.Bytes))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// addLabel adds a label for the given address. If the address already has a
+// label, call labels take precedence over jump labels.
+func addLabel(labels map[uint16]string, addr uint16, label string) {
+	if existing, ok := labels[addr]; ok && strings.HasPrefix(existing, "Call") {
+		return
+	}
+	labels[addr] = label
+}
+
+// formatBytes formats the given bytes as space-separated hex values.
+func formatBytes(bytes []uint8) string {
+	var strs []string
+	for _, b := range bytes {
+		strs = append(strs, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(strs, " ")
+}
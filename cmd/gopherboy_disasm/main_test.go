@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/velovix/gopherboy/gameboy"
+)
+
+// goldenDisassembly is the expected disassembly of the ROM in
+// TestDisassemble, without the filler nop instructions.
+const goldenDisassembly = `; Disassembly of "GOLDEN"
+; Generated by gopherboy_disasm
+
+DEF rP1 EQU $FF00
+DEF rSB EQU $FF01
+DEF rSC EQU $FF02
+DEF rDIV EQU $FF04
+DEF rTIMA EQU $FF05
+DEF rTMA EQU $FF06
+DEF rTAC EQU $FF07
+DEF rIF EQU $FF0F
+DEF rNR10 EQU $FF10
+DEF rNR11 EQU $FF11
+DEF rNR12 EQU $FF12
+DEF rNR13 EQU $FF13
+DEF rNR14 EQU $FF14
+DEF rNR21 EQU $FF16
+DEF rNR22 EQU $FF17
+DEF rNR23 EQU $FF18
+DEF rNR24 EQU $FF19
+DEF rNR30 EQU $FF1A
+DEF rNR31 EQU $FF1B
+DEF rNR32 EQU $FF1C
+DEF rNR33 EQU $FF1D
+DEF rNR34 EQU $FF1E
+DEF rNR41 EQU $FF20
+DEF rNR42 EQU $FF21
+DEF rNR43 EQU $FF22
+DEF rNR44 EQU $FF23
+DEF rNR50 EQU $FF24
+DEF rNR51 EQU $FF25
+DEF rNR52 EQU $FF26
+DEF rLCDC EQU $FF40
+DEF rSTAT EQU $FF41
+DEF rSCY EQU $FF42
+DEF rSCX EQU $FF43
+DEF rLY EQU $FF44
+DEF rLYC EQU $FF45
+DEF rDMA EQU $FF46
+DEF rBGP EQU $FF47
+DEF rOBP0 EQU $FF48
+DEF rOBP1 EQU $FF49
+DEF rWY EQU $FF4A
+DEF rWX EQU $FF4B
+DEF rKEY1 EQU $FF4D
+DEF rVBK EQU $FF4F
+DEF rBANK EQU $FF50
+DEF rHDMA1 EQU $FF51
+DEF rHDMA2 EQU $FF52
+DEF rHDMA3 EQU $FF53
+DEF rHDMA4 EQU $FF54
+DEF rHDMA5 EQU $FF55
+DEF rRP EQU $FF56
+DEF rBCPS EQU $FF68
+DEF rBCPD EQU $FF69
+DEF rOCPS EQU $FF6A
+DEF rOCPD EQU $FF6B
+DEF rSVBK EQU $FF70
+DEF rPCM12 EQU $FF76
+DEF rPCM34 EQU $FF77
+DEF rIE EQU $FFFF
+
+SECTION "ROM Bank $000", ROM0[$0000]
+    jp Jump_000_0150                 ; $0101: C3 50 01
+    db $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00 ; $0104: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00
+    db $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00 ; $0114: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00
+    db $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00 ; $0124: 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00
+    db $47, $4F, $4C, $44, $45, $4E, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00 ; $0134: 47 4F 4C 44 45 4E 00 00 00 00 00 00 00 00 00 00
+    db $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00 ; $0144: 00 00 00 00 00 00 00 00 00 00 00 00
+
+Jump_000_0150:
+    call $4000                       ; $0150: CD 00 40
+    jr Jump_000_0150                 ; $0153: 18 FB
+    db $10, $01                      ; $0155: 10 01
+
+SECTION "ROM Bank $001", ROMX[$4000], BANK[$1]
+
+Call_001_4000:
+    ldh a, [rLY]                     ; $4000: F0 44
+    cp a, $90                        ; $4002: FE 90
+    jr nz, Call_001_4000             ; $4004: 20 FA
+    ret                              ; $4006: C9
+    db $C3                           ; $7FFF: C3
+`
+
+func TestDisassemble(t *testing.T) {
+	rom := make([]uint8, 2*romBankSize)
+	copy(rom[0x0100:], []uint8{
+		0x00,             // nop
+		0xC3, 0x50, 0x01, // jp $0150
+	})
+	copy(rom[0x0134:], "GOLDEN")
+	copy(rom[0x0150:], []uint8{
+		0xCD, 0x00, 0x40, // call $4000
+		0x18, 0xFB, // jr $0150
+		0x10, 0x01, // Not a valid stop
+	})
+	copy(rom[romBankSize:], []uint8{
+		0xF0, 0x44, // ldh a, [rLY]
+		0xFE, 0x90, // cp a, $90
+		0x20, 0xFA, // jr nz, $4000
+		0xC9, // ret
+	})
+	// A jump that continues past the end of the bank
+	rom[2*romBankSize-1] = 0xC3
+
+	header, err := gameboy.ParseROMHeader(rom)
+	if err != nil {
+		t.Fatalf("parsing header: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := disassemble(&out, rom, header); err != nil {
+		t.Fatalf("disassembling: %v", err)
+	}
+
+	var lines []string
+	for _, line := range strings.SplitAfter(out.String(), "\n") {
+		if !strings.HasPrefix(line, "    nop ") {
+			lines = append(lines, line)
+		}
+	}
+	actual := strings.Join(lines, "")
+
+	if actual != goldenDisassembly {
+		actualLines := strings.Split(actual, "\n")
+		expectedLines := strings.Split(goldenDisassembly, "\n")
+		for i := 0; i < len(actualLines) && i < len(expectedLines); i++ {
+			if actualLines[i] != expectedLines[i] {
+				t.Fatalf("line %v is %q, expected %q", i+1, actualLines[i], expectedLines[i])
+			}
+		}
+		t.Fatalf("expected %v lines, got %v", len(expectedLines), len(actualLines))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+var nameToButton = map[string]gameboy.Button{
+	"start":  gameboy.ButtonStart,
+	"select": gameboy.ButtonSelect,
+	"b":      gameboy.ButtonB,
+	"a":      gameboy.ButtonA,
+	"down":   gameboy.ButtonDown,
+	"up":     gameboy.ButtonUp,
+	"left":   gameboy.ButtonLeft,
+	"right":  gameboy.ButtonRight,
+}
+
+// inputEvent is a scripted press or release of a button.
+type inputEvent struct {
+	// frame is the frame number that the event happens at, starting from 0.
+	frame   int
+	button  gameboy.Button
+	pressed bool
+}
+
+// parseInputScript parses an input script. Each line of the script has the
+// format:
+//
+//	<frame> <press|release> <button>
+//
+// Buttons are named start, select, b, a, down, up, left, and right. Blank
+// lines and lines starting with # are ignored. Events must be in order of
+// their frame number.
+func parseInputScript(r io.Reader) ([]inputEvent, error) {
+	var events []inputEvent
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, xerrors.Errorf("line %v: expected 3 fields, got %v", lineNum, len(fields))
+		}
+
+		var event inputEvent
+		var err error
+
+		event.frame, err = strconv.Atoi(fields[0])
+		if err != nil || event.frame < 0 {
+			return nil, xerrors.Errorf("line %v: invalid frame number %q", lineNum, fields[0])
+		}
+		if len(events) > 0 && event.frame < events[len(events)-1].frame {
+			return nil, xerrors.Errorf("line %v: events are out of order", lineNum)
+		}
+
+		switch fields[1] {
+		case "press":
+			event.pressed = true
+		case "release":
+			event.pressed = false
+		default:
+			return nil, xerrors.Errorf("line %v: unknown action %q", lineNum, fields[1])
+		}
+
+		var ok bool
+		event.button, ok = nameToButton[strings.ToLower(fields[2])]
+		if !ok {
+			return nil, xerrors.Errorf("line %v: unknown button %q", lineNum, fields[2])
+		}
+
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("reading input script: %w", err)
+	}
+
+	return events, nil
+}
+
+// inputDriver plays back scripted input events.
+type inputDriver struct {
+	buttonStates map[gameboy.Button]bool
+	// newPress is true if a button was pressed since the last call to Update.
+	newPress bool
+
+	// events are the scripted events that haven't happened yet.
+	events []inputEvent
+}
+
+func newInputDriver(events []inputEvent) *inputDriver {
+	return &inputDriver{
+		buttonStates: make(map[gameboy.Button]bool),
+		events:       events,
+	}
+}
+
+// advance applies all scripted events for the given frame.
+func (driver *inputDriver) advance(frame int) {
+	for len(driver.events) > 0 && driver.events[0].frame <= frame {
+		event := driver.events[0]
+		driver.events = driver.events[1:]
+
+		if event.pressed && !driver.buttonStates[event.button] {
+			driver.newPress = true
+		}
+		driver.buttonStates[event.button] = event.pressed
+	}
+}
+
+func (driver *inputDriver) State(btn gameboy.Button) bool {
+	return driver.buttonStates[btn]
+}
+
+// Update returns true if a button was pressed by the script since the last
+// call.
+func (driver *inputDriver) Update() bool {
+	pressed := driver.newPress
+	driver.newPress = false
+
+	return pressed
+}
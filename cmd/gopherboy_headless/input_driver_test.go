@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/velovix/gopherboy/gameboy"
+)
+
+func TestParseInputScript(t *testing.T) {
+	const script = `# Start the game
+0 press start
+  10	release   Start
+
+# Jump while running
+20 press right
+20 press A
+21 release a
+60 release right
+`
+
+	events, err := parseInputScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("parsing input script: %v", err)
+	}
+
+	expected := []inputEvent{
+		{frame: 0, button: gameboy.ButtonStart, pressed: true},
+		{frame: 10, button: gameboy.ButtonStart, pressed: false},
+		{frame: 20, button: gameboy.ButtonRight, pressed: true},
+		{frame: 20, button: gameboy.ButtonA, pressed: true},
+		{frame: 21, button: gameboy.ButtonA, pressed: false},
+		{frame: 60, button: gameboy.ButtonRight, pressed: false},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v events, got %v: %+v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %v is %+v, expected %+v", i, events[i], expected[i])
+		}
+	}
+}
+
+func TestParseInputScriptButtons(t *testing.T) {
+	for name, button := range nameToButton {
+		events, err := parseInputScript(strings.NewReader("5 press " + name))
+		if err != nil {
+			t.Errorf("parsing %v: %v", name, err)
+			continue
+		}
+		if len(events) != 1 || events[0].button != button {
+			t.Errorf("expected %v to be button %v, got %+v", name, button, events)
+		}
+	}
+}
+
+func TestParseInputScriptErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{"too few fields", "0 press", "line 1: expected 3 fields, got 2"},
+		{"too many fields", "0 press a b", "line 1: expected 3 fields, got 4"},
+		{"frame isn't a number", "# comment\nfirst press a", `line 2: invalid frame number "first"`},
+		{"negative frame", "-1 press a", `line 1: invalid frame number "-1"`},
+		{"out of order", "10 press a\n\n5 release a", "line 3: events are out of order"},
+		{"unknown action", "0 tap a", `line 1: unknown action "tap"`},
+		{"unknown button", "0 press l", `line 1: unknown button "l"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseInputScript(strings.NewReader(test.script))
+			if err == nil {
+				t.Fatalf("parsing succeeded")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected the error to contain %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestInputDriver(t *testing.T) {
+	driver := newInputDriver([]inputEvent{
+		{frame: 2, button: gameboy.ButtonA, pressed: true},
+		{frame: 4, button: gameboy.ButtonA, pressed: false},
+		{frame: 4, button: gameboy.ButtonB, pressed: true},
+	})
+
+	tests := []struct {
+		frame   int
+		a, b    bool
+		pressed bool
+	}{
+		{0, false, false, false},
+		{2, true, false, true},
+		{3, true, false, false},
+		{4, false, true, true},
+		{5, false, true, false},
+	}
+	for _, test := range tests {
+		driver.advance(test.frame)
+		if a, b := driver.State(gameboy.ButtonA), driver.State(gameboy.ButtonB); a != test.a || b != test.b {
+			t.Errorf("frame %v: expected A %v and B %v, got %v and %v", test.frame, test.a, test.b, a, b)
+		}
+		if pressed := driver.Update(); pressed != test.pressed {
+			t.Errorf("frame %v: expected Update to return %v", test.frame, test.pressed)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/velovix/gopherboy/gameboy"
+)
+
+func main() {
+	bootROM := flag.String("boot-rom", "",
+		"Path to a file containing the Game Boy boot ROM. If not provided, "+
+			"the boot sequence is skipped.")
+	frames := flag.Int("frames", 600,
+		"The number of frames to run for")
+	inputScript := flag.String("input-script", "",
+		"Path to a file of scripted input events. Each line has the format "+
+			"'<frame> <press|release> <button>'.")
+	screenshotFrames := flag.String("screenshot-frames", "",
+		"A comma-separated list of frame numbers to take screenshots of, "+
+			"starting from 0")
+	screenshotDir := flag.String("screenshot-dir", ".",
+		"The directory to write screenshots to")
+	finalScreenshot := flag.String("final-screenshot", "",
+		"Path to write a screenshot of the final frame to")
+	untilPC := flag.Int("until-pc", -1,
+		"Stop once the program counter reaches this value")
+	untilAddr := flag.Int("until-addr", -1,
+		"Stop once the memory at this address has the value given by --until-value")
+	untilValue := flag.Int("until-value", -1,
+		"The value to wait for at the address given by --until-addr")
+	linkListen := flag.String("link-listen", "",
+		"An address to wait for another emulator to connect to for link "+
+			"cable play, like ':5000'")
+	linkConnect := flag.String("link-connect", "",
+		"The address of another emulator to connect to for link cable play, "+
+			"like 'localhost:5000'")
+	requireHeaderChecksum := flag.Bool("require-header-checksum", false,
+		"If true, games with a bad header checksum aren't run, since the "+
+			"boot ROM refuses to start them. Otherwise, a warning is printed "+
+			"and the boot sequence will hang unless it's skipped.")
+	saveGameDirectory := flag.String("save-game-dir", "",
+		"The directory to find save games in. If not provided, save games "+
+			"are kept in memory and discarded on exit.")
+
+	flag.Parse()
+
+	if len(flag.Args()) < 1 {
+		fmt.Println("Usage: gopherboy_headless [OPTIONS] rom_file")
+		os.Exit(1)
+	}
+
+	if *frames < 0 {
+		fmt.Println("The number of frames cannot be negative")
+		os.Exit(1)
+	}
+	if (*untilAddr == -1) != (*untilValue == -1) {
+		fmt.Println("--until-addr and --until-value must be provided together")
+		os.Exit(1)
+	}
+
+	screenshotSet := make(map[int]bool)
+	if *screenshotFrames != "" {
+		for _, frameStr := range strings.Split(*screenshotFrames, ",") {
+			frame, err := strconv.Atoi(strings.TrimSpace(frameStr))
+			if err != nil {
+				fmt.Println("Error: Invalid screenshot frame:", frameStr)
+				os.Exit(1)
+			}
+			screenshotSet[frame] = true
+		}
+	}
+
+	var opts []gameboy.Option
+	if *requireHeaderChecksum {
+		opts = append(opts, gameboy.WithHeaderChecksumValidation())
+	}
+
+	var bootROMData []byte
+	var err error
+	if *bootROM == "" {
+		opts = append(opts, gameboy.WithoutBootROM())
+	} else {
+		// Load the boot ROM
+		bootROMData, err = ioutil.ReadFile(*bootROM)
+		if err != nil {
+			fmt.Println("Error: While reading boot ROM:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Load the ROM file
+	cartridgeData, err := ioutil.ReadFile(flag.Args()[0])
+	if err != nil {
+		fmt.Println("Error: While reading cartridge:", err)
+		os.Exit(1)
+	}
+
+	var events []inputEvent
+	if *inputScript != "" {
+		file, err := os.Open(*inputScript)
+		if err != nil {
+			fmt.Println("Error: While opening input script:", err)
+			os.Exit(1)
+		}
+		events, err = parseInputScript(file)
+		file.Close()
+		if err != nil {
+			fmt.Println("Error: While parsing input script:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *linkListen != "" && *linkConnect != "" {
+		fmt.Println("Only one of --link-listen and --link-connect may be provided")
+		os.Exit(1)
+	}
+
+	var link *gameboy.TCPSerialDriver
+	if *linkListen != "" {
+		fmt.Println("Waiting for a link cable connection on", *linkListen)
+		link, err = gameboy.ListenTCPLink(*linkListen)
+		if err != nil {
+			fmt.Println("Error: While waiting for link cable connection:", err)
+			os.Exit(1)
+		}
+	} else if *linkConnect != "" {
+		link, err = gameboy.DialTCPLink(*linkConnect)
+		if err != nil {
+			fmt.Println("Error: While connecting link cable:", err)
+			os.Exit(1)
+		}
+	}
+	if link != nil {
+		fmt.Println("Link cable connected")
+		defer link.Close()
+		opts = append(opts, gameboy.WithSerial(link))
+	}
+
+	video := newVideoDriver()
+	input := newInputDriver(events)
+	var saveGames gameboy.SaveGameDriver
+	if *saveGameDirectory == "" {
+		saveGames = newMemorySaveGameDriver()
+	} else {
+		saveGames = &fileSaveGameDriver{directory: *saveGameDirectory}
+	}
+
+	device, err := gameboy.NewDevice(bootROMData, cartridgeData, video, input, saveGames, gameboy.DebugConfiguration{}, opts...)
+	if err != nil {
+		fmt.Println("Error: While initializing Game Boy:", err)
+		os.Exit(1)
+	}
+
+	// Build the stop condition, if any
+	var conditions []func() bool
+	if *untilPC != -1 {
+		pc := uint16(*untilPC)
+		conditions = append(conditions, func() bool {
+			return device.Registers().PC == pc
+		})
+	}
+	if *untilAddr != -1 {
+		addr := uint16(*untilAddr)
+		val := uint8(*untilValue)
+		conditions = append(conditions, func() bool {
+			return device.ReadMemory(addr) == val
+		})
+	}
+	var condition func() bool
+	if len(conditions) > 0 {
+		condition = func() bool {
+			for _, cond := range conditions {
+				if cond() {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	conditionMet := false
+	frame := 0
+	for ; frame < *frames && !conditionMet; frame++ {
+		input.advance(frame)
+
+		conditionMet, err = device.RunFrameUntil(condition)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		if screenshotSet[frame] {
+			filename := path.Join(*screenshotDir, fmt.Sprintf("frame_%v.png", frame))
+			if err := video.screenshot(filename); err != nil {
+				fmt.Println("Error: While taking screenshot:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *finalScreenshot != "" {
+		if err := video.screenshot(*finalScreenshot); err != nil {
+			fmt.Println("Error: While taking screenshot:", err)
+			os.Exit(1)
+		}
+	}
+
+	err = device.Close()
+	if err != nil {
+		fmt.Println("Error: While shutting down:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Ran for", frame, "frames")
+	if len(conditions) > 0 {
+		if !conditionMet {
+			fmt.Println("Stop condition was not met")
+			os.Exit(1)
+		}
+		fmt.Println("Stop condition was met")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// videoDriver keeps the most recently rendered frame in memory instead of
+// displaying it.
+type videoDriver struct {
+	// frame is the last frame that was rendered.
+	frame *image.RGBA
+}
+
+func newVideoDriver() *videoDriver {
+	return &videoDriver{
+		frame: image.NewRGBA(image.Rect(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight)),
+	}
+}
+
+// Render saves the given frame as the most recent one.
+func (vd *videoDriver) Render(frameData []uint8) error {
+	copy(vd.frame.Pix, frameData)
+
+	return nil
+}
+
+// Close does nothing, since this driver has no resources.
+func (vd *videoDriver) Close() {}
+
+// screenshot writes the most recently rendered frame to a PNG file.
+func (vd *videoDriver) screenshot(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return xerrors.Errorf("creating screenshot file: %w", err)
+	}
+	defer file.Close()
+
+	err = png.Encode(file, vd.frame)
+	if err != nil {
+		return xerrors.Errorf("encoding screenshot: %w", err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// loadCheats adds the cheats in the given cheat list file to the device. It's
+// not an error for the file to not exist yet.
+func loadCheats(device *gameboy.Device, filename string) error {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return xerrors.Errorf("opening cheat list: %w", err)
+	}
+	defer file.Close()
+
+	if err := device.Cheats().Load(file); err != nil {
+		return xerrors.Errorf("loading cheat list %v: %w", filename, err)
+	}
+
+	return nil
+}
+
+// saveCheats writes the device's cheats to the given cheat list file.
+func saveCheats(device *gameboy.Device, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return xerrors.Errorf("creating cheat list: %w", err)
+	}
+
+	if err := device.Cheats().Save(file); err != nil {
+		file.Close()
+		return xerrors.Errorf("saving cheat list %v: %w", filename, err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	"github.com/velovix/gopherboy/gameboy"
+	"golang.org/x/xerrors"
+)
+
+// cameraCapturesPerImage is the number of camera captures that each image in
+// a sequence is shown for. The camera captures roughly 15 images a second.
+const cameraCapturesPerImage = 30
+
+// loadImageSource creates a camera image source that cycles through the
+// images in the given files.
+func loadImageSource(filenames []string) (*gameboy.ImageSequenceSource, error) {
+	var images []image.Image
+
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, xerrors.Errorf("opening camera image: %w", err)
+		}
+
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			return nil, xerrors.Errorf("decoding camera image %v: %w", filename, err)
+		}
+
+		images = append(images, img)
+	}
+
+	return gameboy.NewImageSequenceSource(images, cameraCapturesPerImage), nil
+}
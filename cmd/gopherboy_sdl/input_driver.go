@@ -16,8 +16,37 @@ var scancodeToButton = map[sdl.Scancode]gameboy.Button{
 	sdl.SCANCODE_RIGHT: gameboy.ButtonRight,
 }
 
+// rewindScancode is the key that rewinds gameplay while held.
+const rewindScancode = sdl.SCANCODE_BACKSPACE
+
+// scancodeToTilt maps keys to the direction they tilt the Game Boy in, for
+// cartridges with an accelerometer.
+var scancodeToTilt = map[sdl.Scancode][2]float64{
+	sdl.SCANCODE_I: {0, 1},
+	sdl.SCANCODE_K: {0, -1},
+	sdl.SCANCODE_J: {-1, 0},
+	sdl.SCANCODE_L: {1, 0},
+}
+
 type inputDriver struct {
 	buttonStates map[gameboy.Button]bool
+
+	// rewindHeld is true while the rewind key is held down.
+	rewindHeld bool
+	// onRewind is called on every update while the rewind key is held down,
+	// if it's set.
+	onRewind func()
+
+	// tiltKeysHeld contains the tilt keys that are held down.
+	tiltKeysHeld map[sdl.Scancode]bool
+	// mouseTilt is true if the mouse position should control tilt instead
+	// of the keyboard.
+	mouseTilt bool
+	// windowWidth and windowHeight are the size of the window, used to find
+	// the mouse's position relative to the center.
+	windowWidth, windowHeight int32
+	// The last known position of the mouse
+	mouseX, mouseY int32
 }
 
 func newInputDriver() *inputDriver {
@@ -34,6 +63,7 @@ func newInputDriver() *inputDriver {
 		gameboy.ButtonLeft:   false,
 		gameboy.ButtonRight:  false,
 	}
+	driver.tiltKeysHeld = make(map[sdl.Scancode]bool)
 
 	return &driver
 }
@@ -52,6 +82,15 @@ func (driver *inputDriver) Update() bool {
 		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 			switch event := event.(type) {
 			case *sdl.KeyboardEvent:
+				if event.Keysym.Scancode == rewindScancode {
+					driver.rewindHeld = event.State == sdl.PRESSED
+					continue
+				}
+				if _, ok := scancodeToTilt[event.Keysym.Scancode]; ok {
+					driver.tiltKeysHeld[event.Keysym.Scancode] = event.State == sdl.PRESSED
+					continue
+				}
+
 				btn := scancodeToButton[event.Keysym.Scancode]
 
 				if _, ok := driver.buttonStates[btn]; ok {
@@ -62,9 +101,36 @@ func (driver *inputDriver) Update() bool {
 						driver.buttonStates[btn] = false
 					}
 				}
+			case *sdl.MouseMotionEvent:
+				driver.mouseX = event.X
+				driver.mouseY = event.Y
 			}
 		}
 	}, false)
 
+	if driver.rewindHeld && driver.onRewind != nil {
+		driver.onRewind()
+	}
+
 	return buttonPressed
 }
+
+// Tilt reports how the Game Boy is tilted based on the tilt keys or, if mouse
+// tilt is enabled, the mouse's position relative to the center of the window.
+func (driver *inputDriver) Tilt() (x, y float64) {
+	if driver.mouseTilt && driver.windowWidth > 0 && driver.windowHeight > 0 {
+		centerX := float64(driver.windowWidth) / 2
+		centerY := float64(driver.windowHeight) / 2
+		x = (float64(driver.mouseX) - centerX) / centerX
+		y = (centerY - float64(driver.mouseY)) / centerY
+		return x, y
+	}
+
+	for scancode, held := range driver.tiltKeysHeld {
+		if held {
+			x += scancodeToTilt[scancode][0]
+			y += scancodeToTilt[scancode][1]
+		}
+	}
+	return x, y
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 
 	"github.com/pkg/profile"
 	"github.com/velovix/gopherboy/gameboy"
+	"github.com/velovix/gopherboy/patch"
 )
 
 func init() {
@@ -18,6 +21,10 @@ func init() {
 	runtime.LockOSThread()
 }
 
+// rewindStepFrames is the number of frames to rewind for every frame that the
+// rewind key is held.
+const rewindStepFrames = 2
+
 // mainThreadFuncs contains functions queued up to run on the main thread.
 var mainThreadFuncs = make(chan func())
 
@@ -34,9 +41,23 @@ func doOnMainThread(f func(), async bool) {
 	}
 }
 
+// stringList is a flag that can be provided multiple times, collecting each
+// value.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(val string) error {
+	*list = append(*list, val)
+	return nil
+}
+
 func main() {
 	bootROM := flag.String("boot-rom", "",
-		"Path to a file containing the Game Boy boot ROM")
+		"Path to a file containing the Game Boy boot ROM. If not provided, "+
+			"the boot sequence is skipped.")
 	scaleFactor := flag.Float64("scale", 2,
 		"The amount to scale the window by, with 1 being native resolution")
 	breakOnPC := flag.Int("break-on-pc", -1,
@@ -47,12 +68,58 @@ func main() {
 		"A memory address to break at on read")
 	breakOnAddrWrite := flag.Int("break-on-addr-write", -1,
 		"A memory address to break at on write")
+	var breakpoints stringList
+	flag.Var(&breakpoints, "break",
+		"A breakpoint to stop at before running code, like '03:4567' or "+
+			"'0150 if a == $10'. May be provided multiple times. More "+
+			"breakpoints can be added from the debugger console.")
+	var watchpoints stringList
+	flag.Var(&watchpoints, "watch",
+		"An address or range to stop at after it's written to, like 'C000' "+
+			"or 'C000-C0FF if a == 0'. May be provided multiple times.")
+	gdbPort := flag.Int("gdb-port", 0,
+		"A port to wait for GDB to connect on. GDB takes the place of the "+
+			"debugger console.")
 	enableProfiling := flag.Bool("profile", false,
 		"Generates a pprof file if set")
 	unlimitedFPS := flag.Bool("unlimited-fps", false,
 		"If true, frame rate will not be capped. Games will run as quickly as possible.")
 	saveGameDirectory := flag.String("save-game-dir", ".",
 		"The directory to find save games in")
+	rewindBudget := flag.Int("rewind-budget", 32,
+		"The amount of memory in megabytes to use for rewind history. Hold "+
+			"backspace to rewind. If 0, rewinding is disabled.")
+	linkListen := flag.String("link-listen", "",
+		"An address to wait for another emulator to connect to for link "+
+			"cable play, like ':5000'")
+	linkConnect := flag.String("link-connect", "",
+		"The address of another emulator to connect to for link cable play, "+
+			"like 'localhost:5000'")
+	printer := flag.Bool("printer", false,
+		"If true, a Game Boy Printer is connected to the link port. Printed "+
+			"images are saved as PNG files in the print directory.")
+	printDirectory := flag.String("print-dir", ".",
+		"The directory to save printed images in")
+	cameraImages := flag.String("camera-images", "",
+		"A comma-separated list of image files for the Game Boy Camera to "+
+			"see. If more than one is given, the camera cycles through them.")
+	mouseTilt := flag.Bool("mouse-tilt", false,
+		"If true, the mouse's position in the window controls tilt for "+
+			"cartridges with an accelerometer. Otherwise, the I, J, K and L "+
+			"keys do.")
+	requireHeaderChecksum := flag.Bool("require-header-checksum", false,
+		"If true, games with a bad header checksum aren't run, since the "+
+			"boot ROM refuses to start them. Otherwise, a warning is printed "+
+			"and the boot sequence will hang unless it's skipped.")
+	cheatsFile := flag.String("cheats", "",
+		"Path to a cheat list file for this ROM. Each line has the format "+
+			"'<on|off> <code> [description]', where the code is a Game Genie "+
+			"or GameShark code. The list is saved back to the file on exit.")
+	var patches stringList
+	flag.Var(&patches, "patch",
+		"Path to an IPS, UPS or BPS patch to apply to the ROM. The ROM file "+
+			"itself is left untouched. May be provided multiple times, in "+
+			"which case patches are applied in order.")
 	benchmarkComponents := flag.Bool("benchmark-components", false,
 		"If true, some performance information will be printed out about each "+
 			"component, then the emulator will exit.")
@@ -61,21 +128,27 @@ func main() {
 	flag.Parse()
 
 	if len(flag.Args()) < 1 {
-		fmt.Println("Usage: gopherboy --boot-rom boot_rom_file [OPTIONS] rom_file")
+		fmt.Println("Usage: gopherboy [OPTIONS] rom_file")
 		os.Exit(1)
 	}
 
+	var opts []gameboy.Option
+	if *requireHeaderChecksum {
+		opts = append(opts, gameboy.WithHeaderChecksumValidation())
+	}
+
 	var bootROMData []byte
 	var err error
 	if *bootROM == "" {
-		fmt.Println("A boot ROM must be provided")
-		os.Exit(1)
-	}
-	// Load the boot ROM
-	bootROMData, err = ioutil.ReadFile(*bootROM)
-	if err != nil {
-		fmt.Println("Error: While reading boot ROM:", err)
-		os.Exit(1)
+		fmt.Println("No boot ROM provided, skipping the boot sequence")
+		opts = append(opts, gameboy.WithoutBootROM())
+	} else {
+		// Load the boot ROM
+		bootROMData, err = ioutil.ReadFile(*bootROM)
+		if err != nil {
+			fmt.Println("Error: While reading boot ROM:", err)
+			os.Exit(1)
+		}
 	}
 
 	if *scaleFactor <= 0 {
@@ -105,6 +178,18 @@ func main() {
 		fmt.Println("Error: While reading cartridge:", err)
 		os.Exit(1)
 	}
+	for _, patchFile := range patches {
+		patchData, err := ioutil.ReadFile(patchFile)
+		if err != nil {
+			fmt.Println("Error: While reading patch:", err)
+			os.Exit(1)
+		}
+		cartridgeData, err = patch.Apply(cartridgeData, patchData)
+		if err != nil {
+			fmt.Printf("Error: While applying patch %v: %v\n", patchFile, err)
+			os.Exit(1)
+		}
+	}
 
 	video, err := newVideoDriver(*scaleFactor, *unlimitedFPS)
 	if err != nil {
@@ -116,6 +201,9 @@ func main() {
 		fmt.Println("Error: While initializing input driver:", err)
 		os.Exit(1)
 	}
+	input.mouseTilt = *mouseTilt
+	input.windowWidth = int32(gameboy.ScreenWidth * *scaleFactor)
+	input.windowHeight = int32(gameboy.ScreenHeight * *scaleFactor)
 	saveGames := &fileSaveGameDriver{
 		directory: *saveGameDirectory,
 	}
@@ -123,7 +211,7 @@ func main() {
 	var dbConfig gameboy.DebugConfiguration
 
 	if *breakOnPC != -1 || *breakOnOpcode != -1 || *breakOnAddrRead != -1 ||
-		*breakOnAddrWrite != -1 {
+		*breakOnAddrWrite != -1 || len(breakpoints) > 0 || len(watchpoints) > 0 {
 
 		dbConfig.Debugging = true
 		if *breakOnPC != -1 {
@@ -142,14 +230,111 @@ func main() {
 			val := uint16(*breakOnAddrWrite)
 			dbConfig.BreakOnAddrWrite = &val
 		}
+		for _, spec := range breakpoints {
+			bp, err := gameboy.ParseBreakpoint(gameboy.BreakOnExecute, spec)
+			if err != nil {
+				fmt.Printf("Error: Invalid breakpoint '%v': %v\n", spec, err)
+				os.Exit(1)
+			}
+			dbConfig.Breakpoints = append(dbConfig.Breakpoints, bp)
+		}
+		for _, spec := range watchpoints {
+			bp, err := gameboy.ParseBreakpoint(gameboy.BreakOnWrite, spec)
+			if err != nil {
+				fmt.Printf("Error: Invalid watchpoint '%v': %v\n", spec, err)
+				os.Exit(1)
+			}
+			dbConfig.Breakpoints = append(dbConfig.Breakpoints, bp)
+		}
+	}
+
+	if *gdbPort != 0 {
+		gdbServer, err := gameboy.ListenGDB(fmt.Sprintf("localhost:%v", *gdbPort))
+		if err != nil {
+			fmt.Printf("Error: Starting GDB server: %v\n", err)
+			os.Exit(1)
+		}
+		defer gdbServer.Close()
+		fmt.Println("Waiting for GDB to connect on", gdbServer.Addr())
+
+		dbConfig.Debugging = true
+		dbConfig.Driver = gdbServer
+	}
+
+	if *rewindBudget > 0 {
+		opts = append(opts, gameboy.WithRewind(*rewindBudget*1024*1024))
+	}
+
+	if *linkListen != "" && *linkConnect != "" {
+		fmt.Println("Only one of --link-listen and --link-connect may be provided")
+		os.Exit(1)
+	}
+	if *printer && (*linkListen != "" || *linkConnect != "") {
+		fmt.Println("The printer can't be used with a link cable connection")
+		os.Exit(1)
+	}
+
+	var link *gameboy.TCPSerialDriver
+	if *linkListen != "" {
+		fmt.Println("Waiting for a link cable connection on", *linkListen)
+		link, err = gameboy.ListenTCPLink(*linkListen)
+		if err != nil {
+			fmt.Println("Error: While waiting for link cable connection:", err)
+			os.Exit(1)
+		}
+	} else if *linkConnect != "" {
+		link, err = gameboy.DialTCPLink(*linkConnect)
+		if err != nil {
+			fmt.Println("Error: While connecting link cable:", err)
+			os.Exit(1)
+		}
+	}
+	if link != nil {
+		fmt.Println("Link cable connected")
+		defer link.Close()
+		opts = append(opts, gameboy.WithSerial(link))
+	}
+	var gbPrinter *gameboy.Printer
+	if *printer {
+		gbPrinter = gameboy.NewPrinter(&pngPrinterDriver{directory: *printDirectory})
+		opts = append(opts, gameboy.WithSerial(gbPrinter))
 	}
 
-	device, err := gameboy.NewDevice(bootROMData, cartridgeData, video, input, saveGames, dbConfig)
+	if *cameraImages != "" {
+		imageSource, err := loadImageSource(strings.Split(*cameraImages, ","))
+		if err != nil {
+			fmt.Println("Error: While loading camera images:", err)
+			os.Exit(1)
+		}
+		opts = append(opts, gameboy.WithImageSource(imageSource))
+	}
+	opts = append(opts, gameboy.WithTilt(input))
+
+	device, err := gameboy.NewDevice(bootROMData, cartridgeData, video, input, saveGames, dbConfig, opts...)
 	if err != nil {
 		fmt.Println("Error: While initializing Game Boy:", err)
 		os.Exit(1)
 	}
 
+	if *cheatsFile != "" {
+		if err := loadCheats(device, *cheatsFile); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		for _, cheat := range device.Cheats().Cheats() {
+			fmt.Printf("Loaded %v cheat %v (enabled: %v) %v\n",
+				cheat.Type, cheat.Code, cheat.Enabled, cheat.Description)
+		}
+	}
+
+	if *rewindBudget > 0 {
+		input.onRewind = func() {
+			if err := device.Rewind(rewindStepFrames); err != nil {
+				fmt.Println("Error: While rewinding:", err)
+			}
+		}
+	}
+
 	_, err = newSoundDriver(device)
 	if err != nil {
 		fmt.Println("Error: While initializing sound driver:", err)
@@ -162,26 +347,42 @@ func main() {
 	}
 
 	// Stop main loop on sigint
+	ctx, cancel := context.WithCancel(context.Background())
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, os.Interrupt)
-	onExitRequest := make(chan bool)
 	go func() {
-		for range sigint {
-			onExitRequest <- true
-			break
-		}
+		<-sigint
+		cancel()
 	}()
 
 	onDeviceExit := make(chan bool)
 
 	// Start the device
 	go func() {
-		err = device.Start(onExitRequest)
+		err = device.Start(ctx)
 		if err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
 
+		err = device.Close()
+		if err != nil {
+			fmt.Println("Error: While shutting down:", err)
+			os.Exit(1)
+		}
+
+		if *cheatsFile != "" {
+			if err := saveCheats(device, *cheatsFile); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+
+		if gbPrinter != nil && gbPrinter.Err() != nil {
+			fmt.Println("Error: While saving a print:", gbPrinter.Err())
+			os.Exit(1)
+		}
+
 		onDeviceExit <- true
 	}()
 
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// pngPrinterDriver saves images printed by the Game Boy Printer as PNG files.
+type pngPrinterDriver struct {
+	directory string
+}
+
+// Print saves the image to a new PNG file named after the time it was
+// printed. Margins are not included in the image.
+func (driver *pngPrinterDriver) Print(img image.Image, topMargin, bottomMargin int) error {
+	filename := path.Join(driver.directory,
+		fmt.Sprintf("print_%v.png", time.Now().Format("20060102_150405.000")))
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return xerrors.Errorf("creating print file: %w", err)
+	}
+	defer file.Close()
+
+	err = png.Encode(file, img)
+	if err != nil {
+		return xerrors.Errorf("encoding print: %w", err)
+	}
+
+	fmt.Println("Saved print to", filename)
+
+	return file.Close()
+}
@@ -23,11 +23,12 @@ var myDevice *gameboy.Device
 var samples = make(chan float64, totalHz*3)
 
 var (
-	pulseAPhase = 0.0
-	pulseBPhase = 0.0
-	wavePhase   = 0.0
-	shiftCount  = 0.0
-	lfsr        = uint16(0x38C2)
+	pulseAPhase  = 0.0
+	pulseBPhase  = 0.0
+	wavePhase    = 0.0
+	speakerPhase = 0.0
+	shiftCount   = 0.0
+	lfsr         = uint16(0x38C2)
 )
 
 const tau = math.Pi * 2.0
@@ -63,7 +64,15 @@ func SoundCallback(userdata unsafe.Pointer, bufferRaw *C.uint8_t, rawLength C.in
 	pulseBPhaseDelta := tau * myDevice.SoundController.PulseB.Frequency() / totalHz
 	wavePhaseDelta := tau * myDevice.SoundController.Wave.Frequency() / totalHz
 
-	if !myDevice.SoundController.Enabled {
+	// The cartridge's speaker plays even when the sound controller is off
+	speaker := myDevice.SoundController.Speaker
+	speakerOn := speaker != nil && speaker.On
+	var speakerPhaseDelta float64
+	if speakerOn {
+		speakerPhaseDelta = tau * speaker.Frequency() / totalHz
+	}
+
+	if !myDevice.SoundController.Enabled && !speakerOn {
 		// Fill the buffer with zeros
 		for i := 0; i < length; i++ {
 			buffer[i] = 0
@@ -157,6 +166,19 @@ func SoundCallback(userdata unsafe.Pointer, bufferRaw *C.uint8_t, rawLength C.in
 		sampleLeft *= myDevice.SoundController.LeftVolume()
 		sampleRight *= myDevice.SoundController.RightVolume()
 
+		if !myDevice.SoundController.Enabled {
+			sampleLeft, sampleRight = 0, 0
+		}
+
+		// Handle the cartridge's speaker, which isn't affected by the
+		// sound controller's volume
+		if speakerOn {
+			speakerPhase += speakerPhaseDelta
+			sample := square(speakerPhase, 0.5) / 4.0
+			sampleLeft += sample
+			sampleRight += sample
+		}
+
 		buffer[i] = C.uint8_t(sampleLeft * 25)
 		buffer[i+1] = C.uint8_t(sampleRight * 25)
 	}
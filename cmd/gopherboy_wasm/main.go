@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"syscall/js"
 
@@ -16,15 +17,20 @@ func main() {
 
 	var cartridgeData []byte
 	var bootROMData []byte
+	skipBootROM := false
 
 	// Wait for data
 	dataEvents := make(chan message)
 	eventHandler.subscribers = append(eventHandler.subscribers, dataEvents)
 
-	for cartridgeData == nil || bootROMData == nil {
+	for cartridgeData == nil || (bootROMData == nil && !skipBootROM) {
 		msg := <-dataEvents
 
 		switch msg.kind {
+		case "SkipBootROM":
+			skipBootROM = true
+
+			fmt.Println("emulator: Boot ROM will be skipped")
 		case "BootROMData":
 			bootROMData = make([]uint8, msg.data.Length())
 			for i := 0; i < msg.data.Length(); i++ {
@@ -59,13 +65,18 @@ func main() {
 	}
 	eventHandler.subscribers = append(eventHandler.subscribers, input.messages)
 
-	device, err := gameboy.NewDevice(bootROMData, cartridgeData, video, input, &mockSaveGameDriver{}, gameboy.DebugConfiguration{})
+	var opts []gameboy.Option
+	if skipBootROM {
+		opts = append(opts, gameboy.WithoutBootROM())
+	}
+
+	device, err := gameboy.NewDevice(bootROMData, cartridgeData, video, input, &mockSaveGameDriver{}, gameboy.DebugConfiguration{}, opts...)
 	if err != nil {
 		fmt.Println("Error: While initializing Game Boy:", err)
 		return
 	}
 
-	onExit := make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
 
 	onStopMessage := make(chan message)
 	eventHandler.subscribers = append(eventHandler.subscribers, onStopMessage)
@@ -75,15 +86,21 @@ func main() {
 
 			if msg.kind == "Stop" {
 				fmt.Println("Received stop request")
-				onExit <- true
+				cancel()
 				break
 			}
 		}
 	}()
 
-	err = device.Start(onExit)
+	err = device.Start(ctx)
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
 	}
+
+	err = device.Close()
+	if err != nil {
+		fmt.Println("Error: While shutting down:", err)
+		return
+	}
 }
@@ -50,6 +50,12 @@ function main() {
     fileReader.readAsArrayBuffer(files[0]);
   });
 
+  let skipBootROMButton = document.getElementById('skip-boot-rom-button');
+  skipBootROMButton.onclick = function() {
+    emulatorWorker.postMessage(['SkipBootROM', '']);
+    console.log('js: Requested that the boot ROM be skipped');
+  };
+
   let display = document.getElementById('frame-display');
   let displayContext = display.getContext('2d');
 
@@ -260,12 +260,21 @@ const (
 	// controls the position of the window in the X direction.
 	windowPosXAddr = 0xFF4B
 
-	// key1Addr has something to do with switching to double-speed mode on the
-	// CGB. Since this is a DMG emulator for the time being, this is unused.
+	// key1Addr points to the KEY1 register, which is used to switch the CGB
+	// between normal and double-speed mode. Unused on the DMG.
+	//
+	// Bit 7: The current speed. 0 is normal speed, 1 is double-speed.
+	//        (read-only)
+	// Bits 6-1: Unused, always 1
+	// Bit 0: If 1, the speed will be switched the next time a STOP
+	//        instruction is run.
 	key1Addr = 0xFF4D
 
-	// vbkAddr is a VRAM bank register. It's a CGB thing that this emulator
-	// doesn't have to worry about yet
+	// vbkAddr points to the VRAM bank register, which selects which of the
+	// CGB's two VRAM banks is mapped to 0x8000-0x9FFF. Unused on the DMG.
+	//
+	// Bits 7-1: Unused, always 1
+	// Bit 0: The selected VRAM bank
 	vbkAddr = 0xFF4F
 
 	// lcdcAddr points to the LCDC memory register, which controls various
@@ -343,24 +352,48 @@ const (
 	// boot ROM. The boot ROM itself writes to this when it is finished.
 	bootROMDisableAddr = 0xFF50
 
-	// These addresses have something to do with a "new" DMA transfer mode
-	// introduced in the CGB. We don't have to worry about this since this is a
-	// DMG emulator.
+	// These addresses control the CGB's VRAM DMA transfers, which copy data
+	// from ROM or RAM into VRAM. Unused on the DMG.
+	//
+	// HDMA1 and HDMA2 are the upper and lower bytes of the source address.
+	// HDMA3 and HDMA4 are the upper and lower bytes of the destination
+	// address in VRAM. Writing to HDMA5 starts a transfer.
+	//
+	// HDMA5:
+	// Bit 7: The transfer mode.
+	//   0: General purpose DMA. All data is transferred at once.
+	//   1: H-Blank DMA. 0x10 bytes are transferred every H-Blank.
+	// Bits 6-0: The length of the transfer divided by 0x10, minus 1
 	hdma1Addr = 0xFF51
 	hdma2Addr = 0xFF52
 	hdma3Addr = 0xFF53
 	hdma4Addr = 0xFF54
 	hdma5Addr = 0xFF55
 
-	// svbkAddr is a WRAM bank controller thing for the CGB. Unused on the DMG.
+	// svbkAddr points to the WRAM bank register, which selects which WRAM
+	// bank is mapped to 0xD000-0xDFFF on the CGB. Unused on the DMG.
+	//
+	// Bits 7-3: Unused, always 1
+	// Bits 2-0: The selected WRAM bank. A value of 0 selects bank 1.
 	svbkAddr = 0xFF70
 
 	// rpAddr is some register for controlling infrared communications. Only
 	// the CGB has infrared so this goes unused on the DMG.
 	rpAddr = 0xFF56
 
-	// These addresses have something to do with the CGB color palette. They go
+	// These addresses provide access to the CGB's color palette RAM. They go
 	// unused on the DMG.
+	//
+	// BCPS and OCPS select a byte in background and sprite palette RAM,
+	// respectively.
+	// Bit 7: If 1, the selected index is incremented after every write to the
+	//        data register.
+	// Bit 6: Unused, always 1
+	// Bits 5-0: The selected index in palette RAM
+	//
+	// BCPD and OCPD read and write the selected byte of palette RAM. Each
+	// color is two bytes, in little-endian 15-bit RGB. Each palette has 4
+	// colors, and there are 8 background and 8 sprite palettes.
 	bcpsAddr = 0xFF68
 	bcpdAddr = 0xFF69
 	ocpsAddr = 0xFF6A
@@ -415,13 +448,16 @@ const (
 
 	// scAddr points to the SIO Control Memory Register, which allows for
 	// serial communication control.
-	// TODO(velovix): Fill this out more as I learn more about Game Boy serial.
 	//
-	// Bit 7: If set to 1, a transfer will be initiated
-	// Bit 6-1: Unused, always 1
-	// Bit 0: Shift clock TODO(velovix): What is this?
-	//        0: External clock
-	//        1: Internal clock
+	// Bit 7: If set to 1, a transfer will be initiated. It stays set until
+	//        the transfer is finished.
+	// Bit 6-2: Unused, always 1
+	// Bit 1: Clock speed, only on the CGB. Unused and always 1 otherwise.
+	//        0: Normal speed, 8192 bits per second
+	//        1: Fast speed, 262144 bits per second
+	// Bit 0: Shift clock, which decides which Game Boy drives the transfer
+	//        0: External clock, provided by the peer
+	//        1: Internal clock, provided by this Game Boy
 	scAddr = 0xFF02
 
 	// These variables points to the OBP0 and OBP1 memory registers, which
@@ -0,0 +1,292 @@
+package gameboy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrDebuggerDisabled is returned when breakpoints are used on a device
+	// that wasn't created with debugging enabled.
+	ErrDebuggerDisabled = xerrors.New("debugging is not enabled")
+	// ErrNoSuchBreakpoint is returned when a breakpoint ID doesn't refer to
+	// an existing breakpoint.
+	ErrNoSuchBreakpoint = xerrors.New("no such breakpoint")
+)
+
+// BreakpointKind is the kind of event that a breakpoint stops emulation on.
+type BreakpointKind int
+
+const (
+	// BreakOnExecute stops before an instruction in the breakpoint's address
+	// range is run.
+	BreakOnExecute BreakpointKind = iota
+	// BreakOnRead stops after the CPU reads from the breakpoint's address
+	// range.
+	BreakOnRead
+	// BreakOnWrite stops after the CPU writes to the breakpoint's address
+	// range.
+	BreakOnWrite
+	// BreakOnAccess stops after the CPU reads from or writes to the
+	// breakpoint's address range.
+	BreakOnAccess
+	// BreakOnInterrupt stops when an interrupt whose handler is in the
+	// breakpoint's address range is dispatched.
+	BreakOnInterrupt
+)
+
+func (kind BreakpointKind) String() string {
+	switch kind {
+	case BreakOnExecute:
+		return "breakpoint"
+	case BreakOnRead:
+		return "read watchpoint"
+	case BreakOnWrite:
+		return "write watchpoint"
+	case BreakOnAccess:
+		return "access watchpoint"
+	case BreakOnInterrupt:
+		return "interrupt breakpoint"
+	default:
+		return fmt.Sprintf("BreakpointKind(%d)", int(kind))
+	}
+}
+
+// AnyBank is used as a breakpoint's bank to match any ROM bank.
+const AnyBank = -1
+
+// interruptNames maps the names used to refer to interrupts in breakpoint
+// specs to their handler addresses.
+var interruptNames = map[string]uint16{
+	"vblank": vblankInterruptTarget,
+	"stat":   lcdcInterruptTarget,
+	"timer":  timaOverflowInterruptTarget,
+	"serial": serialInterruptTarget,
+	"joypad": p1Thru4InterruptTarget,
+}
+
+// Breakpoint stops emulation when the CPU runs code or accesses memory in an
+// address range, or when an interrupt is dispatched.
+type Breakpoint struct {
+	// ID identifies the breakpoint. It's assigned when the breakpoint is
+	// added to a device.
+	ID int
+	// Kind is the kind of event that the breakpoint stops on.
+	Kind BreakpointKind
+	// Start and End are the first and last address that the breakpoint
+	// covers. They're the same for breakpoints on a single address.
+	Start uint16
+	End   uint16
+	// Bank is the ROM bank that must be mapped for the breakpoint to apply
+	// to addresses in 0x4000-0x7FFF, or AnyBank. It's ignored for addresses
+	// outside of banked ROM.
+	Bank int
+	// Condition is an expression that must be true for the breakpoint to
+	// stop emulation, or an empty string if there's no condition. See
+	// ParseBreakpoint for the expression syntax.
+	Condition string
+	// IgnoreCount is the number of times that the breakpoint is hit before
+	// it stops emulation.
+	IgnoreCount int
+	// Hits is the number of times that the breakpoint has been hit. A hit is
+	// only counted when the breakpoint's condition is true.
+	Hits int
+
+	// temporary breakpoints are removed the next time emulation stops. These
+	// are used for stepping over calls and running to an address.
+	temporary bool
+	// condition is the compiled Condition.
+	condition expression
+}
+
+// ParseBreakpoint parses a breakpoint in the syntax used by the debugger
+// console.
+//
+// Locations are hexadecimal addresses, optionally qualified with a ROM bank
+// like "03:4567". An address range may be given as "C000-C0FF". Interrupt
+// breakpoints instead take the name of an interrupt (vblank, stat, timer,
+// serial or joypad), or nothing to stop on any interrupt.
+//
+// The location may be followed by "if" and a condition, like
+// "03:4567 if a == $10 && [hl] != 0". Conditions may use registers, numbers
+// and memory reads written as an address in brackets. Numbers in conditions
+// are decimal unless they're prefixed with "$" or "0x" for hexadecimal, or
+// "%" for binary.
+func ParseBreakpoint(kind BreakpointKind, spec string) (Breakpoint, error) {
+	bp := Breakpoint{
+		Kind: kind,
+		Bank: AnyBank,
+	}
+
+	location := strings.TrimSpace(spec)
+	// Padding the spec lets "if" be found at the start or end
+	padded := " " + location + " "
+	if i := strings.Index(padded, " if "); i != -1 {
+		bp.Condition = strings.TrimSpace(padded[i+len(" if "):])
+		location = strings.TrimSpace(padded[:i])
+		if bp.Condition == "" {
+			return Breakpoint{}, xerrors.Errorf("missing condition after \"if\": %w",
+				ErrInvalidExpression)
+		}
+	}
+
+	if kind == BreakOnInterrupt {
+		if location == "" {
+			bp.Start = vblankInterruptTarget
+			bp.End = p1Thru4InterruptTarget
+		} else {
+			target, ok := interruptNames[strings.ToLower(location)]
+			if !ok {
+				return Breakpoint{}, xerrors.Errorf("unknown interrupt %q: %w",
+					location, ErrInvalidExpression)
+			}
+			bp.Start = target
+			bp.End = target
+		}
+	} else {
+		var err error
+		bp.Bank, bp.Start, bp.End, err = parseLocation(location)
+		if err != nil {
+			return Breakpoint{}, err
+		}
+	}
+
+	if bp.Condition != "" {
+		if _, err := parseExpression(bp.Condition); err != nil {
+			return Breakpoint{}, xerrors.Errorf("parsing condition: %w", err)
+		}
+	}
+
+	return bp, nil
+}
+
+// parseLocation parses an optionally bank-qualified address or address
+// range, like "4567", "03:4567" or "C000-C0FF".
+func parseLocation(location string) (bank int, start, end uint16, err error) {
+	bank = AnyBank
+
+	if i := strings.Index(location, ":"); i != -1 {
+		val, err := strconv.ParseUint(trimHexPrefix(location[:i]), 16, 16)
+		if err != nil {
+			return 0, 0, 0, xerrors.Errorf("invalid bank %q: %w",
+				location[:i], ErrInvalidExpression)
+		}
+		bank = int(val)
+		location = location[i+1:]
+	}
+
+	startText, endText := location, location
+	if i := strings.Index(location, "-"); i != -1 {
+		startText, endText = location[:i], location[i+1:]
+	}
+
+	startVal, err := strconv.ParseUint(trimHexPrefix(startText), 16, 16)
+	if err != nil {
+		return 0, 0, 0, xerrors.Errorf("invalid address %q: %w",
+			startText, ErrInvalidExpression)
+	}
+	endVal, err := strconv.ParseUint(trimHexPrefix(endText), 16, 16)
+	if err != nil {
+		return 0, 0, 0, xerrors.Errorf("invalid address %q: %w",
+			endText, ErrInvalidExpression)
+	}
+	if endVal < startVal {
+		return 0, 0, 0, xerrors.Errorf("address range %q ends before it starts: %w",
+			location, ErrInvalidExpression)
+	}
+
+	return bank, uint16(startVal), uint16(endVal), nil
+}
+
+// trimHexPrefix removes a "$" or "0x" prefix from a hexadecimal number.
+func trimHexPrefix(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "$")
+	text = strings.TrimPrefix(text, "0x")
+	return strings.TrimPrefix(text, "0X")
+}
+
+// location formats the breakpoint's address range in the syntax accepted by
+// ParseBreakpoint.
+func (bp Breakpoint) location() string {
+	var location string
+	if bp.Bank != AnyBank {
+		location = fmt.Sprintf("%02X:", bp.Bank)
+	}
+	location += fmt.Sprintf("%04X", bp.Start)
+	if bp.End != bp.Start {
+		location += fmt.Sprintf("-%04X", bp.End)
+	}
+	return location
+}
+
+func (bp Breakpoint) String() string {
+	str := fmt.Sprintf("%v %v at %v", bp.Kind, bp.ID, bp.location())
+	if bp.Kind == BreakOnInterrupt {
+		str = fmt.Sprintf("%v %v for ", bp.Kind, bp.ID)
+		if bp.Start == bp.End {
+			str += interruptName(bp.Start)
+		} else {
+			str += "any interrupt"
+		}
+	}
+
+	if bp.Condition != "" {
+		str += " if " + bp.Condition
+	}
+	if bp.IgnoreCount > 0 {
+		str += fmt.Sprintf(", ignoring %v hits", bp.IgnoreCount)
+	}
+	return str
+}
+
+// interruptName returns the name of the interrupt with the given handler
+// address.
+func interruptName(target uint16) string {
+	for name, addr := range interruptNames {
+		if addr == target {
+			return name
+		}
+	}
+	return fmt.Sprintf("%#04x", target)
+}
+
+// AddBreakpoint adds a breakpoint to the device and returns its ID. The
+// breakpoint's ID and hit count are ignored. The device must have been
+// created with debugging enabled.
+func (device *Device) AddBreakpoint(bp Breakpoint) (int, error) {
+	if device.debugger == nil {
+		return 0, ErrDebuggerDisabled
+	}
+	return device.debugger.addBreakpoint(bp)
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID.
+func (device *Device) RemoveBreakpoint(id int) error {
+	if device.debugger == nil {
+		return ErrDebuggerDisabled
+	}
+	return device.debugger.removeBreakpoint(id)
+}
+
+// Breakpoints returns all breakpoints that have been added to the device.
+func (device *Device) Breakpoints() []Breakpoint {
+	if device.debugger == nil {
+		return nil
+	}
+	return device.debugger.listBreakpoints()
+}
+
+// Pause makes the debugger stop before the next instruction is run, as if a
+// breakpoint was hit. It's safe to call from any goroutine while the device
+// is running.
+func (device *Device) Pause(reason string) error {
+	if device.debugger == nil {
+		return ErrDebuggerDisabled
+	}
+	device.debugger.requestStop(reason)
+	return nil
+}
@@ -0,0 +1,366 @@
+package gameboy
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// CameraWidth is the width of images taken by the Game Boy Camera.
+	CameraWidth = 128
+	// CameraHeight is the height of images taken by the Game Boy Camera.
+	CameraHeight = 112
+
+	// cameraRAMBanks is the number of 8 KB RAM banks in the camera
+	// cartridge, for a total of 128 KB.
+	cameraRAMBanks = 16
+	// cameraRegisterCount is the number of sensor registers.
+	cameraRegisterCount = 0x36
+	// cameraImageAddr is where captured images are written to in RAM bank 0,
+	// relative to the start of the bank.
+	cameraImageAddr = 0x100
+	// cameraExposureReference is the exposure time at which the sensor
+	// reports the brightness of the image source as-is. Longer exposures
+	// brighten the image and shorter ones darken it.
+	cameraExposureReference = 0x0800
+)
+
+// Sensor register indexes.
+const (
+	// cameraRegControl starts a capture when bit 0 is set. The bit stays set
+	// until the capture is finished. This is the only readable register.
+	cameraRegControl = 0x00
+	// cameraRegGainAndEdge configures the sensor's gain in bits 0-4 and the
+	// edge enhancement mode in bits 5-7. Bit 7 turns off the sensor's
+	// negative output, which makes captures slightly shorter.
+	cameraRegGainAndEdge = 0x01
+	// cameraRegExposureHigh and cameraRegExposureLow are the exposure time
+	// in steps of 16 microseconds.
+	cameraRegExposureHigh = 0x02
+	cameraRegExposureLow  = 0x03
+	// cameraRegEdgeAndInvert configures the edge enhancement ratio in bits
+	// 4-6 and inverts the image if bit 3 is set.
+	cameraRegEdgeAndInvert = 0x04
+	// cameraRegDither is the start of the 4x4 dithering matrix. Each element
+	// is three thresholds that decide which shade a pixel gets.
+	cameraRegDither = 0x06
+)
+
+// cameraEdgeRatios are the available edge enhancement ratios, times four.
+var cameraEdgeRatios = [8]int{2, 3, 4, 5, 8, 12, 16, 20}
+
+// pocketCamera is the MBC for the Game Boy Camera, also known as the Pocket
+// Camera. It works like an MBC with 128 KB of RAM, but one of the RAM banks can
+// be swapped for the registers of the camera's image sensor.
+//
+// Captured images go through the same processing as on the real sensor. The
+// brightness is scaled by the exposure time, edges are optionally enhanced,
+// and the result is dithered down to four shades using the matrix set by the
+// game. The sensor's analog gain and voltage settings are not emulated.
+type pocketCamera struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	romBanks [][]uint8
+	// ramBanks contains the cartridge's RAM banks, indexed by their bank
+	// number.
+	ramBanks [][]uint8
+
+	// The currently selected ROM bank.
+	currROMBank uint8
+	// The currently selected RAM bank.
+	currRAMBank uint8
+	// True if RAM can be written to. RAM can always be read.
+	ramEnabled bool
+	// True if the sensor registers are mapped in place of RAM.
+	registersMapped bool
+
+	// registers are the sensor registers.
+	registers [cameraRegisterCount]uint8
+	// captureCycles is the number of M-Cycles left before the capture in
+	// progress finishes, or 0 if there isn't one.
+	captureCycles int
+
+	imageSource ImageSourceDriver
+
+	// out is where warnings are printed.
+	out io.Writer
+}
+
+func newPocketCamera(
+	header romHeader,
+	cartridgeData []uint8,
+	imageSource ImageSourceDriver,
+	out io.Writer) *pocketCamera {
+
+	var m pocketCamera
+
+	m.out = out
+
+	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
+	// The camera always has 128 KB of RAM, whatever the header says
+	for i := 0; i < cameraRAMBanks; i++ {
+		m.ramBanks = append(m.ramBanks, make([]uint8, 0x2000))
+	}
+
+	m.currROMBank = 1
+
+	m.imageSource = imageSource
+
+	return &m
+}
+
+// at provides access to the camera's banked ROM, RAM and sensor registers.
+func (m *pocketCamera) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		return m.romBanks[0][addr]
+	case inBankedROMArea(addr):
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
+	case inBankedRAMArea(addr):
+		if m.registersMapped {
+			reg := (addr - bankedRAMAddr) & 0x7F
+			if reg == cameraRegControl {
+				return m.registers[cameraRegControl]
+			}
+			// All other registers are write-only
+			return 0x00
+		}
+		return m.ramBanks[m.currRAMBank][addr-bankedRAMAddr]
+	default:
+		panic(fmt.Sprintf("camera is unable to handle reads to address %#x", addr))
+	}
+}
+
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *pocketCamera) currentROMBank() int {
+	return int(m.currROMBank) % len(m.romBanks)
+}
+
+// set can switch ROM and RAM banks, enable RAM, write to RAM, and configure
+// the sensor.
+func (m *pocketCamera) set(addr uint16, val uint8) {
+	if addr < 0x2000 {
+		// RAM write enable
+		m.ramEnabled = val&0x0F == 0x0A
+	} else if addr < 0x4000 {
+		// ROM bank
+		m.currROMBank = val & 0x3F
+	} else if addr < 0x6000 {
+		// RAM bank, or the sensor registers if bit 4 is set
+		m.registersMapped = val&0x10 == 0x10
+		m.currRAMBank = val & 0x0F
+	} else if addr < 0x8000 {
+		// This area of ROM doesn't do anything when written to
+	} else if inBankedRAMArea(addr) {
+		if m.registersMapped {
+			m.setRegister(uint8((addr-bankedRAMAddr)&0x7F), val)
+		} else if m.ramEnabled {
+			m.ramBanks[m.currRAMBank][addr-bankedRAMAddr] = val
+		}
+	} else {
+		panic(fmt.Sprintf("camera is unable to handle writes to address %#x", addr))
+	}
+}
+
+// setRegister writes to a sensor register.
+func (m *pocketCamera) setRegister(reg uint8, val uint8) {
+	if int(reg) >= cameraRegisterCount {
+		return
+	}
+
+	if reg == cameraRegControl {
+		if val&0x01 == 0x01 && m.captureCycles == 0 {
+			m.captureCycles = m.captureDuration()
+		} else if val&0x01 == 0 {
+			// Captures can be cancelled
+			m.captureCycles = 0
+		}
+		val &= 0x07
+	}
+
+	m.registers[reg] = val
+}
+
+// captureDuration returns the number of M-Cycles a capture will take with the
+// current settings.
+func (m *pocketCamera) captureDuration() int {
+	clocks := 32446 + 16*int(m.exposure())
+	if m.registers[cameraRegGainAndEdge]&0x80 == 0 {
+		// Negative output takes extra time
+		clocks += 512
+	}
+	return clocks / ticksPerMCycle
+}
+
+// exposure returns the current exposure time setting.
+func (m *pocketCamera) exposure() uint16 {
+	return combine16(m.registers[cameraRegExposureLow], m.registers[cameraRegExposureHigh])
+}
+
+// tick progresses any capture in progress by one M-Cycle.
+func (m *pocketCamera) tick() {
+	if m.captureCycles == 0 {
+		return
+	}
+
+	m.captureCycles--
+	if m.captureCycles == 0 {
+		m.capture()
+		m.registers[cameraRegControl] &^= 0x01
+	}
+}
+
+// capture takes an image from the image source, processes it, and writes it
+// to RAM in tile format.
+func (m *pocketCamera) capture() {
+	pixels, err := m.imageSource.Capture()
+	if err != nil {
+		fmt.Fprintln(m.out, "Error: While capturing camera image:", err)
+		return
+	}
+	if len(pixels) != CameraWidth*CameraHeight {
+		fmt.Fprintf(m.out, "Error: Camera image has %v pixels, expected %v\n",
+			len(pixels), CameraWidth*CameraHeight)
+		return
+	}
+
+	// Apply the exposure
+	exposure := int(m.exposure())
+	exposed := make([]int, len(pixels))
+	for i, pixel := range pixels {
+		exposed[i] = clampByte(int(pixel) * exposure / cameraExposureReference)
+	}
+	at := func(x, y int) int {
+		if x < 0 || x >= CameraWidth || y < 0 || y >= CameraHeight {
+			// Edges repeat the outermost pixels
+			x = clampInt(x, 0, CameraWidth-1)
+			y = clampInt(y, 0, CameraHeight-1)
+		}
+		return exposed[y*CameraWidth+x]
+	}
+
+	edgeMode := (m.registers[cameraRegGainAndEdge] >> 5) & 0x3
+	edgeRatio := cameraEdgeRatios[(m.registers[cameraRegEdgeAndInvert]>>4)&0x7]
+	invert := m.registers[cameraRegEdgeAndInvert]&0x08 == 0x08
+
+	imageRAM := m.ramBanks[0][cameraImageAddr:]
+	for i := range imageRAM[:CameraWidth*CameraHeight/4] {
+		imageRAM[i] = 0
+	}
+
+	for y := 0; y < CameraHeight; y++ {
+		for x := 0; x < CameraWidth; x++ {
+			val := at(x, y)
+
+			// Enhance edges by subtracting neighboring pixels
+			var edge int
+			switch edgeMode {
+			case 1:
+				// Horizontal
+				edge = 2*val - at(x-1, y) - at(x+1, y)
+			case 2:
+				// Vertical
+				edge = 2*val - at(x, y-1) - at(x, y+1)
+			case 3:
+				// Both
+				edge = 4*val - at(x-1, y) - at(x+1, y) - at(x, y-1) - at(x, y+1)
+			}
+			val = clampByte(val + edge*edgeRatio/4)
+
+			if invert {
+				val = 0xFF - val
+			}
+
+			// Dither the pixel to a shade using its thresholds in the matrix
+			matrixIndex := cameraRegDither + ((y%4)*4+x%4)*3
+			thresholds := m.registers[matrixIndex : matrixIndex+3]
+			var shade uint8
+			switch {
+			case val < int(thresholds[0]):
+				shade = 3
+			case val < int(thresholds[1]):
+				shade = 2
+			case val < int(thresholds[2]):
+				shade = 1
+			default:
+				shade = 0
+			}
+
+			// Write the shade to the tile this pixel is in
+			tile := (y/8)*(CameraWidth/8) + x/8
+			rowAddr := tile*16 + (y%8)*2
+			bit := uint8(7 - x%8)
+			imageRAM[rowAddr] |= (shade & 0x1) << bit
+			imageRAM[rowAddr+1] |= (shade >> 1) << bit
+		}
+	}
+}
+
+// clampByte limits the given value to the range of a byte.
+func clampByte(val int) int {
+	return clampInt(val, 0, 0xFF)
+}
+
+// clampInt limits the given value to the given range.
+func clampInt(val, min, max int) int {
+	if val < min {
+		return min
+	}
+	if val > max {
+		return max
+	}
+	return val
+}
+
+func (m *pocketCamera) dumpBatteryBackedRAM() []uint8 {
+	var dump []uint8
+
+	for _, bank := range m.ramBanks {
+		dump = append(dump, bank...)
+	}
+
+	return dump
+}
+
+func (m *pocketCamera) loadBatteryBackedRAM(dump []uint8) error {
+	for bankNum, bank := range m.ramBanks {
+		start := len(bank) * bankNum
+		if start+len(bank) > len(dump) {
+			return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+		}
+		copy(bank, dump[start:start+len(bank)])
+	}
+
+	return nil
+}
+
+// saveState writes the camera's bank registers, sensor registers and RAM to a
+// save state.
+func (m *pocketCamera) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.currROMBank)
+	sw.write(m.currRAMBank)
+	sw.write(m.ramEnabled)
+	sw.write(m.registersMapped)
+	sw.write(m.registers)
+	sw.writeInt(m.captureCycles)
+}
+
+// loadState reads the camera's bank registers, sensor registers and RAM from a
+// save state.
+func (m *pocketCamera) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+
+	m.currROMBank = sr.readUint8()
+	m.currRAMBank = sr.readUint8()
+	m.ramEnabled = sr.readBool()
+	m.registersMapped = sr.readBool()
+	sr.read(&m.registers)
+	m.captureCycles = sr.readInt()
+}
@@ -0,0 +1,101 @@
+package gameboy
+
+import "testing"
+
+// testImageSource supplies an image where every pixel has the same
+// brightness.
+type testImageSource struct {
+	brightness uint8
+}
+
+func (source *testImageSource) Capture() ([]uint8, error) {
+	pixels := make([]uint8, CameraWidth*CameraHeight)
+	for i := range pixels {
+		pixels[i] = source.brightness
+	}
+	return pixels, nil
+}
+
+func TestCameraCapture(t *testing.T) {
+	tests := []struct {
+		name     string
+		exposure uint16
+		invert   bool
+		// shade is the shade that every pixel should be dithered to.
+		shade uint8
+	}{
+		{"reference exposure", cameraExposureReference, false, 2},
+		{"short exposure", cameraExposureReference / 4, false, 3},
+		{"long exposure", cameraExposureReference * 2, false, 0},
+		{"inverted", cameraExposureReference * 2, true, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// POCKET CAMERA with 128K of RAM
+			device := newTestCartridgeDevice(t, newTestCartridge(0xFC, 0x00, 0x04),
+				WithImageSource(&testImageSource{brightness: 0x80}))
+
+			device.WriteMemory(0x4000, 0x10) // Map the sensor registers
+			device.WriteMemory(0xA001, 0x80) // No negative output or edge enhancement
+			device.WriteMemory(0xA002, uint8(test.exposure>>8))
+			device.WriteMemory(0xA003, uint8(test.exposure))
+			if test.invert {
+				device.WriteMemory(0xA004, 0x08)
+			}
+			for i := uint16(0); i < 16; i++ {
+				addr := 0xA000 + cameraRegDither + i*3
+				device.WriteMemory(addr, 0x40)
+				device.WriteMemory(addr+1, 0x90)
+				device.WriteMemory(addr+2, 0xC0)
+			}
+
+			device.WriteMemory(0xA000, 0x01) // Start capturing
+			if device.ReadMemory(0xA000)&0x01 == 0 {
+				t.Fatalf("the capture isn't in progress")
+			}
+			if val := device.ReadMemory(0xA002); val != 0x00 {
+				t.Errorf("expected write-only registers to read 0, got %#x", val)
+			}
+			// The longest exposure takes about 25000 M-Cycles
+			if err := device.RunCycles(30000); err != nil {
+				t.Fatalf("running: %v", err)
+			}
+			if device.ReadMemory(0xA000)&0x01 != 0 {
+				t.Fatalf("the capture didn't finish")
+			}
+
+			device.WriteMemory(0x4000, 0x00) // Map RAM bank 0
+			expected := [2]uint8{0x00, 0x00}
+			if test.shade&0x1 != 0 {
+				expected[0] = 0xFF
+			}
+			if test.shade&0x2 != 0 {
+				expected[1] = 0xFF
+			}
+			for _, addr := range []uint16{0xA100, 0xA100 + CameraWidth*CameraHeight/4 - 2} {
+				actual := [2]uint8{device.ReadMemory(addr), device.ReadMemory(addr + 1)}
+				if actual != expected {
+					t.Errorf("expected tile data %#x at %#x, got %#x", expected, addr, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestCameraCancelCapture(t *testing.T) {
+	device := newTestCartridgeDevice(t, newTestCartridge(0xFC, 0x00, 0x04),
+		WithImageSource(&testImageSource{brightness: 0x00}))
+
+	device.WriteMemory(0x4000, 0x10)
+	device.WriteMemory(0xA000, 0x01)
+	device.WriteMemory(0xA000, 0x00)
+	if err := device.RunCycles(20000); err != nil {
+		t.Fatalf("running: %v", err)
+	}
+
+	device.WriteMemory(0x4000, 0x00)
+	if val := device.ReadMemory(0xA100); val != 0x00 {
+		t.Fatalf("a cancelled capture wrote %#x to RAM", val)
+	}
+}
@@ -0,0 +1,148 @@
+package gameboy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// testClock is a Clock whose time only moves when the test says so.
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// advance moves the clock forward.
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestCartridge returns a ROM with a valid header for the given cartridge
+// type, ROM size type and RAM size type. The program at the entry point loops
+// forever.
+func newTestCartridge(cartridgeType, romSizeType, ramSizeType uint8) []uint8 {
+	rom := make([]uint8, romBankCounts[romSizeType]*0x4000)
+	copy(rom[0x0100:], []uint8{0x18, 0xFE}) // jr -2
+	copy(rom[headerStartAddr:], "TEST")
+	rom[0x0147] = cartridgeType
+	rom[0x0148] = romSizeType
+	rom[0x0149] = ramSizeType
+	rom[headerChecksumAddr] = headerChecksum(rom)
+	checksum := globalChecksum(rom)
+	rom[globalChecksumAddr] = uint8(checksum >> 8)
+	rom[globalChecksumAddr+1] = uint8(checksum)
+	return rom
+}
+
+// newTestCartridgeDevice creates a DMG that runs the given ROM without a boot
+// ROM and without printing anything.
+func newTestCartridgeDevice(t testing.TB, rom []uint8, opts ...Option) *Device {
+	t.Helper()
+
+	opts = append([]Option{WithoutBootROM(), WithModel(ModelDMG), WithOutput(ioutil.Discard)}, opts...)
+	device, err := NewDevice(
+		nil,
+		rom,
+		&testROMVideoDriver{},
+		&noopInputDriver{},
+		&testROMSaveGameDriver{},
+		DebugConfiguration{},
+		opts...)
+	if err != nil {
+		t.Fatalf("creating device: %v", err)
+	}
+	return device
+}
+
+// bankMarkerAddr is where newBankNumberedROM puts each bank's number.
+const bankMarkerAddr = 0x0200
+
+// newBankNumberedROM returns the given number of ROM banks, each with its bank
+// number at bankMarkerAddr in the bank.
+func newBankNumberedROM(bankCount int) []uint8 {
+	rom := make([]uint8, bankCount*0x4000)
+	for bank := 0; bank < bankCount; bank++ {
+		rom[bank*0x4000+bankMarkerAddr] = uint8(bank)
+	}
+	return rom
+}
+
+// testSaveGameDriver keeps saves in memory.
+type testSaveGameDriver struct {
+	saves map[string][]uint8
+}
+
+func (driver *testSaveGameDriver) Save(name string, data []uint8) error {
+	driver.saves[name] = data
+	return nil
+}
+
+func (driver *testSaveGameDriver) Load(name string) ([]uint8, error) {
+	return driver.saves[name], nil
+}
+
+func (driver *testSaveGameDriver) Has(name string) (bool, error) {
+	_, ok := driver.saves[name]
+	return ok, nil
+}
+
+func TestLoadTruncatedSave(t *testing.T) {
+	tests := []struct {
+		name          string
+		cartridgeType uint8
+		ramSizeType   uint8
+	}{
+		{"ROM+RAM+BATTERY", 0x09, 0x02},
+		{"MBC1", 0x03, 0x03},
+		{"MBC2", 0x06, 0x00},
+		{"MBC3", 0x10, 0x03},
+		{"MBC5", 0x1B, 0x03},
+		{"MBC6", 0x20, 0x02},
+		{"MBC7", 0x22, 0x00},
+		{"MMM01", 0x0D, 0x03},
+		{"POCKET CAMERA", 0xFC, 0x04},
+		{"TAMA5", 0xFD, 0x00},
+		{"HuC3", 0xFE, 0x03},
+		{"HuC1", 0xFF, 0x03},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saves := &testSaveGameDriver{saves: map[string][]uint8{
+				"TEST": make([]uint8, 16),
+			}}
+			_, err := NewDevice(
+				nil,
+				newTestCartridge(test.cartridgeType, 0x00, test.ramSizeType),
+				&testROMVideoDriver{},
+				&noopInputDriver{},
+				saves,
+				DebugConfiguration{},
+				WithoutBootROM(),
+				WithOutput(ioutil.Discard))
+			if !xerrors.Is(err, ErrSaveTooSmall) {
+				t.Fatalf("expected ErrSaveTooSmall, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWithOutput(t *testing.T) {
+	var out bytes.Buffer
+	newTestCartridgeDevice(t, newTestCartridge(0x00, 0x00, 0x00), WithOutput(&out))
+
+	if !strings.Contains(out.String(), "Cartridge Header:") {
+		t.Fatalf("expected the cartridge header to be printed, got %q", out.String())
+	}
+}
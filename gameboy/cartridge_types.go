@@ -0,0 +1,99 @@
+package gameboy
+
+import "strings"
+
+// CartridgeFeatures describes the hardware in a cartridge, as given by the
+// cartridge type in its header.
+type CartridgeFeatures struct {
+	// Controller is the name of the cartridge's memory bank controller, like
+	// "MBC1". It's "ROM" for cartridges without one, or "Unknown" if the
+	// cartridge type isn't recognized.
+	Controller string
+	// RAM is true if the cartridge has RAM, or another kind of memory for
+	// saving like an EEPROM or flash.
+	RAM bool
+	// Battery is true if the cartridge has a battery that keeps its RAM and
+	// clock going while the Game Boy is off.
+	Battery bool
+	// RTC is true if the cartridge has a real time clock.
+	RTC bool
+	// Rumble is true if the cartridge has a rumble motor.
+	Rumble bool
+	// Accelerometer is true if the cartridge can sense being tilted.
+	Accelerometer bool
+	// Camera is true if the cartridge has a camera.
+	Camera bool
+	// Infrared is true if the cartridge has an infrared port.
+	Infrared bool
+}
+
+// String returns the features in the style used by cartridge type lists, like
+// "MBC3+RTC+RAM+BATTERY".
+func (features CartridgeFeatures) String() string {
+	parts := []string{features.Controller}
+
+	if features.Accelerometer {
+		parts = append(parts, "SENSOR")
+	}
+	if features.Camera {
+		parts = append(parts, "CAMERA")
+	}
+	if features.Infrared {
+		parts = append(parts, "IR")
+	}
+	if features.RTC {
+		parts = append(parts, "RTC")
+	}
+	if features.Rumble {
+		parts = append(parts, "RUMBLE")
+	}
+	if features.RAM {
+		parts = append(parts, "RAM")
+	}
+	if features.Battery {
+		parts = append(parts, "BATTERY")
+	}
+
+	return strings.Join(parts, "+")
+}
+
+// cartridgeTypes maps cartridge types to the hardware in those cartridges.
+var cartridgeTypes = map[uint8]CartridgeFeatures{
+	0x00: {Controller: "ROM"},
+	0x01: {Controller: "MBC1"},
+	0x02: {Controller: "MBC1", RAM: true},
+	0x03: {Controller: "MBC1", RAM: true, Battery: true},
+	0x05: {Controller: "MBC2", RAM: true},
+	0x06: {Controller: "MBC2", RAM: true, Battery: true},
+	0x08: {Controller: "ROM", RAM: true},
+	0x09: {Controller: "ROM", RAM: true, Battery: true},
+	0x0B: {Controller: "MMM01"},
+	0x0C: {Controller: "MMM01", RAM: true},
+	0x0D: {Controller: "MMM01", RAM: true, Battery: true},
+	0x0F: {Controller: "MBC3", RTC: true, Battery: true},
+	0x10: {Controller: "MBC3", RTC: true, RAM: true, Battery: true},
+	0x11: {Controller: "MBC3"},
+	0x12: {Controller: "MBC3", RAM: true},
+	0x13: {Controller: "MBC3", RAM: true, Battery: true},
+	0x19: {Controller: "MBC5"},
+	0x1A: {Controller: "MBC5", RAM: true},
+	0x1B: {Controller: "MBC5", RAM: true, Battery: true},
+	0x1C: {Controller: "MBC5", Rumble: true},
+	0x1D: {Controller: "MBC5", Rumble: true, RAM: true},
+	0x1E: {Controller: "MBC5", Rumble: true, RAM: true, Battery: true},
+	0x20: {Controller: "MBC6", RAM: true, Battery: true},
+	0x22: {Controller: "MBC7", Accelerometer: true, Rumble: true, RAM: true, Battery: true},
+	0xFC: {Controller: "POCKET CAMERA", Camera: true, RAM: true, Battery: true},
+	0xFD: {Controller: "TAMA5", RTC: true, RAM: true, Battery: true},
+	0xFE: {Controller: "HuC3", Infrared: true, RTC: true, RAM: true, Battery: true},
+	0xFF: {Controller: "HuC1", Infrared: true, RAM: true, Battery: true},
+}
+
+// cartridgeFeatures returns the hardware in cartridges of the given type.
+func cartridgeFeatures(cartridgeType uint8) CartridgeFeatures {
+	features, ok := cartridgeTypes[cartridgeType]
+	if !ok {
+		return CartridgeFeatures{Controller: "Unknown"}
+	}
+	return features
+}
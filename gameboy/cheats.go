@@ -0,0 +1,455 @@
+package gameboy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrInvalidCheatCode is returned when a cheat code can't be parsed.
+var ErrInvalidCheatCode = xerrors.New("invalid cheat code")
+
+// CheatType is a kind of cheat code.
+type CheatType int
+
+const (
+	// CheatGameGenie is a Game Genie code, like "00A-17B-C49". These patch
+	// values read from ROM. Codes with three groups of digits only patch the
+	// ROM if it has an expected value, since the same address can map to
+	// different banks.
+	CheatGameGenie CheatType = iota
+	// CheatGameShark is a GameShark code, like "010238CD". These write a value
+	// to RAM every frame.
+	CheatGameShark
+)
+
+func (t CheatType) String() string {
+	switch t {
+	case CheatGameGenie:
+		return "Game Genie"
+	case CheatGameShark:
+		return "GameShark"
+	default:
+		return "Unknown"
+	}
+}
+
+// Cheat is a cheat code that has been added to a device.
+type Cheat struct {
+	// Code is the cheat code, in upper case. Game Genie codes are split into
+	// groups of three digits with dashes.
+	Code string
+	// Description is a user-provided description of what the cheat does.
+	Description string
+	// Enabled is true if the cheat is in effect.
+	Enabled bool
+	// Type is the kind of cheat code.
+	Type CheatType
+}
+
+// romPatch is a decoded Game Genie code, which replaces a value read from ROM.
+type romPatch struct {
+	addr uint16
+	val  uint8
+	// compare is the value the ROM must have for it to be patched. It's only
+	// used if hasCompare is true.
+	compare    uint8
+	hasCompare bool
+}
+
+// ramWrite is a decoded GameShark code, which writes a value to RAM every
+// frame.
+type ramWrite struct {
+	addr uint16
+	val  uint8
+	// ramBank is the internal RAM bank to write to for addresses in
+	// 0xD000-0xDFFF, or -1 to use the current RAM bank.
+	ramBank int
+}
+
+// CheatManager keeps track of the cheats on a device. Its methods are safe to
+// call while the device is running. Changes take effect at the end of the
+// current frame.
+type CheatManager struct {
+	// mutex guards the cheat list.
+	mutex  sync.Mutex
+	cheats []Cheat
+
+	// changed is set to 1 when the cheat list has been modified and the
+	// active cheats need to be updated.
+	changed int32
+
+	// The title and header checksum of the game, used to make sure that a
+	// saved cheat list belongs to this game.
+	title          string
+	headerChecksum uint8
+
+	// romPatches maps ROM addresses to enabled Game Genie codes for that
+	// address. It's only accessed by the device.
+	romPatches map[uint16][]romPatch
+	// ramWrites contains enabled GameShark codes. It's only accessed by the
+	// device.
+	ramWrites []ramWrite
+}
+
+func newCheatManager(header romHeader) *CheatManager {
+	return &CheatManager{
+		title:          header.title,
+		headerChecksum: header.headerChecksum,
+	}
+}
+
+// Cheats returns the device's cheat manager, which can be used to add and
+// remove cheat codes.
+func (device *Device) Cheats() *CheatManager {
+	return device.cheats
+}
+
+// Add parses the given cheat code and adds it to the device, enabled. The code
+// type is detected from its format. An error wrapping ErrInvalidCheatCode is
+// returned if the code can't be parsed.
+func (c *CheatManager) Add(code, description string) error {
+	cheat, err := parseCheat(code)
+	if err != nil {
+		return err
+	}
+	cheat.Description = description
+	cheat.Enabled = true
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.find(cheat.Code) != -1 {
+		return xerrors.Errorf("cheat %v has already been added", cheat.Code)
+	}
+	c.cheats = append(c.cheats, cheat)
+	atomic.StoreInt32(&c.changed, 1)
+
+	return nil
+}
+
+// Remove removes the given cheat code from the device.
+func (c *CheatManager) Remove(code string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i := c.find(normalizeCheatCode(code))
+	if i == -1 {
+		return xerrors.Errorf("no cheat with code %v", code)
+	}
+	c.cheats = append(c.cheats[:i], c.cheats[i+1:]...)
+	atomic.StoreInt32(&c.changed, 1)
+
+	return nil
+}
+
+// SetEnabled enables or disables the given cheat code.
+func (c *CheatManager) SetEnabled(code string, enabled bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i := c.find(normalizeCheatCode(code))
+	if i == -1 {
+		return xerrors.Errorf("no cheat with code %v", code)
+	}
+	c.cheats[i].Enabled = enabled
+	atomic.StoreInt32(&c.changed, 1)
+
+	return nil
+}
+
+// Cheats returns all cheats that have been added to the device, in the order
+// they were added.
+func (c *CheatManager) Cheats() []Cheat {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cheats := make([]Cheat, len(c.cheats))
+	copy(cheats, c.cheats)
+	return cheats
+}
+
+// find returns the index of the cheat with the given normalized code, or -1
+// if there isn't one. The mutex must be held.
+func (c *CheatManager) find(code string) int {
+	for i, cheat := range c.cheats {
+		if cheat.Code == code {
+			return i
+		}
+	}
+	return -1
+}
+
+// Save writes the cheat list to the given writer. The list starts with a line
+// identifying the game, followed by one line per cheat in the format
+// "<on|off> <code> <description>".
+func (c *CheatManager) Save(w io.Writer) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "rom %02X %v\n", c.headerChecksum, c.title)
+	for _, cheat := range c.cheats {
+		state := "off"
+		if cheat.Enabled {
+			state = "on"
+		}
+		line := fmt.Sprintf("%v %v %v", state, cheat.Code, cheat.Description)
+		fmt.Fprintln(bw, strings.TrimSpace(line))
+	}
+
+	if err := bw.Flush(); err != nil {
+		return xerrors.Errorf("writing cheat list: %w", err)
+	}
+	return nil
+}
+
+// Load reads a cheat list written by Save and adds its cheats to the device.
+// Blank lines and lines starting with "#" are ignored. An error is returned if
+// the list is for a different game. Lists without a line identifying the game
+// are accepted, so that they can be written by hand.
+func (c *CheatManager) Load(r io.Reader) error {
+	var cheats []Cheat
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			return xerrors.Errorf("line %v of cheat list is malformed: %q", lineNum, line)
+		}
+		var rest string
+		if len(fields) == 3 {
+			rest = strings.TrimSpace(fields[2])
+		}
+
+		switch fields[0] {
+		case "rom":
+			checksum, err := strconv.ParseUint(fields[1], 16, 8)
+			if err != nil {
+				return xerrors.Errorf("line %v of cheat list has an invalid "+
+					"header checksum: %w", lineNum, err)
+			}
+			if uint8(checksum) != c.headerChecksum || rest != c.title {
+				return xerrors.Errorf("cheat list is for %q, not %q", rest, c.title)
+			}
+		case "on", "off":
+			cheat, err := parseCheat(fields[1])
+			if err != nil {
+				return xerrors.Errorf("line %v of cheat list: %w", lineNum, err)
+			}
+			cheat.Enabled = fields[0] == "on"
+			cheat.Description = rest
+			cheats = append(cheats, cheat)
+		default:
+			return xerrors.Errorf("line %v of cheat list is malformed: %q", lineNum, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return xerrors.Errorf("reading cheat list: %w", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, cheat := range cheats {
+		if i := c.find(cheat.Code); i != -1 {
+			c.cheats[i] = cheat
+		} else {
+			c.cheats = append(c.cheats, cheat)
+		}
+	}
+	atomic.StoreInt32(&c.changed, 1)
+
+	return nil
+}
+
+// update decodes enabled cheats for use by the device if the cheat list has
+// changed. It's called by the device at the end of every frame.
+func (c *CheatManager) update() {
+	if atomic.SwapInt32(&c.changed, 0) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.romPatches = make(map[uint16][]romPatch)
+	c.ramWrites = nil
+
+	for _, cheat := range c.cheats {
+		if !cheat.Enabled {
+			continue
+		}
+		switch cheat.Type {
+		case CheatGameGenie:
+			patch, _ := decodeGameGenie(cheat.Code)
+			c.romPatches[patch.addr] = append(c.romPatches[patch.addr], patch)
+		case CheatGameShark:
+			write, _ := decodeGameShark(cheat.Code)
+			c.ramWrites = append(c.ramWrites, write)
+		}
+	}
+}
+
+// romRead returns the value that the CPU should see when reading the given
+// value from the given ROM address, taking Game Genie codes into account.
+func (c *CheatManager) romRead(addr uint16, val uint8) uint8 {
+	if c == nil || len(c.romPatches) == 0 {
+		return val
+	}
+
+	for _, patch := range c.romPatches[addr] {
+		if !patch.hasCompare || patch.compare == val {
+			return patch.val
+		}
+	}
+	return val
+}
+
+// writeRAM applies GameShark codes to RAM. It's called by the device at the
+// end of every frame, which is when a real GameShark does its writes.
+func (c *CheatManager) writeRAM(m *mmu) {
+	for _, write := range c.ramWrites {
+		if write.ramBank != -1 && write.addr >= bankedInternalRAMAddr &&
+			write.addr < ramMirrorAddr && write.ramBank < len(m.ramBanks) {
+
+			m.ramBanks[write.ramBank][write.addr-bankedInternalRAMAddr] = write.val
+		} else {
+			m.setNoNotify(write.addr, write.val)
+		}
+	}
+}
+
+// normalizeCheatCode puts the given cheat code in the form used by Cheat.Code.
+// Game Genie codes without dashes have them added. The code isn't validated.
+func normalizeCheatCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+
+	if len(code) == 6 || len(code) == 9 {
+		// A Game Genie code
+		var groups []string
+		for i := 0; i < len(code); i += 3 {
+			groups = append(groups, code[i:i+3])
+		}
+		code = strings.Join(groups, "-")
+	}
+
+	return code
+}
+
+// parseCheat parses and validates the given cheat code. The returned cheat is
+// disabled and has no description.
+func parseCheat(code string) (Cheat, error) {
+	normalized := normalizeCheatCode(code)
+
+	switch len(strings.Replace(normalized, "-", "", -1)) {
+	case 6, 9:
+		if _, err := decodeGameGenie(normalized); err != nil {
+			return Cheat{}, xerrors.Errorf("Game Genie code %v: %w", code, err)
+		}
+		return Cheat{Code: normalized, Type: CheatGameGenie}, nil
+	case 8:
+		if _, err := decodeGameShark(normalized); err != nil {
+			return Cheat{}, xerrors.Errorf("GameShark code %v: %w", code, err)
+		}
+		return Cheat{Code: normalized, Type: CheatGameShark}, nil
+	default:
+		return Cheat{}, xerrors.Errorf("%q is not a Game Genie or GameShark "+
+			"code: %w", code, ErrInvalidCheatCode)
+	}
+}
+
+// decodeGameGenie decodes a normalized Game Genie code. The digits of a code
+// "ABC-DEF-GHI" encode a new value AB at address FCDE XORed with 0xF000. The
+// compare value is GI rotated right by two and XORed with 0xBA. H is unused.
+func decodeGameGenie(code string) (romPatch, error) {
+	digits, err := parseHexDigits(strings.Replace(code, "-", "", -1))
+	if err != nil {
+		return romPatch{}, err
+	}
+
+	var patch romPatch
+	patch.val = digits[0]<<4 | digits[1]
+	patch.addr = (uint16(digits[5])<<12 | uint16(digits[2])<<8 |
+		uint16(digits[3])<<4 | uint16(digits[4])) ^ 0xF000
+
+	if !inBank0ROMArea(patch.addr) && !inBankedROMArea(patch.addr) {
+		return romPatch{}, xerrors.Errorf("address %#x is not in ROM: %w",
+			patch.addr, ErrInvalidCheatCode)
+	}
+
+	if len(digits) == 9 {
+		compare := digits[6]<<4 | digits[8]
+		patch.compare = (compare>>2 | compare<<6) ^ 0xBA
+		patch.hasCompare = true
+	}
+
+	return patch, nil
+}
+
+// decodeGameShark decodes a normalized GameShark code. A code "TTVVLLHH"
+// writes the value VV to address HHLL. TT is 0x01 to use the current RAM bank,
+// or 0x80-0x87 or 0x90-0x97 to write to a specific internal RAM bank on the
+// CGB.
+func decodeGameShark(code string) (ramWrite, error) {
+	digits, err := parseHexDigits(code)
+	if err != nil {
+		return ramWrite{}, err
+	}
+
+	codeType := digits[0]<<4 | digits[1]
+	write := ramWrite{
+		val:  digits[2]<<4 | digits[3],
+		addr: combine16(digits[4]<<4|digits[5], digits[6]<<4|digits[7]),
+	}
+
+	switch {
+	case codeType == 0x00 || codeType == 0x01:
+		write.ramBank = -1
+	case codeType&0xE8 == 0x80:
+		write.ramBank = int(codeType & 0x07)
+		if write.ramBank == 0 {
+			// Like the SVBK register, bank 0 means bank 1
+			write.ramBank = 1
+		}
+	default:
+		return ramWrite{}, xerrors.Errorf("unknown code type %#x: %w",
+			codeType, ErrInvalidCheatCode)
+	}
+
+	if !inBankedRAMArea(write.addr) && !inRAMArea(write.addr) {
+		return ramWrite{}, xerrors.Errorf("address %#x is not in RAM: %w",
+			write.addr, ErrInvalidCheatCode)
+	}
+
+	return write, nil
+}
+
+// parseHexDigits returns the value of each hex digit in the given string.
+func parseHexDigits(str string) ([]uint8, error) {
+	digits := make([]uint8, len(str))
+
+	for i, char := range str {
+		val, err := strconv.ParseUint(string(char), 16, 8)
+		if err != nil {
+			return nil, xerrors.Errorf("%q is not a hex digit: %w", char, ErrInvalidCheatCode)
+		}
+		digits[i] = uint8(val)
+	}
+
+	return digits, nil
+}
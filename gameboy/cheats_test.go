@@ -0,0 +1,285 @@
+package gameboy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestParseCheat(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected Cheat
+	}{
+		{"00A-17B-C49", Cheat{Code: "00A-17B-C49", Type: CheatGameGenie}},
+		// Codes are upper cased and dashes are put between groups
+		{"00a17bc49", Cheat{Code: "00A-17B-C49", Type: CheatGameGenie}},
+		{"00A 17B", Cheat{Code: "00A-17B", Type: CheatGameGenie}},
+		{"01FF23C1", Cheat{Code: "01FF23C1", Type: CheatGameShark}},
+		{"91ff-23d1", Cheat{Code: "91FF23D1", Type: CheatGameShark}},
+	}
+
+	for _, test := range tests {
+		actual, err := parseCheat(test.code)
+		if err != nil {
+			t.Errorf("parsing %q: %v", test.code, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("parsing %q: expected %+v, got %+v", test.code, test.expected, actual)
+		}
+	}
+}
+
+func TestParseCheatErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"empty", ""},
+		{"too short", "00A-17"},
+		{"too long", "00A-17B-C49-1"},
+		// Seven digits aren't a Game Genie code, even though they're as long
+		// as one with a dash
+		{"seven digits", "00A17BC"},
+		{"not hex", "00G-17B-C49"},
+		{"Game Genie address in RAM", "00A-170"},
+		{"unknown GameShark type", "02FF23C1"},
+		{"GameShark address in VRAM", "01FF0080"},
+		{"GameShark address in OAM", "01FF00FE"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseCheat(test.code)
+			if !xerrors.Is(err, ErrInvalidCheatCode) {
+				t.Fatalf("expected ErrInvalidCheatCode, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeGameGenie(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected romPatch
+	}{
+		{"00A-17B-C49", romPatch{addr: 0x4A17, val: 0x00, compare: 0xC8, hasCompare: true}},
+		{"00A-17B", romPatch{addr: 0x4A17, val: 0x00}},
+		{"3E0-1AF", romPatch{addr: 0x001A, val: 0x3E}},
+		{"FF7-FF8-E6A", romPatch{addr: 0x77FF, val: 0xFF, compare: 0x00, hasCompare: true}},
+	}
+
+	for _, test := range tests {
+		actual, err := decodeGameGenie(test.code)
+		if err != nil {
+			t.Errorf("decoding %v: %v", test.code, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("decoding %v: expected %+v, got %+v", test.code, test.expected, actual)
+		}
+	}
+}
+
+func TestDecodeGameShark(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected ramWrite
+	}{
+		{"01FF23C1", ramWrite{addr: 0xC123, val: 0xFF, ramBank: -1}},
+		{"000A00A0", ramWrite{addr: 0xA000, val: 0x0A, ramBank: -1}},
+		{"83630CD0", ramWrite{addr: 0xD00C, val: 0x63, ramBank: 3}},
+		{"97010CD0", ramWrite{addr: 0xD00C, val: 0x01, ramBank: 7}},
+		// Bank 0 means bank 1
+		{"80010CD0", ramWrite{addr: 0xD00C, val: 0x01, ramBank: 1}},
+	}
+
+	for _, test := range tests {
+		actual, err := decodeGameShark(test.code)
+		if err != nil {
+			t.Errorf("decoding %v: %v", test.code, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("decoding %v: expected %+v, got %+v", test.code, test.expected, actual)
+		}
+	}
+}
+
+func TestGameGenieROMRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		romVal   uint8
+		expected uint8
+	}{
+		{"compare matches", "00A-17B-C49", 0xC8, 0x00},
+		// The ROM is left alone if it doesn't have the expected value, like
+		// when a different bank is mapped in
+		{"compare mismatch", "00A-17B-C49", 0xC7, 0xC7},
+		{"no compare", "00A-17B", 0xC7, 0x00},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rom := newTestCartridge(0x00, 0x00, 0x00)
+			rom[0x4A17] = test.romVal
+			device := newTestCartridgeDevice(t, rom)
+
+			if err := device.Cheats().Add(test.code, ""); err != nil {
+				t.Fatalf("adding cheat: %v", err)
+			}
+			// Cheats take effect at the end of the frame
+			if actual := device.ReadMemory(0x4A17); actual != test.romVal {
+				t.Fatalf("expected %#x before the end of the frame, got %#x", test.romVal, actual)
+			}
+			if err := device.RunFrame(); err != nil {
+				t.Fatalf("running: %v", err)
+			}
+			if actual := device.ReadMemory(0x4A17); actual != test.expected {
+				t.Fatalf("expected %#x, got %#x", test.expected, actual)
+			}
+
+			if err := device.Cheats().SetEnabled(test.code, false); err != nil {
+				t.Fatalf("disabling cheat: %v", err)
+			}
+			if err := device.RunFrame(); err != nil {
+				t.Fatalf("running: %v", err)
+			}
+			if actual := device.ReadMemory(0x4A17); actual != test.romVal {
+				t.Fatalf("expected %#x once disabled, got %#x", test.romVal, actual)
+			}
+		})
+	}
+}
+
+func TestGameSharkRAMWrite(t *testing.T) {
+	device := newTestCartridgeDevice(t, newTestCartridge(0x00, 0x00, 0x00))
+
+	if err := device.Cheats().Add("01FF23C1", "infinite lives"); err != nil {
+		t.Fatalf("adding cheat: %v", err)
+	}
+
+	// The value is written again at the end of every frame, undoing whatever
+	// the game did to it
+	for frame := 0; frame < 3; frame++ {
+		device.WriteMemory(0xC123, uint8(frame))
+		if err := device.RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+		if actual := device.ReadMemory(0xC123); actual != 0xFF {
+			t.Fatalf("expected 0xff after frame %v, got %#x", frame, actual)
+		}
+	}
+
+	if err := device.Cheats().Remove("01ff-23c1"); err != nil {
+		t.Fatalf("removing cheat: %v", err)
+	}
+	if err := device.RunFrame(); err != nil {
+		t.Fatalf("running: %v", err)
+	}
+	device.WriteMemory(0xC123, 0x12)
+	if err := device.RunFrame(); err != nil {
+		t.Fatalf("running: %v", err)
+	}
+	if actual := device.ReadMemory(0xC123); actual != 0x12 {
+		t.Fatalf("expected the removed cheat to stop writing, got %#x", actual)
+	}
+}
+
+func TestCheatList(t *testing.T) {
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	device := newTestCartridgeDevice(t, rom)
+
+	cheats := device.Cheats()
+	if err := cheats.Add("00A-17B-C49", "walk through walls"); err != nil {
+		t.Fatalf("adding cheat: %v", err)
+	}
+	if err := cheats.Add("01FF23C1", ""); err != nil {
+		t.Fatalf("adding cheat: %v", err)
+	}
+	if err := cheats.SetEnabled("01FF23C1", false); err != nil {
+		t.Fatalf("disabling cheat: %v", err)
+	}
+	if err := cheats.Add("00a17bc49", ""); err == nil {
+		t.Errorf("expected adding the same cheat twice to fail")
+	}
+
+	var list bytes.Buffer
+	if err := cheats.Save(&list); err != nil {
+		t.Fatalf("saving cheat list: %v", err)
+	}
+	expectedList := fmt.Sprintf("rom %02X TEST\n", rom[headerChecksumAddr]) +
+		"on 00A-17B-C49 walk through walls\n" +
+		"off 01FF23C1\n"
+	if list.String() != expectedList {
+		t.Fatalf("expected cheat list %q, got %q", expectedList, list.String())
+	}
+
+	// Loading the list into a fresh device of the same game gets the same
+	// cheats back
+	loaded := newTestCartridgeDevice(t, rom).Cheats()
+	if err := loaded.Load(bytes.NewReader(list.Bytes())); err != nil {
+		t.Fatalf("loading cheat list: %v", err)
+	}
+	expected := []Cheat{
+		{Code: "00A-17B-C49", Description: "walk through walls", Enabled: true, Type: CheatGameGenie},
+		{Code: "01FF23C1", Enabled: false, Type: CheatGameShark},
+	}
+	actual := loaded.Cheats()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v cheats, got %+v", len(expected), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("cheat %v is %+v, expected %+v", i, actual[i], expected[i])
+		}
+	}
+
+	// Lists for other games are refused
+	otherROM := newTestCartridge(0x00, 0x00, 0x00)
+	copy(otherROM[headerStartAddr:], "OTHER")
+	otherROM[headerChecksumAddr] = headerChecksum(otherROM)
+	other := newTestCartridgeDevice(t, otherROM).Cheats()
+	if err := other.Load(bytes.NewReader(list.Bytes())); err == nil {
+		t.Errorf("expected loading another game's cheat list to fail")
+	}
+	if len(other.Cheats()) != 0 {
+		t.Errorf("expected no cheats to be added from another game's list, got %+v", other.Cheats())
+	}
+}
+
+func TestCheatListErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		line string
+	}{
+		{"missing code", "on\n", "line 1"},
+		{"bad state", "\n# comment\nmaybe 01FF23C1\n", "line 3"},
+		{"bad code", "on 00A-17B-C49\non 01FF0080\n", "line 2"},
+		{"bad checksum", "rom ZZ TEST\n", "line 1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cheats := newTestCartridgeDevice(t, newTestCartridge(0x00, 0x00, 0x00)).Cheats()
+
+			err := cheats.Load(strings.NewReader(test.list))
+			if err == nil {
+				t.Fatalf("loading succeeded")
+			}
+			if !strings.Contains(err.Error(), test.line) {
+				t.Fatalf("expected the error to mention %q, got %v", test.line, err)
+			}
+			// Nothing is added from a bad list
+			if len(cheats.Cheats()) != 0 {
+				t.Fatalf("expected no cheats, got %+v", cheats.Cheats())
+			}
+		})
+	}
+}
@@ -79,13 +79,29 @@ func scf(state *State) instruction {
 
 // stop puts the Game Boy in stop mode. In this mode, the screen is blank and
 // the CPU stops. Stop mode is exited when a button is pressed.
+//
+// On the CGB, if a speed switch has been prepared using the KEY1 register,
+// this instruction switches between normal and double speed mode instead.
 func stop(state *State) instruction {
 	// M-Cycle 0: Fetch instruction and do operation
 
 	// For whatever reason, this instruction is two bytes in length
 	state.incrementPC()
 
-	fmt.Println("Switch to STOP mode")
+	if state.mmu.cgbMode && state.mmu.memory[key1Addr]&0x01 == 0x01 {
+		state.doubleSpeed = !state.doubleSpeed
+
+		// Bit 7 reports the current speed and the prepare bit is cleared
+		key1 := uint8(0x7E)
+		if state.doubleSpeed {
+			key1 |= 0x80
+		}
+		state.mmu.memory[key1Addr] = key1
+
+		return nil
+	}
+
+	fmt.Fprintln(state.mmu.out, "Switch to STOP mode")
 
 	state.stopped = true
 
@@ -0,0 +1,138 @@
+package gameboy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ConsoleDebugDriver is a debug driver that reads commands from and prints
+// events to a text console. It's used by default when debugging is enabled,
+// reading from stdin and printing to stdout.
+type ConsoleDebugDriver struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewConsoleDebugDriver creates a debug driver that reads commands line by
+// line from the given reader and prints events to the given writer.
+func NewConsoleDebugDriver(in io.Reader, out io.Writer) *ConsoleDebugDriver {
+	return &ConsoleDebugDriver{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// NextCommand prompts for and reads a command.
+func (driver *ConsoleDebugDriver) NextCommand() (string, error) {
+	for {
+		fmt.Fprint(driver.out, "Now what? ")
+		command, err := driver.in.ReadString('\n')
+		if err != nil && command == "" {
+			fmt.Fprintln(driver.out)
+			return "", err
+		}
+
+		command = strings.TrimSpace(command)
+		if command != "" {
+			return command, nil
+		}
+		fmt.Fprintln(driver.out, "No command received")
+	}
+}
+
+// Event prints the event.
+func (driver *ConsoleDebugDriver) Event(event DebugEvent) {
+	switch event := event.(type) {
+	case StopEvent:
+		fmt.Fprintln(driver.out, event.Reason)
+		driver.printRegisters(event.Registers)
+		fmt.Fprintf(driver.out, "  %v\n", event.Instruction)
+	case ResumeEvent:
+		if !event.Stepping {
+			fmt.Fprintln(driver.out, "Continuing")
+		}
+	case MessageEvent:
+		fmt.Fprintln(driver.out, event.Message)
+	case ErrorEvent:
+		fmt.Fprintln(driver.out, "Error:", event.Err)
+	case RegistersEvent:
+		driver.printRegisters(event.Registers)
+	case MemoryEvent:
+		driver.printMemory(event.Addr, event.Data)
+	case DisassemblyEvent:
+		for _, inst := range event.Instructions {
+			marker := "  "
+			if inst.Addr == event.PC {
+				marker = "=>"
+			}
+			fmt.Fprintf(driver.out, "%v %04X: %-9v %v\n",
+				marker, inst.Addr, formatBytes(inst.Bytes), inst)
+		}
+	case BacktraceEvent:
+		location := event.PC
+		for i, frame := range event.Frames {
+			if frame.Interrupt {
+				fmt.Fprintf(driver.out, "#%-3v %04X in %v interrupt handler, "+
+					"interrupted at %04X\n",
+					i, location, interruptName(frame.Target), frame.CallAddr)
+			} else {
+				fmt.Fprintf(driver.out, "#%-3v %04X in %04X, called from %04X\n",
+					i, location, frame.Target, frame.CallAddr)
+			}
+			location = frame.ReturnAddr
+		}
+		fmt.Fprintf(driver.out, "#%-3v %04X\n", len(event.Frames), location)
+	case BreakpointsEvent:
+		if len(event.Breakpoints) == 0 {
+			fmt.Fprintln(driver.out, "No breakpoints")
+		}
+		for _, bp := range event.Breakpoints {
+			fmt.Fprintf(driver.out, "  %v (%v hits)\n", bp, bp.Hits)
+		}
+	default:
+		panic(fmt.Sprintf("unknown debug event type %T", event))
+	}
+}
+
+func (driver *ConsoleDebugDriver) printRegisters(regs Registers) {
+	fmt.Fprintf(driver.out, "  AF: %#04x\n", uint16(regs.A)<<8|uint16(regs.F))
+	fmt.Fprintf(driver.out, "  BC: %#04x\n", uint16(regs.B)<<8|uint16(regs.C))
+	fmt.Fprintf(driver.out, "  DE: %#04x\n", uint16(regs.D)<<8|uint16(regs.E))
+	fmt.Fprintf(driver.out, "  HL: %#04x\n", uint16(regs.H)<<8|uint16(regs.L))
+	fmt.Fprintf(driver.out, "  SP: %#04x\n", regs.SP)
+	fmt.Fprintf(driver.out, "  PC: %#04x\n", regs.PC)
+}
+
+// printMemory prints a hex dump of the data, 16 bytes per line.
+func (driver *ConsoleDebugDriver) printMemory(addr uint16, data []uint8) {
+	if len(data) == 1 {
+		fmt.Fprintf(driver.out, "Value at address %#04x: %#x\n", addr, data[0])
+		return
+	}
+
+	for start := 0; start < len(data); start += 16 {
+		line := data[start:minInt(start+16, len(data))]
+
+		var ascii strings.Builder
+		for _, b := range line {
+			if b >= 0x20 && b < 0x7F {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(driver.out, "%04X: %-47v  %v\n",
+			addr+uint16(start), formatBytes(line), ascii.String())
+	}
+}
+
+// formatBytes formats bytes as space-separated hex values.
+func formatBytes(data []uint8) string {
+	hex := make([]string, len(data))
+	for i, b := range data {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, " ")
+}
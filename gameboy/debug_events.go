@@ -0,0 +1,108 @@
+package gameboy
+
+// DebugEvent is something reported by the debugger to a DebugDriver. It's
+// one of the *Event types in this file.
+type DebugEvent interface {
+	isDebugEvent()
+}
+
+// StopEvent is sent when emulation stops, before the debugger starts
+// reading commands.
+type StopEvent struct {
+	// Reason describes why emulation stopped, like "Step" or "Hit breakpoint
+	// 1 at 0150".
+	Reason string
+	// Breakpoint is the breakpoint that was hit, or nil if emulation stopped
+	// for another reason, like stepping.
+	Breakpoint *Breakpoint
+	// Addr is the address that was accessed, if the breakpoint is a
+	// watchpoint.
+	Addr uint16
+	// Registers contains the values of the CPU registers. PC is the address
+	// of the next instruction to be run.
+	Registers Registers
+	// Instruction is the next instruction to be run.
+	Instruction Instruction
+}
+
+// ResumeEvent is sent when a command resumes emulation.
+type ResumeEvent struct {
+	// Stepping is true if emulation will stop again once the step is done,
+	// as opposed to running until a breakpoint is hit.
+	Stepping bool
+}
+
+// MessageEvent is sent to report information that doesn't have a more
+// specific event, like confirming that a command worked.
+type MessageEvent struct {
+	Message string
+}
+
+// ErrorEvent is sent when a command fails.
+type ErrorEvent struct {
+	Err error
+}
+
+// RegistersEvent is sent in response to a request for the CPU registers.
+type RegistersEvent struct {
+	Registers Registers
+}
+
+// MemoryEvent is sent in response to a request for memory.
+type MemoryEvent struct {
+	// Addr is the address of the first byte of data.
+	Addr uint16
+	Data []uint8
+}
+
+// DisassemblyEvent is sent in response to a request for disassembled code.
+type DisassemblyEvent struct {
+	// PC is the address of the next instruction to be run, which may or may
+	// not be one of the instructions.
+	PC           uint16
+	Instructions []Instruction
+}
+
+// BacktraceEvent is sent in response to a request for the emulated call
+// stack.
+type BacktraceEvent struct {
+	// PC is the address of the next instruction to be run.
+	PC uint16
+	// Frames contains the functions and interrupt handlers that are running,
+	// innermost first.
+	Frames []StackFrame
+}
+
+// BreakpointsEvent is sent in response to a request for the list of
+// breakpoints.
+type BreakpointsEvent struct {
+	Breakpoints []Breakpoint
+}
+
+func (StopEvent) isDebugEvent()        {}
+func (ResumeEvent) isDebugEvent()      {}
+func (MessageEvent) isDebugEvent()     {}
+func (ErrorEvent) isDebugEvent()       {}
+func (RegistersEvent) isDebugEvent()   {}
+func (MemoryEvent) isDebugEvent()      {}
+func (DisassemblyEvent) isDebugEvent() {}
+func (BacktraceEvent) isDebugEvent()   {}
+func (BreakpointsEvent) isDebugEvent() {}
+
+// StackFrame is a function or interrupt handler on the emulated call stack.
+// Frames are tracked by watching for calls and interrupt dispatches, and are
+// removed once the stack pointer moves above their return address.
+type StackFrame struct {
+	// Target is the address of the function or interrupt handler.
+	Target uint16
+	// CallAddr is the address of the CALL or RST instruction that called
+	// the function. For interrupts, it's the address of the instruction
+	// that was interrupted.
+	CallAddr uint16
+	// ReturnAddr is the address that the function returns to.
+	ReturnAddr uint16
+	// SP is the address of the return address on the stack.
+	SP uint16
+	// Interrupt is true if the frame is for an interrupt handler.
+	Interrupt bool
+}
@@ -1,114 +1,410 @@
 package gameboy
 
 import (
-	"bufio"
 	"fmt"
-	"os"
-	"runtime/debug"
-	"strconv"
-	"strings"
+	"sync"
 )
 
 var printWarnings = false
 
+// stepMode describes how the debugger is stepping through code after it
+// stops.
+type stepMode int
+
+const (
+	// stepNone runs until a breakpoint is hit.
+	stepNone stepMode = iota
+	// stepInto stops before the next instruction.
+	stepInto
+	// stepOut stops after the current function returns.
+	stepOut
+)
+
+// maxStackFrames is the most stack frames that are tracked. Code that
+// manipulates the stack in unusual ways could otherwise make the tracked
+// call stack grow forever.
+const maxStackFrames = 1024
+
 type debugger struct {
-	state *State
+	state  *State
+	driver DebugDriver
 
-	breakOnPC        *uint16
-	breakOnOpcode    *uint8
-	breakOnAddrRead  *uint16
-	breakOnAddrWrite *uint16
-	stepping         bool
+	// mutex guards the breakpoints, which may be changed from other
+	// goroutines while the device is running.
+	mutex       sync.Mutex
+	breakpoints []*Breakpoint
+	nextID      int
+
+	breakOnOpcode *uint8
+
+	step stepMode
+	// stepSP is the stack pointer when stepping out started.
+	stepSP uint16
+	// lastOpcode is the opcode of the last instruction that was run.
+	lastOpcode uint8
+	// pendingStop is the reason that the debugger should stop before the
+	// next instruction, or nil. Watchpoints and interrupt breakpoints are hit
+	// partway through an instruction, so the stop is delayed until it's
+	// done.
+	pendingStop *StopEvent
+
+	// frames is the emulated call stack, innermost last.
+	frames []StackFrame
+	// pendingCall is the CALL or RST instruction being run, or nil. Whether
+	// a conditional call was taken isn't known until it's done.
+	pendingCall *StackFrame
 }
 
+// debuggerAttacher is implemented by debug drivers that need direct access
+// to the debugger, instead of only going through commands.
+type debuggerAttacher interface {
+	// attach is called once the debugger has been created.
+	attach(db *debugger)
+}
+
+func (db *debugger) addBreakpoint(bp Breakpoint) (int, error) {
+	if bp.Condition != "" {
+		var err error
+		bp.condition, err = parseExpression(bp.Condition)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.nextID++
+	bp.ID = db.nextID
+	bp.Hits = 0
+	db.breakpoints = append(db.breakpoints, &bp)
+
+	return bp.ID, nil
+}
+
+func (db *debugger) removeBreakpoint(id int) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for i, bp := range db.breakpoints {
+		if bp.ID == id && !bp.temporary {
+			db.breakpoints = append(db.breakpoints[:i], db.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoSuchBreakpoint
+}
+
+func (db *debugger) setIgnoreCount(id int, count int) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, bp := range db.breakpoints {
+		if bp.ID == id && !bp.temporary {
+			bp.IgnoreCount = bp.Hits + count
+			return nil
+		}
+	}
+	return ErrNoSuchBreakpoint
+}
+
+func (db *debugger) listBreakpoints() []Breakpoint {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var breakpoints []Breakpoint
+	for _, bp := range db.breakpoints {
+		if !bp.temporary {
+			breakpoints = append(breakpoints, *bp)
+		}
+	}
+	return breakpoints
+}
+
+// addTemporaryBreakpoint adds a breakpoint at the given address that's
+// removed the next time emulation stops. The breakpoint only applies while
+// the current ROM bank is mapped. If minSP is provided, the breakpoint is
+// only hit when the stack pointer is at least that value, which skips over
+// recursive calls.
+func (db *debugger) addTemporaryBreakpoint(addr uint16, bank int, minSP *uint16) {
+	bp := &Breakpoint{
+		Kind:      BreakOnExecute,
+		Start:     addr,
+		End:       addr,
+		Bank:      bank,
+		temporary: true,
+	}
+	if minSP != nil {
+		sp := *minSP
+		bp.condition = func(state *State) int {
+			return boolToInt(state.regSP.get() >= sp)
+		}
+	}
+
+	db.mutex.Lock()
+	db.breakpoints = append(db.breakpoints, bp)
+	db.mutex.Unlock()
+}
+
+// removeTemporaryBreakpoints removes all breakpoints used for stepping.
+func (db *debugger) removeTemporaryBreakpoints() {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var kept []*Breakpoint
+	for _, bp := range db.breakpoints {
+		if !bp.temporary {
+			kept = append(kept, bp)
+		}
+	}
+	db.breakpoints = kept
+}
+
+// matches returns true if the breakpoint covers the given address, taking
+// the currently mapped ROM bank into account.
+func (db *debugger) matches(bp *Breakpoint, addr uint16) bool {
+	if addr < bp.Start || addr > bp.End {
+		return false
+	}
+	if bp.Bank == AnyBank {
+		return true
+	}
+	bank := db.state.mmu.romBankAt(addr)
+	return bank == -1 || bank == bp.Bank
+}
+
+// hit records that the breakpoint was reached and returns true if emulation
+// should stop. Breakpoints whose condition is false don't count as being
+// hit.
+func (db *debugger) hit(bp *Breakpoint) bool {
+	if bp.condition != nil && bp.condition(db.state) == 0 {
+		return false
+	}
+	bp.Hits++
+	return bp.Hits > bp.IgnoreCount
+}
+
+// pcHook is called before the instruction at the given address is fetched.
 func (db *debugger) pcHook(pc uint16) {
-	if db.breakOnPC != nil && pc == *db.breakOnPC {
-		fmt.Printf("PC BREAK: %#04x\n", pc)
-		db.stepping = true
+	db.updateCallStack()
+
+	if event := db.checkInstruction(pc); event != nil {
+		db.stop(event)
 	}
 }
 
+// checkInstruction returns the reason to stop before running the instruction
+// at the given address, or nil if emulation should continue.
+func (db *debugger) checkInstruction(pc uint16) *StopEvent {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.pendingStop != nil {
+		event := db.pendingStop
+		db.pendingStop = nil
+		return event
+	}
+
+	switch db.step {
+	case stepInto:
+		return &StopEvent{Reason: "Step"}
+	case stepOut:
+		// The function has returned once a return instruction pops the
+		// stack above where it was when stepping out started
+		if isReturnOpcode(db.lastOpcode) && db.state.regSP.get() > db.stepSP {
+			return &StopEvent{Reason: "Step out"}
+		}
+	}
+
+	if db.breakOnOpcode != nil && db.state.mmu.peek(pc) == *db.breakOnOpcode {
+		return &StopEvent{Reason: fmt.Sprintf("Opcode %#02x", *db.breakOnOpcode)}
+	}
+
+	for _, bp := range db.breakpoints {
+		if bp.Kind != BreakOnExecute || !db.matches(bp, pc) {
+			continue
+		}
+		if db.hit(bp) {
+			if bp.temporary {
+				return &StopEvent{Reason: fmt.Sprintf("Reached %#04x", pc)}
+			}
+			return db.breakpointStop(bp, "")
+		}
+	}
+
+	return nil
+}
+
+// breakpointStop creates a stop event for a breakpoint being hit. The detail
+// describes what happened, if the breakpoint itself isn't enough.
+func (db *debugger) breakpointStop(bp *Breakpoint, detail string) *StopEvent {
+	hit := *bp
+	event := &StopEvent{
+		Reason:     fmt.Sprintf("Hit %v (%v hits)", bp, bp.Hits),
+		Breakpoint: &hit,
+	}
+	if detail != "" {
+		event.Reason += ": " + detail
+	}
+	return event
+}
+
+// requestStop makes the debugger stop before the next instruction is run.
+// It's safe to call from any goroutine.
+func (db *debugger) requestStop(reason string) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.pendingStop == nil {
+		db.pendingStop = &StopEvent{Reason: reason}
+	}
+}
+
+// isReturnOpcode returns true if the opcode is one of the RET or RETI
+// instructions.
+func isReturnOpcode(opcode uint8) bool {
+	switch opcode {
+	case 0xC0, 0xC8, 0xC9, 0xD0, 0xD8, 0xD9:
+		return true
+	default:
+		return false
+	}
+}
+
+// opcodeHook is called after an instruction's opcode is fetched.
 func (db *debugger) opcodeHook(opcode uint8) {
-	if (db.breakOnOpcode != nil && opcode == *db.breakOnOpcode) || db.stepping {
-		fmt.Printf("Opcode BREAK: %#02x\n", opcode)
-		db.printState()
-		db.readCommand()
+	db.lastOpcode = opcode
+
+	switch opcode {
+	case 0xC4, 0xCC, 0xCD, 0xD4, 0xDC, 0xC7, 0xCF, 0xD7, 0xDF, 0xE7, 0xEF, 0xF7, 0xFF:
+		// This is a call, though conditional calls might not be taken
+		start := db.state.instructionStart
+		db.pendingCall = &StackFrame{
+			CallAddr:   start,
+			ReturnAddr: start + uint16(mainOpcodes[opcode].length()),
+			SP:         db.state.regSP.get() - 2,
+		}
 	}
 }
 
-func (db *debugger) memReadHook(addr uint16) {
-	if db.breakOnAddrRead != nil && addr == *db.breakOnAddrRead {
-		fmt.Printf("Address read BREAK: %#04x\n", addr)
-		db.printState()
-		db.readCommand()
+// updateCallStack updates the emulated call stack after an instruction is
+// done.
+func (db *debugger) updateCallStack() {
+	sp := db.state.regSP.get()
+
+	// Remove frames whose return address has been popped off the stack
+	for len(db.frames) > 0 && db.frames[len(db.frames)-1].SP < sp {
+		db.frames = db.frames[:len(db.frames)-1]
+	}
+
+	if db.pendingCall != nil {
+		// The call was taken if the return address was pushed
+		if sp == db.pendingCall.SP {
+			frame := *db.pendingCall
+			frame.Target = db.state.regPC.get()
+			db.pushFrame(frame)
+		}
+		db.pendingCall = nil
+	}
+}
+
+// pushFrame adds a frame to the emulated call stack.
+func (db *debugger) pushFrame(frame StackFrame) {
+	if len(db.frames) == maxStackFrames {
+		db.frames = db.frames[1:]
 	}
+	db.frames = append(db.frames, frame)
+}
+
+// backtrace returns the emulated call stack, innermost first.
+func (db *debugger) backtrace() []StackFrame {
+	frames := make([]StackFrame, len(db.frames))
+	for i, frame := range db.frames {
+		frames[len(frames)-1-i] = frame
+	}
+	return frames
+}
+
+// memReadHook is called when the CPU reads data from memory. Instruction
+// fetches and DMA transfers don't count.
+func (db *debugger) memReadHook(addr uint16) {
+	db.checkWatchpoints(addr, BreakOnRead, func() string {
+		return fmt.Sprintf("read from %#04x", addr)
+	})
 }
 
+// memWriteHook is called when the CPU writes to memory.
 func (db *debugger) memWriteHook(addr uint16, val uint8) {
-	if db.breakOnAddrWrite != nil && addr == *db.breakOnAddrWrite {
-		fmt.Printf("Address write BREAK: %#04x with value %#04x\n", addr, val)
-		db.printState()
-		db.readCommand()
-	}
-}
-
-func (db *debugger) printState() {
-	fmt.Printf("  AF: %#04x\n", db.state.regAF.get())
-	fmt.Printf("  BC: %#04x\n", db.state.regBC.get())
-	fmt.Printf("  DE: %#04x\n", db.state.regDE.get())
-	fmt.Printf("  HL: %#04x\n", db.state.regHL.get())
-	fmt.Printf("  SP: %#04x\n", db.state.regSP.get())
-	fmt.Printf("  PC: %#04x\n", db.state.instructionStart)
-	fmt.Printf("  DV: %#02x\n", db.state.mmu.at(dividerAddr))
-}
-
-func (db *debugger) readCommand() {
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Print("Now what? ")
-		command, _ := reader.ReadString('\n')
-		if len(command) < 2 {
-			fmt.Println("\nNo command received")
+	db.checkWatchpoints(addr, BreakOnWrite, func() string {
+		return fmt.Sprintf("wrote %#02x to %#04x", val, addr)
+	})
+}
+
+// checkWatchpoints checks for watchpoints on an access to the given address,
+// scheduling a stop if one is hit. The access description is only created if
+// it's needed.
+func (db *debugger) checkWatchpoints(addr uint16, kind BreakpointKind, access func() string) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, bp := range db.breakpoints {
+		if bp.Kind != kind && bp.Kind != BreakOnAccess {
 			continue
 		}
-		// Remove trailing newline
-		command = command[:len(command)-1]
-
-		if command == "c" {
-			fmt.Println("Continuing")
-			db.stepping = false
-			break
-		} else if command == "n" {
-			fmt.Println("Stepping")
-			db.stepping = true
-			break
-		} else if strings.HasPrefix(command, "m") {
-			// Parse the command
-			splitted := strings.Split(command, " ")
-			if len(splitted) != 2 {
-				fmt.Println("Format: m [address in hex]")
-				continue
-			}
-			addr, err := strconv.ParseUint(splitted[1], 16, 16)
-			if err != nil {
-				fmt.Println("Error parsing address:", err)
-				continue
-			}
+		if !db.matches(bp, addr) {
+			continue
+		}
+		if db.hit(bp) && db.pendingStop == nil {
+			db.pendingStop = db.breakpointStop(bp, access())
+			db.pendingStop.Addr = addr
+		}
+	}
+}
+
+// interruptHook is called when an interrupt is dispatched, before the
+// program counter is pushed to the stack and moved to the handler.
+func (db *debugger) interruptHook(target uint16) {
+	db.updateCallStack()
+
+	pc := db.state.regPC.get()
+	db.pushFrame(StackFrame{
+		Target:     target,
+		CallAddr:   pc,
+		ReturnAddr: pc,
+		SP:         db.state.regSP.get() - 2,
+		Interrupt:  true,
+	})
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
 
-			// Get the address, temporarily turning off break on read to avoid
-			// a break loop
-			oldBreakOnAddrRead := db.breakOnAddrRead
-			db.breakOnAddrRead = nil
-			fmt.Printf("Value at address %#04x: %#x\n",
-				uint16(addr),
-				db.state.mmu.at(uint16(addr)))
-			db.breakOnAddrRead = oldBreakOnAddrRead
-		} else if command == "trace" {
-			stacktrace := debug.Stack()
-			fmt.Println(string(stacktrace))
-		} else {
-			fmt.Printf("Unknown command '%v'\n", command)
+	for _, bp := range db.breakpoints {
+		if bp.Kind != BreakOnInterrupt || target < bp.Start || target > bp.End {
 			continue
 		}
+		if db.hit(bp) && db.pendingStop == nil {
+			db.pendingStop = db.breakpointStop(bp,
+				fmt.Sprintf("dispatched %v interrupt", interruptName(target)))
+		}
 	}
 }
+
+// stop pauses emulation and runs commands from the driver until one of them
+// resumes emulation.
+func (db *debugger) stop(event *StopEvent) {
+	db.removeTemporaryBreakpoints()
+	db.step = stepNone
+
+	event.Registers = db.state.registers()
+	event.Instruction = db.decode(db.state.instructionStart)
+	db.driver.Event(*event)
+
+	db.runCommands()
+}
+
+// decode decodes the instruction at the given address.
+func (db *debugger) decode(addr uint16) Instruction {
+	inst := disassemble(db.state.mmu.peek, addr, 0xFFFF)
+	inst.Bank = db.state.mmu.romBankAt(addr)
+	return inst
+}
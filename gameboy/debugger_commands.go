@@ -0,0 +1,397 @@
+package gameboy
+
+import (
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const debuggerHelp = `Commands:
+  c, continue               Continue running
+  s, step                   Run one instruction
+  n, next                   Run one instruction, stepping over calls
+  finish                    Run until the current function returns
+  u, until LOCATION         Run until LOCATION is reached
+  b, break LOCATION [if EXPR]
+                            Stop before running code at LOCATION
+  watch RANGE [if EXPR]     Stop after writes to RANGE
+  rwatch RANGE [if EXPR]    Stop after reads from RANGE
+  awatch RANGE [if EXPR]    Stop after reads from or writes to RANGE
+  catch [INTERRUPT] [if EXPR]
+                            Stop when an interrupt is dispatched
+  i, info                   List breakpoints
+  d, delete ID              Remove a breakpoint
+  ignore ID COUNT           Skip the next COUNT hits of a breakpoint
+  r, registers              Print the CPU registers
+  set REGISTER EXPR         Change a CPU register
+  m ADDRESS                 Print the value at ADDRESS
+  x RANGE, x ADDRESS [COUNT]
+                            Print a hex dump of memory
+  w ADDRESS VALUE...        Write values to memory starting at ADDRESS
+  dis [LOCATION [COUNT]]    Disassemble code around the PC or at LOCATION
+  bt, backtrace             Print the emulated call stack
+  trace                     Print the emulator's stack trace
+
+Locations and addresses are hexadecimal and may include a ROM bank, like
+03:4567. Ranges look like C000-C0FF. Interrupts are vblank, stat, timer,
+serial and joypad. Expressions and values may use registers, numbers ($10 is
+hexadecimal) and memory reads like [hl], combined with operators like ==, !=,
+<, &&, || and &.`
+
+// The default amount of memory or code printed by commands.
+const (
+	defaultDumpSize         = 64
+	defaultDisassemblyCount = 16
+	disassemblyBefore       = 5
+	disassemblyAfter        = 10
+)
+
+// runCommands runs commands from the driver until one of them resumes
+// emulation.
+func (db *debugger) runCommands() {
+	for {
+		command, err := db.driver.NextCommand()
+		if err != nil {
+			db.driver.Event(ErrorEvent{xerrors.Errorf("reading command, continuing: %w", err)})
+			db.driver.Event(ResumeEvent{})
+			return
+		}
+
+		resume, err := db.runCommand(strings.TrimSpace(command))
+		if err != nil {
+			db.driver.Event(ErrorEvent{err})
+		}
+		if resume {
+			db.driver.Event(ResumeEvent{Stepping: db.step != stepNone})
+			return
+		}
+	}
+}
+
+// runCommand runs a single command, returning true if emulation should
+// resume.
+func (db *debugger) runCommand(command string) (resume bool, err error) {
+	// Split the command name from its arguments
+	name, args := command, ""
+	if i := strings.IndexAny(command, " \t"); i != -1 {
+		name, args = command[:i], strings.TrimSpace(command[i+1:])
+	}
+
+	switch name {
+	case "c", "continue":
+		return true, nil
+	case "s", "step":
+		db.step = stepInto
+		return true, nil
+	case "n", "next":
+		inst := db.decode(db.state.instructionStart)
+		if inst.IsCall {
+			// Run until the call returns to the next instruction
+			sp := db.state.regSP.get()
+			db.addTemporaryBreakpoint(inst.Addr+uint16(len(inst.Bytes)), inst.Bank, &sp)
+		} else {
+			db.step = stepInto
+		}
+		return true, nil
+	case "finish":
+		db.step = stepOut
+		db.stepSP = db.state.regSP.get()
+		return true, nil
+	case "u", "until":
+		bank, start, _, err := parseLocation(args)
+		if err != nil {
+			return false, xerrors.Errorf("parsing location: %w", err)
+		}
+		db.addTemporaryBreakpoint(start, bank, nil)
+		return true, nil
+	case "b", "break", "watch", "rwatch", "awatch", "catch":
+		kind := map[string]BreakpointKind{
+			"b":      BreakOnExecute,
+			"break":  BreakOnExecute,
+			"watch":  BreakOnWrite,
+			"rwatch": BreakOnRead,
+			"awatch": BreakOnAccess,
+			"catch":  BreakOnInterrupt,
+		}[name]
+
+		bp, err := ParseBreakpoint(kind, args)
+		if err != nil {
+			return false, xerrors.Errorf("parsing breakpoint: %w", err)
+		}
+		bp.ID, err = db.addBreakpoint(bp)
+		if err != nil {
+			return false, xerrors.Errorf("adding breakpoint: %w", err)
+		}
+		db.driver.Event(MessageEvent{"Added " + bp.String()})
+	case "i", "info":
+		db.driver.Event(BreakpointsEvent{db.listBreakpoints()})
+	case "d", "delete":
+		id, err := strconv.Atoi(args)
+		if err != nil {
+			return false, usageError("delete ID")
+		}
+		if err := db.removeBreakpoint(id); err != nil {
+			return false, xerrors.Errorf("removing breakpoint %v: %w", id, err)
+		}
+		db.driver.Event(MessageEvent{"Removed breakpoint " + strconv.Itoa(id)})
+	case "ignore":
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return false, usageError("ignore ID COUNT")
+		}
+		id, err1 := strconv.Atoi(fields[0])
+		count, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || count < 0 {
+			return false, usageError("ignore ID COUNT")
+		}
+		if err := db.setIgnoreCount(id, count); err != nil {
+			return false, xerrors.Errorf("changing breakpoint %v: %w", id, err)
+		}
+		db.driver.Event(MessageEvent{"Ignoring the next " + fields[1] +
+			" hits of breakpoint " + fields[0]})
+	case "r", "registers":
+		db.driver.Event(RegistersEvent{db.state.registers()})
+	case "set":
+		return false, db.setRegister(args)
+	case "m":
+		addr, err := strconv.ParseUint(trimHexPrefix(args), 16, 16)
+		if err != nil {
+			return false, usageError("m ADDRESS")
+		}
+		db.driver.Event(MemoryEvent{
+			Addr: uint16(addr),
+			Data: []uint8{db.state.mmu.peek(uint16(addr))},
+		})
+	case "x":
+		return false, db.dumpMemory(args)
+	case "w":
+		return false, db.writeMemory(args)
+	case "dis":
+		return false, db.disassembleCommand(args)
+	case "bt", "backtrace":
+		db.driver.Event(BacktraceEvent{
+			PC:     db.state.instructionStart,
+			Frames: db.backtrace(),
+		})
+	case "trace":
+		db.driver.Event(MessageEvent{string(debug.Stack())})
+	case "h", "help":
+		db.driver.Event(MessageEvent{debuggerHelp})
+	default:
+		return false, xerrors.Errorf("unknown command '%v'", command)
+	}
+
+	return false, nil
+}
+
+// usageError creates an error that shows the correct way to use a command.
+func usageError(usage string) error {
+	return xerrors.Errorf("usage: %v", usage)
+}
+
+// evaluate parses and evaluates an expression.
+func (db *debugger) evaluate(text string) (int, error) {
+	expr, err := parseExpression(text)
+	if err != nil {
+		return 0, err
+	}
+	return expr(db.state), nil
+}
+
+// setRegister runs the "set" command, which changes the value of a register.
+func (db *debugger) setRegister(args string) error {
+	fields := strings.SplitN(args, " ", 2)
+	if len(fields) != 2 {
+		return usageError("set REGISTER EXPR")
+	}
+	name := strings.ToLower(fields[0])
+
+	val, err := db.evaluate(fields[1])
+	if err != nil {
+		return xerrors.Errorf("evaluating value: %w", err)
+	}
+
+	regs := db.state.registers()
+	eightBit := map[string]*uint8{
+		"a": &regs.A, "f": &regs.F, "b": &regs.B, "c": &regs.C,
+		"d": &regs.D, "e": &regs.E, "h": &regs.H, "l": &regs.L,
+	}
+	pairs := map[string][2]*uint8{
+		"af": {&regs.A, &regs.F}, "bc": {&regs.B, &regs.C},
+		"de": {&regs.D, &regs.E}, "hl": {&regs.H, &regs.L},
+	}
+
+	if reg, ok := eightBit[name]; ok {
+		if val < 0 || val > 0xFF {
+			return xerrors.Errorf("value %#x doesn't fit in register %v", val, name)
+		}
+		*reg = uint8(val)
+	} else {
+		if val < 0 || val > 0xFFFF {
+			return xerrors.Errorf("value %#x doesn't fit in register %v", val, name)
+		}
+		if pair, ok := pairs[name]; ok {
+			*pair[0] = uint8(val >> 8)
+			*pair[1] = uint8(val)
+		} else if name == "sp" {
+			regs.SP = uint16(val)
+		} else if name == "pc" {
+			regs.PC = uint16(val)
+		} else {
+			return xerrors.Errorf("unknown register '%v'", name)
+		}
+	}
+
+	db.state.setRegisters(regs)
+	db.driver.Event(RegistersEvent{db.state.registers()})
+	return nil
+}
+
+// dumpMemory runs the "x" command, which prints a range of memory.
+func (db *debugger) dumpMemory(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return usageError("x RANGE, x ADDRESS [COUNT]")
+	}
+
+	_, start, end, err := parseLocation(fields[0])
+	if err != nil {
+		return xerrors.Errorf("parsing range: %w", err)
+	}
+	size := int(end) - int(start) + 1
+	if len(fields) == 2 {
+		size, err = db.evaluate(fields[1])
+		if err != nil {
+			return xerrors.Errorf("evaluating count: %w", err)
+		}
+	} else if start == end {
+		size = defaultDumpSize
+	}
+	// Don't go past the end of memory
+	size = minInt(size, 0x10000-int(start))
+	if size <= 0 {
+		return xerrors.New("nothing to print")
+	}
+
+	data := make([]uint8, size)
+	for i := range data {
+		data[i] = db.state.mmu.peek(start + uint16(i))
+	}
+	db.driver.Event(MemoryEvent{Addr: start, Data: data})
+	return nil
+}
+
+// writeMemory runs the "w" command, which writes values to memory.
+func (db *debugger) writeMemory(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return usageError("w ADDRESS VALUE...")
+	}
+
+	addr, err := strconv.ParseUint(trimHexPrefix(fields[0]), 16, 16)
+	if err != nil {
+		return xerrors.Errorf("invalid address %q: %w", fields[0], ErrInvalidExpression)
+	}
+
+	// Evaluate everything before writing anything, so that a bad value
+	// doesn't leave the write half done
+	vals := make([]uint8, len(fields)-1)
+	for i, field := range fields[1:] {
+		val, err := db.evaluate(field)
+		if err != nil {
+			return xerrors.Errorf("evaluating value: %w", err)
+		}
+		if val < -0x80 || val > 0xFF {
+			return xerrors.Errorf("value %#x doesn't fit in a byte", val)
+		}
+		vals[i] = uint8(val)
+	}
+
+	for i, val := range vals {
+		db.state.mmu.poke(uint16(addr)+uint16(i), val)
+	}
+	db.driver.Event(MessageEvent{"Wrote " + strconv.Itoa(len(vals)) + " bytes"})
+	return nil
+}
+
+// disassembleCommand runs the "dis" command, which disassembles code around
+// the PC or at a location.
+func (db *debugger) disassembleCommand(args string) error {
+	fields := strings.Fields(args)
+	pc := db.state.instructionStart
+
+	var instructions []Instruction
+	switch len(fields) {
+	case 0:
+		instructions = db.disassembleAround(pc, disassemblyBefore, disassemblyAfter)
+	case 1, 2:
+		_, start, _, err := parseLocation(fields[0])
+		if err != nil {
+			return xerrors.Errorf("parsing location: %w", err)
+		}
+		count := defaultDisassemblyCount
+		if len(fields) == 2 {
+			count, err = db.evaluate(fields[1])
+			if err != nil {
+				return xerrors.Errorf("evaluating count: %w", err)
+			}
+		}
+		instructions = db.disassembleFrom(start, count)
+	default:
+		return usageError("dis [LOCATION [COUNT]]")
+	}
+
+	db.driver.Event(DisassemblyEvent{PC: pc, Instructions: instructions})
+	return nil
+}
+
+// disassembleFrom decodes the given number of instructions starting at the
+// given address.
+func (db *debugger) disassembleFrom(addr uint16, count int) []Instruction {
+	var instructions []Instruction
+	for i := 0; i < count; i++ {
+		inst := db.decode(addr)
+		instructions = append(instructions, inst)
+
+		next := addr + uint16(len(inst.Bytes))
+		if next < addr {
+			// Don't wrap around to the start of memory
+			break
+		}
+		addr = next
+	}
+	return instructions
+}
+
+// disassembleAround decodes instructions before and after the given address.
+// Instructions can't be reliably decoded backwards, so this looks for an
+// earlier address where decoding forwards lines up with the given one.
+func (db *debugger) disassembleAround(pc uint16, before, after int) []Instruction {
+	var instructions []Instruction
+
+	// Instructions are at most 3 bytes long
+	for start := int(pc) - before*3; start < int(pc); start++ {
+		if start < 0 {
+			continue
+		}
+
+		var candidate []Instruction
+		addr := start
+		for addr < int(pc) {
+			inst := db.decode(uint16(addr))
+			candidate = append(candidate, inst)
+			addr += len(inst.Bytes)
+		}
+		if addr == int(pc) {
+			instructions = candidate
+			break
+		}
+	}
+	if len(instructions) > before {
+		instructions = instructions[len(instructions)-before:]
+	}
+
+	return append(instructions, db.disassembleFrom(pc, after)...)
+}
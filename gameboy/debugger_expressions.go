@@ -0,0 +1,300 @@
+package gameboy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrInvalidExpression is returned when a breakpoint condition or location
+// can't be parsed.
+var ErrInvalidExpression = xerrors.New("invalid expression")
+
+// expression is a compiled debugger expression, like "a == $3 && [hl] != 0".
+// It evaluates to an integer, where any nonzero value is considered true.
+type expression func(state *State) int
+
+// binaryOperators lists the binary operators that may appear in an
+// expression, grouped by precedence from lowest to highest.
+var binaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+}
+
+// expressionRegisters maps register names to functions that read them. PC
+// reads the start of the current instruction, so that it's meaningful in
+// watchpoint conditions that are checked partway through an instruction.
+var expressionRegisters = map[string]func(state *State) int{
+	"a":  func(state *State) int { return int(state.regA.get()) },
+	"f":  func(state *State) int { return int(state.regF.get()) },
+	"b":  func(state *State) int { return int(state.regB.get()) },
+	"c":  func(state *State) int { return int(state.regC.get()) },
+	"d":  func(state *State) int { return int(state.regD.get()) },
+	"e":  func(state *State) int { return int(state.regE.get()) },
+	"h":  func(state *State) int { return int(state.regH.get()) },
+	"l":  func(state *State) int { return int(state.regL.get()) },
+	"af": func(state *State) int { return int(state.regAF.get()) },
+	"bc": func(state *State) int { return int(state.regBC.get()) },
+	"de": func(state *State) int { return int(state.regDE.get()) },
+	"hl": func(state *State) int { return int(state.regHL.get()) },
+	"sp": func(state *State) int { return int(state.regSP.get()) },
+	"pc": func(state *State) int { return int(state.instructionStart) },
+}
+
+// Evaluate evaluates an expression using the syntax of breakpoint
+// conditions, like "[hl] + 1", and returns its value. Memory is read without
+// triggering debugger breakpoints.
+func (device *Device) Evaluate(expr string) (int, error) {
+	compiled, err := parseExpression(expr)
+	if err != nil {
+		return 0, err
+	}
+	return compiled(device.state), nil
+}
+
+// parseExpression compiles an expression made of registers, numbers, memory
+// reads and operators. Numbers are decimal unless prefixed with "$" or "0x"
+// for hexadecimal, or "%" for binary. A memory read is written as an address
+// expression in brackets, like "[hl]", and reads a single byte.
+func parseExpression(text string) (expression, error) {
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, xerrors.Errorf("empty expression: %w", ErrInvalidExpression)
+	}
+
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, xerrors.Errorf("unexpected %q: %w", p.tokens[p.pos], ErrInvalidExpression)
+	}
+
+	return expr, nil
+}
+
+// tokenizeExpression splits an expression into numbers, names and operators.
+func tokenizeExpression(text string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(text); {
+		char := rune(text[i])
+
+		switch {
+		case unicode.IsSpace(char):
+			i++
+		case char == '$' || char == '%' || unicode.IsLetter(char) || unicode.IsDigit(char):
+			start := i
+			i++
+			for i < len(text) && (unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i]))) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		case strings.ContainsRune("()[]+-~", char):
+			tokens = append(tokens, string(char))
+			i++
+		case strings.ContainsRune("=!<>&|^", char):
+			// These may be one or two characters long
+			if i+1 < len(text) {
+				switch op := text[i : i+2]; op {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			if char == '=' {
+				return nil, xerrors.Errorf("use \"==\" for comparisons: %w", ErrInvalidExpression)
+			}
+			tokens = append(tokens, string(char))
+			i++
+		default:
+			return nil, xerrors.Errorf("unexpected character %q: %w", char, ErrInvalidExpression)
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseNumber parses a number in the syntax used by expressions.
+func parseNumber(text string) (int, error) {
+	base := 10
+	digits := text
+	switch {
+	case strings.HasPrefix(text, "$"):
+		base, digits = 16, text[1:]
+	case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+		base, digits = 16, text[2:]
+	case strings.HasPrefix(text, "%"):
+		base, digits = 2, text[1:]
+	}
+
+	val, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, xerrors.Errorf("invalid number %q: %w", text, ErrInvalidExpression)
+	}
+	return int(val), nil
+}
+
+// expressionParser is a recursive descent parser for expressions.
+type expressionParser struct {
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token, or an empty string if there are none left.
+func (p *expressionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// expect consumes the next token, returning an error if it isn't the given
+// one.
+func (p *expressionParser) expect(token string) error {
+	if p.peek() != token {
+		return xerrors.Errorf("expected %q: %w", token, ErrInvalidExpression)
+	}
+	p.pos++
+	return nil
+}
+
+// parseBinary parses a chain of binary operators whose precedence is at
+// least the given level.
+func (p *expressionParser) parseBinary(level int) (expression, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		found := false
+		for _, candidate := range binaryOperators[level] {
+			if op == candidate {
+				found = true
+			}
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpression(op, left, right)
+	}
+}
+
+// parseUnary parses an operand, optionally preceded by unary operators.
+func (p *expressionParser) parseUnary() (expression, error) {
+	switch op := p.peek(); op {
+	case "!", "-", "~":
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "!":
+			return func(state *State) int { return boolToInt(operand(state) == 0) }, nil
+		case "-":
+			return func(state *State) int { return -operand(state) }, nil
+		default:
+			return func(state *State) int { return ^operand(state) }, nil
+		}
+	case "(":
+		p.pos++
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case "[":
+		p.pos++
+		addr, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return func(state *State) int {
+			return int(state.mmu.peek(uint16(addr(state))))
+		}, nil
+	case "":
+		return nil, xerrors.Errorf("unexpected end of expression: %w", ErrInvalidExpression)
+	default:
+		p.pos++
+		if reg, ok := expressionRegisters[strings.ToLower(op)]; ok {
+			return reg, nil
+		}
+		val, err := parseNumber(op)
+		if err != nil {
+			return nil, err
+		}
+		return func(state *State) int { return val }, nil
+	}
+}
+
+// binaryExpression combines two expressions with a binary operator.
+func binaryExpression(op string, left, right expression) expression {
+	switch op {
+	case "||":
+		return func(state *State) int { return boolToInt(left(state) != 0 || right(state) != 0) }
+	case "&&":
+		return func(state *State) int { return boolToInt(left(state) != 0 && right(state) != 0) }
+	case "|":
+		return func(state *State) int { return left(state) | right(state) }
+	case "^":
+		return func(state *State) int { return left(state) ^ right(state) }
+	case "&":
+		return func(state *State) int { return left(state) & right(state) }
+	case "==":
+		return func(state *State) int { return boolToInt(left(state) == right(state)) }
+	case "!=":
+		return func(state *State) int { return boolToInt(left(state) != right(state)) }
+	case "<=":
+		return func(state *State) int { return boolToInt(left(state) <= right(state)) }
+	case ">=":
+		return func(state *State) int { return boolToInt(left(state) >= right(state)) }
+	case "<":
+		return func(state *State) int { return boolToInt(left(state) < right(state)) }
+	case ">":
+		return func(state *State) int { return boolToInt(left(state) > right(state)) }
+	case "+":
+		return func(state *State) int { return left(state) + right(state) }
+	case "-":
+		return func(state *State) int { return left(state) - right(state) }
+	default:
+		panic(fmt.Sprintf("unknown binary operator %q", op))
+	}
+}
+
+// boolToInt converts a boolean to 1 or 0.
+func boolToInt(val bool) int {
+	if val {
+		return 1
+	}
+	return 0
+}
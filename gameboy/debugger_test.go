@@ -0,0 +1,403 @@
+package gameboy
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// debuggerTestProgram calls a few functions, including one in ROM bank 2,
+// reads from 0xC000, writes to 0xC010, then enables the VBlank interrupt and
+// counts up in B forever.
+var debuggerTestProgram = map[int][]uint8{
+	0x0040: {0xD9},                   // reti
+	0x0100: {0x00, 0xC3, 0x50, 0x01}, // nop; jp $0150
+	0x0150: {
+		0x31, 0xFE, 0xDF, // ld sp, $DFFE
+		0xCD, 0x00, 0x02, // $0153: call $0200
+		0xFA, 0x00, 0xC0, // $0156: ld a, [$C000]
+		0xEA, 0x10, 0xC0, // $0159: ld [$C010], a
+		0x3E, 0x02, // $015C: ld a, $02
+		0xEA, 0x00, 0x20, // $015E: ld [$2000], a
+		0xCD, 0x00, 0x40, // $0161: call $4000
+		0x3E, 0x01, // $0164: ld a, $01
+		0xE0, 0xFF, // $0166: ldh [rIE], a
+		0xFB,       // $0168: ei
+		0x04,       // $0169: inc b
+		0x18, 0xFD, // $016A: jr $0169
+	},
+	0x0200: {
+		0xCD, 0x10, 0x02, // call $0210
+		0xC9, // $0203: ret
+	},
+	0x0210: {
+		0x0E, 0x07, // ld c, $07
+		0xC9, // $0212: ret
+	},
+	0x4000: {0xC9},             // Bank 1: ret
+	0x8000: {0x16, 0x42, 0xC9}, // Bank 2: ld d, $42; ret
+}
+
+// testDebugDriver runs a script of debugger commands and records events.
+// Once the script runs out, emulation is continued.
+type testDebugDriver struct {
+	t        *testing.T
+	commands []string
+	events   []DebugEvent
+	stops    []StopEvent
+}
+
+func (driver *testDebugDriver) NextCommand() (string, error) {
+	if len(driver.commands) == 0 {
+		return "c", nil
+	}
+	command := driver.commands[0]
+	driver.commands = driver.commands[1:]
+	return command, nil
+}
+
+func (driver *testDebugDriver) Event(event DebugEvent) {
+	driver.events = append(driver.events, event)
+	if stop, ok := event.(StopEvent); ok {
+		driver.stops = append(driver.stops, stop)
+	}
+	if err, ok := event.(ErrorEvent); ok {
+		driver.t.Errorf("debugger error: %v", err.Err)
+	}
+}
+
+// newDebuggerTestDevice creates a device running debuggerTestProgram with the
+// given breakpoints.
+func newDebuggerTestDevice(t *testing.T, driver *testDebugDriver, specs map[string]BreakpointKind, opts ...Option) *Device {
+	t.Helper()
+
+	// MBC1 with 64 KB of ROM
+	rom := newTestCartridge(0x01, 0x01, 0x00)
+	for addr, code := range debuggerTestProgram {
+		copy(rom[addr:], code)
+	}
+
+	var breakpoints []Breakpoint
+	for spec, kind := range specs {
+		bp, err := ParseBreakpoint(kind, spec)
+		if err != nil {
+			t.Fatalf("parsing breakpoint %q: %v", spec, err)
+		}
+		breakpoints = append(breakpoints, bp)
+	}
+
+	opts = append([]Option{WithoutBootROM(), WithModel(ModelDMG), WithOutput(ioutil.Discard)}, opts...)
+	device, err := NewDevice(
+		nil,
+		rom,
+		&testROMVideoDriver{},
+		&noopInputDriver{},
+		&testROMSaveGameDriver{},
+		DebugConfiguration{Debugging: true, Driver: driver, Breakpoints: breakpoints},
+		opts...)
+	if err != nil {
+		t.Fatalf("creating device: %v", err)
+	}
+	return device
+}
+
+// runUntilStops runs the device until the debugger has stopped the given
+// number of times, or a few frames have passed.
+func runUntilStops(t *testing.T, device *Device, driver *testDebugDriver, stops int) {
+	t.Helper()
+
+	for frame := 0; frame < 5 && len(driver.stops) < stops; frame++ {
+		_, err := device.RunFrameUntil(func() bool {
+			return len(driver.stops) >= stops
+		})
+		if err != nil {
+			t.Fatalf("running: %v", err)
+		}
+	}
+	if len(driver.stops) < stops {
+		t.Fatalf("expected %v stops, got %v", stops, len(driver.stops))
+	}
+}
+
+// runFrames runs the device for the given number of frames.
+func runFrames(t *testing.T, device *Device, frames int) {
+	t.Helper()
+
+	for i := 0; i < frames; i++ {
+		if err := device.RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+	}
+}
+
+// expectStops checks the PC and reason of each stop.
+func expectStops(t *testing.T, driver *testDebugDriver, pcs []uint16, reasons []string) {
+	t.Helper()
+
+	for i, pc := range pcs {
+		stop := driver.stops[i]
+		if stop.Registers.PC != pc {
+			t.Errorf("expected stop %v to be at %#04x, got %#04x (%v)", i, pc, stop.Registers.PC, stop.Reason)
+		}
+		if !strings.Contains(stop.Reason, reasons[i]) {
+			t.Errorf("expected stop %v's reason to contain %q, got %q", i, reasons[i], stop.Reason)
+		}
+	}
+}
+
+func TestDebuggerBankedBreakpoints(t *testing.T) {
+	driver := &testDebugDriver{t: t}
+	device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+		"01:4000": BreakOnExecute,
+		"02:4002": BreakOnExecute,
+	})
+
+	// Bank 1's code is never run, so only the bank 2 breakpoint is hit
+	runUntilStops(t, device, driver, 1)
+	expectStops(t, driver, []uint16{0x4002}, []string{"02:4002"})
+	if d := driver.stops[0].Registers.D; d != 0x42 {
+		t.Errorf("expected D to be 0x42, got %#x", d)
+	}
+	if bank := driver.stops[0].Instruction.Bank; bank != 2 {
+		t.Errorf("expected the instruction to be in bank 2, got %v", bank)
+	}
+
+	runFrames(t, device, 2)
+	if len(driver.stops) != 1 {
+		t.Fatalf("expected only one stop, got %q", driver.stops[1].Reason)
+	}
+}
+
+func TestDebuggerConditions(t *testing.T) {
+	driver := &testDebugDriver{t: t}
+	device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+		"0169 if b == 3 && [$C010] == 0": BreakOnExecute,
+	})
+
+	runUntilStops(t, device, driver, 1)
+	if b := driver.stops[0].Registers.B; b != 3 {
+		t.Fatalf("expected B to be 3, got %v", b)
+	}
+	if hits := driver.stops[0].Breakpoint.Hits; hits != 1 {
+		t.Fatalf("expected only hits where the condition is true to be counted, got %v", hits)
+	}
+}
+
+func TestDebuggerIgnoreCount(t *testing.T) {
+	driver := &testDebugDriver{t: t, commands: []string{"ignore 1 2", "c"}}
+	device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+		"0169": BreakOnExecute,
+	})
+
+	runUntilStops(t, device, driver, 2)
+	// B is 0 on the first hit, then the next two are skipped
+	if b := driver.stops[0].Registers.B; b != 0 {
+		t.Errorf("expected B to be 0 on the first stop, got %v", b)
+	}
+	if b := driver.stops[1].Registers.B; b != 3 {
+		t.Errorf("expected B to be 3 after ignoring two hits, got %v", b)
+	}
+	if hits := driver.stops[1].Breakpoint.Hits; hits != 4 {
+		t.Errorf("expected 4 hits, got %v", hits)
+	}
+}
+
+func TestDebuggerWatchpoints(t *testing.T) {
+	driver := &testDebugDriver{t: t}
+	device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+		// The instruction that reads 0xC000 is in this range, but fetching it
+		// isn't a data read
+		"0156-0158": BreakOnRead,
+		"C000":      BreakOnRead,
+		"C00F-C01F": BreakOnWrite,
+		"C000-C010": BreakOnAccess,
+		"0000-3FFF": BreakOnWrite,
+	})
+
+	runUntilStops(t, device, driver, 3)
+	runFrames(t, device, 2)
+	if len(driver.stops) != 3 {
+		t.Fatalf("expected 3 stops, got %q", driver.stops[3].Reason)
+	}
+	// Watchpoints stop after the instruction that accessed memory. Only
+	// the first watchpoint hit by an instruction stops emulation.
+	addrs := []uint16{0xC000, 0xC010, 0x2000}
+	for i, stop := range driver.stops[:3] {
+		if stop.Addr != addrs[i] {
+			t.Errorf("expected stop %v to be for %#04x, got %#04x (%v)", i, addrs[i], stop.Addr, stop.Reason)
+		}
+	}
+	expectStops(t, driver,
+		[]uint16{0x0159, 0x015C, 0x0161},
+		[]string{"read from 0xc000", "wrote 0x", "wrote 0x02 to 0x2000"})
+}
+
+func TestDebuggerWatchpointsIgnoreDMA(t *testing.T) {
+	tests := []struct {
+		name  string
+		model Model
+		start func(device *Device)
+	}{
+		{
+			name:  "OAM DMA",
+			model: ModelDMG,
+			start: func(device *Device) {
+				device.WriteMemory(dmaAddr, 0xC1)
+			},
+		},
+		{
+			name:  "HDMA",
+			model: ModelCGB,
+			start: func(device *Device) {
+				device.WriteMemory(hdma1Addr, 0xC1)
+				device.WriteMemory(hdma2Addr, 0x00)
+				device.WriteMemory(hdma3Addr, 0x00)
+				device.WriteMemory(hdma4Addr, 0x00)
+				// Copy 4 blocks during H-Blank
+				device.WriteMemory(hdma5Addr, 0x80|0x03)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := &testDebugDriver{t: t}
+			device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+				"C100-C1FF": BreakOnAccess,
+			}, WithModel(test.model))
+
+			device.WriteMemory(0xC100, 0xAB)
+			test.start(device)
+			runFrames(t, device, 2)
+			if len(driver.stops) != 0 {
+				t.Fatalf("expected no stops, got %q", driver.stops[0].Reason)
+			}
+		})
+	}
+}
+
+func TestDebuggerCatchInterrupt(t *testing.T) {
+	driver := &testDebugDriver{t: t, commands: []string{"bt", "c"}}
+	device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+		"timer":  BreakOnInterrupt,
+		"vblank": BreakOnInterrupt,
+	})
+
+	runUntilStops(t, device, driver, 1)
+	expectStops(t, driver, []uint16{0x0040}, []string{"dispatched vblank interrupt"})
+
+	frames := backtraceEvent(t, driver).Frames
+	if len(frames) != 1 || !frames[0].Interrupt || frames[0].Target != 0x0040 {
+		t.Fatalf("expected only the interrupt handler on the call stack, got %+v", frames)
+	}
+	if addr := frames[0].ReturnAddr; addr != 0x0169 && addr != 0x016A {
+		t.Fatalf("expected the interrupt to return to the loop, got %#04x", addr)
+	}
+}
+
+// backtraceEvent returns the first backtrace sent by the debugger.
+func backtraceEvent(t *testing.T, driver *testDebugDriver) BacktraceEvent {
+	t.Helper()
+
+	for _, event := range driver.events {
+		if bt, ok := event.(BacktraceEvent); ok {
+			return bt
+		}
+	}
+	t.Fatalf("no backtrace was sent")
+	return BacktraceEvent{}
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		pcs      []uint16
+		reasons  []string
+	}{
+		{
+			name:     "step into a call",
+			commands: []string{"s"},
+			pcs:      []uint16{0x0153, 0x0200},
+			reasons:  []string{"0153", "Step"},
+		},
+		{
+			name:     "next over a call",
+			commands: []string{"n", "n"},
+			pcs:      []uint16{0x0153, 0x0156, 0x0159},
+			reasons:  []string{"0153", "Reached 0x0156", "Step"},
+		},
+		{
+			name:     "finish",
+			commands: []string{"s", "s", "finish"},
+			pcs:      []uint16{0x0153, 0x0200, 0x0210, 0x0203},
+			reasons:  []string{"0153", "Step", "Step", "Step out"},
+		},
+		{
+			name:     "until",
+			commands: []string{"until 0164"},
+			pcs:      []uint16{0x0153, 0x0164},
+			reasons:  []string{"0153", "Reached 0x0164"},
+		},
+		{
+			name:     "until a banked address",
+			commands: []string{"until 02:4000"},
+			pcs:      []uint16{0x0153, 0x4000},
+			reasons:  []string{"0153", "Reached 0x4000"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := &testDebugDriver{t: t, commands: test.commands}
+			device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+				"0153": BreakOnExecute,
+			})
+
+			runUntilStops(t, device, driver, len(test.pcs))
+			expectStops(t, driver, test.pcs, test.reasons)
+		})
+	}
+}
+
+func TestDebuggerBacktrace(t *testing.T) {
+	driver := &testDebugDriver{t: t, commands: []string{"bt", "finish", "bt"}}
+	device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+		"0210": BreakOnExecute,
+	})
+
+	runUntilStops(t, device, driver, 2)
+
+	var backtraces []BacktraceEvent
+	for _, event := range driver.events {
+		if bt, ok := event.(BacktraceEvent); ok {
+			backtraces = append(backtraces, bt)
+		}
+	}
+	if len(backtraces) != 2 {
+		t.Fatalf("expected 2 backtraces, got %v", len(backtraces))
+	}
+
+	expected := []StackFrame{
+		{Target: 0x0210, CallAddr: 0x0200, ReturnAddr: 0x0203, SP: 0xDFFA},
+		{Target: 0x0200, CallAddr: 0x0153, ReturnAddr: 0x0156, SP: 0xDFFC},
+	}
+	if bt := backtraces[0]; bt.PC != 0x0210 || !equalFrames(bt.Frames, expected) {
+		t.Errorf("expected %+v at 0x0210, got %+v at %#04x", expected, bt.Frames, bt.PC)
+	}
+	if bt := backtraces[1]; bt.PC != 0x0203 || !equalFrames(bt.Frames, expected[1:]) {
+		t.Errorf("expected %+v at 0x0203, got %+v at %#04x", expected[1:], bt.Frames, bt.PC)
+	}
+}
+
+func equalFrames(a, b []StackFrame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,10 @@
 package gameboy
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"golang.org/x/xerrors"
@@ -9,8 +12,13 @@ import (
 
 // Device represents the Game Boy hardware.
 type Device struct {
+	// pendingRewindFrames is the number of frames that have been requested to
+	// be rewound, but haven't been yet. It's accessed atomically.
+	pendingRewindFrames int32
+
 	state            *State
 	header           romHeader
+	headerInfo       ROMHeader
 	debugger         *debugger
 	timers           *timers
 	videoController  *videoController
@@ -20,7 +28,32 @@ type Device struct {
 	SoundController  *SoundController
 	opcodeMapper     *opcodeMapper
 
+	// model is the hardware model being emulated.
+	model Model
+
+	// currentInstruction is the next step of the instruction being run, or
+	// nil if the CPU is between instructions.
+	currentInstruction instruction
+
+	// frameCount is the number of frames that have been finished.
+	frameCount int
+	// frameBoundaryPending is true if a frame has been finished, but the
+	// first instruction boundary after it hasn't been reached yet.
+	frameBoundaryPending bool
+	// rewind keeps a history of snapshots for rewinding, or is nil if
+	// rewinding is disabled.
+	rewind *rewindBuffer
+	// cheats contains the cheat codes that have been added to the device.
+	cheats *CheatManager
+	// unusable is set if the device was left in an inconsistent state, which
+	// happens when a save state fails to load and the previous state can't be
+	// restored. The device can't run until a save state is loaded
+	// successfully.
+	unusable error
+
 	saveGames SaveGameDriver
+	// out is where information and warnings are printed.
+	out io.Writer
 }
 
 type DebugConfiguration struct {
@@ -30,6 +63,13 @@ type DebugConfiguration struct {
 	BreakOnOpcode    *uint8
 	BreakOnAddrRead  *uint16
 	BreakOnAddrWrite *uint16
+
+	// Breakpoints are added to the device when it's created. More can be
+	// added later with Device.AddBreakpoint.
+	Breakpoints []Breakpoint
+	// Driver receives events from the debugger and provides commands to it.
+	// If nil, a console on stdin and stdout is used.
+	Driver DebugDriver
 }
 
 func NewDevice(
@@ -38,113 +78,187 @@ func NewDevice(
 	video VideoDriver,
 	input InputDriver,
 	saveGames SaveGameDriver,
-	dbConfig DebugConfiguration) (*Device, error) {
+	dbConfig DebugConfiguration,
+	opts ...Option) (*Device, error) {
 
 	var device Device
 
+	var options deviceOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	device.saveGames = saveGames
 
-	device.header = loadROMHeader(cartridgeData)
-	fmt.Printf("%+v\n", device.header)
+	out := options.output
+	if out == nil {
+		out = os.Stdout
+	}
+	device.out = out
+
+	var err error
+	device.header, cartridgeData, err = selectROMHeader(cartridgeData)
+	if err != nil {
+		return nil, xerrors.Errorf("loading ROM header: %w", err)
+	}
+	device.headerInfo = newROMHeader(device.header, cartridgeData)
+	fmt.Fprint(out, device.headerInfo)
+
+	if !device.headerInfo.HeaderChecksumValid {
+		if options.validateHeaderChecksum {
+			return nil, xerrors.Errorf("header checksum %#x doesn't match the header: %w",
+				device.header.headerChecksum, ErrBadHeaderChecksum)
+		}
+		fmt.Fprintln(out, "Warning: The header checksum doesn't match the header. "+
+			"The boot ROM will refuse to start the game.")
+	}
+	if !device.headerInfo.GlobalChecksumValid {
+		fmt.Fprintln(out, "Warning: The global checksum doesn't match the ROM. "+
+			"It may be corrupted.")
+	}
+
+	cartridgeData, err = fitROMToHeader(device.header, cartridgeData, out)
+	if err != nil {
+		return nil, xerrors.Errorf("loading ROM: %w", err)
+	}
+
+	if options.skipBootROM {
+		bootROM = nil
+	} else if len(bootROM) != bootROMEndAddr && len(bootROM) != cgbBootROMEndAddr {
+		return nil, xerrors.Errorf("invalid boot ROM size %#x", len(bootROM))
+	}
+
+	device.model = resolveModel(options.model, device.header, bootROM)
+	fmt.Fprintln(out, "Emulating model:", device.model)
 
 	batteryBacked := false
+	// The cartridge's speaker, if it has one
+	var speaker *Speaker
+
+	infrared := options.infrared
+	if infrared == nil {
+		infrared = &noopInfraredDriver{}
+	}
+	clock := options.clock
+	if clock == nil {
+		clock = systemClock{}
+	}
 
 	// Create a memory bank controller for this ROM
 	var mbc mbc
 	switch device.header.cartridgeType {
 	case 0x00:
 		// ROM ONLY
-		mbc = newROMOnlyMBC(device.header, cartridgeData)
+		mbc = newROMOnlyMBC(device.header, cartridgeData, out)
 	case 0x01:
 		// MBC1
-		mbc = newMBC1(device.header, cartridgeData)
+		mbc = newMBC1(device.header, cartridgeData, out)
 	case 0x02:
 		// MBC1+RAM
-		mbc = newMBC1(device.header, cartridgeData)
+		mbc = newMBC1(device.header, cartridgeData, out)
 	case 0x03:
 		// MBC1+RAM+BATTERY
-		mbc = newMBC1(device.header, cartridgeData)
+		mbc = newMBC1(device.header, cartridgeData, out)
 		batteryBacked = true
-		// case 0x05:
+	case 0x05:
 		// MBC2
-		// TODO(velovix): Support this
-		// case 0x06:
+		mbc = newMBC2(device.header, cartridgeData, out)
+	case 0x06:
 		// MBC2+BATTERY
-		// TODO(velovix): Support this
-		// case 0x08:
+		mbc = newMBC2(device.header, cartridgeData, out)
+		batteryBacked = true
+	case 0x08:
 		// ROM+RAM
-		// TODO(velovix): Support this
-		// case 0x09:
+		mbc = newROMOnlyMBC(device.header, cartridgeData, out)
+	case 0x09:
 		// ROM+RAM+BATTERY
-		// TODO(velovix): Support this
-		// case 0x0B:
+		mbc = newROMOnlyMBC(device.header, cartridgeData, out)
+		batteryBacked = true
+	case 0x0B:
 		// MMM01
-		// TODO(velovix): Support this
-		// case 0x0C:
+		mbc = newMMM01(device.header, cartridgeData)
+	case 0x0C:
 		// MMM01+RAM
-		// TODO(velovix): Support this
-		// case 0x0D:
+		mbc = newMMM01(device.header, cartridgeData)
+	case 0x0D:
 		// MMM01+RAM+BATTERY
-		// TODO(velovix): Support this
-		// case 0x0F:
+		mbc = newMMM01(device.header, cartridgeData)
+		batteryBacked = true
+	case 0x0F:
 		// MBC3+RTC+BATTERY
-		// TODO(velovix): Support this
-		// batteryBacked = true
-		// case 0x10:
+		mbc = newMBC3(device.header, cartridgeData, clock, out)
+		batteryBacked = true
+	case 0x10:
 		// MBC3+RTC+RAM+BATTERY
-		// TODO(velovix): Support this
+		mbc = newMBC3(device.header, cartridgeData, clock, out)
 		batteryBacked = true
 	case 0x11:
 		// MBC3
-		mbc = newMBC3(device.header, cartridgeData, false)
+		mbc = newMBC3(device.header, cartridgeData, nil, out)
 	case 0x12:
 		// MBC3+RAM
-		mbc = newMBC3(device.header, cartridgeData, false)
+		mbc = newMBC3(device.header, cartridgeData, nil, out)
 	case 0x13:
 		// MBC3+RAM+BATTERY
-		mbc = newMBC3(device.header, cartridgeData, false)
+		mbc = newMBC3(device.header, cartridgeData, nil, out)
 		batteryBacked = true
 	case 0x19:
 		// MBC5
-		mbc = newMBC5(device.header, cartridgeData, false)
+		mbc = newMBC5(device.header, cartridgeData, false, out)
 	case 0x1A:
 		// MBC5+RAM
-		mbc = newMBC5(device.header, cartridgeData, false)
+		mbc = newMBC5(device.header, cartridgeData, false, out)
 	case 0x1B:
 		// MBC5+RAM+BATTERY
-		mbc = newMBC5(device.header, cartridgeData, false)
+		mbc = newMBC5(device.header, cartridgeData, false, out)
 		batteryBacked = true
 	case 0x1C:
 		// MBC5+RUMBLE
-		mbc = newMBC5(device.header, cartridgeData, true)
+		mbc = newMBC5(device.header, cartridgeData, true, out)
 	case 0x1D:
 		// MBC5+RUMBLE+RAM
-		mbc = newMBC5(device.header, cartridgeData, true)
+		mbc = newMBC5(device.header, cartridgeData, true, out)
 	case 0x1E:
 		// MBC5+RUMBLE+RAM+BATTERY
-		mbc = newMBC5(device.header, cartridgeData, true)
+		mbc = newMBC5(device.header, cartridgeData, true, out)
+		batteryBacked = true
+	case 0x20:
+		// MBC6
+		mbc = newMBC6(device.header, cartridgeData, out)
+		batteryBacked = true
+	case 0x22:
+		// MBC7+SENSOR+RUMBLE+RAM+BATTERY
+		tilt := options.tilt
+		if tilt == nil {
+			tilt = &noopTiltDriver{}
+		}
+		mbc = newMBC7(device.header, cartridgeData, tilt)
+		batteryBacked = true
+	case 0xFC:
+		// POCKET CAMERA
+		imageSource := options.imageSource
+		if imageSource == nil {
+			imageSource = &noopImageSourceDriver{}
+		}
+		mbc = newPocketCamera(device.header, cartridgeData, imageSource, out)
+		batteryBacked = true
+	case 0xFD:
+		// BANDAI TAMA5
+		mbc = newTAMA5(device.header, cartridgeData, clock, out)
+		batteryBacked = true
+	case 0xFE:
+		// HuC3
+		huc3 := newHuC3(device.header, cartridgeData, infrared, clock, out)
+		speaker = huc3.speaker
+		mbc = huc3
+		batteryBacked = true
+	case 0xFF:
+		// HuC1+RAM+BATTERY
+		mbc = newHuC1(device.header, cartridgeData, infrared)
 		batteryBacked = true
-	// case 0x20:
-	// MBC6
-	// TODO(velovix): Support this
-	// case 0x22:
-	// MBC7+SENSOR+RUMBLE+RAM+BATTERY
-	// TODO(velovix): Support this
-	// case 0xFC:
-	// POCKET CAMERA
-	// TODO(velovix): Support this
-	// case 0xFD:
-	// BANDAI TAMA5
-	// TODO(velovix): Support this
-	// case 0xFE:
-	// HuC3
-	// TODO(velovix): Support this
-	// case 0xFF:
-	// HuC1+RAM+BATTERY
-	// TODO(velovix): Support this
-	// batteryBacked = true
 	default:
-		return nil, xerrors.Errorf("unknown cartridge type %#x", device.header.cartridgeType)
+		return nil, xerrors.Errorf("cartridge type %#x: %w",
+			device.header.cartridgeType, ErrUnsupportedCartridge)
 	}
 
 	// Load up a save game if we're using a battery backed cartridge
@@ -155,25 +269,68 @@ func NewDevice(
 			return nil, xerrors.Errorf("checking for saves: %w", err)
 		}
 		if hasSave {
-			fmt.Println("Loading battery-backed game save...")
+			fmt.Fprintln(out, "Loading battery-backed game save...")
 			data, err := device.saveGames.Load(device.header.title)
 			if err != nil {
 				return nil, xerrors.Errorf("loading game save: %w", err)
 			}
-			batteryMBC.loadBatteryBackedRAM(data)
+			if err := batteryMBC.loadBatteryBackedRAM(data); err != nil {
+				return nil, xerrors.Errorf("loading game save: %w", err)
+			}
 		}
 	}
 
-	mmu := newMMU(bootROM, cartridgeData, mbc)
+	mmu := newMMU(bootROM, cartridgeData, mbc, device.model.isCGB(), out)
+	device.cheats = newCheatManager(device.header)
+	mmu.cheats = device.cheats
 	device.state = NewState(mmu)
 
 	if dbConfig.Debugging {
-		device.debugger = &debugger{state: device.state}
+		driver := dbConfig.Driver
+		if driver == nil {
+			driver = NewConsoleDebugDriver(os.Stdin, os.Stdout)
+		}
+		device.debugger = &debugger{state: device.state, driver: driver}
 
-		device.debugger.breakOnPC = dbConfig.BreakOnPC
 		device.debugger.breakOnOpcode = dbConfig.BreakOnOpcode
-		device.debugger.breakOnAddrRead = dbConfig.BreakOnAddrRead
-		device.debugger.breakOnAddrWrite = dbConfig.BreakOnAddrWrite
+
+		// Breakpoints on a single address are converted into regular
+		// breakpoints
+		breakpoints := dbConfig.Breakpoints
+		if dbConfig.BreakOnPC != nil {
+			breakpoints = append(breakpoints, Breakpoint{
+				Kind:  BreakOnExecute,
+				Start: *dbConfig.BreakOnPC,
+				End:   *dbConfig.BreakOnPC,
+				Bank:  AnyBank,
+			})
+		}
+		if dbConfig.BreakOnAddrRead != nil {
+			breakpoints = append(breakpoints, Breakpoint{
+				Kind:  BreakOnRead,
+				Start: *dbConfig.BreakOnAddrRead,
+				End:   *dbConfig.BreakOnAddrRead,
+				Bank:  AnyBank,
+			})
+		}
+		if dbConfig.BreakOnAddrWrite != nil {
+			breakpoints = append(breakpoints, Breakpoint{
+				Kind:  BreakOnWrite,
+				Start: *dbConfig.BreakOnAddrWrite,
+				End:   *dbConfig.BreakOnAddrWrite,
+				Bank:  AnyBank,
+			})
+		}
+		for _, bp := range breakpoints {
+			if _, err := device.debugger.addBreakpoint(bp); err != nil {
+				return nil, xerrors.Errorf("adding breakpoint: %w", err)
+			}
+		}
+
+		// Some drivers, like the GDB server, control the debugger directly
+		if attacher, ok := driver.(debuggerAttacher); ok {
+			attacher.attach(device.debugger)
+		}
 
 		device.state.mmu.db = device.debugger
 	}
@@ -187,18 +344,33 @@ func NewDevice(
 
 	device.joypad = newJoypad(device.state, input)
 
-	device.serial = newSerial(device.state)
+	serialDriver := options.serial
+	if serialDriver == nil {
+		serialDriver = &noopSerialDriver{}
+	}
+	device.serial = newSerial(device.state, serialDriver)
 
 	device.interruptManager = newInterruptManager(device.state, device.timers)
+	device.interruptManager.db = device.debugger
 	device.joypad.interruptManager = device.interruptManager
 	device.videoController.interruptManager = device.interruptManager
 	device.timers.interruptManager = device.interruptManager
+	device.serial.interruptManager = device.interruptManager
 	mmu.interruptManager = device.interruptManager
 
 	device.SoundController = newSoundController(device.state)
+	device.SoundController.Speaker = speaker
 
 	device.opcodeMapper = newOpcodeMapper(device.state)
 
+	if options.skipBootROM {
+		device.loadPostBootState()
+	}
+
+	if options.rewindBudget > 0 {
+		device.rewind = newRewindBuffer(options.rewindBudget)
+	}
+
 	return &device, nil
 }
 
@@ -217,7 +389,7 @@ func (device *Device) BenchmarkComponents() {
 			device.timers.tick()
 		}
 	}
-	fmt.Println("Timer performance:", float64(secondCycles)/time.Since(start).Seconds())
+	fmt.Fprintln(device.out, "Timer performance:", float64(secondCycles)/time.Since(start).Seconds())
 
 	start = time.Now()
 	oldVideoDriver := device.videoController.driver
@@ -227,7 +399,7 @@ func (device *Device) BenchmarkComponents() {
 			device.videoController.tick()
 		}
 	}
-	fmt.Println("Video controller performance:", float64(secondCycles)/time.Since(start).Seconds())
+	fmt.Fprintln(device.out, "Video controller performance:", float64(secondCycles)/time.Since(start).Seconds())
 	device.videoController.driver = oldVideoDriver
 
 	start = time.Now()
@@ -239,7 +411,7 @@ func (device *Device) BenchmarkComponents() {
 		}
 	}
 	device.joypad.driver = oldInputDriver
-	fmt.Println("Joypad performance:", float64(secondCycles)/time.Since(start).Seconds())
+	fmt.Fprintln(device.out, "Joypad performance:", float64(secondCycles)/time.Since(start).Seconds())
 
 	start = time.Now()
 	for i := 0; i < secondCycles; i++ {
@@ -247,7 +419,7 @@ func (device *Device) BenchmarkComponents() {
 			device.interruptManager.check()
 		}
 	}
-	fmt.Println("Interrupt manager performance:", float64(secondCycles)/time.Since(start).Seconds())
+	fmt.Fprintln(device.out, "Interrupt manager performance:", float64(secondCycles)/time.Since(start).Seconds())
 
 	start = time.Now()
 	for i := 0; i < secondCycles; i++ {
@@ -255,7 +427,7 @@ func (device *Device) BenchmarkComponents() {
 			device.SoundController.tick()
 		}
 	}
-	fmt.Println("Sound controller performance:", float64(secondCycles)/time.Since(start).Seconds())
+	fmt.Fprintln(device.out, "Sound controller performance:", float64(secondCycles)/time.Since(start).Seconds())
 
 	start = time.Now()
 	for i := 0; i < secondCycles; i++ {
@@ -263,90 +435,222 @@ func (device *Device) BenchmarkComponents() {
 			//device.opcodeMapper.run(0x00)
 		}
 	}
-	fmt.Println("Opcode mapper performance:", float64(secondCycles)/time.Since(start).Seconds())
+	fmt.Fprintln(device.out, "Opcode mapper performance:", float64(secondCycles)/time.Since(start).Seconds())
 }
 
-// Start starts the main processing loop of the Gameboy.
-func (device *Device) Start(onExit chan bool) error {
-	var currentInstruction instruction
-	var err error
-
+// Start runs the device until the given context is canceled or an error
+// occurs. The device stops between instructions, so it is left in a state
+// that SaveState can capture. Close should be called once the device is no
+// longer needed.
+func (device *Device) Start(ctx context.Context) error {
 	for {
-		// Check periodically (but not every tick for performance reasons) if
-		// the main loop should be exited
-		if device.timers.cpuClock == 0 {
-			select {
-			case <-onExit:
-				// Save the game, if necessary
-				if mbc, ok := device.state.mmu.mbc.(batteryBackedMBC); ok {
-					fmt.Println("Saving battery-backed game state...")
-					data := mbc.dumpBatteryBackedRAM()
-					err := device.saveGames.Save(device.header.title, data)
-					if err != nil {
-						return xerrors.Errorf("saving game: %w", err)
-					}
-				}
-				return nil
-			default:
-			}
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if err := device.RunFrame(); err != nil {
+			return err
 		}
 
-		device.joypad.tick()
 		if device.state.stopped {
-			// We're in stop mode, don't do anything
+			// We're in stop mode and nothing is happening, so there's no need
+			// to run at full speed
 			time.Sleep(time.Millisecond)
-			continue
 		}
+	}
+}
+
+// RunFrame runs the device until the next frame is finished and the current
+// instruction is done. If the screen is off, the device is run for the length
+// of a frame instead. If the CPU is in stop mode, the device is run for one
+// M-Cycle.
+func (device *Device) RunFrame() error {
+	_, err := device.RunFrameUntil(nil)
+	return err
+}
+
+// RunFrameUntil runs the device like RunFrame, but stops early if the given
+// condition is true after an instruction finishes. Returns true if the
+// condition was met. A nil condition is never met.
+func (device *Device) RunFrameUntil(condition func() bool) (bool, error) {
+	frameMCycles := fullFrameClocks / ticksPerMCycle
+	if device.state.doubleSpeed {
+		frameMCycles *= 2
+	}
+
+	conditionMet := func() bool {
+		return condition != nil && device.currentInstruction == nil && condition()
+	}
+
+	startFrame := device.frameCount
+	for i := 0; i < frameMCycles; i++ {
+		if err := device.tick(); err != nil {
+			return false, err
+		}
+		if conditionMet() {
+			return true, nil
+		}
+		if device.frameCount != startFrame {
+			return false, nil
+		}
+		if device.state.stopped {
+			// Nothing happens in stop mode until a button is pressed, so
+			// there's no frame to wait for. A frame that finished right as
+			// the CPU stopped is handled once it starts again
+			return false, nil
+		}
+	}
+
+	// No frame was finished, but the device should still be left between
+	// instructions
+	for !device.state.stopped &&
+		(device.currentInstruction != nil || device.frameBoundaryPending) {
+		if err := device.tick(); err != nil {
+			return false, err
+		}
+		if conditionMet() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// StepInstruction runs the device until the current instruction is done. If
+// the CPU is halted or stopped, the device is run for one M-Cycle.
+func (device *Device) StepInstruction() error {
+	for {
+		if err := device.tick(); err != nil {
+			return err
+		}
+		if device.currentInstruction == nil {
+			return nil
+		}
+	}
+}
 
-		device.timers.tick()
+// RunCycles runs the device for the given number of M-Cycles. The device may
+// be left in the middle of an instruction.
+func (device *Device) RunCycles(n int) error {
+	for i := 0; i < n; i++ {
+		if err := device.tick(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close shuts down the device, saving the game if the cartridge has
+// battery-backed RAM. The device must not be used afterwards.
+func (device *Device) Close() error {
+	if device.rewind != nil {
+		device.rewind.close()
+	}
+
+	if device.unusable != nil {
+		// Saving the game could overwrite a good save with garbage
+		return xerrors.Errorf("not saving game: %w", device.unusable)
+	}
 
-		if device.state.halted {
-			// The device is halted. Process no new instructions, but check for
-			// interrupts.
+	// Save the game, if necessary
+	if mbc, ok := device.state.mmu.mbc.(batteryBackedMBC); ok {
+		fmt.Fprintln(device.out, "Saving battery-backed game state...")
+		data := mbc.dumpBatteryBackedRAM()
+		err := device.saveGames.Save(device.header.title, data)
+		if err != nil {
+			return xerrors.Errorf("saving game: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// tick runs the device for one M-Cycle.
+func (device *Device) tick() error {
+	if device.unusable != nil {
+		return device.unusable
+	}
+
+	var err error
+
+	device.joypad.tick()
+	if device.state.stopped {
+		// We're in stop mode, don't do anything
+		return nil
+	}
+
+	device.timers.tick()
+	device.serial.tick()
+
+	if device.state.halted {
+		// The device is halted. Process no new instructions, but check for
+		// interrupts.
+		device.interruptManager.check()
+	} else if !device.state.halted {
+		if device.currentInstruction == nil {
+			// Process interrupts before fetching a new instruction. Note
+			// that this means interrupt processing does not happen while
+			// an instruction is being executed
+			// TODO(velovix): Is this the right behavior?
 			device.interruptManager.check()
-		} else if !device.state.halted {
+
+			device.state.instructionDone()
+
 			// Notify the debugger that we're at this PC value
-			/*if device.debugger != nil {
+			if device.debugger != nil {
 				device.debugger.pcHook(device.state.regPC.get())
-			}*/
-
-			if currentInstruction == nil {
-				// Process interrupts before fetching a new instruction. Note
-				// that this means interrupt processing does not happen while
-				// an instruction is being executed
-				// TODO(velovix): Is this the right behavior?
-				device.interruptManager.check()
-
-				// Fetch a new operation
-				opcode := device.state.incrementPC()
-
-				if device.debugger != nil {
-					device.debugger.opcodeHook(opcode)
-				}
-
-				currentInstruction, err = device.opcodeMapper.getInstruction(opcode)
-				if err != nil {
-					return err
-				}
 			}
 
-			// Get the next step in the instruction
-			currentInstruction = currentInstruction(device.state)
+			// Fetch a new operation
+			opcode := device.state.incrementPC()
+
+			if device.debugger != nil {
+				device.debugger.opcodeHook(opcode)
+			}
+
+			device.currentInstruction, err = device.opcodeMapper.getInstruction(opcode)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Get the next step in the instruction
+		device.currentInstruction = device.currentInstruction(device.state)
+	}
+
+	if device.frameBoundaryPending && device.currentInstruction == nil {
+		device.frameBoundaryPending = false
+		if err := device.onFrameBoundary(); err != nil {
+			return err
 		}
+	}
 
-		device.state.mmu.tick()
+	device.state.mmu.tick()
+	// In double speed mode, the video and sound controllers run at half
+	// the rate of the CPU
+	if !device.state.doubleSpeed || device.timers.cpuClock%2 == 0 {
 		device.videoController.tick()
 		device.SoundController.tick()
+	}
 
-		// Process any delayed requests to toggle the master interrupt switch.
-		// These are created by the EI and DI instructions.
-		if device.state.enableInterruptsTimer > 0 {
-			device.state.enableInterruptsTimer--
-			if device.state.enableInterruptsTimer == 0 {
-				device.state.interruptsEnabled = true
-			}
+	if device.videoController.frameDone {
+		device.videoController.frameDone = false
+		device.frameBoundaryPending = true
+	}
+
+	// Process any delayed requests to toggle the master interrupt switch.
+	// These are created by the EI and DI instructions.
+	if device.state.enableInterruptsTimer > 0 {
+		device.state.enableInterruptsTimer--
+		if device.state.enableInterruptsTimer == 0 {
+			device.state.interruptsEnabled = true
 		}
 	}
+
+	return nil
 }
 
 const (
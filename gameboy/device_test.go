@@ -0,0 +1,111 @@
+package gameboy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newStoppingDevice creates a device running a program that enters stop mode
+// right away.
+func newStoppingDevice(t *testing.T) *Device {
+	t.Helper()
+
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	copy(rom[0x0100:], []uint8{
+		0x10, 0x00, // stop
+		0x18, 0xFC, // jr -4
+	})
+	return newTestCartridgeDevice(t, rom)
+}
+
+// finishesQuickly runs the given function and fails the test if it doesn't
+// return within a second.
+func finishesQuickly(t *testing.T, name string, f func() error) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%v didn't return while the CPU was stopped", name)
+	}
+}
+
+func TestRunFrameWhileStopped(t *testing.T) {
+	device := newStoppingDevice(t)
+
+	finishesQuickly(t, "RunFrame", device.RunFrame)
+	if !device.state.stopped {
+		t.Fatalf("the CPU didn't enter stop mode")
+	}
+
+	// A frame that finished on the same M-Cycle that the CPU stopped
+	device.frameBoundaryPending = true
+	startFrame := device.frameCount
+	finishesQuickly(t, "RunFrame", device.RunFrame)
+	if device.frameCount != startFrame {
+		t.Fatalf("a frame was finished while the CPU was stopped")
+	}
+}
+
+func TestStartWhileStopped(t *testing.T) {
+	device := newStoppingDevice(t)
+	device.frameBoundaryPending = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finishesQuickly(t, "Start", func() error {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		return device.Start(ctx)
+	})
+}
+
+func TestRunFrameUntil(t *testing.T) {
+	device := newCountingDevice(t)
+
+	met, err := device.RunFrameUntil(func() bool {
+		return device.Registers().A == 5
+	})
+	if err != nil {
+		t.Fatalf("running: %v", err)
+	}
+	if !met {
+		t.Fatalf("the condition wasn't met")
+	}
+	if a := device.Registers().A; a != 5 {
+		t.Fatalf("stopped with A at %v, expected 5", a)
+	}
+
+	// A condition that is never met runs the same frames as RunFrame
+	withCondition := newCountingDevice(t)
+	withoutCondition := newCountingDevice(t)
+	for i := 0; i < 3; i++ {
+		met, err := withCondition.RunFrameUntil(func() bool { return false })
+		if err != nil {
+			t.Fatalf("running: %v", err)
+		}
+		if met {
+			t.Fatalf("a condition that is never met was met")
+		}
+		if err := withoutCondition.RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+	}
+	if withCondition.frameCount != withoutCondition.frameCount {
+		t.Fatalf("ran %v frames with a condition and %v frames without",
+			withCondition.frameCount, withoutCondition.frameCount)
+	}
+	if actual, expected := withCondition.Registers(), withoutCondition.Registers(); actual != expected {
+		t.Fatalf("registers are %+v with a condition and %+v without", actual, expected)
+	}
+}
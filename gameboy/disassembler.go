@@ -0,0 +1,624 @@
+package gameboy
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Instruction is a decoded CPU instruction. Instructions are formatted in the
+// syntax used by RGBDS.
+type Instruction struct {
+	// Addr is the address of the instruction's first byte.
+	Addr uint16
+	// Bank is the ROM bank that the instruction is in, or -1 if it isn't
+	// known.
+	Bank int
+	// Bytes contains the bytes that make up the instruction, including its
+	// opcode and any immediate values.
+	Bytes []uint8
+	// Mnemonic is the name of the instruction, like "ld". It's "db" if the
+	// bytes aren't a valid instruction.
+	Mnemonic string
+	// Operands contains the instruction's formatted operands, like "a" or
+	// "[hl+]".
+	Operands []string
+	// Cycles is the number of M-Cycles the instruction takes. For
+	// conditional instructions, this is the number of M-Cycles when the
+	// condition isn't met.
+	Cycles int
+	// BranchCycles is the number of M-Cycles a conditional instruction takes
+	// when the condition is met. It's the same as Cycles for other
+	// instructions.
+	BranchCycles int
+	// Target is the address that the instruction jumps to or calls, if
+	// HasTarget is true.
+	Target uint16
+	// HasTarget is true if the instruction jumps to or calls a known address.
+	// Jumps to HL don't have a known address.
+	HasTarget bool
+	// IsCall is true if the instruction is a CALL or RST.
+	IsCall bool
+	// IsData is true if the bytes aren't a valid instruction, or are part of
+	// the cartridge header. These are formatted as "db" directives.
+	IsData bool
+
+	// targetOperand is the index of the operand that contains the target, or
+	// -1 if there isn't one.
+	targetOperand int
+}
+
+// String returns the instruction in RGBDS syntax, like "ld a, [hl+]".
+func (inst Instruction) String() string {
+	return inst.Format(nil)
+}
+
+// Format returns the instruction in RGBDS syntax. If the instruction's target
+// has a label in the given map, it's used in place of the address.
+func (inst Instruction) Format(labels map[uint16]string) string {
+	operands := inst.Operands
+	if inst.HasTarget && inst.targetOperand != -1 {
+		if label, ok := labels[inst.Target]; ok {
+			operands = append([]string(nil), operands...)
+			operands[inst.targetOperand] = label
+		}
+	}
+
+	if len(operands) == 0 {
+		return inst.Mnemonic
+	}
+	return inst.Mnemonic + " " + strings.Join(operands, ", ")
+}
+
+// Disassemble decodes the instruction at the given address of the device's
+// memory, as the CPU would see it. Memory is read without triggering debugger
+// breakpoints or other side effects.
+func (device *Device) Disassemble(addr uint16) Instruction {
+	inst := disassemble(device.state.mmu.peek, addr, 0xFFFF)
+	inst.Bank = device.state.mmu.romBankAt(addr)
+	return inst
+}
+
+// DisassembleROM decodes the instruction at the given address in a ROM bank.
+// Addresses 0x0000-0x3FFF are always in bank 0, and addresses 0x4000-0x7FFF
+// are in the given bank. Instructions that would continue past the end of
+// the bank are decoded as data, since the bytes after it depend on which bank
+// is mapped.
+func DisassembleROM(rom []uint8, bank int, addr uint16) (Instruction, error) {
+	if inBank0ROMArea(addr) {
+		bank = 0
+	} else if !inBankedROMArea(addr) {
+		return Instruction{}, xerrors.Errorf("address %#04x is not in ROM", addr)
+	}
+
+	bankStart := bank * romBankSize
+	if bank < 0 || bankStart+romBankSize > len(rom) {
+		return Instruction{}, xerrors.Errorf("ROM bank %v does not exist", bank)
+	}
+	bankAddr := addr &^ (romBankSize - 1)
+
+	read := func(addr uint16) uint8 {
+		return rom[bankStart+int(addr-bankAddr)]
+	}
+
+	inst := disassemble(read, addr, bankAddr+romBankSize-1)
+	inst.Bank = bank
+	return inst, nil
+}
+
+// DisassembleROMBank decodes an entire ROM bank, one instruction after
+// another. Data and code aren't told apart, so data will be decoded as
+// instructions too, with the exception of the cartridge header in bank 0.
+func DisassembleROMBank(rom []uint8, bank int) ([]Instruction, error) {
+	var instructions []Instruction
+
+	start := uint16(bankedROMAddr)
+	if bank == 0 {
+		start = 0
+	}
+	end := int(start) + romBankSize
+
+	for addr := int(start); addr < end; {
+		var inst Instruction
+		if bank == 0 && addr >= nintendoLogoAddr && addr < headerEndAddr {
+			inst = dataInstruction(rom[addr:minInt(addr+16, headerEndAddr)])
+			inst.Addr = uint16(addr)
+			inst.Bank = 0
+		} else {
+			var err error
+			inst, err = DisassembleROM(rom, bank, uint16(addr))
+			if err != nil {
+				return nil, err
+			}
+			if bank == 0 && addr < nintendoLogoAddr && addr+len(inst.Bytes) > nintendoLogoAddr {
+				// Don't let an instruction run into the header
+				inst = dataInstruction(inst.Bytes[:nintendoLogoAddr-addr])
+				inst.Addr = uint16(addr)
+				inst.Bank = 0
+			}
+		}
+
+		instructions = append(instructions, inst)
+		addr += len(inst.Bytes)
+	}
+
+	return instructions, nil
+}
+
+// LabelTargets creates labels for the targets of the given instructions. Only
+// targets that are the start of one of the given instructions get a label.
+// Call targets are named like "Call_001_4000" and other jump targets like
+// "Jump_001_4000", where the first number is the bank. Targets in an unknown
+// bank are named like "Jump_C000".
+func LabelTargets(instructions []Instruction) map[uint16]string {
+	starts := make(map[uint16]Instruction)
+	for _, inst := range instructions {
+		starts[inst.Addr] = inst
+	}
+
+	labels := make(map[uint16]string)
+	for _, inst := range instructions {
+		if !inst.HasTarget {
+			continue
+		}
+		target, ok := starts[inst.Target]
+		if !ok {
+			continue
+		}
+
+		kind := "Jump"
+		if inst.IsCall {
+			kind = "Call"
+		}
+		if existing, ok := labels[inst.Target]; ok && strings.HasPrefix(existing, "Call") {
+			// Calls take precedence, since they usually mark a function
+			continue
+		}
+
+		if target.Bank == -1 {
+			labels[inst.Target] = fmt.Sprintf("%v_%04X", kind, inst.Target)
+		} else {
+			labels[inst.Target] = fmt.Sprintf("%v_%03X_%04X", kind, target.Bank, inst.Target)
+		}
+	}
+
+	return labels
+}
+
+// HardwareRegisterNames returns the names of hardware registers by address,
+// as they're named in the hardware.inc file commonly used with RGBDS.
+func HardwareRegisterNames() map[uint16]string {
+	names := make(map[uint16]string, len(hardwareRegisterNames))
+	for addr, name := range hardwareRegisterNames {
+		names[addr] = name
+	}
+	return names
+}
+
+// hardwareRegisterNames maps hardware register addresses to their names.
+var hardwareRegisterNames = map[uint16]string{
+	p1Addr:             "rP1",
+	sbAddr:             "rSB",
+	scAddr:             "rSC",
+	dividerAddr:        "rDIV",
+	timaAddr:           "rTIMA",
+	tmaAddr:            "rTMA",
+	tacAddr:            "rTAC",
+	ifAddr:             "rIF",
+	nr10Addr:           "rNR10",
+	nr11Addr:           "rNR11",
+	nr12Addr:           "rNR12",
+	nr13Addr:           "rNR13",
+	nr14Addr:           "rNR14",
+	nr21Addr:           "rNR21",
+	nr22Addr:           "rNR22",
+	nr23Addr:           "rNR23",
+	nr24Addr:           "rNR24",
+	nr30Addr:           "rNR30",
+	nr31Addr:           "rNR31",
+	nr32Addr:           "rNR32",
+	nr33Addr:           "rNR33",
+	nr34Addr:           "rNR34",
+	nr41Addr:           "rNR41",
+	nr42Addr:           "rNR42",
+	nr43Addr:           "rNR43",
+	nr44Addr:           "rNR44",
+	nr50Addr:           "rNR50",
+	nr51Addr:           "rNR51",
+	nr52Addr:           "rNR52",
+	lcdcAddr:           "rLCDC",
+	statAddr:           "rSTAT",
+	scrollYAddr:        "rSCY",
+	scrollXAddr:        "rSCX",
+	lyAddr:             "rLY",
+	lycAddr:            "rLYC",
+	dmaAddr:            "rDMA",
+	bgpAddr:            "rBGP",
+	obp0Addr:           "rOBP0",
+	obp1Addr:           "rOBP1",
+	windowPosYAddr:     "rWY",
+	windowPosXAddr:     "rWX",
+	key1Addr:           "rKEY1",
+	vbkAddr:            "rVBK",
+	bootROMDisableAddr: "rBANK",
+	hdma1Addr:          "rHDMA1",
+	hdma2Addr:          "rHDMA2",
+	hdma3Addr:          "rHDMA3",
+	hdma4Addr:          "rHDMA4",
+	hdma5Addr:          "rHDMA5",
+	rpAddr:             "rRP",
+	bcpsAddr:           "rBCPS",
+	bcpdAddr:           "rBCPD",
+	ocpsAddr:           "rOCPS",
+	ocpdAddr:           "rOCPD",
+	svbkAddr:           "rSVBK",
+	pcm12Ch2Addr:       "rPCM12",
+	pcm34Ch4Addr:       "rPCM34",
+	ieAddr:             "rIE",
+}
+
+// romBankSize is the size of a ROM bank.
+const romBankSize = 0x4000
+
+// opcodeInfo describes how to decode an opcode.
+type opcodeInfo struct {
+	// format is the instruction in RGBDS syntax, with placeholders for
+	// immediate values. The placeholders are:
+	//   n8:  An 8-bit immediate value
+	//   n16: A 16-bit immediate value
+	//   a8:  An address in 0xFF00-0xFFFF given by an 8-bit immediate value
+	//   a16: An address given by a 16-bit immediate value
+	//   e8:  A jump target given by a signed 8-bit offset
+	//   s8:  A signed 8-bit immediate value
+	format string
+	// cycles is the number of M-Cycles the instruction takes.
+	cycles int
+	// branchCycles is the number of M-Cycles a conditional instruction takes
+	// if the condition is met, or 0 for other instructions.
+	branchCycles int
+}
+
+// length returns the length of the instruction in bytes, including the
+// opcode.
+func (info opcodeInfo) length() int {
+	length := 1
+	for _, field := range strings.FieldsFunc(info.format, isOperandSeparator) {
+		switch field {
+		case "n16", "a16":
+			length += 2
+		case "n8", "a8", "e8", "s8":
+			length++
+		}
+	}
+	return length
+}
+
+// isOperandSeparator returns true for characters that separate placeholders
+// from the rest of an instruction's format.
+func isOperandSeparator(char rune) bool {
+	return strings.ContainsRune(" ,[]+", char)
+}
+
+// registerOperands are the operands that most instructions operating on an
+// 8-bit register use, in the order their opcodes are laid out.
+var registerOperands = [8]string{"b", "c", "d", "e", "h", "l", "[hl]", "a"}
+
+// mainOpcodes describes how to decode each opcode without a 0xCB prefix.
+// Opcodes that don't correspond to an instruction have an empty format.
+var mainOpcodes = makeMainOpcodes()
+
+// cbOpcodes describes how to decode each opcode with a 0xCB prefix. The
+// lengths and cycle counts include the prefix.
+var cbOpcodes = makeCBOpcodes()
+
+func makeMainOpcodes() [0x100]opcodeInfo {
+	opcodes := [0x100]opcodeInfo{
+		0x00: {"nop", 1, 0},
+		0x01: {"ld bc, n16", 3, 0},
+		0x02: {"ld [bc], a", 2, 0},
+		0x03: {"inc bc", 2, 0},
+		0x07: {"rlca", 1, 0},
+		0x08: {"ld [a16], sp", 5, 0},
+		0x09: {"add hl, bc", 2, 0},
+		0x0A: {"ld a, [bc]", 2, 0},
+		0x0B: {"dec bc", 2, 0},
+		0x0F: {"rrca", 1, 0},
+		0x10: {"stop", 1, 0},
+		0x11: {"ld de, n16", 3, 0},
+		0x12: {"ld [de], a", 2, 0},
+		0x13: {"inc de", 2, 0},
+		0x17: {"rla", 1, 0},
+		0x18: {"jr e8", 3, 0},
+		0x19: {"add hl, de", 2, 0},
+		0x1A: {"ld a, [de]", 2, 0},
+		0x1B: {"dec de", 2, 0},
+		0x1F: {"rra", 1, 0},
+		0x20: {"jr nz, e8", 2, 3},
+		0x21: {"ld hl, n16", 3, 0},
+		0x22: {"ld [hl+], a", 2, 0},
+		0x23: {"inc hl", 2, 0},
+		0x27: {"daa", 1, 0},
+		0x28: {"jr z, e8", 2, 3},
+		0x29: {"add hl, hl", 2, 0},
+		0x2A: {"ld a, [hl+]", 2, 0},
+		0x2B: {"dec hl", 2, 0},
+		0x2F: {"cpl", 1, 0},
+		0x30: {"jr nc, e8", 2, 3},
+		0x31: {"ld sp, n16", 3, 0},
+		0x32: {"ld [hl-], a", 2, 0},
+		0x33: {"inc sp", 2, 0},
+		0x37: {"scf", 1, 0},
+		0x38: {"jr c, e8", 2, 3},
+		0x39: {"add hl, sp", 2, 0},
+		0x3A: {"ld a, [hl-]", 2, 0},
+		0x3B: {"dec sp", 2, 0},
+		0x3F: {"ccf", 1, 0},
+		0x76: {"halt", 1, 0},
+		0xC0: {"ret nz", 2, 5},
+		0xC1: {"pop bc", 3, 0},
+		0xC2: {"jp nz, a16", 3, 4},
+		0xC3: {"jp a16", 4, 0},
+		0xC4: {"call nz, a16", 3, 6},
+		0xC5: {"push bc", 4, 0},
+		0xC6: {"add a, n8", 2, 0},
+		0xC8: {"ret z", 2, 5},
+		0xC9: {"ret", 4, 0},
+		0xCA: {"jp z, a16", 3, 4},
+		0xCC: {"call z, a16", 3, 6},
+		0xCD: {"call a16", 6, 0},
+		0xCE: {"adc a, n8", 2, 0},
+		0xD0: {"ret nc", 2, 5},
+		0xD1: {"pop de", 3, 0},
+		0xD2: {"jp nc, a16", 3, 4},
+		0xD4: {"call nc, a16", 3, 6},
+		0xD5: {"push de", 4, 0},
+		0xD6: {"sub a, n8", 2, 0},
+		0xD8: {"ret c", 2, 5},
+		0xD9: {"reti", 4, 0},
+		0xDA: {"jp c, a16", 3, 4},
+		0xDC: {"call c, a16", 3, 6},
+		0xDE: {"sbc a, n8", 2, 0},
+		0xE0: {"ldh [a8], a", 3, 0},
+		0xE1: {"pop hl", 3, 0},
+		0xE2: {"ldh [c], a", 2, 0},
+		0xE5: {"push hl", 4, 0},
+		0xE6: {"and a, n8", 2, 0},
+		0xE8: {"add sp, s8", 4, 0},
+		0xE9: {"jp hl", 1, 0},
+		0xEA: {"ld [a16], a", 4, 0},
+		0xEE: {"xor a, n8", 2, 0},
+		0xF0: {"ldh a, [a8]", 3, 0},
+		0xF1: {"pop af", 3, 0},
+		0xF2: {"ldh a, [c]", 2, 0},
+		0xF3: {"di", 1, 0},
+		0xF5: {"push af", 4, 0},
+		0xF6: {"or a, n8", 2, 0},
+		0xF8: {"ld hl, sp+s8", 3, 0},
+		0xF9: {"ld sp, hl", 2, 0},
+		0xFA: {"ld a, [a16]", 4, 0},
+		0xFB: {"ei", 1, 0},
+		0xFE: {"cp a, n8", 2, 0},
+	}
+
+	// INC, DEC and LD with an 8-bit immediate value, laid out in columns
+	for i, reg := range registerOperands {
+		cycles, ldCycles := 1, 2
+		if reg == "[hl]" {
+			cycles, ldCycles = 3, 3
+		}
+		opcodes[i<<3|0x04] = opcodeInfo{"inc " + reg, cycles, 0}
+		opcodes[i<<3|0x05] = opcodeInfo{"dec " + reg, cycles, 0}
+		opcodes[i<<3|0x06] = opcodeInfo{"ld " + reg + ", n8", ldCycles, 0}
+	}
+
+	// LD between registers, except for 0x76 which is HALT
+	for i := 0x40; i < 0x80; i++ {
+		if i == 0x76 {
+			continue
+		}
+		dest := registerOperands[(i>>3)&0x7]
+		src := registerOperands[i&0x7]
+		cycles := 1
+		if dest == "[hl]" || src == "[hl]" {
+			cycles = 2
+		}
+		opcodes[i] = opcodeInfo{"ld " + dest + ", " + src, cycles, 0}
+	}
+
+	// Arithmetic and logic with a register
+	aluOps := [8]string{"add", "adc", "sub", "sbc", "and", "xor", "or", "cp"}
+	for i := 0x80; i < 0xC0; i++ {
+		src := registerOperands[i&0x7]
+		cycles := 1
+		if src == "[hl]" {
+			cycles = 2
+		}
+		opcodes[i] = opcodeInfo{aluOps[(i>>3)&0x7] + " a, " + src, cycles, 0}
+	}
+
+	// RST, which calls one of eight fixed addresses
+	for i := 0; i < 8; i++ {
+		opcodes[0xC7|i<<3] = opcodeInfo{fmt.Sprintf("rst $%02X", i<<3), 4, 0}
+	}
+
+	return opcodes
+}
+
+func makeCBOpcodes() [0x100]opcodeInfo {
+	var opcodes [0x100]opcodeInfo
+
+	shiftOps := [8]string{"rlc", "rrc", "rl", "rr", "sla", "sra", "swap", "srl"}
+
+	for i := range opcodes {
+		reg := registerOperands[i&0x7]
+		bit := (i >> 3) & 0x7
+
+		var format string
+		switch i >> 6 {
+		case 0:
+			format = shiftOps[bit] + " " + reg
+		case 1:
+			format = fmt.Sprintf("bit %v, %v", bit, reg)
+		case 2:
+			format = fmt.Sprintf("res %v, %v", bit, reg)
+		case 3:
+			format = fmt.Sprintf("set %v, %v", bit, reg)
+		}
+
+		cycles := 2
+		if reg == "[hl]" {
+			if i>>6 == 1 {
+				// BIT only reads from memory
+				cycles = 3
+			} else {
+				cycles = 4
+			}
+		}
+
+		opcodes[i] = opcodeInfo{format, cycles, 0}
+	}
+
+	return opcodes
+}
+
+// disassemble decodes the instruction at the given address using the given
+// function to read memory. Bytes past lastAddr aren't read, and an
+// instruction that would need them is decoded as data.
+func disassemble(read func(addr uint16) uint8, addr uint16, lastAddr uint16) Instruction {
+	opcode := read(addr)
+
+	info := mainOpcodes[opcode]
+	length := info.length()
+	if opcode == 0xCB {
+		if addr == lastAddr {
+			return positionedData([]uint8{opcode}, addr)
+		}
+		info = cbOpcodes[read(addr+1)]
+		length = 2
+	} else if opcode == 0x10 {
+		// STOP is followed by a byte that's ignored
+		length = 2
+	}
+
+	if info.format == "" || int(addr)+length-1 > int(lastAddr) {
+		return positionedData([]uint8{opcode}, addr)
+	}
+
+	bytes := make([]uint8, length)
+	for i := range bytes {
+		bytes[i] = read(addr + uint16(i))
+	}
+	if opcode == 0x10 && bytes[1] != 0x00 {
+		// RGBDS always assembles STOP followed by 0x00, so anything else has
+		// to be data
+		return positionedData(bytes, addr)
+	}
+
+	inst := Instruction{
+		Addr:          addr,
+		Bank:          -1,
+		Bytes:         bytes,
+		Cycles:        info.cycles,
+		BranchCycles:  info.cycles,
+		targetOperand: -1,
+	}
+	if info.branchCycles != 0 {
+		inst.BranchCycles = info.branchCycles
+	}
+
+	parts := strings.SplitN(info.format, " ", 2)
+	inst.Mnemonic = parts[0]
+	if len(parts) == 1 {
+		return inst
+	}
+
+	// The immediate value, if there is one, comes after the opcode
+	var imm8 uint8
+	var imm16 uint16
+	if opcode != 0xCB && length == 2 {
+		imm8 = bytes[1]
+	} else if length == 3 {
+		imm16 = combine16(bytes[1], bytes[2])
+	}
+
+	for i, operand := range strings.Split(parts[1], ", ") {
+		switch {
+		case operand == "n8":
+			operand = fmt.Sprintf("$%02X", imm8)
+		case operand == "n16":
+			operand = fmt.Sprintf("$%04X", imm16)
+		case operand == "[a8]":
+			operand = "[" + addressOperand(0xFF00|uint16(imm8)) + "]"
+		case operand == "[a16]":
+			operand = "[" + addressOperand(imm16) + "]"
+		case operand == "a16":
+			inst.Target = imm16
+			inst.HasTarget = true
+			inst.targetOperand = i
+			operand = fmt.Sprintf("$%04X", imm16)
+		case operand == "e8":
+			inst.Target = addr + uint16(length) + uint16(int8(imm8))
+			inst.HasTarget = true
+			inst.targetOperand = i
+			operand = fmt.Sprintf("$%04X", inst.Target)
+		case operand == "s8":
+			operand = fmt.Sprint(int8(imm8))
+		case operand == "sp+s8":
+			if int8(imm8) < 0 {
+				operand = fmt.Sprintf("sp - %v", -int(int8(imm8)))
+			} else {
+				operand = fmt.Sprintf("sp + %v", imm8)
+			}
+		case inst.Mnemonic == "rst":
+			inst.Target = uint16(opcode & 0x38)
+			inst.HasTarget = true
+			inst.targetOperand = i
+		}
+		inst.Operands = append(inst.Operands, operand)
+	}
+
+	inst.IsCall = inst.HasTarget && (inst.Mnemonic == "call" || inst.Mnemonic == "rst")
+
+	return inst
+}
+
+// addressOperand formats the given address, using the name of the hardware
+// register at that address if there is one.
+func addressOperand(addr uint16) string {
+	if name, ok := hardwareRegisterNames[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// dataInstruction creates an instruction that defines the given bytes as data.
+func dataInstruction(bytes []uint8) Instruction {
+	inst := Instruction{
+		Bank:          -1,
+		Bytes:         append([]uint8(nil), bytes...),
+		Mnemonic:      "db",
+		IsData:        true,
+		targetOperand: -1,
+	}
+	for _, b := range bytes {
+		inst.Operands = append(inst.Operands, fmt.Sprintf("$%02X", b))
+	}
+	return inst
+}
+
+// positionedData creates a data instruction for the given bytes at the given
+// address.
+func positionedData(bytes []uint8, addr uint16) Instruction {
+	inst := dataInstruction(bytes)
+	inst.Addr = addr
+	return inst
+}
+
+// minInt returns the smaller of the two values.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
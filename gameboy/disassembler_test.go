@@ -0,0 +1,277 @@
+package gameboy
+
+import (
+	"strings"
+	"testing"
+)
+
+// disassembleBytes decodes the instruction at the given address of a memory
+// space that contains the given bytes at that address and zeros elsewhere.
+func disassembleBytes(bytes []uint8, addr uint16) Instruction {
+	memory := make([]uint8, 0x10000)
+	for i, b := range bytes {
+		memory[(int(addr)+i)&0xFFFF] = b
+	}
+	return disassemble(func(addr uint16) uint8 { return memory[addr] }, addr, 0xFFFF)
+}
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		bytes        []uint8
+		expected     string
+		length       int
+		cycles       int
+		branchCycles int
+	}{
+		{[]uint8{0x00}, "nop", 1, 1, 1},
+		{[]uint8{0x01, 0x34, 0x12}, "ld bc, $1234", 3, 3, 3},
+		{[]uint8{0x08, 0x00, 0xC0}, "ld [$C000], sp", 3, 5, 5},
+		{[]uint8{0x36, 0x12}, "ld [hl], $12", 2, 3, 3},
+		{[]uint8{0x2A}, "ld a, [hl+]", 1, 2, 2},
+		{[]uint8{0x46}, "ld b, [hl]", 1, 2, 2},
+		{[]uint8{0x76}, "halt", 1, 1, 1},
+		{[]uint8{0x86}, "add a, [hl]", 1, 2, 2},
+		{[]uint8{0xE0, 0x44}, "ldh [rLY], a", 2, 3, 3},
+		{[]uint8{0xF0, 0x80}, "ldh a, [$FF80]", 2, 3, 3},
+		{[]uint8{0xFA, 0x05, 0xD0}, "ld a, [$D005]", 3, 4, 4},
+		{[]uint8{0xE8, 0xFB}, "add sp, -5", 2, 4, 4},
+		{[]uint8{0xF8, 0xFE}, "ld hl, sp - 2", 2, 3, 3},
+		{[]uint8{0xF8, 0x02}, "ld hl, sp + 2", 2, 3, 3},
+		{[]uint8{0x10, 0x00}, "stop", 2, 1, 1},
+		// Conditional instructions take longer when the condition is met
+		{[]uint8{0x20, 0x05}, "jr nz, $0157", 2, 2, 3},
+		{[]uint8{0x18, 0xFE}, "jr $0150", 2, 3, 3},
+		{[]uint8{0xC2, 0x00, 0x40}, "jp nz, $4000", 3, 3, 4},
+		{[]uint8{0xC4, 0x00, 0x40}, "call nz, $4000", 3, 3, 6},
+		{[]uint8{0xCD, 0x00, 0x40}, "call $4000", 3, 6, 6},
+		{[]uint8{0xD8}, "ret c", 1, 2, 5},
+		{[]uint8{0xC9}, "ret", 1, 4, 4},
+		{[]uint8{0xE9}, "jp hl", 1, 1, 1},
+		{[]uint8{0xFF}, "rst $38", 1, 4, 4},
+		// CB-prefixed instructions
+		{[]uint8{0xCB, 0x11}, "rl c", 2, 2, 2},
+		{[]uint8{0xCB, 0x37}, "swap a", 2, 2, 2},
+		{[]uint8{0xCB, 0x7E}, "bit 7, [hl]", 2, 3, 3},
+		{[]uint8{0xCB, 0x86}, "res 0, [hl]", 2, 4, 4},
+		{[]uint8{0xCB, 0xFF}, "set 7, a", 2, 2, 2},
+		// Invalid opcodes and STOP followed by anything but 0x00 are data
+		{[]uint8{0xD3}, "db $D3", 1, 0, 0},
+		{[]uint8{0x10, 0x01}, "db $10, $01", 2, 0, 0},
+	}
+
+	for _, test := range tests {
+		inst := disassembleBytes(test.bytes, 0x0150)
+
+		if actual := inst.String(); actual != test.expected {
+			t.Errorf("% X: expected %q, got %q", test.bytes, test.expected, actual)
+			continue
+		}
+		if len(inst.Bytes) != test.length {
+			t.Errorf("%v: expected a length of %v, got %v", test.expected, test.length, len(inst.Bytes))
+		}
+		if inst.Cycles != test.cycles || inst.BranchCycles != test.branchCycles {
+			t.Errorf("%v: expected %v/%v cycles, got %v/%v", test.expected,
+				test.cycles, test.branchCycles, inst.Cycles, inst.BranchCycles)
+		}
+		if inst.IsData != (inst.Mnemonic == "db") {
+			t.Errorf("%v: IsData is %v", test.expected, inst.IsData)
+		}
+	}
+}
+
+func TestDisassembleTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		bytes  []uint8
+		addr   uint16
+		target uint16
+		isCall bool
+	}{
+		{"jump", []uint8{0xC3, 0x34, 0x12}, 0x0150, 0x1234, false},
+		{"call", []uint8{0xCC, 0x34, 0x12}, 0x0150, 0x1234, true},
+		{"rst", []uint8{0xCF}, 0x0150, 0x0008, true},
+		{"relative", []uint8{0x38, 0x80}, 0x0150, 0x00D2, false},
+		// Relative jump targets wrap around the address space
+		{"relative past the end", []uint8{0x18, 0x05}, 0xFFFE, 0x0005, false},
+		{"relative before the start", []uint8{0x18, 0xFC}, 0x0000, 0xFFFE, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inst := disassembleBytes(test.bytes, test.addr)
+			if !inst.HasTarget || inst.Target != test.target {
+				t.Fatalf("expected a target of %#04x, got %#04x (%v)", test.target, inst.Target, inst.HasTarget)
+			}
+			if inst.IsCall != test.isCall {
+				t.Fatalf("expected IsCall to be %v", test.isCall)
+			}
+
+			// The target is replaced by its label
+			formatted := inst.Format(map[uint16]string{test.target: "Target"})
+			if !strings.HasSuffix(formatted, "Target") {
+				t.Fatalf("expected the target to be labeled, got %q", formatted)
+			}
+		})
+	}
+
+	if inst := disassembleBytes([]uint8{0xE9}, 0x0150); inst.HasTarget {
+		t.Errorf("expected jp hl not to have a known target, got %#04x", inst.Target)
+	}
+}
+
+func TestDisassembleROM(t *testing.T) {
+	rom := newTestCartridge(0x01, 0x01, 0x00)
+	// Bank 2 ends with an instruction that needs bytes from the next bank
+	copy(rom[3*romBankSize-2:], []uint8{0x00, 0x01, 0x34})
+	// And bank 3 with a CB prefix
+	rom[4*romBankSize-1] = 0xCB
+	copy(rom[2*romBankSize+0x0100:], []uint8{0xCD, 0x00, 0x40})
+
+	tests := []struct {
+		bank     int
+		addr     uint16
+		expected string
+		// expectedBank is the bank that the instruction is in.
+		expectedBank int
+	}{
+		{2, 0x4100, "call $4000", 2},
+		// Addresses in bank 0 are always in bank 0
+		{2, 0x0100, "jr $0100", 0},
+		{2, 0x7FFF, "db $01", 2},
+		{3, 0x7FFF, "db $CB", 3},
+	}
+
+	for _, test := range tests {
+		inst, err := DisassembleROM(rom, test.bank, test.addr)
+		if err != nil {
+			t.Errorf("disassembling %v:%#04x: %v", test.bank, test.addr, err)
+			continue
+		}
+		if inst.String() != test.expected || inst.Bank != test.expectedBank || inst.Addr != test.addr {
+			t.Errorf("%v:%#04x: expected %q in bank %v, got %q in bank %v at %#04x", test.bank, test.addr,
+				test.expected, test.expectedBank, inst.String(), inst.Bank, inst.Addr)
+		}
+	}
+
+	if _, err := DisassembleROM(rom, 1, 0x8000); err == nil {
+		t.Errorf("expected disassembling outside of ROM to fail")
+	}
+	if _, err := DisassembleROM(rom, 4, 0x4000); err == nil {
+		t.Errorf("expected disassembling a bank that doesn't exist to fail")
+	}
+}
+
+func TestDisassembleROMBank(t *testing.T) {
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	// A jump that would run into the header
+	copy(rom[0x0100:], []uint8{0x00, 0x00, 0xC3, 0x50})
+	copy(rom[0x0150:], []uint8{0xCB, 0x37, 0x18, 0xFC})
+
+	instructions, err := DisassembleROMBank(rom, 0)
+	if err != nil {
+		t.Fatalf("disassembling: %v", err)
+	}
+
+	byAddr := make(map[uint16]Instruction)
+	end := 0
+	for _, inst := range instructions {
+		if int(inst.Addr) != end {
+			t.Fatalf("expected an instruction at %#04x, got one at %#04x", end, inst.Addr)
+		}
+		if inst.Bank != 0 {
+			t.Fatalf("expected %v at %#04x to be in bank 0, got %v", inst, inst.Addr, inst.Bank)
+		}
+		byAddr[inst.Addr] = inst
+		end += len(inst.Bytes)
+	}
+	if end != romBankSize {
+		t.Fatalf("expected the instructions to cover the bank, but they end at %#04x", end)
+	}
+
+	tests := []struct {
+		addr     uint16
+		length   int
+		isData   bool
+		expected string
+	}{
+		{0x0101, 1, false, "nop"},
+		{0x0102, 2, true, "db $C3, $50"},
+		// The header is split into lines of data
+		{0x0104, 16, true, ""},
+		{0x0134, 16, true, "db $54, $45, $53, $54, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00, $00"},
+		{0x0144, 12, true, ""},
+		{0x0150, 2, false, "swap a"},
+		{0x0152, 2, false, "jr $0150"},
+	}
+	for _, test := range tests {
+		inst, ok := byAddr[test.addr]
+		if !ok {
+			t.Errorf("expected an instruction at %#04x", test.addr)
+			continue
+		}
+		if len(inst.Bytes) != test.length || inst.IsData != test.isData {
+			t.Errorf("%#04x: expected %v bytes with IsData %v, got %v", test.addr, test.length, test.isData, inst)
+		}
+		if test.expected != "" && inst.String() != test.expected {
+			t.Errorf("%#04x: expected %q, got %q", test.addr, test.expected, inst.String())
+		}
+	}
+}
+
+func TestLabelTargets(t *testing.T) {
+	instructions := []Instruction{
+		{Addr: 0x4000, Bank: 1, Mnemonic: "nop"},
+		{Addr: 0x4001, Bank: 1, Mnemonic: "jr", Target: 0x4000, HasTarget: true},
+		{Addr: 0x4003, Bank: 1, Mnemonic: "jp", Target: 0x4006, HasTarget: true},
+		// Calls take precedence over jumps to the same place, no matter the
+		// order
+		{Addr: 0x4006, Bank: 1, Mnemonic: "call", Target: 0x4000, HasTarget: true, IsCall: true},
+		{Addr: 0x4009, Bank: 1, Mnemonic: "call", Target: 0x4006, HasTarget: true, IsCall: true},
+		{Addr: 0x400C, Bank: 1, Mnemonic: "jp", Target: 0x4006, HasTarget: true},
+		// Targets in the middle of an instruction or outside of the
+		// instructions don't get labels
+		{Addr: 0x400F, Bank: 1, Mnemonic: "jp", Target: 0x4007, HasTarget: true},
+		{Addr: 0x4012, Bank: 1, Mnemonic: "jp", Target: 0x5000, HasTarget: true},
+		{Addr: 0xC000, Bank: -1, Mnemonic: "jr", Target: 0xC000, HasTarget: true},
+	}
+
+	labels := LabelTargets(instructions)
+
+	expected := map[uint16]string{
+		0x4000: "Call_001_4000",
+		0x4006: "Call_001_4006",
+		0xC000: "Jump_C000",
+	}
+	if len(labels) != len(expected) {
+		t.Fatalf("expected labels %v, got %v", expected, labels)
+	}
+	for addr, label := range expected {
+		if labels[addr] != label {
+			t.Errorf("label at %#04x is %q, expected %q", addr, labels[addr], label)
+		}
+	}
+}
+
+func TestDeviceDisassemble(t *testing.T) {
+	driver := &testDebugDriver{t: t}
+	device := newDebuggerTestDevice(t, driver, map[string]BreakpointKind{
+		"0200-0212": BreakOnRead,
+		"4000":      BreakOnAccess,
+	})
+
+	// Disassembling reads memory, but not as the CPU
+	if inst := device.Disassemble(0x0200); inst.String() != "call $0210" || inst.Bank != 0 {
+		t.Errorf("expected call $0210 in bank 0, got %q in bank %v", inst, inst.Bank)
+	}
+	if inst := device.Disassemble(0x4000); inst.String() != "ret" || inst.Bank != 1 {
+		t.Errorf("expected ret in bank 1, got %q in bank %v", inst, inst.Bank)
+	}
+	if inst := device.Disassemble(0xC000); inst.Bank != -1 {
+		t.Errorf("expected RAM not to be in a bank, got %v", inst.Bank)
+	}
+
+	runFrames(t, device, 2)
+	if len(driver.stops) != 0 {
+		t.Fatalf("expected no stops, got %q", driver.stops[0].Reason)
+	}
+}
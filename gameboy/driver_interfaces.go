@@ -1,5 +1,7 @@
 package gameboy
 
+import "image"
+
 // VideoDriver describes an object can display RGBA frames.
 type VideoDriver interface {
 	// Render displays the given frame data on-screen.
@@ -66,3 +68,119 @@ type SaveGameDriver interface {
 	// Has returns true if a game save exists under the given name.
 	Has(name string) (bool, error)
 }
+
+// SerialDriver describes an object that connects the Game Boy's link port to
+// a peer.
+type SerialDriver interface {
+	// Transfer sends a byte to the peer and returns the byte the peer sent
+	// back. It's called when this Game Boy finishes a transfer using its
+	// internal clock. If no peer is connected or the peer isn't waiting for a
+	// transfer, 0xFF should be returned.
+	Transfer(out uint8) uint8
+	// SetPending is called when this Game Boy starts or stops waiting for the
+	// peer to start a transfer using the peer's clock. While pending, the
+	// given byte should be sent to the peer when it starts a transfer. It's
+	// called again if the byte changes.
+	SetPending(out uint8, pending bool)
+	// Receive returns the byte sent by the peer and true if the peer has
+	// started a transfer since this Game Boy started waiting. It's called
+	// every M-Cycle while a transfer is pending.
+	Receive() (uint8, bool)
+}
+
+// noopSerialDriver is a mock serial driver that acts like a disconnected link
+// cable.
+type noopSerialDriver struct{}
+
+func (driver *noopSerialDriver) Transfer(out uint8) uint8 {
+	return 0xFF
+}
+
+func (driver *noopSerialDriver) SetPending(out uint8, pending bool) {
+}
+
+func (driver *noopSerialDriver) Receive() (uint8, bool) {
+	return 0, false
+}
+
+// PrinterDriver describes an object that receives images printed by the Game
+// Boy Printer.
+type PrinterDriver interface {
+	// Print is called when the printer finishes printing an image. The
+	// margins are the amount of blank paper fed before and after the image,
+	// from 0 to 15.
+	Print(img image.Image, topMargin, bottomMargin int) error
+}
+
+// ImageSourceDriver describes an object that supplies images to the Game Boy
+// Camera, like a webcam.
+type ImageSourceDriver interface {
+	// Capture returns a grayscale image that's CameraWidth by CameraHeight
+	// pixels. Each element is the brightness of a pixel from 0 (black) to 255
+	// (white), laid out row by row.
+	Capture() ([]uint8, error)
+}
+
+// noopImageSourceDriver is a mock image source that always supplies a gray
+// image.
+type noopImageSourceDriver struct{}
+
+func (driver *noopImageSourceDriver) Capture() ([]uint8, error) {
+	pixels := make([]uint8, CameraWidth*CameraHeight)
+	for i := range pixels {
+		pixels[i] = 0x80
+	}
+	return pixels, nil
+}
+
+// TiltDriver describes an object that reports how the Game Boy is tilted, for
+// cartridges with an accelerometer.
+type TiltDriver interface {
+	// Tilt returns how far the Game Boy is tilted on the X and Y axes, from -1
+	// to 1. Positive X values tilt the right side down and positive Y values
+	// tilt the top side down.
+	Tilt() (x, y float64)
+}
+
+// noopTiltDriver is a mock tilt driver that always reports that the Game Boy
+// is level.
+type noopTiltDriver struct{}
+
+func (driver *noopTiltDriver) Tilt() (x, y float64) {
+	return 0, 0
+}
+
+// InfraredDriver describes an object that connects a cartridge's infrared port
+// to a peer.
+type InfraredDriver interface {
+	// SetLight turns this Game Boy's infrared LED on or off.
+	SetLight(on bool)
+	// DetectsLight returns true if this Game Boy's infrared sensor is
+	// receiving light from the peer.
+	DetectsLight() bool
+}
+
+// noopInfraredDriver is a mock infrared driver that never receives any light.
+type noopInfraredDriver struct{}
+
+func (driver *noopInfraredDriver) SetLight(on bool) {
+}
+
+func (driver *noopInfraredDriver) DetectsLight() bool {
+	return false
+}
+
+// DebugDriver describes an object that lets the user control the debugger,
+// like a console or a GUI. When emulation stops, the debugger sends a
+// StopEvent and runs commands from the driver until one of them resumes
+// emulation. The device doesn't run while the debugger waits for a command,
+// so it's safe for the driver to inspect the device in the meantime.
+type DebugDriver interface {
+	// NextCommand blocks until the user enters a debugger command and
+	// returns it. Commands use the syntax listed by the "help" command. If an
+	// error is returned, emulation continues.
+	NextCommand() (string, error)
+	// Event reports something that happened in the debugger, like emulation
+	// stopping or the result of a command.
+	Event(event DebugEvent)
+}
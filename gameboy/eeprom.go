@@ -0,0 +1,253 @@
+package gameboy
+
+// eepromState is what the EEPROM is doing.
+type eepromState int
+
+const (
+	// eepromIdle means that the EEPROM is waiting for a start bit.
+	eepromIdle eepromState = iota
+	// eepromCommand means that the EEPROM is receiving a command.
+	eepromCommand
+	// eepromReading means that the EEPROM is sending data.
+	eepromReading
+	// eepromWriting means that the EEPROM is receiving data to write.
+	eepromWriting
+	// eepromDone means that the EEPROM has finished a command and is waiting
+	// to be deselected.
+	eepromDone
+)
+
+const (
+	// eepromWords is the number of 16-bit words in the EEPROM.
+	eepromWords = 128
+	// eepromCommandBits is the number of bits in a command, not including the
+	// start bit. Commands are a 2-bit opcode followed by an 8-bit address.
+	eepromCommandBits = 10
+)
+
+// eeprom93LC56 emulates a 93LC56 serial EEPROM with 256 bytes of storage,
+// organized as 16-bit words. The game talks to it by toggling the chip
+// select, clock and data in lines, and reads back results from the data out
+// line.
+type eeprom93LC56 struct {
+	// data is the contents of the EEPROM. Words are stored with the low byte
+	// first.
+	data [eepromWords * 2]uint8
+
+	// The state of the input lines
+	chipSelect bool
+	clock      bool
+	dataIn     bool
+	// The state of the output line
+	dataOut bool
+
+	state eepromState
+	// writeEnabled is true if writes and erases are allowed.
+	writeEnabled bool
+	// shift holds bits being received or sent.
+	shift uint16
+	// bitCount is the number of bits received or left to send.
+	bitCount int
+	// addr is the word being read or written.
+	addr uint8
+	// writeAll is true if the data being received should be written to every
+	// word.
+	writeAll bool
+}
+
+func newEEPROM93LC56() *eeprom93LC56 {
+	e := &eeprom93LC56{dataOut: true}
+
+	// EEPROMs start out erased
+	for i := range e.data {
+		e.data[i] = 0xFF
+	}
+
+	return e
+}
+
+// read returns the state of the EEPROM's lines. Bit 7 is chip select, bit 6
+// is the clock, bit 1 is data in and bit 0 is data out.
+func (e *eeprom93LC56) read() uint8 {
+	var val uint8
+	if e.chipSelect {
+		val |= 0x80
+	}
+	if e.clock {
+		val |= 0x40
+	}
+	if e.dataIn {
+		val |= 0x02
+	}
+	if e.dataOut {
+		val |= 0x01
+	}
+	return val
+}
+
+// write sets the EEPROM's input lines. Bit 7 is chip select, bit 6 is the
+// clock and bit 1 is data in. Data is shifted in and out on the rising edge
+// of the clock.
+func (e *eeprom93LC56) write(val uint8) {
+	chipSelect := val&0x80 == 0x80
+	clock := val&0x40 == 0x40
+	e.dataIn = val&0x02 == 0x02
+
+	risingEdge := clock && !e.clock
+	e.clock = clock
+
+	if !chipSelect {
+		// Deselecting the chip cancels any command in progress. The data out
+		// line reports that the EEPROM is ready.
+		e.chipSelect = false
+		e.state = eepromIdle
+		e.dataOut = true
+		return
+	}
+	e.chipSelect = true
+
+	if !risingEdge {
+		return
+	}
+
+	bit := uint16(0)
+	if e.dataIn {
+		bit = 1
+	}
+
+	switch e.state {
+	case eepromIdle:
+		if bit == 1 {
+			// A start bit
+			e.state = eepromCommand
+			e.shift = 0
+			e.bitCount = 0
+		}
+	case eepromCommand:
+		e.shift = e.shift<<1 | bit
+		e.bitCount++
+		if e.bitCount == eepromCommandBits {
+			e.runCommand(uint8(e.shift>>8), uint8(e.shift))
+		}
+	case eepromReading:
+		e.dataOut = e.shift&0x8000 == 0x8000
+		e.shift <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			// Reads continue on to the next word until the chip is
+			// deselected
+			e.addr = (e.addr + 1) % eepromWords
+			e.shift = e.word(e.addr)
+			e.bitCount = 16
+		}
+	case eepromWriting:
+		e.shift = e.shift<<1 | bit
+		e.bitCount++
+		if e.bitCount == 16 {
+			if e.writeEnabled {
+				if e.writeAll {
+					for i := uint8(0); i < eepromWords; i++ {
+						e.setWord(i, e.shift)
+					}
+				} else {
+					e.setWord(e.addr, e.shift)
+				}
+			}
+			e.dataOut = true
+			e.state = eepromDone
+		}
+	case eepromDone:
+		// Wait to be deselected
+	}
+}
+
+// runCommand starts the command with the given opcode and address.
+func (e *eeprom93LC56) runCommand(opcode uint8, addr uint8) {
+	e.addr = addr % eepromWords
+	e.state = eepromDone
+
+	switch opcode {
+	case 0x2:
+		// READ, which sends a dummy 0 bit followed by the word
+		e.dataOut = false
+		e.shift = e.word(e.addr)
+		e.bitCount = 16
+		e.state = eepromReading
+	case 0x1:
+		// WRITE
+		e.shift = 0
+		e.bitCount = 0
+		e.writeAll = false
+		e.state = eepromWriting
+	case 0x3:
+		// ERASE
+		if e.writeEnabled {
+			e.setWord(e.addr, 0xFFFF)
+		}
+		e.dataOut = true
+	case 0x0:
+		// The upper two bits of the address pick one of several commands
+		switch addr >> 6 {
+		case 0x0:
+			// EWDS, which disables writes
+			e.writeEnabled = false
+		case 0x1:
+			// WRAL, which writes to every word
+			e.shift = 0
+			e.bitCount = 0
+			e.writeAll = true
+			e.state = eepromWriting
+		case 0x2:
+			// ERAL, which erases every word
+			if e.writeEnabled {
+				for i := uint8(0); i < eepromWords; i++ {
+					e.setWord(i, 0xFFFF)
+				}
+			}
+			e.dataOut = true
+		case 0x3:
+			// EWEN, which enables writes
+			e.writeEnabled = true
+		}
+	}
+}
+
+// word returns the word at the given address.
+func (e *eeprom93LC56) word(addr uint8) uint16 {
+	return combine16(e.data[int(addr)*2], e.data[int(addr)*2+1])
+}
+
+// setWord sets the word at the given address.
+func (e *eeprom93LC56) setWord(addr uint8, val uint16) {
+	e.data[int(addr)*2], e.data[int(addr)*2+1] = split16(val)
+}
+
+// saveState writes the EEPROM's contents and state to a save state.
+func (e *eeprom93LC56) saveState(sw *stateWriter) {
+	sw.write(e.data)
+	sw.write(e.chipSelect)
+	sw.write(e.clock)
+	sw.write(e.dataIn)
+	sw.write(e.dataOut)
+	sw.writeInt(int(e.state))
+	sw.write(e.writeEnabled)
+	sw.write(e.shift)
+	sw.writeInt(e.bitCount)
+	sw.write(e.addr)
+	sw.write(e.writeAll)
+}
+
+// loadState reads the EEPROM's contents and state from a save state.
+func (e *eeprom93LC56) loadState(sr *stateReader) {
+	sr.read(&e.data)
+	e.chipSelect = sr.readBool()
+	e.clock = sr.readBool()
+	e.dataIn = sr.readBool()
+	e.dataOut = sr.readBool()
+	e.state = eepromState(sr.readInt())
+	e.writeEnabled = sr.readBool()
+	e.shift = sr.readUint16()
+	e.bitCount = sr.readInt()
+	e.addr = sr.readUint8()
+	e.writeAll = sr.readBool()
+}
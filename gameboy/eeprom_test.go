@@ -0,0 +1,163 @@
+package gameboy
+
+import "testing"
+
+// eepromSend clocks the lowest count bits of the given value into the EEPROM,
+// most significant bit first.
+func eepromSend(e *eeprom93LC56, val uint16, count int) {
+	for i := count - 1; i >= 0; i-- {
+		var dataIn uint8
+		if val&(1<<uint(i)) != 0 {
+			dataIn = 0x02
+		}
+		e.write(0x80 | dataIn)
+		e.write(0xC0 | dataIn)
+	}
+}
+
+// eepromReceive clocks count bits out of the EEPROM.
+func eepromReceive(e *eeprom93LC56, count int) uint16 {
+	var val uint16
+	for i := 0; i < count; i++ {
+		e.write(0x80)
+		e.write(0xC0)
+		val = val<<1 | uint16(e.read()&0x01)
+	}
+	return val
+}
+
+// eepromStartCommand selects the EEPROM and sends a start bit followed by the
+// given opcode and address.
+func eepromStartCommand(e *eeprom93LC56, opcode uint8, addr uint8) {
+	e.write(0x00)
+	e.write(0x80)
+	eepromSend(e, 1, 1)
+	eepromSend(e, uint16(opcode), 2)
+	eepromSend(e, uint16(addr), 8)
+}
+
+// eepromRead reads the word at the given address.
+func eepromRead(t *testing.T, e *eeprom93LC56, addr uint8) uint16 {
+	t.Helper()
+
+	eepromStartCommand(e, 0x2, addr)
+	if e.read()&0x01 != 0 {
+		t.Fatalf("expected a dummy 0 bit before the word at %#x", addr)
+	}
+	val := eepromReceive(e, 16)
+	e.write(0x00)
+	return val
+}
+
+// eepromWrite writes a word with the WRITE command, or to every word with
+// the WRAL command if all is true.
+func eepromWrite(e *eeprom93LC56, addr uint8, val uint16, all bool) {
+	if all {
+		eepromStartCommand(e, 0x0, 0x40)
+	} else {
+		eepromStartCommand(e, 0x1, addr)
+	}
+	eepromSend(e, val, 16)
+	e.write(0x00)
+}
+
+func TestEEPROMStartsErased(t *testing.T) {
+	e := newEEPROM93LC56()
+	for _, addr := range []uint8{0x00, 0x7F} {
+		if val := eepromRead(t, e, addr); val != 0xFFFF {
+			t.Errorf("expected word %#x to be erased, got %#x", addr, val)
+		}
+	}
+}
+
+func TestEEPROMWriteProtection(t *testing.T) {
+	e := newEEPROM93LC56()
+
+	eepromWrite(e, 0x05, 0x1234, false)
+	if val := eepromRead(t, e, 0x05); val != 0xFFFF {
+		t.Fatalf("a write before EWEN changed the word to %#x", val)
+	}
+
+	// EWEN
+	eepromStartCommand(e, 0x0, 0xC0)
+	e.write(0x00)
+	eepromWrite(e, 0x05, 0x1234, false)
+	if val := eepromRead(t, e, 0x05); val != 0x1234 {
+		t.Fatalf("expected %#x after writing, got %#x", 0x1234, val)
+	}
+	// Words are stored with the low byte first
+	if e.data[10] != 0x34 || e.data[11] != 0x12 {
+		t.Errorf("expected the bytes 0x34 0x12, got %#x %#x", e.data[10], e.data[11])
+	}
+
+	// EWDS
+	eepromStartCommand(e, 0x0, 0x00)
+	e.write(0x00)
+	eepromWrite(e, 0x05, 0x5678, false)
+	if val := eepromRead(t, e, 0x05); val != 0x1234 {
+		t.Fatalf("a write after EWDS changed the word to %#x", val)
+	}
+}
+
+func TestEEPROMCommands(t *testing.T) {
+	e := newEEPROM93LC56()
+	eepromStartCommand(e, 0x0, 0xC0) // EWEN
+	e.write(0x00)
+
+	// WRAL
+	eepromWrite(e, 0, 0xABCD, true)
+	for _, addr := range []uint8{0x00, 0x40, 0x7F} {
+		if val := eepromRead(t, e, addr); val != 0xABCD {
+			t.Errorf("expected WRAL to set word %#x to 0xabcd, got %#x", addr, val)
+		}
+	}
+
+	// ERASE
+	eepromStartCommand(e, 0x3, 0x10)
+	e.write(0x00)
+	if val := eepromRead(t, e, 0x10); val != 0xFFFF {
+		t.Errorf("expected ERASE to erase the word, got %#x", val)
+	}
+	if val := eepromRead(t, e, 0x11); val != 0xABCD {
+		t.Errorf("expected ERASE to leave other words alone, got %#x", val)
+	}
+
+	// READ continues on to the next word
+	eepromWrite(e, 0x7F, 0x1357, false)
+	eepromStartCommand(e, 0x2, 0x7F)
+	if val := eepromReceive(e, 16); val != 0x1357 {
+		t.Errorf("expected the first word to be 0x1357, got %#x", val)
+	}
+	if val := eepromReceive(e, 16); val != 0xABCD {
+		t.Errorf("expected the read to wrap around to word 0, got %#x", val)
+	}
+	e.write(0x00)
+
+	// ERAL
+	eepromStartCommand(e, 0x0, 0x80)
+	e.write(0x00)
+	for _, addr := range []uint8{0x00, 0x7F} {
+		if val := eepromRead(t, e, addr); val != 0xFFFF {
+			t.Errorf("expected ERAL to erase word %#x, got %#x", addr, val)
+		}
+	}
+}
+
+func TestEEPROMDeselectCancels(t *testing.T) {
+	e := newEEPROM93LC56()
+	eepromStartCommand(e, 0x0, 0xC0) // EWEN
+	e.write(0x00)
+
+	eepromStartCommand(e, 0x1, 0x20)
+	eepromSend(e, 0x12, 8)
+	e.write(0x00)
+	// The rest of the word is ignored, since it isn't preceded by a start
+	// bit
+	e.write(0x80)
+	eepromSend(e, 0x00, 8)
+	e.write(0x00)
+
+	if val := eepromRead(t, e, 0x20); val != 0xFFFF {
+		t.Fatalf("a cancelled write changed the word to %#x", val)
+	}
+}
@@ -0,0 +1,749 @@
+package gameboy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// gdbInterrupt is sent by GDB outside of a packet to stop a running
+	// target, usually because the user pressed Ctrl-C.
+	gdbInterrupt = 0x03
+
+	// Signals reported to GDB when the target stops.
+	gdbSignalInterrupt = 2
+	gdbSignalTrap      = 5
+
+	// gdbMaxPacketSize is the largest packet GDB is told it can send.
+	gdbMaxPacketSize = 0x1000
+)
+
+// gdbRegisterCount is the number of registers reported to GDB. They're the
+// 16-bit register pairs AF, BC, DE, HL, SP and PC in that order, which
+// matches the start of GDB's register layout for the Z80.
+const gdbRegisterCount = 6
+
+// gdbTargetXML describes the registers to GDB.
+const gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <architecture>gbz80</architecture>
+  <feature name="org.gnu.gdb.z80.cpu">
+    <reg name="af" bitsize="16" type="int16"/>
+    <reg name="bc" bitsize="16" type="int16"/>
+    <reg name="de" bitsize="16" type="int16"/>
+    <reg name="hl" bitsize="16" type="data_ptr"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+// GDBServer is a debug driver that lets GDB debug the emulated CPU over the
+// GDB remote serial protocol. It supports reading and writing registers and
+// memory, breakpoints, watchpoints, single-stepping and continuing.
+//
+// The server is used by passing it as the driver in the device's
+// DebugConfiguration. The device stops before running its first instruction
+// and waits for GDB to connect. Only one GDB may be connected at a time.
+// When GDB detaches or disconnects, its breakpoints are removed and the
+// device continues running until another GDB connects.
+type GDBServer struct {
+	listener net.Listener
+	// connections receives connections from GDB. It's closed when the
+	// server is closed.
+	connections chan *gdbConnection
+
+	// db is the debugger of the device that the server is attached to. It's
+	// set once under the mutex, before the device starts running.
+	db *debugger
+
+	// The values below are only used by the emulation goroutine, which talks
+	// to GDB while the device is stopped.
+
+	// conn is the connection to GDB, or nil if it isn't connected.
+	conn *gdbConnection
+	// lastStop is the reason that the device last stopped.
+	lastStop StopEvent
+	// lastSignal is the signal that was reported for the last stop.
+	lastSignal int
+	// resumed is true if GDB resumed the device and is waiting for a stop
+	// reply.
+	resumed bool
+	// breakpoints maps the breakpoints and watchpoints GDB has inserted to
+	// the IDs of the debugger's breakpoints.
+	breakpoints map[string]int
+
+	// mutex guards all values below, which are used by the connection
+	// goroutines.
+	mutex sync.Mutex
+	// running is true if the device is running, as opposed to being stopped
+	// in the debugger.
+	running bool
+	// active is the connection to GDB, or nil if it isn't connected. Unlike
+	// conn, it's set as soon as GDB connects.
+	active net.Conn
+	// interrupted is true if GDB asked for the device to be stopped, and it
+	// hasn't stopped yet.
+	interrupted bool
+	// closed is true once the server has been closed.
+	closed bool
+}
+
+// ListenGDB creates a GDB server that waits for GDB to connect on the given
+// address, like "localhost:2345".
+func ListenGDB(address string) (*GDBServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, xerrors.Errorf("listening for GDB connection: %w", err)
+	}
+
+	server := &GDBServer{
+		listener:    listener,
+		connections: make(chan *gdbConnection, 1),
+		breakpoints: make(map[string]int),
+		running:     true,
+	}
+
+	go server.acceptLoop()
+
+	return server, nil
+}
+
+// Addr returns the address that the server is listening on.
+func (server *GDBServer) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+// Close stops listening for connections and disconnects GDB. If the device is
+// stopped waiting for GDB, it continues running.
+func (server *GDBServer) Close() error {
+	server.mutex.Lock()
+	server.closed = true
+	if server.active != nil {
+		server.active.Close()
+	}
+	server.mutex.Unlock()
+
+	// Closing the listener ends the accept loop, which closes connections
+	return server.listener.Close()
+}
+
+// attach is called when a device is created with the server as its debug
+// driver.
+func (server *GDBServer) attach(db *debugger) {
+	server.mutex.Lock()
+	server.db = db
+	server.mutex.Unlock()
+
+	// GDB expects the target to be stopped when it connects
+	db.requestStop("Waiting for GDB")
+}
+
+// acceptLoop accepts connections from GDB until the server is closed.
+func (server *GDBServer) acceptLoop() {
+	defer close(server.connections)
+
+	for {
+		netConn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.mutex.Lock()
+		if server.active != nil || server.closed {
+			// Only one GDB can be connected at once
+			server.mutex.Unlock()
+			netConn.Close()
+			continue
+		}
+		server.active = netConn
+		if server.running && server.db != nil {
+			server.db.requestStop("GDB connected")
+		}
+		server.mutex.Unlock()
+
+		conn := &gdbConnection{
+			conn:    netConn,
+			packets: make(chan string),
+		}
+		go conn.readLoop(server)
+
+		server.connections <- conn
+	}
+}
+
+// NextCommand talks to GDB until it resumes the device, then returns the
+// debugger command that does the same.
+func (server *GDBServer) NextCommand() (string, error) {
+	for {
+		if server.conn == nil {
+			conn, ok := <-server.connections
+			if !ok {
+				return "", xerrors.New("GDB server is closed")
+			}
+			server.conn = conn
+		}
+
+		packet, ok := <-server.conn.packets
+		if !ok {
+			// GDB disconnected. If another GDB has already connected, it
+			// takes over. Otherwise, the device keeps running without it.
+			server.detach()
+			select {
+			case conn, ok := <-server.connections:
+				if ok {
+					server.conn = conn
+					continue
+				}
+			default:
+			}
+			return "continue", nil
+		}
+
+		reply, command := server.handlePacket(packet)
+		if command != "" {
+			server.mutex.Lock()
+			server.running = true
+			server.mutex.Unlock()
+			server.resumed = command != "detach"
+			if command == "detach" {
+				return "continue", nil
+			}
+			return command, nil
+		}
+		server.conn.send(reply)
+	}
+}
+
+// Event sends a stop reply to GDB when the device stops. Other events are
+// ignored.
+func (server *GDBServer) Event(event DebugEvent) {
+	stop, ok := event.(StopEvent)
+	if !ok {
+		return
+	}
+
+	server.mutex.Lock()
+	server.running = false
+	server.lastSignal = gdbSignalTrap
+	if server.interrupted {
+		server.lastSignal = gdbSignalInterrupt
+		server.interrupted = false
+	}
+	server.mutex.Unlock()
+
+	server.lastStop = stop
+	if server.resumed && server.conn != nil {
+		server.conn.send(server.stopReply())
+	}
+	server.resumed = false
+}
+
+// interrupt stops the device if it's running.
+func (server *GDBServer) interrupt() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.running && server.db != nil {
+		server.interrupted = true
+		server.db.requestStop("Interrupted by GDB")
+	}
+}
+
+// detach removes GDB's breakpoints and forgets about the connection.
+func (server *GDBServer) detach() {
+	for key, id := range server.breakpoints {
+		server.db.removeBreakpoint(id)
+		delete(server.breakpoints, key)
+	}
+
+	server.conn.conn.Close()
+	server.disconnected(server.conn)
+	server.conn = nil
+	server.resumed = false
+}
+
+// disconnected is called when a connection to GDB ends, allowing another GDB
+// to connect.
+func (server *GDBServer) disconnected(conn *gdbConnection) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.active == conn.conn {
+		server.active = nil
+	}
+}
+
+// stopReply creates the packet that tells GDB why the device stopped.
+func (server *GDBServer) stopReply() string {
+	reply := fmt.Sprintf("T%02x", server.lastSignal)
+
+	if bp := server.lastStop.Breakpoint; bp != nil {
+		switch bp.Kind {
+		case BreakOnWrite:
+			reply += fmt.Sprintf("watch:%x;", server.lastStop.Addr)
+		case BreakOnRead:
+			reply += fmt.Sprintf("rwatch:%x;", server.lastStop.Addr)
+		case BreakOnAccess:
+			reply += fmt.Sprintf("awatch:%x;", server.lastStop.Addr)
+		}
+	}
+
+	return reply
+}
+
+// handlePacket handles a packet from GDB. It returns the reply to send, or a
+// debugger command if the packet resumes the device. Resuming packets are
+// replied to when the device stops again.
+func (server *GDBServer) handlePacket(packet string) (reply string, command string) {
+	if packet == "" {
+		return "", ""
+	}
+	state := server.db.state
+
+	switch packet[0] {
+	case '?':
+		return server.stopReply(), ""
+	case 'g':
+		var data []uint8
+		for i := 0; i < gdbRegisterCount; i++ {
+			val := server.readRegister(i)
+			data = append(data, uint8(val), uint8(val>>8))
+		}
+		return hex.EncodeToString(data), ""
+	case 'G':
+		data, err := hex.DecodeString(packet[1:])
+		if err != nil || len(data) < gdbRegisterCount*2 {
+			return "E01", ""
+		}
+		for i := 0; i < gdbRegisterCount; i++ {
+			server.writeRegister(i, uint16(data[i*2])|uint16(data[i*2+1])<<8)
+		}
+		return "OK", ""
+	case 'p':
+		reg, err := strconv.ParseUint(packet[1:], 16, 32)
+		if err != nil {
+			return "E01", ""
+		}
+		if reg >= gdbRegisterCount {
+			// GDB's Z80 layout has registers that the Game Boy doesn't
+			return "xxxx", ""
+		}
+		val := server.readRegister(int(reg))
+		return hex.EncodeToString([]uint8{uint8(val), uint8(val >> 8)}), ""
+	case 'P':
+		fields := strings.SplitN(packet[1:], "=", 2)
+		if len(fields) != 2 {
+			return "E01", ""
+		}
+		reg, err := strconv.ParseUint(fields[0], 16, 32)
+		data, err2 := hex.DecodeString(fields[1])
+		if err != nil || err2 != nil || len(data) != 2 || reg >= gdbRegisterCount {
+			return "E01", ""
+		}
+		server.writeRegister(int(reg), uint16(data[0])|uint16(data[1])<<8)
+		return "OK", ""
+	case 'm':
+		addr, length, _, err := parseGDBMemoryArgs(packet[1:])
+		if err != nil {
+			return "E01", ""
+		}
+		data := make([]uint8, length)
+		for i := range data {
+			data[i] = state.mmu.peek(uint16(addr + i))
+		}
+		return hex.EncodeToString(data), ""
+	case 'M', 'X':
+		addr, length, payload, err := parseGDBMemoryArgs(packet[1:])
+		if err != nil {
+			return "E01", ""
+		}
+		data := []uint8(payload)
+		if packet[0] == 'M' {
+			data, err = hex.DecodeString(payload)
+			if err != nil {
+				return "E01", ""
+			}
+		}
+		if len(data) != length {
+			return "E01", ""
+		}
+		for i, val := range data {
+			state.mmu.poke(uint16(addr+i), val)
+		}
+		return "OK", ""
+	case 'Z', 'z':
+		return server.handleBreakpointPacket(packet), ""
+	case 'c', 's', 'C', 'S':
+		// The signal given to C and S is ignored
+		args := packet[1:]
+		if packet[0] == 'C' || packet[0] == 'S' {
+			args = ""
+			if i := strings.Index(packet, ";"); i != -1 {
+				args = packet[i+1:]
+			}
+		}
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				return "E01", ""
+			}
+			regs := state.registers()
+			regs.PC = uint16(addr)
+			state.setRegisters(regs)
+		}
+		if packet[0] == 'c' || packet[0] == 'C' {
+			return "", "continue"
+		}
+		return "", "step"
+	case 'D':
+		server.conn.send("OK")
+		server.detach()
+		return "", "detach"
+	case 'k':
+		server.detach()
+		return "", "detach"
+	case 'H', 'T':
+		// There's only one thread
+		return "OK", ""
+	case 'v':
+		return server.handleVPacket(packet)
+	case 'q', 'Q':
+		return server.handleQueryPacket(packet), ""
+	default:
+		// An empty reply tells GDB that the packet isn't supported
+		return "", ""
+	}
+}
+
+// handleVPacket handles the multi-letter packets that start with "v".
+func (server *GDBServer) handleVPacket(packet string) (reply string, command string) {
+	switch {
+	case packet == "vCont?":
+		return "vCont;c;C;s;S", ""
+	case strings.HasPrefix(packet, "vCont;"):
+		// Only the first action matters, since there's only one thread
+		action := strings.SplitN(packet[len("vCont;"):], ";", 2)[0]
+		if action == "" {
+			return "E01", ""
+		}
+		switch action[0] {
+		case 'c', 'C':
+			return "", "continue"
+		case 's', 'S':
+			return "", "step"
+		default:
+			return "E01", ""
+		}
+	default:
+		return "", ""
+	}
+}
+
+// handleQueryPacket handles general query and set packets.
+func (server *GDBServer) handleQueryPacket(packet string) string {
+	name := packet
+	if i := strings.IndexAny(packet, ":,"); i != -1 {
+		name = packet[:i]
+	}
+
+	switch name {
+	case "qSupported":
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+",
+			gdbMaxPacketSize)
+	case "QStartNoAckMode":
+		// The OK is still acknowledged, so no-ack mode starts after it's sent
+		server.conn.send("OK")
+		atomic.StoreInt32(&server.conn.noAck, 1)
+		return ""
+	case "qAttached":
+		return "1"
+	case "qC":
+		return "QC1"
+	case "qfThreadInfo":
+		return "m1"
+	case "qsThreadInfo":
+		return "l"
+	case "qXfer":
+		// The packet looks like qXfer:features:read:target.xml:offset,length
+		fields := strings.Split(packet, ":")
+		if len(fields) != 5 || fields[1] != "features" || fields[2] != "read" {
+			return ""
+		}
+		if fields[3] != "target.xml" {
+			return "E00"
+		}
+		offset, length, _, err := parseGDBMemoryArgs(fields[4])
+		if err != nil {
+			return "E01"
+		}
+		if offset >= len(gdbTargetXML) {
+			return "l"
+		}
+		chunk := gdbTargetXML[offset:minInt(offset+length, len(gdbTargetXML))]
+		if offset+len(chunk) == len(gdbTargetXML) {
+			return "l" + chunk
+		}
+		return "m" + chunk
+	default:
+		return ""
+	}
+}
+
+// handleBreakpointPacket handles the Z and z packets, which insert and remove
+// breakpoints and watchpoints.
+func (server *GDBServer) handleBreakpointPacket(packet string) string {
+	// The packet looks like Z0,addr,kind, where kind is the number of bytes
+	// for watchpoints
+	key := packet[1:]
+	fields := strings.Split(key, ",")
+	if len(fields) < 3 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(fields[1], 16, 16)
+	length, err2 := strconv.ParseUint(strings.SplitN(fields[2], ";", 2)[0], 16, 16)
+	if err != nil || err2 != nil {
+		return "E01"
+	}
+
+	var kind BreakpointKind
+	switch fields[0] {
+	case "0", "1":
+		kind = BreakOnExecute
+		length = 1
+	case "2":
+		kind = BreakOnWrite
+	case "3":
+		kind = BreakOnRead
+	case "4":
+		kind = BreakOnAccess
+	default:
+		return ""
+	}
+
+	if packet[0] == 'z' {
+		if id, ok := server.breakpoints[key]; ok {
+			server.db.removeBreakpoint(id)
+			delete(server.breakpoints, key)
+		}
+		return "OK"
+	}
+
+	if _, ok := server.breakpoints[key]; ok {
+		// GDB may insert the same breakpoint more than once
+		return "OK"
+	}
+	end := addr
+	if length > 1 {
+		end = uint64(minInt(int(addr+length-1), 0xFFFF))
+	}
+	id, err := server.db.addBreakpoint(Breakpoint{
+		Kind:  kind,
+		Start: uint16(addr),
+		End:   uint16(end),
+		Bank:  AnyBank,
+	})
+	if err != nil {
+		return "E01"
+	}
+	server.breakpoints[key] = id
+	return "OK"
+}
+
+// readRegister reads one of the registers reported to GDB.
+func (server *GDBServer) readRegister(reg int) uint16 {
+	state := server.db.state
+	switch reg {
+	case 0:
+		return state.regAF.get()
+	case 1:
+		return state.regBC.get()
+	case 2:
+		return state.regDE.get()
+	case 3:
+		return state.regHL.get()
+	case 4:
+		return state.regSP.get()
+	case 5:
+		return state.regPC.get()
+	default:
+		panic(fmt.Sprintf("invalid GDB register %v", reg))
+	}
+}
+
+// writeRegister writes to one of the registers reported to GDB.
+func (server *GDBServer) writeRegister(reg int, val uint16) {
+	regs := server.db.state.registers()
+	switch reg {
+	case 0:
+		regs.A, regs.F = uint8(val>>8), uint8(val)
+	case 1:
+		regs.B, regs.C = uint8(val>>8), uint8(val)
+	case 2:
+		regs.D, regs.E = uint8(val>>8), uint8(val)
+	case 3:
+		regs.H, regs.L = uint8(val>>8), uint8(val)
+	case 4:
+		regs.SP = val
+	case 5:
+		regs.PC = val
+	default:
+		panic(fmt.Sprintf("invalid GDB register %v", reg))
+	}
+	server.db.state.setRegisters(regs)
+}
+
+// parseGDBMemoryArgs parses arguments in the form "addr,length" or
+// "addr,length:data", as used by memory packets. Addresses outside of the
+// Game Boy's address space are rejected, and lengths are cut off at the end
+// of it.
+func parseGDBMemoryArgs(args string) (addr, length int, data string, err error) {
+	if i := strings.Index(args, ":"); i != -1 {
+		args, data = args[:i], args[i+1:]
+	}
+
+	fields := strings.Split(args, ",")
+	if len(fields) != 2 {
+		return 0, 0, "", xerrors.Errorf("invalid memory arguments %q", args)
+	}
+	addrVal, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return 0, 0, "", xerrors.Errorf("invalid address: %w", err)
+	}
+	lengthVal, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return 0, 0, "", xerrors.Errorf("invalid length: %w", err)
+	}
+	if addrVal > 0xFFFF {
+		return 0, 0, "", xerrors.Errorf("address %#x is out of range", addrVal)
+	}
+
+	addr = int(addrVal)
+	length = minInt(int(lengthVal), 0x10000-addr)
+	return addr, length, data, nil
+}
+
+// gdbConnection is a connection to GDB.
+type gdbConnection struct {
+	conn net.Conn
+	// packets receives packets from GDB. It's closed when the connection
+	// ends.
+	packets chan string
+	// noAck is nonzero once GDB has turned off acknowledgements. It's
+	// accessed atomically.
+	noAck int32
+}
+
+// readLoop reads packets from GDB until the connection ends.
+func (conn *gdbConnection) readLoop(server *GDBServer) {
+	defer server.disconnected(conn)
+	defer close(conn.packets)
+	reader := bufio.NewReader(conn.conn)
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch b {
+		case gdbInterrupt:
+			server.interrupt()
+		case '$':
+			packet, ok, err := readGDBPacket(reader)
+			if err != nil {
+				return
+			}
+			if atomic.LoadInt32(&conn.noAck) == 0 {
+				ack := "+"
+				if !ok {
+					ack = "-"
+				}
+				if _, err := conn.conn.Write([]uint8(ack)); err != nil {
+					return
+				}
+			}
+			if ok {
+				conn.packets <- packet
+			}
+		default:
+			// Acknowledgements from GDB and anything else between packets
+			// is ignored
+		}
+	}
+}
+
+// readGDBPacket reads the rest of a packet after its "$", returning false if
+// the checksum doesn't match.
+func readGDBPacket(reader *bufio.Reader) (packet string, ok bool, err error) {
+	var data []uint8
+	var sum uint8
+	escaped := false
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		if b == '#' {
+			break
+		}
+		sum += b
+
+		switch {
+		case escaped:
+			data = append(data, b^0x20)
+			escaped = false
+		case b == '}':
+			escaped = true
+		default:
+			data = append(data, b)
+		}
+	}
+
+	var checksum [2]uint8
+	for i := range checksum {
+		checksum[i], err = reader.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+	}
+	expected, err := strconv.ParseUint(string(checksum[:]), 16, 8)
+	if err != nil || uint8(expected) != sum {
+		return "", false, nil
+	}
+
+	return string(data), true, nil
+}
+
+// send sends a packet to GDB. Errors are ignored, since a broken connection
+// is noticed by the read loop.
+func (conn *gdbConnection) send(packet string) {
+	var data []uint8
+	var sum uint8
+
+	data = append(data, '$')
+	for _, b := range []uint8(packet) {
+		switch b {
+		case '$', '#', '}', '*':
+			// These characters have special meanings, so they're escaped
+			data = append(data, '}', b^0x20)
+			sum += '}' + (b ^ 0x20)
+		default:
+			data = append(data, b)
+			sum += b
+		}
+	}
+	data = append(data, []uint8(fmt.Sprintf("#%02x", sum))...)
+
+	conn.conn.Write(data)
+}
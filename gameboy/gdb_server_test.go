@@ -0,0 +1,186 @@
+package gameboy
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gdbTestProgram is a small program for testing the GDB server. It calls a
+// function, writes to 0xC000, then enables the VBlank interrupt and loops
+// forever.
+var gdbTestProgram = map[uint16][]uint8{
+	0x0040: {0xD9},                   // reti
+	0x0100: {0x00, 0xC3, 0x50, 0x01}, // nop; jp $0150
+	0x0150: {
+		0x31, 0xFE, 0xDF, // ld sp, $DFFE
+		0xCD, 0x80, 0x01, // call $0180
+		0x3E, 0x05, // ld a, $05
+		0xEA, 0x00, 0xC0, // ld [$C000], a
+		0x3E, 0x01, // ld a, $01
+		0xE0, 0xFF, // ldh [rIE], a
+		0xFB,       // ei
+		0x18, 0xFE, // jr @
+	},
+	0x0180: {0x06, 0x01, 0xC9}, // ld b, $01; ret
+}
+
+// gdbTestClient is a scripted GDB client.
+type gdbTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// send sends a packet.
+func (client *gdbTestClient) send(packet string) {
+	var sum uint8
+	for _, b := range []uint8(packet) {
+		sum += b
+	}
+	fmt.Fprintf(client.conn, "$%v#%02x", packet, sum)
+}
+
+// receive reads a packet, skipping over acknowledgements.
+func (client *gdbTestClient) receive() string {
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for {
+		b, err := client.reader.ReadByte()
+		if err != nil {
+			client.t.Fatalf("reading reply: %v", err)
+		}
+		if b != '$' {
+			continue
+		}
+
+		packet, ok, err := readGDBPacket(client.reader)
+		if err != nil {
+			client.t.Fatalf("reading reply: %v", err)
+		}
+		if !ok {
+			client.t.Fatalf("reply %q has a bad checksum", packet)
+		}
+		client.conn.Write([]uint8("+"))
+		return packet
+	}
+}
+
+// expect sends a packet and checks the reply.
+func (client *gdbTestClient) expect(packet, reply string) {
+	client.t.Helper()
+
+	client.send(packet)
+	if actual := client.receive(); actual != reply {
+		client.t.Fatalf("sent %q, expected %q but got %q", packet, reply, actual)
+	}
+}
+
+func TestGDBServer(t *testing.T) {
+	rom := make([]uint8, 0x8000)
+	for addr, code := range gdbTestProgram {
+		copy(rom[addr:], code)
+	}
+
+	server, err := ListenGDB("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("starting GDB server: %v", err)
+	}
+	defer server.Close()
+
+	device, err := NewDevice(
+		nil,
+		rom,
+		&testROMVideoDriver{},
+		&noopInputDriver{},
+		&testROMSaveGameDriver{},
+		DebugConfiguration{Debugging: true, Driver: server},
+		WithoutBootROM(),
+		WithModel(ModelDMG),
+		WithOutput(ioutil.Discard))
+	if err != nil {
+		t.Fatalf("creating device: %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := device.RunFrame(); err != nil {
+				t.Errorf("running: %v", err)
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(done)
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("connecting to GDB server: %v", err)
+	}
+	defer conn.Close()
+	client := &gdbTestClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	client.send("qSupported:swbreak+")
+	if reply := client.receive(); !strings.Contains(reply, "qXfer:features:read+") {
+		t.Fatalf("unexpected qSupported reply %q", reply)
+	}
+	client.send("qXfer:features:read:target.xml:0,ffff")
+	if reply := client.receive(); !strings.HasPrefix(reply, "l<?xml") {
+		t.Fatalf("unexpected target description %q", reply)
+	}
+
+	// The device waits at the entry point for GDB
+	client.expect("?", "T05")
+	client.expect("p5", "0001")
+
+	// Breakpoints and stepping
+	client.expect("Z0,180,1", "OK")
+	client.expect("c", "T05")
+	client.expect("p5", "8001")
+	client.expect("p4", "fcdf")
+	client.expect("s", "T05")
+	client.expect("p1", "1301")
+	client.expect("z0,180,1", "OK")
+
+	// Registers
+	client.expect("P1=3412", "OK")
+	client.expect("p1", "3412")
+	client.expect("P0=ff12", "OK")
+	client.expect("g", "f012"+"3412"+"d800"+"4d01"+"fcdf"+"8201")
+
+	// Memory
+	client.expect("Mc000,2:abcd", "OK")
+	client.expect("mc000,3", "abcd00")
+	client.expect("m180,3", "0601c9")
+
+	// Watchpoints
+	client.expect("Z2,c000,1", "OK")
+	client.expect("c", "T05watch:c000;")
+	client.expect("p5", "5b01")
+	client.expect("mc000,1", "05")
+	client.expect("z2,c000,1", "OK")
+
+	// Interrupting a running device
+	client.send("c")
+	time.Sleep(10 * time.Millisecond)
+	conn.Write([]uint8{gdbInterrupt})
+	if reply := client.receive(); reply != "T02" {
+		t.Fatalf("expected an interrupt stop reply, got %q", reply)
+	}
+
+	client.expect("D", "OK")
+}
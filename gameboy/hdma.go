@@ -0,0 +1,73 @@
+package gameboy
+
+// hdmaBlockSize is the number of bytes transferred by a VRAM DMA transfer at
+// a time. H-Blank DMA transfers move one block per H-Blank.
+const hdmaBlockSize = 0x10
+
+// onHDMA5Write triggers when the HDMA5 register is written to. This starts a
+// VRAM DMA transfer using the source and destination addresses in HDMA1-4, or
+// cancels an in-progress H-Blank DMA transfer.
+//
+// Reading from HDMA5 returns the number of blocks left in the transfer minus
+// one, with bit 7 set to 0 if the transfer is active. 0xFF is read once the
+// transfer is done.
+func (m *mmu) onHDMA5Write(addr uint16, val uint8) uint8 {
+	if m.hdmaActive && val&0x80 == 0 {
+		// Writing a 0 to bit 7 during an H-Blank DMA transfer cancels it
+		m.hdmaActive = false
+		return 0x80 | uint8(m.hdmaRemaining-1)
+	}
+
+	// The lower 4 bits of both addresses are ignored
+	m.hdmaSource = combine16(m.memory[hdma2Addr]&0xF0, m.memory[hdma1Addr])
+	dest := combine16(m.memory[hdma4Addr]&0xF0, m.memory[hdma3Addr])
+	// The destination is always in VRAM
+	m.hdmaDest = videoRAMAddr + (dest & 0x1FF0)
+	m.hdmaRemaining = int(val&0x7F) + 1
+
+	if val&0x80 == 0 {
+		// General purpose DMA. Everything is transferred at once.
+		// TODO: The CPU should be stalled while this transfer happens
+		for m.hdmaRemaining > 0 {
+			m.hdmaTransferBlock()
+		}
+		return 0xFF
+	}
+
+	// H-Blank DMA. The transfer will happen piece by piece.
+	m.hdmaActive = true
+	return val & 0x7F
+}
+
+// hblankHDMA is called by the video controller at the start of every H-Blank
+// period. It continues an H-Blank DMA transfer, if one is in progress.
+func (m *mmu) hblankHDMA() {
+	if !m.hdmaActive {
+		return
+	}
+
+	m.hdmaTransferBlock()
+
+	if m.hdmaRemaining == 0 {
+		m.hdmaActive = false
+	}
+}
+
+// hdmaTransferBlock copies one block of data to VRAM as part of a VRAM DMA
+// transfer and updates HDMA5 with the number of remaining blocks.
+func (m *mmu) hdmaTransferBlock() {
+	for i := 0; i < hdmaBlockSize; i++ {
+		vramOffset := (m.hdmaDest - videoRAMAddr) & 0x1FFF
+		m.videoRAM[m.currVideoRAMBank][vramOffset] = m.peek(m.hdmaSource)
+
+		m.hdmaSource++
+		m.hdmaDest++
+	}
+
+	m.hdmaRemaining--
+	if m.hdmaRemaining == 0 {
+		m.memory[hdma5Addr] = 0xFF
+	} else {
+		m.memory[hdma5Addr] = uint8(m.hdmaRemaining - 1)
+	}
+}
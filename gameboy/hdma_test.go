@@ -0,0 +1,125 @@
+package gameboy
+
+import (
+	"testing"
+)
+
+// newCGBTestDevice creates a CGB that runs a ROM that loops forever.
+func newCGBTestDevice(t *testing.T) *Device {
+	t.Helper()
+
+	return newTestCartridgeDevice(t, newTestCartridge(0x00, 0x00, 0x00), WithModel(ModelCGB))
+}
+
+// startHDMA fills WRAM at 0xC000 with a pattern and writes the HDMA registers
+// to copy it to 0x8100 in VRAM. The lower 4 bits of the addresses and the
+// upper 3 bits of the destination are set to make sure they're ignored.
+func startHDMA(device *Device, hdma5 uint8) {
+	for i := 0; i < 0x100; i++ {
+		device.WriteMemory(0xC000+uint16(i), uint8(i)^0xA5)
+	}
+
+	device.WriteMemory(hdma1Addr, 0xC0)
+	device.WriteMemory(hdma2Addr, 0x0F)
+	device.WriteMemory(hdma3Addr, 0xE1)
+	device.WriteMemory(hdma4Addr, 0x0F)
+	device.WriteMemory(hdma5Addr, hdma5)
+}
+
+// expectHDMABytes checks that the given number of bytes have been copied from
+// WRAM to VRAM bank 0 by a transfer started with startHDMA.
+func expectHDMABytes(t *testing.T, device *Device, count int) {
+	t.Helper()
+
+	vram := device.state.mmu.videoRAM[0]
+	for i := 0; i < 0x100; i++ {
+		expected := uint8(0)
+		if i < count {
+			expected = uint8(i) ^ 0xA5
+		}
+		if actual := vram[0x0100+i]; actual != expected {
+			t.Fatalf("expected %#x at %#04x after copying %#x bytes, got %#x",
+				expected, 0x8100+i, count, actual)
+		}
+	}
+}
+
+func TestGeneralPurposeDMA(t *testing.T) {
+	device := newCGBTestDevice(t)
+
+	// Two blocks, all at once
+	startHDMA(device, 0x01)
+
+	expectHDMABytes(t, device, 2*hdmaBlockSize)
+	if hdma5 := device.ReadMemory(hdma5Addr); hdma5 != 0xFF {
+		t.Fatalf("expected HDMA5 to read 0xff once the transfer is done, got %#x", hdma5)
+	}
+}
+
+func TestHBlankDMA(t *testing.T) {
+	device := newCGBTestDevice(t)
+	m := device.state.mmu
+
+	// Three blocks, one per H-Blank
+	startHDMA(device, 0x80|0x02)
+
+	// HDMA5 reads the number of blocks left minus one, with bit 7 cleared
+	// while the transfer is active
+	for _, expected := range []uint8{0x02, 0x01, 0x00} {
+		if hdma5 := device.ReadMemory(hdma5Addr); hdma5 != expected {
+			t.Fatalf("expected HDMA5 to read %#x, got %#x", expected, hdma5)
+		}
+		m.hblankHDMA()
+	}
+	if hdma5 := device.ReadMemory(hdma5Addr); hdma5 != 0xFF {
+		t.Fatalf("expected HDMA5 to read 0xff once the transfer is done, got %#x", hdma5)
+	}
+	m.hblankHDMA()
+	expectHDMABytes(t, device, 3*hdmaBlockSize)
+}
+
+func TestHBlankDMAVideoController(t *testing.T) {
+	device := newCGBTestDevice(t)
+
+	startHDMA(device, 0x80|0x0F)
+	if err := device.RunFrame(); err != nil {
+		t.Fatalf("running: %v", err)
+	}
+
+	// A frame has more than enough H-Blanks for the transfer
+	expectHDMABytes(t, device, 0x10*hdmaBlockSize)
+	if hdma5 := device.ReadMemory(hdma5Addr); hdma5 != 0xFF {
+		t.Fatalf("expected HDMA5 to read 0xff once the transfer is done, got %#x", hdma5)
+	}
+}
+
+func TestHBlankDMACancel(t *testing.T) {
+	device := newCGBTestDevice(t)
+	m := device.state.mmu
+
+	startHDMA(device, 0x80|0x03)
+	m.hblankHDMA()
+
+	// Three blocks are left. Bit 7 is set now that the transfer isn't
+	// active.
+	device.WriteMemory(hdma5Addr, 0x00)
+	if hdma5 := device.ReadMemory(hdma5Addr); hdma5 != 0x82 {
+		t.Fatalf("expected HDMA5 to read 0x82 after cancelling, got %#x", hdma5)
+	}
+
+	m.hblankHDMA()
+	expectHDMABytes(t, device, hdmaBlockSize)
+}
+
+func TestHDMAVideoRAMBank(t *testing.T) {
+	device := newCGBTestDevice(t)
+
+	// Transfers go to the VRAM bank that is mapped
+	device.WriteMemory(vbkAddr, 0x01)
+	startHDMA(device, 0x00)
+
+	expectHDMABytes(t, device, 0)
+	if actual := device.state.mmu.videoRAM[1][0x0100]; actual != 0xA5 {
+		t.Fatalf("expected the block to be copied to VRAM bank 1, got %#x", actual)
+	}
+}
@@ -0,0 +1,160 @@
+package gameboy
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// huc1 implements Hudson Soft's HuC1 memory bank controller. It works like a
+// simplified MBC1 with up to 64 16K ROM banks and 4 8K RAM banks, but the RAM
+// area can be switched to an infrared port instead. Games use this port to
+// trade data with other Game Boys.
+type huc1 struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	romBanks [][]uint8
+	// ramBanks contains all extra RAM banks, indexed by their bank number.
+	// These extra RAM banks are supplied by the cartridge.
+	ramBanks [][]uint8
+
+	// The currently selected ROM bank.
+	currROMBank uint8
+	// The currently selected RAM bank.
+	currRAMBank uint8
+	// True if the infrared port is mapped in place of RAM. The HuC1 has no
+	// RAM enable register, so RAM is accessible whenever this is false.
+	infraredMapped bool
+
+	infrared InfraredDriver
+}
+
+func newHuC1(
+	header romHeader,
+	cartridgeData []uint8,
+	infrared InfraredDriver) *huc1 {
+
+	var m huc1
+
+	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
+	m.ramBanks = makeRAMBanks(header.ramSizeType)
+
+	m.currROMBank = 1
+
+	m.infrared = infrared
+
+	return &m
+}
+
+// at provides access to the HuC1 banked ROM, banked RAM, and infrared port.
+func (m *huc1) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		return m.romBanks[0][addr]
+	case inBankedROMArea(addr):
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
+	case inBankedRAMArea(addr):
+		if m.infraredMapped {
+			return infraredPortValue(m.infrared)
+		}
+
+		if len(m.ramBanks) == 0 {
+			// The default value for unavailable RAM
+			return 0xFF
+		}
+		bank := int(m.currRAMBank) % len(m.ramBanks)
+		return m.ramBanks[bank][addr-bankedRAMAddr]
+	default:
+		panic(fmt.Sprintf("HuC1 is unable to handle reads to address %#x", addr))
+	}
+}
+
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *huc1) currentROMBank() int {
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return int(m.currROMBank) % len(m.romBanks)
+}
+
+// set can switch ROM and RAM banks, switch between RAM and the infrared port,
+// write to RAM, and turn the infrared LED on and off.
+func (m *huc1) set(addr uint16, val uint8) {
+	if addr < 0x2000 {
+		// RAM/IR select. 0x0E maps the infrared port, anything else maps RAM.
+		m.infraredMapped = val&0x0F == 0x0E
+	} else if addr < 0x4000 {
+		// ROM bank
+		bank := val & 0x3F
+		if bank == 0x00 {
+			// Bank 0 is not directly selectable, map to bank 1 instead
+			bank = 0x01
+		}
+		m.currROMBank = bank
+	} else if addr < 0x6000 {
+		// RAM bank
+		m.currRAMBank = val & 0x03
+	} else if addr < 0x8000 {
+		// This area of ROM doesn't do anything when written to
+	} else if inBankedRAMArea(addr) {
+		if m.infraredMapped {
+			m.infrared.SetLight(val&0x01 == 0x01)
+		} else if len(m.ramBanks) > 0 {
+			bank := int(m.currRAMBank) % len(m.ramBanks)
+			m.ramBanks[bank][addr-bankedRAMAddr] = val
+		}
+	} else {
+		panic(fmt.Sprintf("HuC1 is unable to handle writes to address %#x", addr))
+	}
+}
+
+// infraredPortValue returns the value read from a Hudson Soft MBC's infrared
+// port. Bit 0 is set if light is being received and the unused upper bits
+// read as 0xC0.
+func infraredPortValue(infrared InfraredDriver) uint8 {
+	if infrared.DetectsLight() {
+		return 0xC1
+	}
+	return 0xC0
+}
+
+func (m *huc1) dumpBatteryBackedRAM() []uint8 {
+	var dump []uint8
+
+	for _, bank := range m.ramBanks {
+		dump = append(dump, bank...)
+	}
+
+	return dump
+}
+
+func (m *huc1) loadBatteryBackedRAM(dump []uint8) error {
+	for bankNum, bank := range m.ramBanks {
+		start := len(bank) * bankNum
+		if start+len(bank) > len(dump) {
+			return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+		}
+		copy(bank, dump[start:start+len(bank)])
+	}
+
+	return nil
+}
+
+// saveState writes the HuC1's bank registers and RAM to a save state.
+func (m *huc1) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.currROMBank)
+	sw.write(m.currRAMBank)
+	sw.write(m.infraredMapped)
+}
+
+// loadState reads the HuC1's bank registers and RAM from a save state.
+func (m *huc1) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+
+	m.currROMBank = sr.readUint8()
+	m.currRAMBank = sr.readUint8()
+	m.infraredMapped = sr.readBool()
+}
@@ -0,0 +1,467 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// The values written to 0x0000-0x1FFF of a HuC3 to choose what is mapped to
+// 0xA000-0xBFFF.
+const (
+	// huc3ModeRAMReadOnly maps RAM, but doesn't allow it to be written to.
+	huc3ModeRAMReadOnly = 0x0
+	// huc3ModeRAM maps RAM for reading and writing.
+	huc3ModeRAM = 0xA
+	// huc3ModeRTCCommand maps the RTC command register for writing.
+	huc3ModeRTCCommand = 0xB
+	// huc3ModeRTCResponse maps the RTC response register for reading.
+	huc3ModeRTCResponse = 0xC
+	// huc3ModeRTCSemaphore maps the RTC semaphore, which games use to wait
+	// for commands to finish.
+	huc3ModeRTCSemaphore = 0xD
+	// huc3ModeInfrared maps the infrared port.
+	huc3ModeInfrared = 0xE
+)
+
+// HuC3 RTC commands. These are written to bits 4-6 of the command register,
+// with an argument in bits 0-3.
+const (
+	// huc3CommandRead reads the nibble at the access address into the
+	// response register, then increments the address.
+	huc3CommandRead = 0x1
+	// huc3CommandWrite writes the argument to the nibble at the access
+	// address, then increments the address.
+	huc3CommandWrite = 0x3
+	// huc3CommandAddrLow sets the lower nibble of the access address.
+	huc3CommandAddrLow = 0x4
+	// huc3CommandAddrHigh sets the upper nibble of the access address.
+	huc3CommandAddrHigh = 0x5
+	// huc3CommandExtended runs the extended command given as the argument.
+	huc3CommandExtended = 0x6
+)
+
+// HuC3 extended commands.
+const (
+	// huc3ExtendedLatch copies the current time to memory.
+	huc3ExtendedLatch = 0x0
+	// huc3ExtendedSetTime sets the current time from memory.
+	huc3ExtendedSetTime = 0x1
+	// huc3ExtendedStatus puts a status in the response register, which is
+	// always 1.
+	huc3ExtendedStatus = 0x2
+	// huc3ExtendedTone plays the tone generator when run twice in a row.
+	huc3ExtendedTone = 0xE
+)
+
+const (
+	// huc3MinutesAddr is where the minute of the day is latched to in RTC
+	// memory, as three nibbles starting with the least significant one.
+	huc3MinutesAddr = 0x00
+	// huc3DaysAddr is where the day counter is latched to in RTC memory, as
+	// three nibbles starting with the least significant one.
+	huc3DaysAddr = 0x03
+	// huc3ToneAddr is the location in RTC memory that selects which tone the
+	// tone generator plays.
+	huc3ToneAddr = 0x27
+
+	// huc3MemorySize is the number of nibbles of RTC memory.
+	huc3MemorySize = 0x100
+	// huc3MinutesPerDay is the number of values the minute counter has
+	// before it wraps around and increments the day counter.
+	huc3MinutesPerDay = 24 * 60
+	// huc3DaysMask masks the day counter to its 12 bits.
+	huc3DaysMask = 0xFFF
+
+	// huc3ToneMCycles is how long the tone generator plays for, in M-Cycles.
+	// This is about half a second.
+	huc3ToneMCycles = cpuClockRate / ticksPerMCycle / 2
+
+	// huc3RTCSaveSize is the size in bytes of the RTC data appended to
+	// battery-backed RAM in a game save. It's the RTC memory packed two
+	// nibbles to a byte, the little-endian 16-bit minute and day counters,
+	// then a little-endian 64-bit UNIX timestamp of when the save was made.
+	huc3RTCSaveSize = huc3MemorySize/2 + 2 + 2 + 8
+)
+
+// huc3ToneFrequencies are the frequencies in Hz of each tone the tone
+// generator can play. The real tones haven't been documented, so each one is
+// approximated as a beep at a different pitch.
+var huc3ToneFrequencies = [4]float64{2048, 1024, 4096, 1536}
+
+// huc3 implements Hudson Soft's HuC3 memory bank controller. Along with up to
+// 128 16K ROM banks and 4 8K RAM banks, it has a real time clock, an infrared
+// port and a speaker driven by a tone generator. Games talk to the RTC through
+// a command register and read results back nibble by nibble.
+//
+// The RTC keeps a minute of the day and a day counter, along with 256 nibbles
+// of memory that games can read and write. Games also store alarm settings in
+// this memory, but alarms are not emulated.
+type huc3 struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	romBanks [][]uint8
+	// ramBanks contains all extra RAM banks, indexed by their bank number.
+	// These extra RAM banks are supplied by the cartridge.
+	ramBanks [][]uint8
+
+	// The currently selected ROM bank.
+	currROMBank uint8
+	// The currently selected RAM bank.
+	currRAMBank uint8
+	// mode chooses what is mapped to 0xA000-0xBFFF.
+	mode uint8
+
+	clock Clock
+	// minutes is the minute of the day, from 0 to 1439.
+	minutes uint16
+	// days is a 12-bit day counter.
+	days uint16
+	// lastUpdate is the time that the counters were last brought up to date.
+	lastUpdate time.Time
+
+	// memory is the RTC's memory, one nibble per element.
+	memory [huc3MemorySize]uint8
+	// accessAddr is the address in RTC memory that reads and writes use.
+	accessAddr uint8
+	// command is the last command that was run.
+	command uint8
+	// result is the nibble returned by the last command.
+	result uint8
+	// lastExtended is the argument of the last extended command, or 0xFF if
+	// the last command wasn't an extended one.
+	lastExtended uint8
+
+	// speaker is the cartridge's speaker, which the tone generator plays
+	// through.
+	speaker *Speaker
+	// toneCycles is the number of M-Cycles left before the tone generator
+	// stops playing.
+	toneCycles int
+
+	infrared InfraredDriver
+
+	// out is where warnings are printed.
+	out io.Writer
+}
+
+func newHuC3(
+	header romHeader,
+	cartridgeData []uint8,
+	infrared InfraredDriver,
+	clock Clock,
+	out io.Writer) *huc3 {
+
+	var m huc3
+
+	m.out = out
+
+	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
+	m.ramBanks = makeRAMBanks(header.ramSizeType)
+
+	m.currROMBank = 1
+	m.lastExtended = 0xFF
+
+	m.clock = clock
+	m.lastUpdate = m.clock.Now()
+
+	m.speaker = &Speaker{}
+	m.infrared = infrared
+
+	return &m
+}
+
+// at provides access to the HuC3 banked ROM, banked RAM, RTC, and infrared
+// port.
+func (m *huc3) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		return m.romBanks[0][addr]
+	case inBankedROMArea(addr):
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
+	case inBankedRAMArea(addr):
+		switch m.mode {
+		case huc3ModeRAMReadOnly, huc3ModeRAM:
+			if len(m.ramBanks) == 0 {
+				// The default value for unavailable RAM
+				return 0xFF
+			}
+			bank := int(m.currRAMBank) % len(m.ramBanks)
+			return m.ramBanks[bank][addr-bankedRAMAddr]
+		case huc3ModeRTCResponse:
+			return 0x80 | m.command<<4 | m.result
+		case huc3ModeRTCSemaphore:
+			// Commands finish right away, so the RTC is always ready
+			return 0x01
+		case huc3ModeInfrared:
+			return infraredPortValue(m.infrared)
+		default:
+			return 0xFF
+		}
+	default:
+		panic(fmt.Sprintf("HuC3 is unable to handle reads to address %#x", addr))
+	}
+}
+
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *huc3) currentROMBank() int {
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return int(m.currROMBank) % len(m.romBanks)
+}
+
+// set can switch ROM and RAM banks, choose what is mapped to the RAM area,
+// write to RAM, run RTC commands, and turn the infrared LED on and off.
+func (m *huc3) set(addr uint16, val uint8) {
+	if addr < 0x2000 {
+		// Mode select
+		m.mode = val & 0x0F
+	} else if addr < 0x4000 {
+		// ROM bank
+		bank := val & 0x7F
+		if bank == 0x00 {
+			// Bank 0 is not directly selectable, map to bank 1 instead
+			bank = 0x01
+		}
+		m.currROMBank = bank
+	} else if addr < 0x6000 {
+		// RAM bank
+		m.currRAMBank = val & 0x03
+	} else if addr < 0x8000 {
+		// This area of ROM doesn't do anything when written to
+	} else if inBankedRAMArea(addr) {
+		switch m.mode {
+		case huc3ModeRAM:
+			if len(m.ramBanks) > 0 {
+				bank := int(m.currRAMBank) % len(m.ramBanks)
+				m.ramBanks[bank][addr-bankedRAMAddr] = val
+			}
+		case huc3ModeRTCCommand:
+			m.runCommand((val>>4)&0x7, val&0x0F)
+		case huc3ModeRTCSemaphore:
+			// Games write 0 here to ask the RTC to run the command, but
+			// commands are run as soon as they're written
+		case huc3ModeInfrared:
+			m.infrared.SetLight(val&0x01 == 0x01)
+		}
+	} else {
+		panic(fmt.Sprintf("HuC3 is unable to handle writes to address %#x", addr))
+	}
+}
+
+// runCommand runs an RTC command with the given argument.
+func (m *huc3) runCommand(command, arg uint8) {
+	m.command = command
+
+	extended := uint8(0xFF)
+
+	switch command {
+	case huc3CommandRead:
+		m.result = m.memory[m.accessAddr]
+		m.accessAddr++
+	case huc3CommandWrite:
+		m.memory[m.accessAddr] = arg
+		m.accessAddr++
+	case huc3CommandAddrLow:
+		m.accessAddr = (m.accessAddr & 0xF0) | arg
+	case huc3CommandAddrHigh:
+		m.accessAddr = (m.accessAddr & 0x0F) | arg<<4
+	case huc3CommandExtended:
+		extended = arg
+		switch arg {
+		case huc3ExtendedLatch:
+			m.update()
+			m.putCounter(huc3MinutesAddr, m.minutes)
+			m.putCounter(huc3DaysAddr, m.days)
+		case huc3ExtendedSetTime:
+			m.minutes = m.getCounter(huc3MinutesAddr) % huc3MinutesPerDay
+			m.days = m.getCounter(huc3DaysAddr)
+			m.lastUpdate = m.clock.Now()
+		case huc3ExtendedStatus:
+			m.result = 0x1
+		case huc3ExtendedTone:
+			if m.lastExtended == huc3ExtendedTone {
+				tone := m.memory[huc3ToneAddr] & 0x3
+				m.speaker.frequency = huc3ToneFrequencies[tone]
+				m.speaker.On = true
+				m.toneCycles = huc3ToneMCycles
+				// Running the command again should start a new tone
+				extended = 0xFF
+			}
+		default:
+			fmt.Fprintf(m.out, "Unknown HuC3 extended command %#x\n", arg)
+		}
+	default:
+		fmt.Fprintf(m.out, "Unknown HuC3 RTC command %#x\n", command)
+	}
+
+	m.lastExtended = extended
+}
+
+// putCounter writes a 12-bit counter to RTC memory, starting with the least
+// significant nibble.
+func (m *huc3) putCounter(addr uint8, val uint16) {
+	for i := uint8(0); i < 3; i++ {
+		m.memory[addr+i] = uint8(val>>(i*4)) & 0x0F
+	}
+}
+
+// getCounter reads a 12-bit counter from RTC memory, starting with the least
+// significant nibble.
+func (m *huc3) getCounter(addr uint8) uint16 {
+	var val uint16
+	for i := uint8(0); i < 3; i++ {
+		val |= uint16(m.memory[addr+i]&0x0F) << (i * 4)
+	}
+	return val
+}
+
+// update brings the minute and day counters up to date with the amount of
+// time that has passed since the last update.
+func (m *huc3) update() {
+	elapsed := int64(m.clock.Now().Sub(m.lastUpdate) / time.Minute)
+	if elapsed <= 0 {
+		return
+	}
+	// Only consume whole minutes so that partial minutes are counted in the
+	// next update
+	m.lastUpdate = m.lastUpdate.Add(time.Duration(elapsed) * time.Minute)
+
+	minutes := elapsed + int64(m.minutes)
+	m.minutes = uint16(minutes % huc3MinutesPerDay)
+	days := minutes/huc3MinutesPerDay + int64(m.days)
+	m.days = uint16(days & huc3DaysMask)
+}
+
+// tick stops the tone generator once its tone has finished.
+func (m *huc3) tick() {
+	if m.toneCycles == 0 {
+		return
+	}
+
+	m.toneCycles--
+	if m.toneCycles == 0 {
+		m.speaker.On = false
+	}
+}
+
+// dumpRTC returns the state of the RTC in a form that can be saved alongside
+// battery-backed RAM.
+func (m *huc3) dumpRTC() []uint8 {
+	m.update()
+
+	dump := make([]uint8, huc3RTCSaveSize)
+
+	for i := 0; i < huc3MemorySize; i += 2 {
+		dump[i/2] = m.memory[i] | m.memory[i+1]<<4
+	}
+	offset := huc3MemorySize / 2
+	binary.LittleEndian.PutUint16(dump[offset:], m.minutes)
+	binary.LittleEndian.PutUint16(dump[offset+2:], m.days)
+	binary.LittleEndian.PutUint64(dump[offset+4:], uint64(m.lastUpdate.Unix()))
+
+	return dump
+}
+
+// loadRTC restores the state of the RTC from the given save data. The clock is
+// advanced by the time that has passed since the save was made. Returns false
+// if the data is not a valid RTC save.
+func (m *huc3) loadRTC(dump []uint8) bool {
+	if len(dump) != huc3RTCSaveSize {
+		return false
+	}
+
+	for i := 0; i < huc3MemorySize; i += 2 {
+		m.memory[i] = dump[i/2] & 0x0F
+		m.memory[i+1] = dump[i/2] >> 4
+	}
+	offset := huc3MemorySize / 2
+	m.minutes = binary.LittleEndian.Uint16(dump[offset:]) % huc3MinutesPerDay
+	m.days = binary.LittleEndian.Uint16(dump[offset+2:]) & huc3DaysMask
+	timestamp := int64(binary.LittleEndian.Uint64(dump[offset+4:]))
+	m.lastUpdate = time.Unix(timestamp, 0)
+
+	// Catch up on the time that passed while the emulator was off
+	m.update()
+
+	return true
+}
+
+// dumpBatteryBackedRAM returns a dump of all RAM banks, followed by the state
+// of the RTC.
+func (m *huc3) dumpBatteryBackedRAM() []uint8 {
+	var dump []uint8
+
+	for _, bank := range m.ramBanks {
+		dump = append(dump, bank...)
+	}
+	dump = append(dump, m.dumpRTC()...)
+
+	return dump
+}
+
+// loadBatteryBackedRAM loads RAM banks and the state of the RTC from the given
+// dump.
+func (m *huc3) loadBatteryBackedRAM(dump []uint8) error {
+	ramSize := 0
+	for bankNum, bank := range m.ramBanks {
+		start := len(bank) * bankNum
+		if start+len(bank) > len(dump) {
+			return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+		}
+		copy(bank, dump[start:start+len(bank)])
+		ramSize += len(bank)
+	}
+
+	if len(dump) > ramSize {
+		if !m.loadRTC(dump[ramSize:]) {
+			fmt.Fprintln(m.out, "Warning: Ignoring RTC save data with an unexpected size")
+		}
+	}
+
+	return nil
+}
+
+// saveState writes the HuC3's bank registers, RAM, RTC, and tone generator to
+// a save state.
+func (m *huc3) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.currROMBank)
+	sw.write(m.currRAMBank)
+	sw.write(m.mode)
+	sw.writeBytes(m.dumpRTC())
+	sw.write(m.accessAddr)
+	sw.write(m.command)
+	sw.write(m.result)
+	sw.write(m.lastExtended)
+	sw.write(m.speaker.On)
+	sw.write(m.speaker.frequency)
+	sw.writeInt(m.toneCycles)
+}
+
+// loadState reads the HuC3's bank registers, RAM, RTC, and tone generator from
+// a save state.
+func (m *huc3) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+
+	m.currROMBank = sr.readUint8()
+	m.currRAMBank = sr.readUint8()
+	m.mode = sr.readUint8()
+	rtcData := sr.readBytes()
+	if sr.err == nil && !m.loadRTC(rtcData) {
+		sr.fail("invalid RTC data")
+	}
+	m.accessAddr = sr.readUint8()
+	m.command = sr.readUint8()
+	m.result = sr.readUint8()
+	m.lastExtended = sr.readUint8()
+	m.speaker.On = sr.readBool()
+	sr.read(&m.speaker.frequency)
+	m.toneCycles = sr.readInt()
+}
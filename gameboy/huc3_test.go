@@ -0,0 +1,154 @@
+package gameboy
+
+import (
+	"testing"
+	"time"
+)
+
+// huc3Command runs an RTC command on a HuC3 cartridge and returns the
+// resulting nibble.
+func huc3Command(device *Device, command, arg uint8) uint8 {
+	device.WriteMemory(0x0000, huc3ModeRTCCommand)
+	device.WriteMemory(0xA000, command<<4|arg)
+
+	device.WriteMemory(0x0000, huc3ModeRTCResponse)
+	return device.ReadMemory(0xA000) & 0x0F
+}
+
+// huc3SetAddr sets the address in RTC memory that reads and writes use.
+func huc3SetAddr(device *Device, addr uint8) {
+	huc3Command(device, huc3CommandAddrLow, addr&0x0F)
+	huc3Command(device, huc3CommandAddrHigh, addr>>4)
+}
+
+// huc3ReadCounter reads a 12-bit counter from RTC memory.
+func huc3ReadCounter(device *Device, addr uint8) uint16 {
+	huc3SetAddr(device, addr)
+	var val uint16
+	for i := uint(0); i < 3; i++ {
+		val |= uint16(huc3Command(device, huc3CommandRead, 0)) << (i * 4)
+	}
+	return val
+}
+
+// huc3WriteCounter writes a 12-bit counter to RTC memory.
+func huc3WriteCounter(device *Device, addr uint8, val uint16) {
+	huc3SetAddr(device, addr)
+	for i := uint(0); i < 3; i++ {
+		huc3Command(device, huc3CommandWrite, uint8(val>>(i*4))&0x0F)
+	}
+}
+
+func newHuC3TestDevice(t *testing.T, clock Clock) *Device {
+	t.Helper()
+
+	// HuC3 with 32K of RAM
+	return newTestCartridgeDevice(t, newTestCartridge(0xFE, 0x00, 0x03), WithClock(clock))
+}
+
+func TestHuC3Memory(t *testing.T) {
+	device := newHuC3TestDevice(t, newTestClock())
+
+	huc3SetAddr(device, 0x42)
+	for _, nibble := range []uint8{0x1, 0x2, 0xF} {
+		huc3Command(device, huc3CommandWrite, nibble)
+	}
+
+	// Reads and writes increment the address
+	huc3SetAddr(device, 0x42)
+	for _, expected := range []uint8{0x1, 0x2, 0xF} {
+		if actual := huc3Command(device, huc3CommandRead, 0); actual != expected {
+			t.Errorf("expected to read %#x, got %#x", expected, actual)
+		}
+	}
+
+	device.WriteMemory(0x0000, huc3ModeRTCResponse)
+	if val := device.ReadMemory(0xA000); val != 0x80|huc3CommandRead<<4|0xF {
+		t.Errorf("expected the response register to hold the last command, got %#x", val)
+	}
+
+	device.WriteMemory(0x0000, huc3ModeRTCSemaphore)
+	if val := device.ReadMemory(0xA000); val != 0x01 {
+		t.Errorf("expected the RTC to be ready, got %#x", val)
+	}
+
+	if status := huc3Command(device, huc3CommandExtended, huc3ExtendedStatus); status != 0x1 {
+		t.Errorf("expected a status of 1, got %#x", status)
+	}
+}
+
+func TestHuC3Clock(t *testing.T) {
+	clock := newTestClock()
+	device := newHuC3TestDevice(t, clock)
+
+	clock.advance(24*time.Hour + 2*time.Hour + 3*time.Minute + 30*time.Second)
+	huc3Command(device, huc3CommandExtended, huc3ExtendedLatch)
+	if minutes := huc3ReadCounter(device, huc3MinutesAddr); minutes != 2*60+3 {
+		t.Errorf("expected %v minutes, got %v", 2*60+3, minutes)
+	}
+	if days := huc3ReadCounter(device, huc3DaysAddr); days != 1 {
+		t.Errorf("expected 1 day, got %v", days)
+	}
+
+	// The latched time doesn't change until the next latch
+	clock.advance(time.Hour)
+	if minutes := huc3ReadCounter(device, huc3MinutesAddr); minutes != 2*60+3 {
+		t.Errorf("latched minutes changed to %v", minutes)
+	}
+
+	// Set the time to the last minute of the last day, then wrap around.
+	// The partial minute from before counts towards the next one.
+	huc3WriteCounter(device, huc3MinutesAddr, huc3MinutesPerDay-1)
+	huc3WriteCounter(device, huc3DaysAddr, huc3DaysMask)
+	huc3Command(device, huc3CommandExtended, huc3ExtendedSetTime)
+	clock.advance(time.Minute)
+	huc3Command(device, huc3CommandExtended, huc3ExtendedLatch)
+	if minutes := huc3ReadCounter(device, huc3MinutesAddr); minutes != 0 {
+		t.Errorf("expected the minutes to wrap around to 0, got %v", minutes)
+	}
+	if days := huc3ReadCounter(device, huc3DaysAddr); days != 0 {
+		t.Errorf("expected the days to wrap around to 0, got %v", days)
+	}
+}
+
+func TestHuC3Tone(t *testing.T) {
+	device := newHuC3TestDevice(t, newTestClock())
+	speaker := device.SoundController.Speaker
+	if speaker == nil {
+		t.Fatalf("expected the HuC3 to have a speaker")
+	}
+
+	huc3SetAddr(device, huc3ToneAddr)
+	huc3Command(device, huc3CommandWrite, 0x2)
+
+	// The tone only plays when the command is run twice in a row
+	huc3Command(device, huc3CommandExtended, huc3ExtendedTone)
+	if speaker.On {
+		t.Fatalf("speaker started after a single tone command")
+	}
+	huc3Command(device, huc3CommandExtended, huc3ExtendedTone)
+	if !speaker.On {
+		t.Fatalf("speaker didn't start after two tone commands")
+	}
+	if speaker.Frequency() != huc3ToneFrequencies[2] {
+		t.Errorf("expected tone 2 at %v Hz, got %v Hz", huc3ToneFrequencies[2], speaker.Frequency())
+	}
+
+	// The tone plays for about half a second, which is 30 frames
+	for i := 0; i < 25; i++ {
+		if err := device.RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+	}
+	if !speaker.On {
+		t.Fatalf("speaker stopped early")
+	}
+	for i := 0; i < 10; i++ {
+		if err := device.RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+	}
+	if speaker.On {
+		t.Fatalf("speaker is still playing after 35 frames")
+	}
+}
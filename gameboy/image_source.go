@@ -0,0 +1,89 @@
+package gameboy
+
+import (
+	"image"
+	imagecolor "image/color"
+)
+
+// ImageSequenceSource is an image source that supplies still images to the
+// Game Boy Camera instead of using a webcam. It cycles through a sequence of
+// images, showing each one for a number of captures before moving on to the
+// next.
+type ImageSequenceSource struct {
+	frames [][]uint8
+	// capturesPerImage is the number of captures that each image is shown
+	// for.
+	capturesPerImage int
+	// captureCount is the number of captures that have been made.
+	captureCount int
+}
+
+// NewStaticImageSource creates an image source that always supplies the given
+// image.
+func NewStaticImageSource(img image.Image) *ImageSequenceSource {
+	return NewImageSequenceSource([]image.Image{img}, 1)
+}
+
+// NewImageSequenceSource creates an image source that cycles through the given
+// images, showing each one for the given number of captures. Images are
+// scaled and cropped to fill the camera's resolution.
+func NewImageSequenceSource(images []image.Image, capturesPerImage int) *ImageSequenceSource {
+	if len(images) == 0 {
+		panic("an image sequence source needs at least one image")
+	}
+	if capturesPerImage < 1 {
+		capturesPerImage = 1
+	}
+
+	source := &ImageSequenceSource{capturesPerImage: capturesPerImage}
+	for _, img := range images {
+		source.frames = append(source.frames, toCameraFrame(img))
+	}
+
+	return source
+}
+
+// Capture returns the current image in the sequence.
+func (source *ImageSequenceSource) Capture() ([]uint8, error) {
+	index := (source.captureCount / source.capturesPerImage) % len(source.frames)
+	source.captureCount++
+
+	frame := make([]uint8, len(source.frames[index]))
+	copy(frame, source.frames[index])
+	return frame, nil
+}
+
+// toCameraFrame converts an image to grayscale at the camera's resolution.
+// The image is cropped around its center to match the camera's aspect ratio,
+// then scaled using nearest-neighbor sampling.
+func toCameraFrame(img image.Image) []uint8 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Crop to the camera's aspect ratio
+	cropWidth, cropHeight := width, height
+	if width*CameraHeight > height*CameraWidth {
+		cropWidth = height * CameraWidth / CameraHeight
+	} else {
+		cropHeight = width * CameraHeight / CameraWidth
+	}
+	cropX := bounds.Min.X + (width-cropWidth)/2
+	cropY := bounds.Min.Y + (height-cropHeight)/2
+
+	frame := make([]uint8, CameraWidth*CameraHeight)
+	if cropWidth == 0 || cropHeight == 0 {
+		return frame
+	}
+
+	for y := 0; y < CameraHeight; y++ {
+		for x := 0; x < CameraWidth; x++ {
+			srcX := cropX + x*cropWidth/CameraWidth
+			srcY := cropY + y*cropHeight/CameraHeight
+
+			gray := imagecolor.GrayModel.Convert(img.At(srcX, srcY)).(imagecolor.Gray)
+			frame[y*CameraWidth+x] = gray.Y
+		}
+	}
+
+	return frame
+}
@@ -0,0 +1,46 @@
+package gameboy
+
+import "sync"
+
+// InfraredLink points the infrared ports of two devices in the same process at
+// each other. Each end of the link is an InfraredDriver that should be given
+// to one of the devices. The devices may run in separate goroutines.
+type InfraredLink struct {
+	mutex sync.Mutex
+	// lights is true for each end whose LED is turned on.
+	lights [2]bool
+}
+
+// NewInfraredLink creates an infrared link with both LEDs turned off.
+func NewInfraredLink() *InfraredLink {
+	return &InfraredLink{}
+}
+
+// End returns the infrared driver for one end of the link. The index must be
+// 0 or 1.
+func (link *InfraredLink) End(index int) InfraredDriver {
+	if index != 0 && index != 1 {
+		panic("infrared link end index must be 0 or 1")
+	}
+	return &infraredLinkDriver{link: link, index: index}
+}
+
+// infraredLinkDriver is the infrared driver for one end of an infrared link.
+type infraredLinkDriver struct {
+	link  *InfraredLink
+	index int
+}
+
+func (driver *infraredLinkDriver) SetLight(on bool) {
+	driver.link.mutex.Lock()
+	defer driver.link.mutex.Unlock()
+
+	driver.link.lights[driver.index] = on
+}
+
+func (driver *infraredLinkDriver) DetectsLight() bool {
+	driver.link.mutex.Lock()
+	defer driver.link.mutex.Unlock()
+
+	return driver.link.lights[1-driver.index]
+}
@@ -0,0 +1,81 @@
+package gameboy
+
+// Registers contains the values of the CPU registers.
+type Registers struct {
+	A, F, B, C, D, E, H, L uint8
+	SP, PC                 uint16
+}
+
+// Registers returns the current values of the CPU registers.
+func (device *Device) Registers() Registers {
+	return device.state.registers()
+}
+
+// SetRegisters changes the values of the CPU registers. Changing PC while the
+// device is partway through an instruction has no effect until the
+// instruction is done, so this is best used while stopped in the debugger.
+func (device *Device) SetRegisters(regs Registers) {
+	device.state.setRegisters(regs)
+}
+
+// ReadMemory returns the value at the given address, as the CPU would see it.
+// Unlike reads made by the CPU, this doesn't trigger any debugger
+// breakpoints.
+func (device *Device) ReadMemory(addr uint16) uint8 {
+	return device.state.mmu.peek(addr)
+}
+
+// WriteMemory writes a value to the given address, as the CPU would. This
+// has the same side effects as a write from the CPU, like switching ROM banks
+// or starting a DMA transfer, but doesn't trigger any debugger breakpoints.
+func (device *Device) WriteMemory(addr uint16, val uint8) {
+	device.state.mmu.poke(addr, val)
+}
+
+// Backtrace returns the emulated call stack, innermost first. Calls and
+// interrupt dispatches are only tracked while debugging is enabled, so the
+// call stack is always empty otherwise.
+func (device *Device) Backtrace() []StackFrame {
+	if device.debugger == nil {
+		return nil
+	}
+	return device.debugger.backtrace()
+}
+
+// registers returns the current values of the CPU registers.
+func (state *State) registers() Registers {
+	return Registers{
+		A:  state.regA.get(),
+		F:  state.regF.get(),
+		B:  state.regB.get(),
+		C:  state.regC.get(),
+		D:  state.regD.get(),
+		E:  state.regE.get(),
+		H:  state.regH.get(),
+		L:  state.regL.get(),
+		SP: state.regSP.get(),
+		PC: state.regPC.get(),
+	}
+}
+
+// setRegisters changes the values of the CPU registers.
+func (state *State) setRegisters(regs Registers) {
+	state.regA.set(regs.A)
+	state.regF.set(regs.F)
+	state.regB.set(regs.B)
+	state.regC.set(regs.C)
+	state.regD.set(regs.D)
+	state.regE.set(regs.E)
+	state.regH.set(regs.H)
+	state.regL.set(regs.L)
+	state.regSP.set(regs.SP)
+	if regs.PC != state.regPC.get() {
+		state.regPC.set(regs.PC)
+		state.instructionStart = regs.PC
+	}
+}
+
+// Header returns information about the game from its cartridge header.
+func (device *Device) Header() ROMHeader {
+	return device.headerInfo
+}
@@ -5,6 +5,9 @@ package gameboy
 type interruptManager struct {
 	state  *State
 	timers *timers
+	// db is notified when interrupts are dispatched, or is nil if debugging
+	// is disabled.
+	db *debugger
 
 	// The value of the interrupt flag register, whose value indicates whether
 	// or not certain interrupts are scheduled to happen.
@@ -67,6 +70,9 @@ func (mgr *interruptManager) check() {
 			mgr.state.interruptsEnabled = false
 			// Clear the interrupt flag
 			mgr.interruptFlags = clearedInterruptFlags
+			if mgr.db != nil {
+				mgr.db.interruptHook(target)
+			}
 			// Push the current program counter to the stack for later use
 			mgr.state.pushToStack16(mgr.state.regPC.get())
 			mgr.state.regPC.set(target)
@@ -146,8 +152,8 @@ func (mgr *interruptManager) flagP10ToP13() {
 // interrupt handling action is taken here, but the unused bits in this
 // register are set to 1.
 func (mgr *interruptManager) onIFWrite(addr uint16, value uint8) uint8 {
-	// The first three bits of the register are unused
-	value |= 0xE3
+	// The upper three bits of the register are unused
+	value |= 0xE0
 
 	mgr.interruptFlags = value
 
@@ -161,3 +167,15 @@ func (mgr *interruptManager) onIEWrite(addr uint16, value uint8) uint8 {
 
 	return value
 }
+
+// saveState writes the interrupt registers to a save state.
+func (mgr *interruptManager) saveState(sw *stateWriter) {
+	sw.write(mgr.interruptFlags)
+	sw.write(mgr.interruptEnable)
+}
+
+// loadState reads the interrupt registers from a save state.
+func (mgr *interruptManager) loadState(sr *stateReader) {
+	mgr.interruptFlags = sr.readUint8()
+	mgr.interruptEnable = sr.readUint8()
+}
@@ -103,3 +103,14 @@ func buttonStatesToNibble(states ...bool) uint8 {
 
 	return output
 }
+
+// saveState writes the state of the joypad to a save state. Button states
+// come from the input driver and are not included.
+func (j *joypad) saveState(sw *stateWriter) {
+	sw.writeInt(j.lastEventProcess)
+}
+
+// loadState reads the state of the joypad from a save state.
+func (j *joypad) loadState(sr *stateReader) {
+	j.lastEventProcess = sr.readInt()
+}
@@ -0,0 +1,110 @@
+package gameboy
+
+import "sync"
+
+// LoopbackSerialDriver is a serial driver that connects the Game Boy's link
+// port to itself, so every byte sent is received right back. Transfers using
+// the external clock never finish, since there's no peer to provide a clock.
+type LoopbackSerialDriver struct{}
+
+// Transfer returns the byte being sent.
+func (driver *LoopbackSerialDriver) Transfer(out uint8) uint8 {
+	return out
+}
+
+// SetPending does nothing, since there's no peer to start a transfer.
+func (driver *LoopbackSerialDriver) SetPending(out uint8, pending bool) {
+}
+
+// Receive always reports that no transfer has been started.
+func (driver *LoopbackSerialDriver) Receive() (uint8, bool) {
+	return 0, false
+}
+
+// LinkCable connects two devices in the same process. Each end of the cable
+// is a SerialDriver that should be given to one of the devices. The devices
+// may run in separate goroutines.
+type LinkCable struct {
+	mutex sync.Mutex
+	ends  [2]linkCableEnd
+}
+
+// linkCableEnd is the state of the device on one end of a link cable.
+type linkCableEnd struct {
+	// pending is true if the device is waiting for the other device to start
+	// a transfer.
+	pending bool
+	// out is the byte the device will send when the other device starts a
+	// transfer.
+	out uint8
+	// received is true if the other device has started a transfer that the
+	// device hasn't received yet.
+	received bool
+	// in is the byte sent by the other device.
+	in uint8
+}
+
+// NewLinkCable creates a link cable with nothing plugged in to either end.
+func NewLinkCable() *LinkCable {
+	return &LinkCable{}
+}
+
+// End returns the serial driver for one end of the cable. The index must be 0
+// or 1.
+func (cable *LinkCable) End(index int) SerialDriver {
+	if index != 0 && index != 1 {
+		panic("link cable end index must be 0 or 1")
+	}
+	return &linkCableDriver{cable: cable, index: index}
+}
+
+// linkCableDriver is the serial driver for one end of a link cable.
+type linkCableDriver struct {
+	cable *LinkCable
+	index int
+}
+
+func (driver *linkCableDriver) Transfer(out uint8) uint8 {
+	driver.cable.mutex.Lock()
+	defer driver.cable.mutex.Unlock()
+
+	peer := &driver.cable.ends[1-driver.index]
+	if !peer.pending {
+		// Nothing is shifted in from a peer that isn't ready
+		return 0xFF
+	}
+
+	peer.pending = false
+	peer.received = true
+	peer.in = out
+
+	return peer.out
+}
+
+func (driver *linkCableDriver) SetPending(out uint8, pending bool) {
+	driver.cable.mutex.Lock()
+	defer driver.cable.mutex.Unlock()
+
+	end := &driver.cable.ends[driver.index]
+	if !pending {
+		// A transfer that the device stopped waiting for is discarded
+		end.received = false
+	}
+	// A transfer that was already received hasn't been finished by the device
+	// yet, so it can't accept another one
+	end.pending = pending && !end.received
+	end.out = out
+}
+
+func (driver *linkCableDriver) Receive() (uint8, bool) {
+	driver.cable.mutex.Lock()
+	defer driver.cable.mutex.Unlock()
+
+	end := &driver.cable.ends[driver.index]
+	if !end.received {
+		return 0, false
+	}
+
+	end.received = false
+	return end.in, true
+}
@@ -0,0 +1,76 @@
+package gameboy
+
+import "testing"
+
+// newSerialTransferDevice creates a device running a program that sends the
+// given byte over the link port with interrupts disabled, polls SC until the
+// transfer is done, and then copies the received byte into B.
+func newSerialTransferDevice(t *testing.T, driver SerialDriver, out uint8, internalClock bool) *Device {
+	t.Helper()
+
+	sc := uint8(0x80)
+	if internalClock {
+		sc |= 0x01
+	}
+
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	copy(rom[0x0100:], []uint8{
+		0xF3,      // di
+		0x3E, out, // ld a,out
+		0xE0, 0x01, // ldh (SB),a
+		0x3E, sc, // ld a,sc
+		0xE0, 0x02, // ldh (SC),a
+		0xF0, 0x02, // ldh a,(SC)
+		0xCB, 0x7F, // bit 7,a
+		0x20, 0xFA, // jr nz,-6
+		0xF0, 0x01, // ldh a,(SB)
+		0x47,       // ld b,a
+		0x18, 0xFE, // jr -2
+	})
+	return newTestCartridgeDevice(t, rom, WithSerial(driver))
+}
+
+func TestLinkCable(t *testing.T) {
+	tests := []struct {
+		name string
+		// master is the end of the cable that provides the clock.
+		master int
+	}{
+		{"first end is master", 0},
+		{"second end is master", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cable := NewLinkCable()
+			out := [2]uint8{0x12, 0x34}
+
+			var devices [2]*Device
+			for i := range devices {
+				devices[i] = newSerialTransferDevice(t, cable.End(i), out[i], i == test.master)
+			}
+			master := devices[test.master]
+			slave := devices[1-test.master]
+
+			// The slave has to be waiting before the master starts the
+			// transfer
+			for _, device := range []*Device{slave, master, slave} {
+				if err := device.RunFrame(); err != nil {
+					t.Fatalf("running: %v", err)
+				}
+			}
+
+			for i, device := range devices {
+				expected := out[1-i]
+				if actual := device.Registers().B; actual != expected {
+					t.Errorf("end %v received %#x, expected %#x", i, actual, expected)
+				}
+				// The interrupt is flagged even though interrupts are
+				// disabled, so that games can poll for it
+				if device.ReadMemory(0xFF0F)&0x08 == 0 {
+					t.Errorf("end %v didn't flag a serial interrupt", i)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package gameboy
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
 
 // mbc1 implements an MBC1 memory bank controller. An MBC1 can support up to
 // 125 16K ROM banks, up to 4 8K RAM banks, and potentially a battery backup.
@@ -29,14 +34,30 @@ type mbc1 struct {
 	// 0x4000-0x8000. If this value is 1, they are interpreted as the RAM bank
 	// selection.
 	bankSelectionMode uint8
+
+	// multicart is true if the MBC1 is wired up like an MBC1M, which is used
+	// by cartridges with multiple games on them. Bit 4 of bank register 1 is
+	// not connected, and bank register 2 selects one of four 256 KB games
+	// instead of providing bits 5 and 6 of the ROM bank.
+	multicart bool
+
+	// out is where warnings are printed.
+	out io.Writer
 }
 
-func newMBC1(header romHeader, cartridgeData []uint8) *mbc1 {
+func newMBC1(header romHeader, cartridgeData []uint8, out io.Writer) *mbc1 {
 	var m mbc1
 
+	m.out = out
+
 	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
 	m.ramBanks = makeRAMBanks(header.ramSizeType)
 
+	m.multicart = isMBC1Multicart(m.romBanks)
+	if m.multicart {
+		fmt.Fprintln(m.out, "Detected an MBC1 multicart")
+	}
+
 	// The default bank values
 	m.bankReg1 = 0x1
 	m.bankReg2 = 0x0
@@ -54,8 +75,8 @@ func (m *mbc1) at(addr uint16) uint8 {
 			return m.romBanks[0][addr]
 		case 1:
 			// The bank here is specified by the value written to
-			// 0x4000-0x5FFF, shifted 5 bits over.
-			bank := int(m.bankReg2 << 5)
+			// 0x4000-0x5FFF, shifted over to be the upper bits.
+			bank := int(m.bankReg2) << m.bankReg2Shift()
 			// If an out-of-bounds ROM bank is selected, the value will "wrap
 			// around"
 			bank %= len(m.romBanks)
@@ -64,13 +85,7 @@ func (m *mbc1) at(addr uint16) uint8 {
 			panic(fmt.Sprintf("invalid bank selection mode %#x", m.bankSelectionMode))
 		}
 	case inBankedROMArea(addr):
-		// The current bank is calculated by combining bank registers 1 and 2
-		bank := int(m.bankReg1 | (m.bankReg2 << 5))
-		// If an out-of-bounds ROM bank is selected, the value will "wrap
-		// around"
-		bank %= len(m.romBanks)
-
-		return m.romBanks[bank][addr-bankedROMAddr]
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
 	case inBankedRAMArea(addr):
 		if m.ramEnabled && len(m.ramBanks) > 0 {
 			switch m.bankSelectionMode {
@@ -97,6 +112,52 @@ func (m *mbc1) at(addr uint16) uint8 {
 	}
 }
 
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *mbc1) currentROMBank() int {
+	// The current bank is calculated by combining bank registers 1 and 2
+	bank := int(m.bankReg1&m.bankReg1Mask()) | int(m.bankReg2)<<m.bankReg2Shift()
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return bank % len(m.romBanks)
+}
+
+// bankReg1Mask returns the bits of bank register 1 that are connected to the
+// ROM.
+func (m *mbc1) bankReg1Mask() uint8 {
+	if m.multicart {
+		return 0x0F
+	}
+	return 0x1F
+}
+
+// bankReg2Shift returns how far bank register 2 is shifted over when it's used
+// as the upper bits of the ROM bank.
+func (m *mbc1) bankReg2Shift() uint {
+	if m.multicart {
+		return 4
+	}
+	return 5
+}
+
+// isMBC1Multicart returns true if the given ROM banks look like they're from
+// an MBC1M multicart. These cartridges are 1 MB and contain four 256 KB games,
+// each with its own header. The first game is usually a menu for picking one
+// of the others.
+func isMBC1Multicart(romBanks [][]uint8) bool {
+	if len(romBanks) != 64 {
+		return false
+	}
+
+	// Count the games by looking for headers at the start of each one
+	games := 0
+	for bank := 0; bank < len(romBanks); bank += 0x10 {
+		if hasNintendoLogo(romBanks[bank], 0) {
+			games++
+		}
+	}
+
+	return games > 1
+}
+
 // set can do many things with the MBC1.
 //
 // If the target address is within ROM, it will control some aspect of the MBC1
@@ -120,7 +181,8 @@ func (m *mbc1) set(addr uint16, val uint8) {
 
 		// This register cannot have 0x0 written to it. A write of 0x0 will be
 		// interpreted as 0x1. This means that banks 0x0, 0x20, 0x40, and 0x60
-		// are inaccessible
+		// are inaccessible. On a multicart, bit 4 isn't connected but is still
+		// checked, so a write of 0x10 selects the first bank of a game.
 		if m.bankReg1 == 0x00 {
 			m.bankReg1 = 0x01
 		}
@@ -174,14 +236,40 @@ func (m *mbc1) dumpBatteryBackedRAM() []uint8 {
 	return dump
 }
 
-func (m *mbc1) loadBatteryBackedRAM(dump []uint8) {
+func (m *mbc1) loadBatteryBackedRAM(dump []uint8) error {
 	for bankNum, bank := range m.ramBanks {
 		for i := range bank {
 			dumpIndex := len(bank)*bankNum + i
-			if i >= len(dump) {
-				panic(fmt.Sprintf("RAM dump is too small for this MBC: %v", i))
+			if dumpIndex >= len(dump) {
+				return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
 			}
 			bank[i] = dump[dumpIndex]
 		}
 	}
+
+	return nil
+}
+
+// saveState writes the MBC1's bank registers and RAM to a save state.
+func (m *mbc1) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.bankReg1)
+	sw.write(m.bankReg2)
+	sw.write(m.ramEnabled)
+	sw.write(m.bankSelectionMode)
+}
+
+// loadState reads the MBC1's bank registers and RAM from a save state.
+func (m *mbc1) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+
+	m.bankReg1 = sr.readUint8()
+	m.bankReg2 = sr.readUint8()
+	m.ramEnabled = sr.readBool()
+	m.bankSelectionMode = sr.readUint8()
 }
@@ -0,0 +1,52 @@
+package gameboy
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMBC1Banks(t *testing.T) {
+	tests := []struct {
+		name         string
+		gameHeaders  bool
+		mode         uint8
+		reg1, reg2   uint8
+		bank0, bank1 int
+	}{
+		{"MBC1", false, 0, 0x03, 0x01, 0, 35},
+		{"MBC1 bank 0 is bank 1", false, 0, 0x00, 0x01, 0, 33},
+		{"MBC1 mode 1", false, 1, 0x03, 0x01, 32, 35},
+		{"MBC1M", true, 0, 0x03, 0x01, 0, 19},
+		{"MBC1M bit 4 is ignored", true, 0, 0x13, 0x02, 0, 35},
+		{"MBC1M first bank of a game", true, 0, 0x10, 0x03, 0, 48},
+		{"MBC1M mode 1", true, 1, 0x03, 0x03, 48, 51},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A 1 MB ROM, which is the size of an MBC1M multicart
+			rom := newBankNumberedROM(64)
+			if test.gameHeaders {
+				// Each 256 KB game has its own header
+				for bank := 0; bank < 64; bank += 0x10 {
+					copy(rom[bank*0x4000+nintendoLogoAddr:], nintendoLogo)
+				}
+			}
+			m := newMBC1(romHeader{romSizeType: 0x05}, rom, ioutil.Discard)
+			if m.multicart != test.gameHeaders {
+				t.Fatalf("expected multicart to be %v", test.gameHeaders)
+			}
+
+			m.set(0x6000, test.mode)
+			m.set(0x2000, test.reg1)
+			m.set(0x4000, test.reg2)
+
+			if bank := int(m.at(bankMarkerAddr)); bank != test.bank0 {
+				t.Errorf("expected bank %v at 0x0000, got %v", test.bank0, bank)
+			}
+			if bank := int(m.at(bankedROMAddr + bankMarkerAddr)); bank != test.bank1 {
+				t.Errorf("expected bank %v at 0x4000, got %v", test.bank1, bank)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package gameboy
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// mbc2RAMSize is the number of 4-bit values stored in the MBC2's built-in RAM.
+const mbc2RAMSize = 512
+
+// mbc2 implements the MBC2 memory bank controller. An MBC2 can support up to
+// 16 16K ROM banks. Instead of external RAM banks, it has 512x4 bits of RAM
+// built into the controller itself, which may be battery-backed.
+type mbc2 struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	romBanks [][]uint8
+	// ram is the MBC2's built-in RAM. Only the lower 4 bits of each value are
+	// used.
+	ram []uint8
+
+	// The currently selected ROM bank.
+	currROMBank uint8
+	// True if RAM turned on.
+	ramEnabled bool
+
+	// out is where warnings are printed.
+	out io.Writer
+}
+
+func newMBC2(header romHeader, cartridgeData []uint8, out io.Writer) *mbc2 {
+	var m mbc2
+
+	m.out = out
+
+	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
+	m.ram = make([]uint8, mbc2RAMSize)
+
+	m.currROMBank = 1
+
+	return &m
+}
+
+// at provides access to the MBC2 banked ROM and built-in RAM.
+func (m *mbc2) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		return m.romBanks[0][addr]
+	case inBankedROMArea(addr):
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
+	case inBankedRAMArea(addr):
+		if !m.ramEnabled {
+			// The default value for disabled RAM
+			return 0xFF
+		}
+		// Only 9 bits of the address are used, so the RAM is echoed
+		// throughout the whole banked RAM area. The upper 4 bits of each
+		// value are not connected and read as 1.
+		return m.ram[(addr-bankedRAMAddr)%mbc2RAMSize] | 0xF0
+	default:
+		panic(fmt.Sprintf("MBC2 is unable to handle reads to address %#x", addr))
+	}
+}
+
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *mbc2) currentROMBank() int {
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return int(m.currROMBank) % len(m.romBanks)
+}
+
+// set can do many things with the MBC2.
+//
+// Writes to 0x0000-0x3FFF control the MBC2. Bit 8 of the address decides
+// which register is written to. If it is 0, RAM is enabled or disabled. If it
+// is 1, the ROM bank is selected.
+//
+// If the target address is within the RAM bank area, the built-in RAM will be
+// written to.
+func (m *mbc2) set(addr uint16, val uint8) {
+	if addr < 0x4000 {
+		if addr&0x0100 == 0 {
+			// The RAM enable/disable register
+			lower, _ := split(val)
+			// 0x0A is the magic number to turn RAM on
+			m.ramEnabled = lower == 0x0A
+		} else {
+			// The ROM Bank Number register. Only the lower 4 bits are used.
+			bank := val & 0x0F
+			// A write of 0x0 will be interpreted as 0x1, making bank 0
+			// inaccessible from this area
+			if bank == 0x00 {
+				bank = 0x01
+			}
+			m.currROMBank = bank
+		}
+	} else if addr < 0x8000 {
+		// This area of ROM doesn't do anything when written to
+	} else if inBankedRAMArea(addr) {
+		if m.ramEnabled {
+			// Only the lower 4 bits can be stored
+			m.ram[(addr-bankedRAMAddr)%mbc2RAMSize] = val & 0x0F
+		} else {
+			fmt.Fprintf(m.out, "Attempt to write to banked RAM when RAM is disabled: At address %#x\n", addr)
+		}
+	} else {
+		panic(fmt.Sprintf("MBC2 is unable to handle writes to address %#x", addr))
+	}
+}
+
+func (m *mbc2) dumpBatteryBackedRAM() []uint8 {
+	dump := make([]uint8, len(m.ram))
+	copy(dump, m.ram)
+
+	return dump
+}
+
+func (m *mbc2) loadBatteryBackedRAM(dump []uint8) error {
+	if len(dump) < len(m.ram) {
+		return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+	}
+
+	for i := range m.ram {
+		m.ram[i] = dump[i] & 0x0F
+	}
+
+	return nil
+}
+
+// saveState writes the MBC2's registers and built-in RAM to a save state.
+func (m *mbc2) saveState(sw *stateWriter) {
+	sw.writeBytes(m.ram)
+
+	sw.write(m.currROMBank)
+	sw.write(m.ramEnabled)
+}
+
+// loadState reads the MBC2's registers and built-in RAM from a save state.
+func (m *mbc2) loadState(sr *stateReader) {
+	sr.readBytesInto(m.ram)
+
+	m.currROMBank = sr.readUint8()
+	m.ramEnabled = sr.readBool()
+}
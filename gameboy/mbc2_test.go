@@ -0,0 +1,127 @@
+package gameboy
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// newTestMBC2 creates an MBC2 with 16 numbered ROM banks.
+func newTestMBC2() *mbc2 {
+	return newMBC2(romHeader{romSizeType: 0x03}, newBankNumberedROM(16), ioutil.Discard)
+}
+
+func TestMBC2Registers(t *testing.T) {
+	tests := []struct {
+		name       string
+		addr       uint16
+		val        uint8
+		bank       int
+		ramEnabled bool
+	}{
+		// Bit 8 of the address selects the ROM bank register
+		{"ROM bank", 0x2100, 0x05, 5, false},
+		{"ROM bank at 0x0100", 0x0100, 0x03, 3, false},
+		{"ROM bank at 0x3FFF", 0x3FFF, 0x0F, 15, false},
+		{"bank 0 is bank 1", 0x2100, 0x00, 1, false},
+		{"upper bits are ignored", 0x2100, 0x1A, 10, false},
+		{"bank 0 with upper bits is bank 1", 0x2100, 0x10, 1, false},
+		// And otherwise the RAM enable register
+		{"RAM enable", 0x0000, 0x0A, 1, true},
+		{"RAM enable at 0x2000", 0x2000, 0x0A, 1, true},
+		{"RAM enable at 0x3EFF", 0x3EFF, 0x1A, 1, true},
+		{"RAM disable", 0x00FF, 0x0B, 1, false},
+		// The rest of ROM doesn't do anything
+		{"upper ROM", 0x4100, 0x05, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestMBC2()
+			m.set(test.addr, test.val)
+
+			if bank := int(m.at(bankedROMAddr + bankMarkerAddr)); bank != test.bank {
+				t.Errorf("expected bank %v at 0x4000, got %v", test.bank, bank)
+			}
+			if bank := m.currentROMBank(); bank != test.bank {
+				t.Errorf("expected the current bank to be %v, got %v", test.bank, bank)
+			}
+			if m.ramEnabled != test.ramEnabled {
+				t.Errorf("expected RAM enabled to be %v", test.ramEnabled)
+			}
+			if bank := int(m.at(bankMarkerAddr)); bank != 0 {
+				t.Errorf("expected bank 0 at 0x0000, got %v", bank)
+			}
+		})
+	}
+}
+
+func TestMBC2RAM(t *testing.T) {
+	m := newTestMBC2()
+
+	// RAM is disabled at first
+	m.set(0xA000, 0x05)
+	if val := m.at(0xA000); val != 0xFF {
+		t.Fatalf("expected disabled RAM to read 0xff, got %#x", val)
+	}
+
+	m.set(0x0000, 0x0A)
+	if val := m.at(0xA000); val != 0xF0 {
+		t.Fatalf("expected the write to disabled RAM to be ignored, got %#x", val)
+	}
+
+	// Only the lower 4 bits are stored, and the upper 4 read as 1
+	m.set(0xA000, 0xAB)
+	m.set(0xB1FF, 0x05)
+	if m.ram[0x000] != 0x0B || m.ram[0x1FF] != 0x05 {
+		t.Fatalf("expected 0x0b and 0x05 to be stored, got %#x and %#x", m.ram[0x000], m.ram[0x1FF])
+	}
+
+	// The 512 values are echoed throughout 0xA000-0xBFFF
+	for addr := 0xA000; addr < 0xC000; addr += mbc2RAMSize {
+		if val := m.at(uint16(addr)); val != 0xFB {
+			t.Errorf("expected 0xfb at %#04x, got %#x", addr, val)
+		}
+		if val := m.at(uint16(addr + 0x1FF)); val != 0xF5 {
+			t.Errorf("expected 0xf5 at %#04x, got %#x", addr+0x1FF, val)
+		}
+	}
+
+	m.set(0x0000, 0x00)
+	if val := m.at(0xA000); val != 0xFF {
+		t.Fatalf("expected disabled RAM to read 0xff, got %#x", val)
+	}
+}
+
+func TestMBC2BatteryBackedRAM(t *testing.T) {
+	m := newTestMBC2()
+
+	dump := make([]uint8, mbc2RAMSize)
+	for i := range dump {
+		dump[i] = uint8(i)
+	}
+	if err := m.loadBatteryBackedRAM(dump); err != nil {
+		t.Fatalf("loading RAM: %v", err)
+	}
+
+	// The upper 4 bits of a save aren't kept
+	m.set(0x0000, 0x0A)
+	if val := m.at(0xA0FF); val != 0xFF {
+		t.Errorf("expected 0xff at 0xA0FF, got %#x", val)
+	}
+	saved := m.dumpBatteryBackedRAM()
+	if len(saved) != mbc2RAMSize {
+		t.Fatalf("expected a %v byte save, got %v bytes", mbc2RAMSize, len(saved))
+	}
+	for i, val := range saved {
+		if val != uint8(i)&0x0F {
+			t.Fatalf("expected %#x at %#x in the save, got %#x", uint8(i)&0x0F, i, val)
+		}
+	}
+
+	err := m.loadBatteryBackedRAM(make([]uint8, mbc2RAMSize-1))
+	if !xerrors.Is(err, ErrSaveTooSmall) {
+		t.Fatalf("expected ErrSaveTooSmall, got %v", err)
+	}
+}
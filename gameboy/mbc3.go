@@ -1,6 +1,11 @@
 package gameboy
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
 
 // mbc3 implements the MBC3 memory bank controller. An MBC3 can support up to
 // 128 16K ROM banks, up to up to 8 8K RAM banks, potentially a real time
@@ -12,38 +17,47 @@ type mbc3 struct {
 	// These extra RAM banks are supplied by the cartridge.
 	ramBanks [][]uint8
 
-	// True if this MBC has a real time clock in it.
-	hasRTC bool
+	// The real time clock in this MBC, or nil if this MBC doesn't have one.
+	rtc *realTimeClock
 
 	// The currently selected ROM bank.
 	currROMBank uint8
-	// The currently selected RAM bank.
+	// The currently selected RAM bank. Values from 0x08 to 0x0C select an RTC
+	// register instead.
 	currRAMBank uint8
 	// True if RAM turned on.
 	ramAndRTCEnabled bool
-	// True if the RTC is "latched", meaning that its value should be frozen in
-	// memory.
-	rtcLatched bool
+	// The last value written to the Latch Clock Data register. The RTC is
+	// latched when a 0x00 and then a 0x01 are written to this register.
+	lastLatchWrite uint8
+
+	// out is where warnings are printed.
+	out io.Writer
 }
 
+// newMBC3 creates an MBC3. The cartridge has a real time clock driven by the
+// given clock, or no real time clock if the clock is nil.
 func newMBC3(
 	header romHeader,
 	cartridgeData []uint8,
-	hasRTC bool) *mbc3 {
+	clock Clock,
+	out io.Writer) *mbc3 {
 
 	var m mbc3
 
-	if hasRTC {
-		panic("MBC3 devices with an RTC are not supported")
-	}
+	m.out = out
 
-	m.hasRTC = hasRTC
+	if clock != nil {
+		m.rtc = newRealTimeClock(clock)
+	}
 
 	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
 	m.ramBanks = makeRAMBanks(header.ramSizeType)
 
 	m.currROMBank = 1
 	m.currRAMBank = 0
+	// Make sure a 0x01 write on its own doesn't latch the clock
+	m.lastLatchWrite = 0xFF
 
 	return &m
 }
@@ -54,18 +68,22 @@ func (m *mbc3) at(addr uint16) uint8 {
 	case inBank0ROMArea(addr):
 		return m.romBanks[0][addr]
 	case inBankedROMArea(addr):
-		bank := m.currROMBank
-		if bank == 0 {
-			// Bank 0 is not directly selectable, map to bank 1 instead
-			bank = 1
-		}
-		// If an out-of-bounds ROM bank is selected, the value will "wrap
-		// around"
-		bank %= uint8(len(m.romBanks))
-		return m.romBanks[bank][addr-bankedROMAddr]
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
 	case inBankedRAMArea(addr):
 		// Banked RAM or Real Time Clock register area
-		if m.ramAndRTCEnabled && len(m.ramBanks) > 0 {
+		if !m.ramAndRTCEnabled {
+			// The default value for disabled RAM
+			return 0xFF
+		}
+
+		if m.currRAMBank >= rtcSecondsReg {
+			if m.rtc != nil {
+				return m.rtc.at(m.currRAMBank)
+			}
+			return 0xFF
+		}
+
+		if len(m.ramBanks) > 0 {
 			bank := m.currRAMBank
 			// If an out-of-bounds RAM bank is selected, the value will "wrap
 			// around"
@@ -73,7 +91,7 @@ func (m *mbc3) at(addr uint16) uint8 {
 
 			return m.ramBanks[bank][addr-bankedRAMAddr]
 		} else {
-			// The default value for disabled or unavailable RAM
+			// The default value for unavailable RAM
 			return 0xFF
 		}
 	default:
@@ -81,6 +99,17 @@ func (m *mbc3) at(addr uint16) uint8 {
 	}
 }
 
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *mbc3) currentROMBank() int {
+	bank := int(m.currROMBank)
+	if bank == 0 {
+		// Bank 0 is not directly selectable, map to bank 1 instead
+		bank = 1
+	}
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return bank % len(m.romBanks)
+}
+
 // set can do many things with the MBC3.
 //
 // If the target address is within ROM, it will control some aspect of the MBC3
@@ -88,7 +117,7 @@ func (m *mbc3) at(addr uint16) uint8 {
 // itself and the given value.
 //
 // If the target address is within the RAM bank area, the selected RAM bank
-// will be written to.
+// or RTC register will be written to.
 func (m *mbc3) set(addr uint16, val uint8) {
 	if addr < 0x2000 {
 		// The RAM and RTC enable/disable area. Used to turn on and off access
@@ -118,26 +147,34 @@ func (m *mbc3) set(addr uint16, val uint8) {
 		// that corresponding RTC register to the RAM bank address space.
 		if val <= 0x07 {
 			m.currRAMBank = val
-		} else if val <= 0x0C && m.hasRTC {
-			panic("Attempt to control the RTC register, but RTC is not supported")
+		} else if val <= rtcDayHighReg && m.rtc != nil {
+			m.currRAMBank = val
 		} else {
 			panic(fmt.Sprintf("Unexpected value in RAM Bank/RTC Register Select %#x", val))
 		}
 	} else if addr < 0x8000 {
 		// Latch Clock Data Register
-		// Writing a 0x01 to this area will lock the RTC at its current value
-		// until a 0x00 is written. Note that the RTC itself continues to tick
-		// even while this latch is set. Only the in-memory value remains
-		// unchanged.
-		if m.hasRTC {
-			m.rtcLatched = val == 0x01
-			fmt.Println("Warning: Attempt to latch the RTC register, but RTC is not supported")
+		// Writing a 0x00 and then a 0x01 to this area will copy the current
+		// time into the RTC registers, where it stays until the clock is
+		// latched again. Note that the RTC itself continues to tick while
+		// latched. Only the in-memory value remains unchanged.
+		if m.rtc != nil && m.lastLatchWrite == 0x00 && val == 0x01 {
+			m.rtc.latch()
 		}
+		m.lastLatchWrite = val
 	} else if inBankedRAMArea(addr) {
-		if m.ramAndRTCEnabled {
-			m.ramBanks[m.currRAMBank][addr-bankedRAMAddr] = val
-		} else {
-			fmt.Printf("Attempt to write to banked RAM when RAM is disabled: At address %#x\n", addr)
+		if !m.ramAndRTCEnabled {
+			fmt.Fprintf(m.out, "Attempt to write to banked RAM when RAM is disabled: At address %#x\n", addr)
+			return
+		}
+
+		if m.currRAMBank >= rtcSecondsReg {
+			if m.rtc != nil {
+				m.rtc.set(m.currRAMBank, val)
+			}
+		} else if len(m.ramBanks) > 0 {
+			bank := m.currRAMBank % uint8(len(m.ramBanks))
+			m.ramBanks[bank][addr-bankedRAMAddr] = val
 		}
 	} else {
 		panic(fmt.Sprintf("The MBC3 should not have been notified of a write "+
@@ -145,6 +182,8 @@ func (m *mbc3) set(addr uint16, val uint8) {
 	}
 }
 
+// dumpBatteryBackedRAM returns a dump of all RAM banks, followed by the state
+// of the RTC if this MBC has one.
 func (m *mbc3) dumpBatteryBackedRAM() []uint8 {
 	var dump []uint8
 
@@ -154,17 +193,68 @@ func (m *mbc3) dumpBatteryBackedRAM() []uint8 {
 		}
 	}
 
+	if m.rtc != nil {
+		dump = append(dump, m.rtc.dump()...)
+	}
+
 	return dump
 }
 
-func (m *mbc3) loadBatteryBackedRAM(dump []uint8) {
+// loadBatteryBackedRAM loads RAM banks and, if present, the state of the RTC
+// from the given dump.
+func (m *mbc3) loadBatteryBackedRAM(dump []uint8) error {
+	ramSize := 0
 	for bankNum, bank := range m.ramBanks {
 		for i := range bank {
 			dumpIndex := len(bank)*bankNum + i
-			if i >= len(dump) {
-				panic(fmt.Sprintf("RAM dump is too small for this MBC: %v", i))
+			if dumpIndex >= len(dump) {
+				return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
 			}
 			bank[i] = dump[dumpIndex]
 		}
+		ramSize += len(bank)
+	}
+
+	if m.rtc != nil && len(dump) > ramSize {
+		if !m.rtc.load(dump[ramSize:]) {
+			fmt.Fprintln(m.out, "Warning: Ignoring RTC save data with an unexpected size")
+		}
+	}
+
+	return nil
+}
+
+// saveState writes the MBC3's bank registers, RAM, and RTC to a save state.
+func (m *mbc3) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.currROMBank)
+	sw.write(m.currRAMBank)
+	sw.write(m.ramAndRTCEnabled)
+	sw.write(m.lastLatchWrite)
+
+	if m.rtc != nil {
+		sw.writeBytes(m.rtc.dump())
+	}
+}
+
+// loadState reads the MBC3's bank registers, RAM, and RTC from a save state.
+func (m *mbc3) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+
+	m.currROMBank = sr.readUint8()
+	m.currRAMBank = sr.readUint8()
+	m.ramAndRTCEnabled = sr.readBool()
+	m.lastLatchWrite = sr.readUint8()
+
+	if m.rtc != nil {
+		rtcData := sr.readBytes()
+		if sr.err == nil && !m.rtc.load(rtcData) {
+			sr.fail("invalid RTC data")
+		}
 	}
 }
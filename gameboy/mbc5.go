@@ -1,6 +1,11 @@
 package gameboy
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
 
 type mbc5 struct {
 	// romBanks contains cartridge ROM banks, indexed by their bank number.
@@ -20,15 +25,21 @@ type mbc5 struct {
 	hasRumble bool
 	// If true, the rumble motor is currently running.
 	rumbling bool
+
+	// out is where warnings are printed.
+	out io.Writer
 }
 
 func newMBC5(
 	header romHeader,
 	cartridgeData []uint8,
-	hasRumble bool) *mbc5 {
+	hasRumble bool,
+	out io.Writer) *mbc5 {
 
 	var m mbc5
 
+	m.out = out
+
 	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
 	m.ramBanks = makeRAMBanks(header.ramSizeType)
 
@@ -46,11 +57,7 @@ func (m *mbc5) at(addr uint16) uint8 {
 	case inBank0ROMArea(addr):
 		return m.romBanks[0][addr]
 	case inBankedROMArea(addr):
-		bank := m.currROMBank
-		// If an out-of-bounds ROM bank is selected, the value will "wrap
-		// around"
-		bank %= uint16(len(m.romBanks))
-		return m.romBanks[bank][addr-bankedROMAddr]
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
 	case inBankedRAMArea(addr):
 		if m.ramEnabled && len(m.ramBanks) > 0 {
 			bank := m.currRAMBank
@@ -68,6 +75,12 @@ func (m *mbc5) at(addr uint16) uint8 {
 	}
 }
 
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *mbc5) currentROMBank() int {
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return int(m.currROMBank) % len(m.romBanks)
+}
+
 // set can do many things with the MBC5.
 //
 // Writing to special areas in ROM can turn on and off cartridge RAM, specify
@@ -122,7 +135,7 @@ func (m *mbc5) set(addr uint16, val uint8) {
 		if m.ramEnabled {
 			m.ramBanks[m.currRAMBank][addr-bankedRAMAddr] = val
 		} else {
-			fmt.Printf("Attempt to write to banked RAM when RAM is disabled: At address %#x\n", addr)
+			fmt.Fprintf(m.out, "Attempt to write to banked RAM when RAM is disabled: At address %#x\n", addr)
 		}
 	} else {
 		panic(fmt.Sprintf("MBC5 is unable to handle writes to address %#x", addr))
@@ -141,14 +154,40 @@ func (m *mbc5) dumpBatteryBackedRAM() []uint8 {
 	return dump
 }
 
-func (m *mbc5) loadBatteryBackedRAM(dump []uint8) {
+func (m *mbc5) loadBatteryBackedRAM(dump []uint8) error {
 	for bankNum, bank := range m.ramBanks {
 		for i := range bank {
 			dumpIndex := len(bank)*bankNum + i
-			if i >= len(dump) {
-				panic(fmt.Sprintf("RAM dump is too small for this MBC: %v", i))
+			if dumpIndex >= len(dump) {
+				return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
 			}
 			bank[i] = dump[dumpIndex]
 		}
 	}
+
+	return nil
+}
+
+// saveState writes the MBC5's bank registers and RAM to a save state.
+func (m *mbc5) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.currROMBank)
+	sw.write(m.currRAMBank)
+	sw.write(m.ramEnabled)
+	sw.write(m.rumbling)
+}
+
+// loadState reads the MBC5's bank registers and RAM from a save state.
+func (m *mbc5) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+
+	m.currROMBank = sr.readUint16()
+	m.currRAMBank = sr.readUint8()
+	m.ramEnabled = sr.readBool()
+	m.rumbling = sr.readBool()
 }
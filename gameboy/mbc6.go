@@ -0,0 +1,386 @@
+package gameboy
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// mbc6ROMBankSize is the size of the switchable ROM and flash banks.
+	mbc6ROMBankSize = 0x2000
+	// mbc6RAMBankSize is the size of the switchable RAM banks.
+	mbc6RAMBankSize = 0x1000
+	// mbc6RAMBanks is the number of RAM banks, for a total of 32 KB.
+	mbc6RAMBanks = 8
+	// mbc6FlashSize is the size of the flash memory, which is 1 MB.
+	mbc6FlashSize = 0x100000
+
+	// mbc6FlashManufacturerID and mbc6FlashDeviceID are the IDs reported by
+	// the flash chip in ID mode. They identify it as a Macronix MX29F008.
+	mbc6FlashManufacturerID = 0xC2
+	mbc6FlashDeviceID       = 0x81
+)
+
+// mbc6FlashMode is what the flash chip will do with the next read or write.
+type mbc6FlashMode int
+
+const (
+	// mbc6FlashRead means that reads return the contents of flash.
+	mbc6FlashRead mbc6FlashMode = iota
+	// mbc6FlashUnlock1 means that the first unlock write was received.
+	mbc6FlashUnlock1
+	// mbc6FlashUnlock2 means that both unlock writes were received, so the
+	// next write is a command.
+	mbc6FlashUnlock2
+	// mbc6FlashID means that reads return the manufacturer and device IDs.
+	mbc6FlashID
+	// mbc6FlashProgram means that the next write programs a byte.
+	mbc6FlashProgram
+	// mbc6FlashEraseUnlock means that an erase was started, and is waiting
+	// for its own unlock sequence and the erase command.
+	mbc6FlashEraseUnlock
+	// mbc6FlashStatus means that the last program or erase has finished and
+	// reads return the chip's status.
+	mbc6FlashStatus
+)
+
+// mbc6 implements the MBC6 memory bank controller, used by Net de Get:
+// Minigame @ 100. Instead of one switchable ROM bank and one switchable RAM
+// bank, it has two of each, half as large. Each ROM window can show either
+// ROM or a 1 MB flash chip, which the game uses to store minigames that it
+// downloads.
+//
+// The flash chip is programmed with the usual unlock sequence of writing 0xAA
+// to 0x5555 and 0x55 to 0x2AAA in flash, followed by a command. Programming
+// and erasing finish instantly. Erasing a sector erases the 8 KB bank it's in.
+type mbc6 struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	// Unlike other MBCs, these are 8 KB in size.
+	romBanks [][]uint8
+	// ramBanks contains the cartridge's 4 KB RAM banks, indexed by their bank
+	// number.
+	ramBanks [][]uint8
+	// flash is the contents of the flash chip.
+	flash []uint8
+
+	// The ROM or flash bank selected for 0x4000-0x5FFF and 0x6000-0x7FFF.
+	currROMBanks [2]uint8
+	// True if flash is mapped in place of ROM for each window.
+	flashMapped [2]bool
+	// The RAM bank selected for 0xA000-0xAFFF and 0xB000-0xBFFF.
+	currRAMBanks [2]uint8
+
+	// True if RAM turned on.
+	ramEnabled bool
+	// True if the flash chip can be accessed.
+	flashEnabled bool
+	// True if the flash chip can be programmed and erased.
+	flashWriteEnabled bool
+
+	// flashMode is what the flash chip will do with the next read or write.
+	flashMode mbc6FlashMode
+	// eraseCommand is true if the erase unlock sequence has been received, so
+	// the next write chooses what to erase.
+	eraseCommand bool
+
+	// out is where warnings are printed.
+	out io.Writer
+}
+
+func newMBC6(header romHeader, cartridgeData []uint8, out io.Writer) *mbc6 {
+	var m mbc6
+
+	m.out = out
+
+	// Split the usual 16 KB banks into 8 KB ones
+	for _, bank := range makeROMBanks(header.romSizeType, cartridgeData) {
+		m.romBanks = append(m.romBanks, bank[:mbc6ROMBankSize], bank[mbc6ROMBankSize:])
+	}
+	// The MBC6 always has 32 KB of RAM, whatever the header says
+	for i := 0; i < mbc6RAMBanks; i++ {
+		m.ramBanks = append(m.ramBanks, make([]uint8, mbc6RAMBankSize))
+	}
+
+	// Flash starts out erased
+	m.flash = make([]uint8, mbc6FlashSize)
+	for i := range m.flash {
+		m.flash[i] = 0xFF
+	}
+
+	return &m
+}
+
+// at provides access to the MBC6 banked ROM, flash and banked RAM.
+func (m *mbc6) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		// Bank 0 is made up of the first two 8 KB banks
+		bank := int(addr) / mbc6ROMBankSize
+		return m.romBanks[bank][int(addr)%mbc6ROMBankSize]
+	case inBankedROMArea(addr):
+		window := int(addr-bankedROMAddr) / mbc6ROMBankSize
+		offset := int(addr) % mbc6ROMBankSize
+		if m.flashMapped[window] {
+			return m.readFlash(m.flashAddr(window, offset))
+		}
+		// If an out-of-bounds ROM bank is selected, the value will "wrap
+		// around"
+		bank := int(m.currROMBanks[window]) % len(m.romBanks)
+		return m.romBanks[bank][offset]
+	case inBankedRAMArea(addr):
+		if !m.ramEnabled {
+			// The default value for disabled RAM
+			return 0xFF
+		}
+		window := int(addr-bankedRAMAddr) / mbc6RAMBankSize
+		bank := int(m.currRAMBanks[window]) % len(m.ramBanks)
+		return m.ramBanks[bank][int(addr)%mbc6RAMBankSize]
+	default:
+		panic(fmt.Sprintf("MBC6 is unable to handle reads to address %#x", addr))
+	}
+}
+
+// set can switch ROM, flash and RAM banks, enable RAM and flash, write to RAM,
+// and send commands to the flash chip.
+func (m *mbc6) set(addr uint16, val uint8) {
+	switch {
+	case addr < 0x0400:
+		// RAM enable
+		m.ramEnabled = val&0x0F == 0x0A
+	case addr < 0x0800:
+		// RAM bank A
+		m.currRAMBanks[0] = val & 0x07
+	case addr < 0x0C00:
+		// RAM bank B
+		m.currRAMBanks[1] = val & 0x07
+	case addr < 0x1000:
+		// Flash enable
+		m.flashEnabled = val&0x01 == 0x01
+	case addr < 0x2000:
+		// Flash write enable
+		m.flashWriteEnabled = val&0x01 == 0x01
+	case addr < 0x2800:
+		// ROM/flash bank A
+		m.currROMBanks[0] = val & 0x7F
+	case addr < 0x3000:
+		// ROM/flash select A
+		m.flashMapped[0] = val&0x08 == 0x08
+	case addr < 0x3800:
+		// ROM/flash bank B
+		m.currROMBanks[1] = val & 0x7F
+	case addr < 0x4000:
+		// ROM/flash select B
+		m.flashMapped[1] = val&0x08 == 0x08
+	case inBankedROMArea(addr):
+		window := int(addr-bankedROMAddr) / mbc6ROMBankSize
+		if m.flashMapped[window] {
+			m.writeFlash(m.flashAddr(window, int(addr)%mbc6ROMBankSize), val)
+		}
+	case inBankedRAMArea(addr):
+		if m.ramEnabled {
+			window := int(addr-bankedRAMAddr) / mbc6RAMBankSize
+			bank := int(m.currRAMBanks[window]) % len(m.ramBanks)
+			m.ramBanks[bank][int(addr)%mbc6RAMBankSize] = val
+		}
+	default:
+		panic(fmt.Sprintf("MBC6 is unable to handle writes to address %#x", addr))
+	}
+}
+
+// flashAddr returns the address in flash of the given offset into a ROM
+// window.
+func (m *mbc6) flashAddr(window int, offset int) int {
+	return (int(m.currROMBanks[window])*mbc6ROMBankSize + offset) % len(m.flash)
+}
+
+// readFlash reads from the flash chip at the given address.
+func (m *mbc6) readFlash(flashAddr int) uint8 {
+	if !m.flashEnabled {
+		return 0xFF
+	}
+
+	switch m.flashMode {
+	case mbc6FlashID:
+		switch flashAddr & 0x1 {
+		case 0:
+			return mbc6FlashManufacturerID
+		default:
+			return mbc6FlashDeviceID
+		}
+	case mbc6FlashStatus:
+		// Bit 7 is set when the last operation has finished, which is
+		// always
+		return 0x80
+	default:
+		return m.flash[flashAddr]
+	}
+}
+
+// writeFlash sends a write to the flash chip at the given address, which is
+// either part of a command or data to program.
+func (m *mbc6) writeFlash(flashAddr int, val uint8) {
+	if !m.flashEnabled {
+		return
+	}
+
+	// Commands are recognized by the lower 15 bits of the address
+	cmdAddr := flashAddr & 0x7FFF
+
+	if val == 0xF0 && m.flashMode != mbc6FlashProgram {
+		// Reset back to read mode, which can be done at any time
+		m.flashMode = mbc6FlashRead
+		m.eraseCommand = false
+		return
+	}
+
+	switch m.flashMode {
+	case mbc6FlashRead, mbc6FlashID, mbc6FlashStatus:
+		if cmdAddr == 0x5555 && val == 0xAA {
+			m.flashMode = mbc6FlashUnlock1
+		}
+	case mbc6FlashUnlock1:
+		if cmdAddr == 0x2AAA && val == 0x55 {
+			m.flashMode = mbc6FlashUnlock2
+		} else {
+			m.flashMode = mbc6FlashRead
+			m.eraseCommand = false
+		}
+	case mbc6FlashUnlock2:
+		m.runFlashCommand(flashAddr, cmdAddr, val)
+	case mbc6FlashProgram:
+		if m.flashWriteEnabled {
+			// Programming can only clear bits. Setting them again takes an
+			// erase.
+			m.flash[flashAddr] &= val
+		}
+		m.flashMode = mbc6FlashStatus
+	case mbc6FlashEraseUnlock:
+		// The erase command has its own unlock sequence
+		if cmdAddr == 0x5555 && val == 0xAA {
+			m.flashMode = mbc6FlashUnlock1
+			m.eraseCommand = true
+		} else {
+			m.flashMode = mbc6FlashRead
+		}
+	}
+}
+
+// runFlashCommand runs a flash command that follows an unlock sequence.
+func (m *mbc6) runFlashCommand(flashAddr int, cmdAddr int, val uint8) {
+	if m.eraseCommand {
+		m.eraseCommand = false
+
+		switch {
+		case val == 0x10 && cmdAddr == 0x5555:
+			// Chip erase
+			if m.flashWriteEnabled {
+				for i := range m.flash {
+					m.flash[i] = 0xFF
+				}
+			}
+			m.flashMode = mbc6FlashStatus
+		case val == 0x30:
+			// Sector erase
+			if m.flashWriteEnabled {
+				start := flashAddr - flashAddr%mbc6ROMBankSize
+				for i := start; i < start+mbc6ROMBankSize; i++ {
+					m.flash[i] = 0xFF
+				}
+			}
+			m.flashMode = mbc6FlashStatus
+		default:
+			m.flashMode = mbc6FlashRead
+		}
+		return
+	}
+
+	if cmdAddr != 0x5555 {
+		m.flashMode = mbc6FlashRead
+		return
+	}
+
+	switch val {
+	case 0x90:
+		m.flashMode = mbc6FlashID
+	case 0xA0:
+		m.flashMode = mbc6FlashProgram
+	case 0x80:
+		m.flashMode = mbc6FlashEraseUnlock
+	default:
+		fmt.Fprintf(m.out, "Unknown MBC6 flash command %#x\n", val)
+		m.flashMode = mbc6FlashRead
+	}
+}
+
+// dumpBatteryBackedRAM returns a dump of all RAM banks, followed by the
+// contents of flash.
+func (m *mbc6) dumpBatteryBackedRAM() []uint8 {
+	var dump []uint8
+
+	for _, bank := range m.ramBanks {
+		dump = append(dump, bank...)
+	}
+	dump = append(dump, m.flash...)
+
+	return dump
+}
+
+// loadBatteryBackedRAM loads RAM banks and, if present, the contents of flash
+// from the given dump.
+func (m *mbc6) loadBatteryBackedRAM(dump []uint8) error {
+	ramSize := 0
+	for bankNum, bank := range m.ramBanks {
+		start := len(bank) * bankNum
+		if start+len(bank) > len(dump) {
+			return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+		}
+		copy(bank, dump[start:start+len(bank)])
+		ramSize += len(bank)
+	}
+
+	if len(dump) > ramSize {
+		if len(dump)-ramSize != len(m.flash) {
+			fmt.Fprintln(m.out, "Warning: Ignoring flash save data with an unexpected size")
+			return nil
+		}
+		copy(m.flash, dump[ramSize:])
+	}
+
+	return nil
+}
+
+// saveState writes the MBC6's bank registers, RAM, and flash to a save state.
+func (m *mbc6) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+	sw.writeBytes(m.flash)
+
+	sw.write(m.currROMBanks)
+	sw.write(m.flashMapped)
+	sw.write(m.currRAMBanks)
+	sw.write(m.ramEnabled)
+	sw.write(m.flashEnabled)
+	sw.write(m.flashWriteEnabled)
+	sw.writeInt(int(m.flashMode))
+	sw.write(m.eraseCommand)
+}
+
+// loadState reads the MBC6's bank registers, RAM, and flash from a save state.
+func (m *mbc6) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+	sr.readBytesInto(m.flash)
+
+	sr.read(&m.currROMBanks)
+	sr.read(&m.flashMapped)
+	sr.read(&m.currRAMBanks)
+	m.ramEnabled = sr.readBool()
+	m.flashEnabled = sr.readBool()
+	m.flashWriteEnabled = sr.readBool()
+	m.flashMode = mbc6FlashMode(sr.readInt())
+	m.eraseCommand = sr.readBool()
+}
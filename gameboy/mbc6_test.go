@@ -0,0 +1,135 @@
+package gameboy
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// newMBC6FlashTestDevice returns an MBC6 with flash enabled, and flash banks
+// 2 and 1 mapped to 0x4000 and 0x6000, which is where the flash command
+// addresses are.
+func newMBC6FlashTestDevice() *mbc6 {
+	m := newMBC6(romHeader{}, make([]uint8, 0x8000), ioutil.Discard)
+
+	m.set(0x0C00, 0x01) // Enable flash
+	m.set(0x1000, 0x01) // Enable flash writes
+	m.set(0x2000, 0x02) // Flash bank 2 in window A
+	m.set(0x2800, 0x08)
+	m.set(0x3000, 0x01) // Flash bank 1 in window B
+	m.set(0x3800, 0x08)
+
+	return m
+}
+
+// mbc6FlashCommand sends the unlock sequence to the flash chip, followed by
+// the given command.
+func mbc6FlashCommand(m *mbc6, command uint8) {
+	m.set(0x5555, 0xAA) // 0x5555 in flash
+	m.set(0x6AAA, 0x55) // 0x2AAA in flash
+	m.set(0x5555, command)
+}
+
+// mbc6EraseFlash sends an erase command to the flash chip. The final write
+// goes to the given address.
+func mbc6EraseFlash(m *mbc6, addr uint16, command uint8) {
+	mbc6FlashCommand(m, 0x80)
+	m.set(0x5555, 0xAA)
+	m.set(0x6AAA, 0x55)
+	m.set(addr, command)
+}
+
+// mbc6ProgramFlash programs a byte of flash.
+func mbc6ProgramFlash(m *mbc6, addr uint16, val uint8) {
+	mbc6FlashCommand(m, 0xA0)
+	m.set(addr, val)
+	m.set(addr, 0xF0) // Back to read mode
+}
+
+func TestMBC6FlashID(t *testing.T) {
+	m := newMBC6FlashTestDevice()
+
+	mbc6FlashCommand(m, 0x90)
+	if id := m.at(0x4000); id != mbc6FlashManufacturerID {
+		t.Errorf("expected manufacturer ID %#x, got %#x", mbc6FlashManufacturerID, id)
+	}
+	if id := m.at(0x4001); id != mbc6FlashDeviceID {
+		t.Errorf("expected device ID %#x, got %#x", mbc6FlashDeviceID, id)
+	}
+
+	m.set(0x4000, 0xF0)
+	if val := m.at(0x4000); val != 0xFF {
+		t.Errorf("expected erased flash after leaving ID mode, got %#x", val)
+	}
+}
+
+func TestMBC6FlashProgram(t *testing.T) {
+	m := newMBC6FlashTestDevice()
+
+	mbc6FlashCommand(m, 0xA0)
+	m.set(0x6010, 0x5A)
+	if status := m.at(0x6010); status&0x80 == 0 {
+		t.Errorf("expected the status to say programming finished, got %#x", status)
+	}
+	m.set(0x6010, 0xF0)
+	if val := m.at(0x6010); val != 0x5A {
+		t.Fatalf("expected to read back 0x5A, got %#x", val)
+	}
+	if val := m.flash[1*mbc6ROMBankSize+0x10]; val != 0x5A {
+		t.Fatalf("expected the byte to be programmed in bank 1, got %#x", val)
+	}
+
+	// Programming can't set bits that are already cleared
+	mbc6ProgramFlash(m, 0x6010, 0xA5)
+	if val := m.at(0x6010); val != 0x00 {
+		t.Fatalf("expected 0x00 after programming 0xA5 over 0x5A, got %#x", val)
+	}
+
+	// Without writes enabled, programming does nothing
+	m.set(0x1000, 0x00)
+	mbc6ProgramFlash(m, 0x6011, 0x00)
+	if val := m.at(0x6011); val != 0xFF {
+		t.Fatalf("programmed with writes disabled, read %#x", val)
+	}
+}
+
+func TestMBC6FlashErase(t *testing.T) {
+	m := newMBC6FlashTestDevice()
+
+	mbc6ProgramFlash(m, 0x4010, 0x12)
+	mbc6ProgramFlash(m, 0x6010, 0x34)
+
+	// A sector erase only erases the bank that it's sent to
+	mbc6EraseFlash(m, 0x6000, 0x30)
+	if status := m.at(0x6010); status&0x80 == 0 {
+		t.Errorf("expected the status to say erasing finished, got %#x", status)
+	}
+	m.set(0x6000, 0xF0)
+	if val := m.at(0x6010); val != 0xFF {
+		t.Errorf("expected bank 1 to be erased, got %#x", val)
+	}
+	if val := m.at(0x4010); val != 0x12 {
+		t.Errorf("expected bank 2 to be left alone, got %#x", val)
+	}
+
+	mbc6EraseFlash(m, 0x5555, 0x10)
+	m.set(0x4000, 0xF0)
+	for i, val := range m.flash {
+		if val != 0xFF {
+			t.Fatalf("expected the chip to be erased, got %#x at %#x", val, i)
+		}
+	}
+}
+
+func TestMBC6FlashDisabled(t *testing.T) {
+	m := newMBC6FlashTestDevice()
+	m.set(0x0C00, 0x00)
+
+	mbc6ProgramFlash(m, 0x6010, 0x00)
+	if m.flash[1*mbc6ROMBankSize+0x10] != 0xFF {
+		t.Fatalf("disabled flash was programmed")
+	}
+	mbc6FlashCommand(m, 0x90)
+	if val := m.at(0x4000); val != 0xFF {
+		t.Fatalf("expected disabled flash to read 0xFF, got %#x", val)
+	}
+}
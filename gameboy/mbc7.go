@@ -0,0 +1,202 @@
+package gameboy
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// mbc7AccelerometerCenter is the accelerometer value when the Game Boy is
+	// level.
+	mbc7AccelerometerCenter = 0x81D0
+	// mbc7AccelerometerScale is how much the accelerometer value changes for
+	// a full tilt, which is 1 g of acceleration.
+	mbc7AccelerometerScale = 0x70
+	// mbc7AccelerometerErased is the value of the accelerometer registers
+	// after they're erased, before new values are latched.
+	mbc7AccelerometerErased = 0x8000
+)
+
+// mbc7 is the memory bank controller used by games with an accelerometer,
+// like Kirby Tilt 'n' Tumble. Instead of RAM, it has a small EEPROM for save
+// data that the game talks to serially.
+type mbc7 struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	romBanks [][]uint8
+
+	// The currently selected ROM bank.
+	currROMBank uint8
+	// Both RAM enable registers need to be set for the accelerometer and
+	// EEPROM to be accessible.
+	ramEnabled1 bool
+	ramEnabled2 bool
+
+	// The latched accelerometer values.
+	accelerometerX uint16
+	accelerometerY uint16
+	// True if the accelerometer values have been erased, which needs to
+	// happen before new values can be latched.
+	accelerometerErased bool
+
+	eeprom *eeprom93LC56
+
+	tilt TiltDriver
+}
+
+func newMBC7(header romHeader, cartridgeData []uint8, tilt TiltDriver) *mbc7 {
+	var m mbc7
+
+	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
+
+	m.currROMBank = 1
+	m.accelerometerX = mbc7AccelerometerErased
+	m.accelerometerY = mbc7AccelerometerErased
+
+	m.eeprom = newEEPROM93LC56()
+	m.tilt = tilt
+
+	return &m
+}
+
+// at provides access to the MBC7 banked ROM, accelerometer and EEPROM.
+func (m *mbc7) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		return m.romBanks[0][addr]
+	case inBankedROMArea(addr):
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
+	case inBankedRAMArea(addr):
+		if !m.ramEnabled1 || !m.ramEnabled2 || addr >= 0xB000 {
+			return 0xFF
+		}
+
+		// Registers are selected by bits 4-7 of the address
+		switch (addr >> 4) & 0xF {
+		case 0x2:
+			lower, _ := split16(m.accelerometerX)
+			return lower
+		case 0x3:
+			_, upper := split16(m.accelerometerX)
+			return upper
+		case 0x4:
+			lower, _ := split16(m.accelerometerY)
+			return lower
+		case 0x5:
+			_, upper := split16(m.accelerometerY)
+			return upper
+		case 0x6:
+			// Unused, always 0
+			return 0x00
+		case 0x8:
+			return m.eeprom.read()
+		default:
+			return 0xFF
+		}
+	default:
+		panic(fmt.Sprintf("MBC7 is unable to handle reads to address %#x", addr))
+	}
+}
+
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *mbc7) currentROMBank() int {
+	return int(m.currROMBank) % len(m.romBanks)
+}
+
+// set can switch ROM banks, enable the accelerometer and EEPROM, latch
+// accelerometer values, and talk to the EEPROM.
+func (m *mbc7) set(addr uint16, val uint8) {
+	if addr < 0x2000 {
+		// RAM enable 1
+		m.ramEnabled1 = val&0x0F == 0x0A
+		if !m.ramEnabled1 {
+			m.ramEnabled2 = false
+		}
+	} else if addr < 0x4000 {
+		// ROM bank
+		m.currROMBank = val & 0x7F
+	} else if addr < 0x6000 {
+		// RAM enable 2, which only works if RAM enable 1 is set
+		if m.ramEnabled1 {
+			m.ramEnabled2 = val == 0x40
+		}
+	} else if addr < 0x8000 {
+		// This area of ROM doesn't do anything when written to
+	} else if inBankedRAMArea(addr) {
+		if !m.ramEnabled1 || !m.ramEnabled2 || addr >= 0xB000 {
+			return
+		}
+
+		switch (addr >> 4) & 0xF {
+		case 0x0:
+			// Erase the latched accelerometer values
+			if val == 0x55 {
+				m.accelerometerX = mbc7AccelerometerErased
+				m.accelerometerY = mbc7AccelerometerErased
+				m.accelerometerErased = true
+			}
+		case 0x1:
+			// Latch new accelerometer values
+			if val == 0xAA && m.accelerometerErased {
+				x, y := m.tilt.Tilt()
+				m.accelerometerX = accelerometerValue(x)
+				m.accelerometerY = accelerometerValue(y)
+				m.accelerometerErased = false
+			}
+		case 0x8:
+			m.eeprom.write(val)
+		}
+	} else {
+		panic(fmt.Sprintf("MBC7 is unable to handle writes to address %#x", addr))
+	}
+}
+
+// accelerometerValue converts a tilt from -1 to 1 into an accelerometer
+// register value. The accelerometer reports lower values the further the
+// Game Boy is tilted in the positive direction.
+func accelerometerValue(tilt float64) uint16 {
+	if tilt < -1 {
+		tilt = -1
+	} else if tilt > 1 {
+		tilt = 1
+	}
+
+	return uint16(mbc7AccelerometerCenter - int(tilt*mbc7AccelerometerScale))
+}
+
+func (m *mbc7) dumpBatteryBackedRAM() []uint8 {
+	dump := make([]uint8, len(m.eeprom.data))
+	copy(dump, m.eeprom.data[:])
+	return dump
+}
+
+func (m *mbc7) loadBatteryBackedRAM(dump []uint8) error {
+	if len(dump) < len(m.eeprom.data) {
+		return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+	}
+	copy(m.eeprom.data[:], dump)
+
+	return nil
+}
+
+// saveState writes the MBC7's registers and EEPROM to a save state.
+func (m *mbc7) saveState(sw *stateWriter) {
+	sw.write(m.currROMBank)
+	sw.write(m.ramEnabled1)
+	sw.write(m.ramEnabled2)
+	sw.write(m.accelerometerX)
+	sw.write(m.accelerometerY)
+	sw.write(m.accelerometerErased)
+	m.eeprom.saveState(sw)
+}
+
+// loadState reads the MBC7's registers and EEPROM from a save state.
+func (m *mbc7) loadState(sr *stateReader) {
+	m.currROMBank = sr.readUint8()
+	m.ramEnabled1 = sr.readBool()
+	m.ramEnabled2 = sr.readBool()
+	m.accelerometerX = sr.readUint16()
+	m.accelerometerY = sr.readUint16()
+	m.accelerometerErased = sr.readBool()
+	m.eeprom.loadState(sr)
+}
@@ -0,0 +1,59 @@
+package gameboy
+
+import "testing"
+
+// testTiltDriver reports a fixed tilt.
+type testTiltDriver struct {
+	x, y float64
+}
+
+func (driver *testTiltDriver) Tilt() (x, y float64) {
+	return driver.x, driver.y
+}
+
+func TestMBC7AccelerometerLatch(t *testing.T) {
+	tilt := &testTiltDriver{x: 0.5, y: -1}
+	// MBC7+SENSOR+RUMBLE+RAM+BATTERY
+	device := newTestCartridgeDevice(t, newTestCartridge(0x22, 0x00, 0x00), WithTilt(tilt))
+
+	readAccelerometer := func() (x, y uint16) {
+		x = combine16(device.ReadMemory(0xA020), device.ReadMemory(0xA030))
+		y = combine16(device.ReadMemory(0xA040), device.ReadMemory(0xA050))
+		return x, y
+	}
+
+	if val := device.ReadMemory(0xA020); val != 0xFF {
+		t.Fatalf("expected 0xff while the accelerometer is disabled, got %#x", val)
+	}
+	device.WriteMemory(0x0000, 0x0A)
+	device.WriteMemory(0x4000, 0x40)
+
+	// Values can't be latched until they're erased
+	device.WriteMemory(0xA010, 0xAA)
+	if x, y := readAccelerometer(); x != mbc7AccelerometerErased || y != mbc7AccelerometerErased {
+		t.Fatalf("latched %#x, %#x without erasing first", x, y)
+	}
+
+	device.WriteMemory(0xA000, 0x55)
+	device.WriteMemory(0xA010, 0xAA)
+	expectedX := uint16(mbc7AccelerometerCenter - mbc7AccelerometerScale/2)
+	expectedY := uint16(mbc7AccelerometerCenter + mbc7AccelerometerScale)
+	if x, y := readAccelerometer(); x != expectedX || y != expectedY {
+		t.Fatalf("expected %#x, %#x after latching, got %#x, %#x", expectedX, expectedY, x, y)
+	}
+
+	// The latched values stay until the next erase and latch
+	tilt.x, tilt.y = 0, 0
+	device.WriteMemory(0xA010, 0xAA)
+	if x, y := readAccelerometer(); x != expectedX || y != expectedY {
+		t.Fatalf("latched values changed to %#x, %#x without erasing", x, y)
+	}
+	device.WriteMemory(0xA000, 0x55)
+	if x, y := readAccelerometer(); x != mbc7AccelerometerErased || y != mbc7AccelerometerErased {
+		t.Fatalf("expected erased values, got %#x, %#x", x, y)
+	}
+	device.WriteMemory(0xA010, 0xAA)
+	if x, y := readAccelerometer(); x != mbc7AccelerometerCenter || y != mbc7AccelerometerCenter {
+		t.Fatalf("expected level values, got %#x, %#x", x, y)
+	}
+}
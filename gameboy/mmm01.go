@@ -0,0 +1,294 @@
+package gameboy
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// mmm01MenuSize is the size of the menu at the end of an MMM01 cartridge's
+// ROM, which is what the MMM01 maps in when the Game Boy starts.
+const mmm01MenuSize = 0x8000
+
+// mmm01 implements the MMM01 memory bank controller, which is used by
+// cartridges with multiple games on them. It starts out "unmapped", showing a
+// menu at the end of the ROM. The menu configures which part of the ROM and
+// RAM the chosen game gets, then locks that configuration in place and starts
+// the game. From then on, the MMM01 acts like an MBC1 within the game's part
+// of the ROM.
+type mmm01 struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	romBanks [][]uint8
+	// ramBanks contains all extra RAM banks, indexed by their bank number.
+	// These extra RAM banks are supplied by the cartridge.
+	ramBanks [][]uint8
+
+	// mapped is true once the menu has locked in the game's configuration.
+	// Until then, the menu at the end of the ROM is mapped and the registers
+	// that configure the game can be written to.
+	mapped bool
+
+	// romBankLow is a 5-bit value that selects a ROM bank within the game,
+	// like an MBC1's bank register 1.
+	romBankLow uint8
+	// romBankMid is a 2-bit value that provides bits 5 and 6 of the ROM bank.
+	// It can only be set before the game is mapped.
+	romBankMid uint8
+	// romBankHigh is a 2-bit value that provides bits 7 and 8 of the ROM
+	// bank. It can only be set before the game is mapped.
+	romBankHigh uint8
+	// romBankMask is a 4-bit value. Each bit locks one of bits 1-4 of
+	// romBankLow once the game is mapped, which limits how much ROM the game
+	// can see.
+	romBankMask uint8
+
+	// ramBankLow is a 2-bit value that selects a RAM bank within the game.
+	ramBankLow uint8
+	// ramBankHigh is a 2-bit value that provides bits 2 and 3 of the RAM bank.
+	// It can only be set before the game is mapped.
+	ramBankHigh uint8
+	// ramBankMask is a 2-bit value. Each bit locks one of the bits of
+	// ramBankLow, which limits how much RAM the game can see.
+	ramBankMask uint8
+
+	// True if RAM turned on.
+	ramEnabled bool
+	// bankSelectionMode works like the MBC1's mode select. If it's 1, bank 0
+	// of the game can be switched and RAM banks can be selected.
+	bankSelectionMode uint8
+	// modeLocked is true if the game isn't allowed to change the bank
+	// selection mode.
+	modeLocked bool
+	// multiplexed is true if the mid ROM bank and low RAM bank registers are
+	// swapped, for games that use more RAM banks than ROM banks.
+	multiplexed bool
+}
+
+func newMMM01(header romHeader, cartridgeData []uint8) *mmm01 {
+	var m mmm01
+
+	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
+	m.ramBanks = makeRAMBanks(header.ramSizeType)
+
+	return &m
+}
+
+// isMMM01 returns true if the given cartridge data looks like it's from an
+// MMM01 cartridge. The header of these cartridges is in the menu at the end of
+// the ROM, so the header at the start belongs to the first game and doesn't
+// mention the MMM01.
+func isMMM01(cartridgeData []uint8) bool {
+	menuStart := len(cartridgeData) - mmm01MenuSize
+	if menuStart <= 0 || !hasNintendoLogo(cartridgeData, menuStart) {
+		return false
+	}
+
+	header, err := loadROMHeader(cartridgeData[menuStart:])
+	if err != nil {
+		return false
+	}
+	return isMMM01Type(header.cartridgeType)
+}
+
+// isMMM01Type returns true if the given cartridge type is one of the MMM01
+// types.
+func isMMM01Type(cartridgeType uint8) bool {
+	return cartridgeType >= 0x0B && cartridgeType <= 0x0D
+}
+
+// moveMMM01MenuToEnd returns a copy of the given cartridge data with the menu
+// at the start of the ROM moved to the end.
+func moveMMM01MenuToEnd(cartridgeData []uint8) []uint8 {
+	if len(cartridgeData) <= mmm01MenuSize {
+		return cartridgeData
+	}
+
+	moved := make([]uint8, 0, len(cartridgeData))
+	moved = append(moved, cartridgeData[mmm01MenuSize:]...)
+	moved = append(moved, cartridgeData[:mmm01MenuSize]...)
+	return moved
+}
+
+// romBanksInUse returns the ROM banks mapped to 0x0000-0x3FFF and
+// 0x4000-0x7FFF.
+func (m *mmm01) romBanksInUse() (bank0, bank int) {
+	if !m.mapped {
+		// The menu is in the last 32 KB of the ROM
+		return len(m.romBanks) - 2, len(m.romBanks) - 1
+	}
+
+	var upper int
+	if m.multiplexed {
+		upper = int(m.ramBankLow)<<5 | int(m.romBankHigh)<<7
+	} else {
+		upper = int(m.romBankMid)<<5 | int(m.romBankHigh)<<7
+	}
+
+	// Bank 0 of the game only has the bits that are locked by the mask
+	bank0 = int(m.romBankLow&(m.romBankMask<<1)) | upper
+	if m.multiplexed && m.bankSelectionMode == 0 {
+		// The RAM bank only affects bank 0 in mode 1
+		bank0 &^= 0x3 << 5
+	}
+
+	bank = int(m.romBankLow) | upper
+	if bank == bank0 {
+		// Bank 0 of the game is not directly selectable, map to bank 1
+		// instead
+		bank++
+	}
+
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return bank0 % len(m.romBanks), bank % len(m.romBanks)
+}
+
+// ramBankInUse returns the RAM bank mapped to 0xA000-0xBFFF.
+func (m *mmm01) ramBankInUse() int {
+	var bank int
+	if m.multiplexed {
+		bank = int(m.romBankMid) | int(m.ramBankHigh)<<2
+	} else {
+		bank = int(m.ramBankLow) | int(m.ramBankHigh)<<2
+	}
+
+	// If an out-of-bounds RAM bank is selected, the value will "wrap around"
+	return bank % len(m.ramBanks)
+}
+
+// at provides access to the MMM01 banked ROM and RAM.
+func (m *mmm01) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		bank0, _ := m.romBanksInUse()
+		return m.romBanks[bank0][addr]
+	case inBankedROMArea(addr):
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
+	case inBankedRAMArea(addr):
+		if !m.ramEnabled || len(m.ramBanks) == 0 {
+			// The default value for disabled RAM
+			return 0xFF
+		}
+		return m.ramBanks[m.ramBankInUse()][addr-bankedRAMAddr]
+	default:
+		panic(fmt.Sprintf("MMM01 is unable to handle reads to address %#x", addr))
+	}
+}
+
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *mmm01) currentROMBank() int {
+	_, bank := m.romBanksInUse()
+	return bank
+}
+
+// set can switch banks, enable RAM, and write to RAM. Before the game is
+// mapped, it also configures the part of ROM and RAM that the game gets.
+func (m *mmm01) set(addr uint16, val uint8) {
+	if addr < 0x2000 {
+		// RAM enable, and the RAM bank mask and map enable before mapping
+		m.ramEnabled = val&0x0F == 0x0A
+		if !m.mapped {
+			m.ramBankMask = (val >> 4) & 0x03
+			m.mapped = val&0x40 == 0x40
+		}
+	} else if addr < 0x4000 {
+		// ROM bank, and the mid ROM bank before mapping
+		if !m.mapped {
+			m.romBankMid = (val >> 5) & 0x03
+		}
+		// Only bits that aren't locked by the mask can be changed
+		locked := m.romBankMask << 1
+		if !m.mapped {
+			locked = 0
+		}
+		m.romBankLow = (m.romBankLow & locked) | (val & 0x1F &^ locked)
+	} else if addr < 0x6000 {
+		// RAM bank, and the high RAM and ROM banks before mapping
+		locked := m.ramBankMask
+		if !m.mapped {
+			locked = 0
+		}
+		m.ramBankLow = (m.ramBankLow & locked) | (val & 0x03 &^ locked)
+		if !m.mapped {
+			m.ramBankHigh = (val >> 2) & 0x03
+			m.romBankHigh = (val >> 4) & 0x03
+			m.modeLocked = val&0x40 == 0x40
+		}
+	} else if addr < 0x8000 {
+		// Mode select, and the ROM bank mask and multiplexing before mapping
+		if !m.modeLocked {
+			m.bankSelectionMode = val & 0x01
+		}
+		if !m.mapped {
+			m.romBankMask = (val >> 2) & 0x0F
+			m.multiplexed = val&0x40 == 0x40
+		}
+	} else if inBankedRAMArea(addr) {
+		if m.ramEnabled && len(m.ramBanks) > 0 {
+			m.ramBanks[m.ramBankInUse()][addr-bankedRAMAddr] = val
+		}
+	} else {
+		panic(fmt.Sprintf("MMM01 is unable to handle writes to address %#x", addr))
+	}
+}
+
+func (m *mmm01) dumpBatteryBackedRAM() []uint8 {
+	var dump []uint8
+
+	for _, bank := range m.ramBanks {
+		dump = append(dump, bank...)
+	}
+
+	return dump
+}
+
+func (m *mmm01) loadBatteryBackedRAM(dump []uint8) error {
+	for bankNum, bank := range m.ramBanks {
+		start := len(bank) * bankNum
+		if start+len(bank) > len(dump) {
+			return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+		}
+		copy(bank, dump[start:start+len(bank)])
+	}
+
+	return nil
+}
+
+// saveState writes the MMM01's bank registers and RAM to a save state.
+func (m *mmm01) saveState(sw *stateWriter) {
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.mapped)
+	sw.write(m.romBankLow)
+	sw.write(m.romBankMid)
+	sw.write(m.romBankHigh)
+	sw.write(m.romBankMask)
+	sw.write(m.ramBankLow)
+	sw.write(m.ramBankHigh)
+	sw.write(m.ramBankMask)
+	sw.write(m.ramEnabled)
+	sw.write(m.bankSelectionMode)
+	sw.write(m.modeLocked)
+	sw.write(m.multiplexed)
+}
+
+// loadState reads the MMM01's bank registers and RAM from a save state.
+func (m *mmm01) loadState(sr *stateReader) {
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+
+	m.mapped = sr.readBool()
+	m.romBankLow = sr.readUint8()
+	m.romBankMid = sr.readUint8()
+	m.romBankHigh = sr.readUint8()
+	m.romBankMask = sr.readUint8()
+	m.ramBankLow = sr.readUint8()
+	m.ramBankHigh = sr.readUint8()
+	m.ramBankMask = sr.readUint8()
+	m.ramEnabled = sr.readBool()
+	m.bankSelectionMode = sr.readUint8()
+	m.modeLocked = sr.readBool()
+	m.multiplexed = sr.readBool()
+}
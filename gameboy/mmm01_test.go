@@ -0,0 +1,133 @@
+package gameboy
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newMMM01TestDevice returns an MMM01 with 512 KB of ROM and 32 KB of RAM.
+func newMMM01TestDevice() *mmm01 {
+	return newMMM01(romHeader{romSizeType: 0x04, ramSizeType: 0x03}, newBankNumberedROM(32))
+}
+
+// mmm01ROMBanks returns the ROM banks that the MMM01 has mapped in.
+func mmm01ROMBanks(m *mmm01) (bank0, bank int) {
+	return int(m.at(bankMarkerAddr)), int(m.at(bankedROMAddr + bankMarkerAddr))
+}
+
+func TestMMM01Menu(t *testing.T) {
+	m := newMMM01TestDevice()
+
+	// The menu at the end of the ROM is mapped in until a game is
+	bank0, bank := mmm01ROMBanks(m)
+	if bank0 != 30 || bank != 31 {
+		t.Fatalf("expected the menu in banks 30 and 31, got %v and %v", bank0, bank)
+	}
+}
+
+func TestMMM01ROMBanks(t *testing.T) {
+	m := newMMM01TestDevice()
+
+	// Give the game the eight banks starting at bank 8 by locking bits 3
+	// and 4 of the ROM bank
+	m.set(0x6000, 0x0C<<2)
+	m.set(0x2000, 0x08)
+	m.set(0x0000, 0x40)
+
+	tests := []struct {
+		val  uint8
+		bank int
+	}{
+		// Bank 0 of the game isn't selectable, just like on an MBC1
+		{0x00, 9},
+		{0x03, 11},
+		{0x07, 15},
+		// Locked bits and the mid ROM bank can't be changed anymore
+		{0x1F, 15},
+		{0x62, 10},
+	}
+	for _, test := range tests {
+		m.set(0x2000, test.val)
+		bank0, bank := mmm01ROMBanks(m)
+		if bank0 != 8 {
+			t.Errorf("after writing %#x, expected the game's bank 0 to be bank 8, got %v",
+				test.val, bank0)
+		}
+		if bank != test.bank {
+			t.Errorf("after writing %#x, expected bank %v, got %v", test.val, test.bank, bank)
+		}
+	}
+
+	// The menu can't be mapped back in
+	m.set(0x0000, 0x00)
+	if bank0, _ := mmm01ROMBanks(m); bank0 != 8 {
+		t.Errorf("expected the game to stay mapped, got bank %v", bank0)
+	}
+}
+
+func TestMMM01MidROMBank(t *testing.T) {
+	// A 1 MB ROM
+	m := newMMM01(romHeader{romSizeType: 0x05}, newBankNumberedROM(64))
+
+	// Give the game the 32 banks starting at bank 32, using the mid ROM bank
+	m.set(0x6000, 0x00)
+	m.set(0x2000, 0x20)
+	m.set(0x0000, 0x40)
+
+	bank0, bank := mmm01ROMBanks(m)
+	if bank0 != 32 || bank != 33 {
+		t.Fatalf("expected banks 32 and 33, got %v and %v", bank0, bank)
+	}
+
+	// With no bits locked, the game can select all of its banks
+	m.set(0x2000, 0x1F)
+	if bank0, bank := mmm01ROMBanks(m); bank0 != 32 || bank != 63 {
+		t.Fatalf("expected banks 32 and 63, got %v and %v", bank0, bank)
+	}
+}
+
+func TestMMM01RAMBanks(t *testing.T) {
+	m := newMMM01TestDevice()
+
+	// Give the game RAM bank 1 only, by locking both bits of the RAM bank
+	m.set(0x4000, 0x01)
+	m.set(0x0000, 0x30|0x40)
+	m.set(0x0000, 0x0A)
+
+	// Locked bits can't be changed by the game
+	m.set(0x4000, 0x02)
+	m.set(bankedRAMAddr, 0x42)
+	if m.ramBanks[1][0] != 0x42 {
+		t.Fatalf("expected the write to go to RAM bank 1")
+	}
+	if val := m.at(bankedRAMAddr); val != 0x42 {
+		t.Fatalf("expected to read back 0x42, got %#x", val)
+	}
+
+	m.set(0x0000, 0x00)
+	if val := m.at(bankedRAMAddr); val != 0xFF {
+		t.Fatalf("expected disabled RAM to read 0xFF, got %#x", val)
+	}
+}
+
+func TestMMM01MenuAtStart(t *testing.T) {
+	// Some dumps have the menu in the first 32 KB of the ROM
+	rom := newTestCartridge(0x0B, 0x02, 0x00)
+	copy(rom[nintendoLogoAddr:], nintendoLogo)
+	rom[bankMarkerAddr] = 0xAA
+
+	header, moved, err := selectROMHeader(rom)
+	if err != nil {
+		t.Fatalf("selecting the header: %v", err)
+	}
+	if header.cartridgeType != 0x0B {
+		t.Fatalf("expected an MMM01 header, got cartridge type %#x", header.cartridgeType)
+	}
+	if !isMMM01(moved) {
+		t.Fatalf("the menu wasn't moved to the end of the ROM")
+	}
+	menu := moved[len(moved)-mmm01MenuSize:]
+	if !bytes.Equal(menu, rom[:mmm01MenuSize]) {
+		t.Fatalf("the menu at the end of the ROM doesn't match the original")
+	}
+}
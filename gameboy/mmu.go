@@ -2,6 +2,9 @@ package gameboy
 
 import (
 	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
 )
 
 // dmaCycleLength is the number of cycles a DMA transfer takes.
@@ -13,14 +16,18 @@ const dmaCycleLength = 671
 type mmu struct {
 	memory *[0x10000]uint8
 
-	// bootROM refers to the first 256 bytes of ROM, which is where the Game
-	// Boy boot sequence is stored.
+	// bootROM is where the Game Boy boot sequence is stored. On the DMG, it's
+	// 256 bytes in size and is mapped to 0x0000-0x00FF. On the CGB, it's 2304
+	// bytes in size and is additionally mapped to 0x0200-0x08FF.
 	bootROM []uint8
-	// ram is the built-in RAM on the device.
-	ram []uint8
-	// videoRAM is where sprite and tile data is stored for the video
-	// controller to access.
-	videoRAM []uint8
+	// ramBanks are the banks of built-in RAM on the device. Bank 0 is always
+	// mapped to 0xC000-0xCFFF, and a switchable bank is mapped to
+	// 0xD000-0xDFFF. Only the CGB can switch banks.
+	ramBanks [][]uint8
+	// videoRAM contains the banks of video RAM, which is where sprite and tile
+	// data is stored for the video controller to access. The DMG only uses
+	// bank 0, while the CGB has 2 banks.
+	videoRAM [][]uint8
 	// oamRAM is where sprite attribute data is stored for the video controller
 	// to access.
 	oamRAM []uint8
@@ -34,6 +41,29 @@ type mmu struct {
 	// The boot ROM itself turns this off before the game starts. If this is
 	// turned off, 0x0000 to 0x0100 maps to ROM bank 0.
 	bootROMEnabled bool
+
+	// cgbMode is true if the device is running as a CGB. This maps CGB-only
+	// registers and enables RAM and VRAM banking.
+	cgbMode bool
+	// unmapped is true for each address that is unmapped on the hardware
+	// being emulated.
+	unmapped *[0x10000]bool
+	// currRAMBank is the RAM bank mapped to 0xD000-0xDFFF.
+	currRAMBank int
+	// currVideoRAMBank is the VRAM bank mapped to 0x8000-0x9FFF.
+	currVideoRAMBank int
+
+	// hdmaActive is true if an H-Blank VRAM DMA transfer is in progress.
+	hdmaActive bool
+	// hdmaSource is the next address to be transferred by a VRAM DMA
+	// transfer.
+	hdmaSource uint16
+	// hdmaDest is the next address in VRAM to be written to by a VRAM DMA
+	// transfer.
+	hdmaDest uint16
+	// hdmaRemaining is the number of 0x10 byte blocks left in the VRAM DMA
+	// transfer.
+	hdmaRemaining int
 	// dmaActive is true if a DMA transfer is happening.
 	dmaActive bool
 	// dmaCursor is the next memory address to be transferred.
@@ -48,6 +78,11 @@ type mmu struct {
 
 	// mbc is the memory bank controller that this MMU will use.
 	mbc mbc
+	// tickingMBC is the same MBC if it needs to be ticked, or nil otherwise.
+	tickingMBC tickingMBC
+
+	// cheats patches values read from ROM with Game Genie codes.
+	cheats *CheatManager
 
 	// Components that will be consulted for their internal values when certain
 	// addresses are read from.
@@ -56,14 +91,26 @@ type mmu struct {
 	interruptManager *interruptManager
 
 	db *debugger
+
+	// out is where warnings are printed.
+	out io.Writer
 }
 
 // mbc describes a memory bank controller.
 type mbc interface {
 	set(addr uint16, val uint8)
 	at(addr uint16) uint8
+	// saveState writes the MBC's registers and RAM to a save state. ROM is
+	// not included.
+	saveState(sw *stateWriter)
+	// loadState reads the MBC's registers and RAM from a save state.
+	loadState(sr *stateReader)
 }
 
+// ErrSaveTooSmall is returned when a game save doesn't have enough data to
+// fill the cartridge's battery-backed RAM, like when the save is truncated.
+var ErrSaveTooSmall = xerrors.New("game save is too small for this cartridge")
+
 // batteryBackedMBC is a memory bank controller with RAM that is
 // battery-backed, meaning that it can be saved after the device
 // is powered off.
@@ -73,44 +120,117 @@ type batteryBackedMBC interface {
 	// the MBC.
 	dumpBatteryBackedRAM() []uint8
 	// loadBatteryBackedRAM loads the given data into all battery-backed RAM in
-	// the MBC.
-	loadBatteryBackedRAM(dump []uint8)
+	// the MBC. Returns ErrSaveTooSmall if the data doesn't fill all of it.
+	loadBatteryBackedRAM(dump []uint8) error
+}
+
+// tickingMBC is a memory bank controller with hardware that needs to be
+// progressed along with the rest of the device, like a camera sensor.
+type tickingMBC interface {
+	mbc
+	// tick progresses the MBC by one M-Cycle.
+	tick()
+}
+
+// bankedROMMBC is a memory bank controller that can report which ROM bank is
+// mapped to 0x4000-0x7FFF.
+type bankedROMMBC interface {
+	mbc
+	// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+	currentROMBank() int
 }
 
 type onWriteFunc func(addr uint16, val uint8) uint8
 
-func newMMU(bootROM []byte, cartridgeData []uint8, mbc mbc) *mmu {
-	if len(bootROM) != bootROMEndAddr {
+// newMMU creates a new MMU. If bootROM is nil, the boot ROM starts out
+// disabled. Warnings are printed to out.
+func newMMU(bootROM []byte, cartridgeData []uint8, mbc mbc, cgbMode bool, out io.Writer) *mmu {
+	if bootROM != nil && len(bootROM) != bootROMEndAddr && len(bootROM) != cgbBootROMEndAddr {
 		panic(fmt.Sprintf("invalid boot ROM size %#x", len(bootROM)))
 	}
 
 	m := &mmu{
 		memory:         &[0x10000]uint8{},
 		bootROM:        bootROM,
-		ram:            make([]uint8, ramMirrorAddr-ramAddr),
-		bootROMEnabled: true,
-		videoRAM:       make([]uint8, bankedRAMAddr-videoRAMAddr),
+		bootROMEnabled: bootROM != nil,
+		cgbMode:        cgbMode,
 		oamRAM:         make([]uint8, invalidArea2Addr-oamRAMAddr),
 		ioRAM:          make([]uint8, hramAddr-ioAddr),
 		hram:           make([]uint8, lastAddr-hramAddr+1),
 		subscribers:    make(map[uint16]onWriteFunc),
 		mbc:            mbc,
+		out:            out,
 	}
 
+	if ticking, ok := mbc.(tickingMBC); ok {
+		m.tickingMBC = ticking
+	}
+
+	ramBankCount := 2
+	videoRAMBankCount := 1
+	m.unmapped = &isUnmappedAddress
+	if cgbMode {
+		ramBankCount = 8
+		videoRAMBankCount = 2
+		m.unmapped = &isUnmappedAddressCGB
+	}
+	for i := 0; i < ramBankCount; i++ {
+		m.ramBanks = append(m.ramBanks, make([]uint8, ramBankSize))
+	}
+	for i := 0; i < videoRAMBankCount; i++ {
+		m.videoRAM = append(m.videoRAM, make([]uint8, bankedRAMAddr-videoRAMAddr))
+	}
+	m.currRAMBank = 1
+
 	m.subscribeTo(bootROMDisableAddr, m.onBootROMDisableWrite)
 	m.subscribeTo(dmaAddr, m.onDMAWrite)
 
+	if cgbMode {
+		m.subscribeTo(vbkAddr, m.onVBKWrite)
+		m.subscribeTo(svbkAddr, m.onSVBKWrite)
+		m.subscribeTo(key1Addr, m.onKEY1Write)
+		m.subscribeTo(hdma5Addr, m.onHDMA5Write)
+
+		// Set the initial values of CGB registers
+		m.memory[vbkAddr] = 0xFE
+		m.memory[svbkAddr] = 0xF8
+		m.memory[key1Addr] = 0x7E
+		m.memory[hdma5Addr] = 0xFF
+	}
+
 	return m
 }
 
-// at returns the value in the given address.
+// at returns the value in the given address. It's used for data reads by the
+// CPU, which the debugger's watchpoints can see. Instruction fetches and DMA
+// transfers use peek instead.
 func (m *mmu) at(addr uint16) uint8 {
 	if m.db != nil {
 		m.db.memReadHook(addr)
 	}
 
+	return m.peek(addr)
+}
+
+// romBankAt returns the ROM bank mapped to the given address, or -1 if the
+// address isn't in ROM or the MBC can't report which bank is mapped.
+func (m *mmu) romBankAt(addr uint16) int {
+	switch {
+	case inBank0ROMArea(addr):
+		return 0
+	case inBankedROMArea(addr):
+		if banked, ok := m.mbc.(bankedROMMBC); ok {
+			return banked.currentROMBank()
+		}
+	}
+	return -1
+}
+
+// peek returns the value in the given address without notifying the
+// debugger.
+func (m *mmu) peek(addr uint16) uint8 {
 	switch {
-	case isUnmappedAddress[addr]:
+	case m.unmapped[addr]:
 		// Unmapped areas of memory always read 0xFF
 		return 0xFF
 	case inBootROMArea(addr):
@@ -118,24 +238,31 @@ func (m *mmu) at(addr uint16) uint8 {
 		if m.bootROMEnabled {
 			return m.bootROM[addr-bootROMAddr]
 		} else {
-			return m.mbc.at(addr)
+			return m.cheats.romRead(addr, m.mbc.at(addr))
 		}
+	case inCGBBootROMArea(addr) && m.bootROMEnabled && len(m.bootROM) == cgbBootROMEndAddr:
+		// The CGB boot ROM is mapped over part of ROM bank 0
+		return m.bootROM[addr]
 	case inBank0ROMArea(addr):
-		return m.mbc.at(addr)
+		return m.cheats.romRead(addr, m.mbc.at(addr))
 	case inBankedROMArea(addr):
 		// Some additional ROM bank, controlled by the MBC
-		return m.mbc.at(addr)
+		return m.cheats.romRead(addr, m.mbc.at(addr))
+	case inVideoRAMArea(addr):
+		return m.videoRAM[m.currVideoRAMBank][addr-videoRAMAddr]
 	case inBankedRAMArea(addr):
 		// The MBC handles RAM banking and availability
 		return m.mbc.at(addr)
+	case inRAMArea(addr):
+		return *m.ramAddress(addr)
 	case inRAMMirrorArea(addr):
-		// A bank 0 RAM mirror
-		return m.memory[addr-(ramMirrorAddr-ramAddr)]
+		// A mirror of built-in RAM
+		return *m.ramAddress(addr - (ramMirrorAddr - ramAddr))
 	case inInvalidArea(addr):
 		// Invalid area, which always returns 0xFF since it's the MMU's default
 		// value
 		if printWarnings {
-			fmt.Printf("Warning: Read from invalid memory address %#x\n", addr)
+			fmt.Fprintf(m.out, "Warning: Read from invalid memory address %#x\n", addr)
 		}
 		return 0xFF
 	case addr == ifAddr:
@@ -147,13 +274,18 @@ func (m *mmu) at(addr uint16) uint8 {
 	case addr == timaAddr:
 		return m.timers.tima
 	case addr == tacAddr:
-		return m.timers.tac
+		// The upper 5 bits are unused and always read as 1
+		return m.timers.tac | 0xF8
 	case addr == tmaAddr:
 		return m.timers.tma
 	case addr == lyAddr:
 		return m.videoController.ly
 	case addr == lycAddr:
 		return m.videoController.lyc
+	case addr == bcpdAddr && m.cgbMode:
+		return m.videoController.bgPaletteRAM[m.videoController.bgPaletteIndex]
+	case addr == ocpdAddr && m.cgbMode:
+		return m.videoController.spritePaletteRAM[m.videoController.spritePaletteIndex]
 	default:
 		return m.memory[addr]
 	}
@@ -161,13 +293,17 @@ func (m *mmu) at(addr uint16) uint8 {
 
 // tick progresses the MMU by one m-cycle.
 func (m *mmu) tick() {
+	if m.tickingMBC != nil {
+		m.tickingMBC.tick()
+	}
+
 	for i := 0; i < ticksPerMCycle; i++ {
 		if m.dmaActive {
 			// Work on a DMA transfer
 			lower, _ := split16(m.dmaCursor)
 			if lower <= 0x9F {
 				// Transfer a byte
-				m.setNoNotify(oamRAMAddr+uint16(lower), m.at(m.dmaCursor))
+				m.setNoNotify(oamRAMAddr+uint16(lower), m.peek(m.dmaCursor))
 
 				m.dmaCursor++
 			}
@@ -186,7 +322,7 @@ func (m *mmu) tick() {
 // that side effects may occur.
 func (m *mmu) set(addr uint16, val uint8) {
 	// Unmapped addresses cannot be written to
-	if isUnmappedAddress[addr] {
+	if m.unmapped[addr] {
 		return
 	}
 
@@ -198,6 +334,15 @@ func (m *mmu) set(addr uint16, val uint8) {
 	m.setNoNotify(addr, val)
 }
 
+// poke writes a value to the given address like set, but without notifying
+// the debugger.
+func (m *mmu) poke(addr uint16, val uint8) {
+	db := m.db
+	m.db = nil
+	m.set(addr, val)
+	m.db = db
+}
+
 // setNoNotify requests the MMU to set the value at the given address to the
 // given value. Subscribed devices are not notified. This is useful for devices
 // that might incorrectly trigger themselves when writing to a place in memory.
@@ -210,12 +355,18 @@ func (m *mmu) setNoNotify(addr uint16, val uint8) {
 	case inBank0ROMArea(addr) || inBankedROMArea(addr):
 		// "Writes" to ROM areas are used to control MBCs
 		m.mbc.set(addr, val)
+	case inVideoRAMArea(addr):
+		m.videoRAM[m.currVideoRAMBank][addr-videoRAMAddr] = val
 	case inBankedRAMArea(addr):
 		// The MBC handles RAM banking and availability
 		m.mbc.set(addr, val)
+	case inRAMArea(addr):
+		*m.ramAddress(addr) = val
+	case inRAMMirrorArea(addr):
+		*m.ramAddress(addr - (ramMirrorAddr - ramAddr)) = val
 	case inInvalidArea(addr):
 		if printWarnings {
-			fmt.Printf("Warning: Write to invalid area %#x\n", addr)
+			fmt.Fprintf(m.out, "Warning: Write to invalid area %#x\n", addr)
 		}
 	default:
 		m.memory[addr] = val
@@ -252,10 +403,105 @@ func (m *mmu) onDMAWrite(addr uint16, val uint8) uint8 {
 // to. It disables the boot ROM.
 func (m *mmu) onBootROMDisableWrite(addr uint16, val uint8) uint8 {
 	if m.bootROMEnabled {
-		fmt.Println("Disabled boot ROM")
+		fmt.Fprintln(m.out, "Disabled boot ROM")
 		m.bootROMEnabled = false
 	}
 
 	// This register always reads 0xFF
 	return 0xFF
 }
+
+// ramAddress returns a pointer to the value in built-in RAM at the given
+// address, taking the current RAM bank into account.
+func (m *mmu) ramAddress(addr uint16) *uint8 {
+	if addr < bankedInternalRAMAddr {
+		return &m.ramBanks[0][addr-ramAddr]
+	}
+	return &m.ramBanks[m.currRAMBank][addr-bankedInternalRAMAddr]
+}
+
+// onVBKWrite triggers when the VRAM bank register is written to. It switches
+// the VRAM bank mapped to 0x8000-0x9FFF.
+func (m *mmu) onVBKWrite(addr uint16, val uint8) uint8 {
+	m.currVideoRAMBank = int(val & 0x01)
+
+	// Unused bits 7-1 are always 1
+	return val | 0xFE
+}
+
+// onSVBKWrite triggers when the WRAM bank register is written to. It switches
+// the RAM bank mapped to 0xD000-0xDFFF.
+func (m *mmu) onSVBKWrite(addr uint16, val uint8) uint8 {
+	m.currRAMBank = int(val & 0x07)
+	if m.currRAMBank == 0 {
+		// Bank 0 can't be selected here, bank 1 is used instead
+		m.currRAMBank = 1
+	}
+
+	// Unused bits 7-3 are always 1
+	return val | 0xF8
+}
+
+// onKEY1Write triggers when the KEY1 register is written to. This prepares a
+// speed switch, which happens when the next STOP instruction is run.
+func (m *mmu) onKEY1Write(addr uint16, val uint8) uint8 {
+	// The current speed bit is read-only and unused bits are always 1
+	return (m.memory[key1Addr] & 0x80) | 0x7E | (val & 0x01)
+}
+
+// saveState writes the contents of memory and the progress of any DMA
+// transfers to a save state. The boot ROM and cartridge are not included.
+func (m *mmu) saveState(sw *stateWriter) {
+	sw.writeRaw(m.memory[:])
+	for _, bank := range m.ramBanks {
+		sw.writeBytes(bank)
+	}
+	for _, bank := range m.videoRAM {
+		sw.writeBytes(bank)
+	}
+
+	sw.write(m.bootROMEnabled)
+	sw.writeInt(m.currRAMBank)
+	sw.writeInt(m.currVideoRAMBank)
+
+	sw.write(m.hdmaActive)
+	sw.write(m.hdmaSource)
+	sw.write(m.hdmaDest)
+	sw.writeInt(m.hdmaRemaining)
+	sw.write(m.dmaActive)
+	sw.write(m.dmaCursor)
+	sw.writeInt(m.dmaCycleCount)
+}
+
+// loadState reads the contents of memory and the progress of any DMA
+// transfers from a save state.
+func (m *mmu) loadState(sr *stateReader) {
+	sr.read(m.memory[:])
+	for _, bank := range m.ramBanks {
+		sr.readBytesInto(bank)
+	}
+	for _, bank := range m.videoRAM {
+		sr.readBytesInto(bank)
+	}
+
+	m.bootROMEnabled = sr.readBool()
+	if m.bootROMEnabled && m.bootROM == nil {
+		sr.fail("save state was made during the boot sequence, but no boot ROM is loaded")
+	}
+	m.currRAMBank = sr.readInt()
+	if m.currRAMBank < 0 || m.currRAMBank >= len(m.ramBanks) {
+		sr.fail("invalid RAM bank %v", m.currRAMBank)
+	}
+	m.currVideoRAMBank = sr.readInt()
+	if m.currVideoRAMBank < 0 || m.currVideoRAMBank >= len(m.videoRAM) {
+		sr.fail("invalid VRAM bank %v", m.currVideoRAMBank)
+	}
+
+	m.hdmaActive = sr.readBool()
+	m.hdmaSource = sr.readUint16()
+	m.hdmaDest = sr.readUint16()
+	m.hdmaRemaining = sr.readInt()
+	m.dmaActive = sr.readBool()
+	m.dmaCursor = sr.readUint16()
+	m.dmaCycleCount = sr.readInt()
+}
@@ -0,0 +1,152 @@
+package gameboy
+
+import (
+	"testing"
+)
+
+func TestSVBK(t *testing.T) {
+	device := newCGBTestDevice(t)
+
+	if svbk := device.ReadMemory(svbkAddr); svbk != 0xF8 {
+		t.Fatalf("expected SVBK to start at 0xf8, got %#x", svbk)
+	}
+
+	// Put the bank number in each bank
+	for bank := 1; bank < 8; bank++ {
+		device.WriteMemory(svbkAddr, uint8(bank))
+		device.WriteMemory(0xD000, uint8(bank))
+	}
+	device.WriteMemory(0xC000, 0x10)
+
+	tests := []struct {
+		svbk     uint8
+		readback uint8
+		bank     uint8
+	}{
+		{0x01, 0xF9, 1},
+		{0x07, 0xFF, 7},
+		// Bank 0 can't be mapped to 0xD000, so bank 1 is used instead
+		{0x00, 0xF8, 1},
+		// Only the lower 3 bits select the bank
+		{0xFA, 0xFA, 2},
+	}
+	for _, test := range tests {
+		device.WriteMemory(svbkAddr, test.svbk)
+		if readback := device.ReadMemory(svbkAddr); readback != test.readback {
+			t.Errorf("SVBK %#x reads back as %#x, expected %#x", test.svbk, readback, test.readback)
+		}
+		if bank := device.ReadMemory(0xD000); bank != test.bank {
+			t.Errorf("SVBK %#x maps bank %v, expected %v", test.svbk, bank, test.bank)
+		}
+		// The echo follows the mapped bank too
+		if bank := device.ReadMemory(0xF000); bank != test.bank {
+			t.Errorf("SVBK %#x maps bank %v to the echo, expected %v", test.svbk, bank, test.bank)
+		}
+		// 0xC000-0xCFFF is always bank 0
+		if actual := device.ReadMemory(0xC000); actual != 0x10 {
+			t.Errorf("SVBK %#x changed 0xC000 to %#x", test.svbk, actual)
+		}
+	}
+}
+
+func TestVBK(t *testing.T) {
+	device := newCGBTestDevice(t)
+
+	if vbk := device.ReadMemory(vbkAddr); vbk != 0xFE {
+		t.Fatalf("expected VBK to start at 0xfe, got %#x", vbk)
+	}
+
+	device.WriteMemory(0x8000, 0x12)
+	device.WriteMemory(0x9FFF, 0x34)
+	device.WriteMemory(vbkAddr, 0x01)
+	if vbk := device.ReadMemory(vbkAddr); vbk != 0xFF {
+		t.Fatalf("expected VBK to read back as 0xff, got %#x", vbk)
+	}
+	if actual := device.ReadMemory(0x8000); actual != 0x00 {
+		t.Fatalf("expected bank 1 to be empty, got %#x", actual)
+	}
+	device.WriteMemory(0x8000, 0x56)
+	device.WriteMemory(0x9FFF, 0x78)
+
+	// Only bit 0 selects the bank
+	device.WriteMemory(vbkAddr, 0xFE)
+	if actual := device.ReadMemory(0x8000); actual != 0x12 {
+		t.Fatalf("expected bank 0 to have 0x12 at 0x8000, got %#x", actual)
+	}
+	if actual := device.ReadMemory(0x9FFF); actual != 0x34 {
+		t.Fatalf("expected bank 0 to have 0x34 at 0x9FFF, got %#x", actual)
+	}
+
+	vram := device.state.mmu.videoRAM
+	if vram[1][0x0000] != 0x56 || vram[1][0x1FFF] != 0x78 {
+		t.Fatalf("expected bank 1 to have 0x56 and 0x78, got %#x and %#x", vram[1][0x0000], vram[1][0x1FFF])
+	}
+}
+
+func TestSpeedSwitch(t *testing.T) {
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	copy(rom[0x0100:], []uint8{
+		0x3E, 0x01, // ld a, $01
+		0xE0, 0x4D, // ldh [rKEY1], a
+		0x10, 0x00, // stop
+		0x18, 0xFE, // $0106: jr $0106
+	})
+
+	tests := []struct {
+		name   string
+		model  Model
+		key1   uint8
+		double bool
+	}{
+		// Bit 7 reports double speed and the prepare bit is cleared
+		{"CGB", ModelCGB, 0xFE, true},
+		// KEY1 isn't there, so STOP stops the CPU instead
+		{"DMG", ModelDMG, 0xFF, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := newTestCartridgeDevice(t, rom, WithModel(test.model))
+
+			if err := device.RunFrame(); err != nil {
+				t.Fatalf("running: %v", err)
+			}
+
+			if key1 := device.ReadMemory(key1Addr); key1 != test.key1 {
+				t.Errorf("expected KEY1 to read %#x, got %#x", test.key1, key1)
+			}
+			if device.state.doubleSpeed != test.double {
+				t.Errorf("expected double speed to be %v", test.double)
+			}
+			if device.state.stopped != !test.double {
+				t.Errorf("expected stopped to be %v", !test.double)
+			}
+		})
+	}
+}
+
+func TestKEY1(t *testing.T) {
+	device := newCGBTestDevice(t)
+
+	if key1 := device.ReadMemory(key1Addr); key1 != 0x7E {
+		t.Fatalf("expected KEY1 to start at 0x7e, got %#x", key1)
+	}
+
+	// Only the prepare bit can be written
+	device.WriteMemory(key1Addr, 0x81)
+	if key1 := device.ReadMemory(key1Addr); key1 != 0x7F {
+		t.Fatalf("expected KEY1 to read 0x7f after preparing a switch, got %#x", key1)
+	}
+	device.WriteMemory(key1Addr, 0x00)
+	if key1 := device.ReadMemory(key1Addr); key1 != 0x7E {
+		t.Fatalf("expected KEY1 to read 0x7e after cancelling a switch, got %#x", key1)
+	}
+
+	// The current speed bit stays put when preparing a switch back
+	device.state.doubleSpeed = true
+	device.state.mmu.memory[key1Addr] = 0xFE
+	device.WriteMemory(key1Addr, 0x01)
+	if key1 := device.ReadMemory(key1Addr); key1 != 0xFF {
+		t.Fatalf("expected KEY1 to read 0xff after preparing a switch back, got %#x", key1)
+	}
+}
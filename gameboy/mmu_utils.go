@@ -4,55 +4,20 @@ import "fmt"
 
 // makeROMBanks creates the necessary amount of ROM banks as specified by the
 // given ROM size type, then returns it as a map whose key is a ROM bank number
-// and whose value is the corresponding ROM bank.
+// and whose value is the corresponding ROM bank. The cartridge data must
+// already be the size that the ROM size type describes.
 func makeROMBanks(romSizeType uint8, cartridgeData []uint8) [][]uint8 {
-	var romBankCount int
-
-	switch romSizeType {
-	case 0x00:
-		// A single ROM bank, no switching going on
-		romBankCount = 2
-	case 0x01:
-		// Four ROM banks, 16 KB in size
-		romBankCount = 4
-	case 0x02:
-		// Eight ROM banks, 16 KB in size
-		romBankCount = 8
-	case 0x03:
-		// Sixteen ROM banks, 16 KB in size
-		romBankCount = 16
-	case 0x04:
-		// Thirty-two ROM banks, 16 KB in size
-		romBankCount = 32
-	case 0x05:
-		// Sixty-four ROM banks, 16 KB in size
-		romBankCount = 64
-	case 0x06:
-		// 128 ROM banks, 16 KB in size
-		romBankCount = 128
-	case 0x07:
-		// 256 ROM banks, 16 KB in size
-		romBankCount = 256
-	case 0x08:
-		// 512 ROM banks, 16 KB in size
-		romBankCount = 512
-	default:
+	romBankCount, ok := romBankCounts[romSizeType]
+	if !ok {
 		panic(fmt.Sprintf("Unsupported ROM size type %v", romSizeType))
 	}
 
 	romBanks := make([][]uint8, romBankCount)
 
-	// Create the ROM banks
-	for i := 0; i < romBankCount; i++ {
-		romBanks[i] = make([]uint8, 0x4000)
-	}
-
-	// Put cartridge data into each bank
-	for bank, data := range romBanks {
-		startAddr := 0x4000 * int(bank)
-		for i := startAddr; i < startAddr+0x4000; i++ {
-			data[i-startAddr] = cartridgeData[i]
-		}
+	// Create the ROM banks and put cartridge data into each one
+	for bank := range romBanks {
+		romBanks[bank] = make([]uint8, 0x4000)
+		copy(romBanks[bank], cartridgeData[0x4000*bank:])
 	}
 
 	return romBanks
@@ -97,6 +62,10 @@ func inBootROMArea(addr uint16) bool {
 	return addr < bootROMEndAddr
 }
 
+func inCGBBootROMArea(addr uint16) bool {
+	return addr >= cgbBootROMAddr && addr < cgbBootROMEndAddr
+}
+
 func inBank0ROMArea(addr uint16) bool {
 	return addr < bankedROMAddr
 }
@@ -153,4 +122,15 @@ const (
 	ioAddr           = 0xFF00
 	hramAddr         = 0xFF80
 	lastAddr         = 0xFFFF
+
+	// The CGB boot ROM is also mapped to this area, in addition to the usual
+	// boot ROM area.
+	cgbBootROMAddr    = 0x0200
+	cgbBootROMEndAddr = 0x0900
+
+	// bankedInternalRAMAddr is the start of the switchable bank of built-in
+	// RAM.
+	bankedInternalRAMAddr = 0xD000
+	// ramBankSize is the size of a bank of built-in RAM.
+	ramBankSize = 0x1000
 )
@@ -0,0 +1,65 @@
+package gameboy
+
+// Model is a Game Boy hardware model that the device can emulate.
+type Model int
+
+const (
+	// ModelAuto picks a model based on the cartridge header and boot ROM. The
+	// CGB is used if the game supports it and either a CGB boot ROM is
+	// provided or the boot ROM is skipped. Otherwise, the DMG is used.
+	ModelAuto Model = iota
+	// ModelDMG0 is an early revision of the original Game Boy, only released
+	// in Japan.
+	ModelDMG0
+	// ModelDMG is the original Game Boy.
+	ModelDMG
+	// ModelMGB is the Game Boy Pocket.
+	ModelMGB
+	// ModelSGB is the Super Game Boy. SGB-specific functionality is not
+	// emulated, but the CPU and hardware registers start in the state the SGB
+	// boot ROM leaves them in.
+	ModelSGB
+	// ModelCGB is the Game Boy Color.
+	ModelCGB
+)
+
+func (m Model) String() string {
+	switch m {
+	case ModelAuto:
+		return "Auto"
+	case ModelDMG0:
+		return "DMG0"
+	case ModelDMG:
+		return "DMG"
+	case ModelMGB:
+		return "MGB"
+	case ModelSGB:
+		return "SGB"
+	case ModelCGB:
+		return "CGB"
+	default:
+		return "Unknown"
+	}
+}
+
+// isCGB returns true if this model has CGB hardware.
+func (m Model) isCGB() bool {
+	return m == ModelCGB
+}
+
+// resolveModel decides which model to emulate for ModelAuto based on the
+// cartridge header and boot ROM. A nil boot ROM means that the boot sequence
+// is being skipped.
+func resolveModel(model Model, header romHeader, bootROM []byte) Model {
+	if model != ModelAuto {
+		return model
+	}
+
+	// Bit 7 of the CGB flag is set for games that support CGB functions
+	cgbGame := header.cgbFlag&0x80 == 0x80
+	if cgbGame && (bootROM == nil || len(bootROM) == cgbBootROMEndAddr) {
+		return ModelCGB
+	}
+
+	return ModelDMG
+}
@@ -0,0 +1,111 @@
+package gameboy
+
+import "io"
+
+// Option configures optional behavior of a Device.
+type Option func(*deviceOptions)
+
+// deviceOptions contains the configuration set by Options.
+type deviceOptions struct {
+	model        Model
+	skipBootROM  bool
+	rewindBudget int
+	serial       SerialDriver
+	imageSource  ImageSourceDriver
+	tilt         TiltDriver
+	infrared     InfraredDriver
+	clock        Clock
+	output       io.Writer
+
+	validateHeaderChecksum bool
+}
+
+// WithModel sets the hardware model that the device emulates. By default,
+// ModelAuto is used.
+func WithModel(model Model) Option {
+	return func(opts *deviceOptions) {
+		opts.model = model
+	}
+}
+
+// WithoutBootROM skips the boot sequence. Instead of running a boot ROM, the
+// device starts at the beginning of the game with CPU and hardware registers
+// set to the values the selected model's boot ROM leaves them in. The boot ROM
+// given to NewDevice is ignored and may be nil.
+func WithoutBootROM() Option {
+	return func(opts *deviceOptions) {
+		opts.skipBootROM = true
+	}
+}
+
+// WithHeaderChecksumValidation makes NewDevice return ErrBadHeaderChecksum
+// for games with a bad header checksum, since the boot ROM would refuse to
+// start them. By default, only a warning is printed, because homebrew games
+// and ROM hacks often don't fix up the header.
+func WithHeaderChecksumValidation() Option {
+	return func(opts *deviceOptions) {
+		opts.validateHeaderChecksum = true
+	}
+}
+
+// WithRewind enables rewinding with Device.Rewind. Snapshots of the device are
+// periodically taken and compressed in the background. The given budget is
+// the maximum number of bytes that the compressed snapshots may use. Older
+// snapshots are discarded to stay under the budget.
+func WithRewind(budget int) Option {
+	return func(opts *deviceOptions) {
+		opts.rewindBudget = budget
+	}
+}
+
+// WithSerial connects the device's link port using the given serial driver.
+// By default, the link port acts like nothing is connected.
+func WithSerial(driver SerialDriver) Option {
+	return func(opts *deviceOptions) {
+		opts.serial = driver
+	}
+}
+
+// WithImageSource sets the image source used by the Game Boy Camera. By
+// default, the camera sees a plain gray image. This option does nothing for
+// other cartridges.
+func WithImageSource(driver ImageSourceDriver) Option {
+	return func(opts *deviceOptions) {
+		opts.imageSource = driver
+	}
+}
+
+// WithTilt sets the driver used by cartridges with an accelerometer to find
+// out how the Game Boy is tilted. By default, the Game Boy is always level.
+// This option does nothing for other cartridges.
+func WithTilt(driver TiltDriver) Option {
+	return func(opts *deviceOptions) {
+		opts.tilt = driver
+	}
+}
+
+// WithInfrared connects the infrared port of cartridges that have one using
+// the given driver. By default, the port never receives any light. This option
+// does nothing for other cartridges.
+func WithInfrared(driver InfraredDriver) Option {
+	return func(opts *deviceOptions) {
+		opts.infrared = driver
+	}
+}
+
+// WithClock sets the source of the current time for cartridges with a real
+// time clock, like MBC3 cartridges with an RTC. By default, the system time is
+// used. This option does nothing for other cartridges.
+func WithClock(clock Clock) Option {
+	return func(opts *deviceOptions) {
+		opts.clock = clock
+	}
+}
+
+// WithOutput sets where the device prints information and warnings, like the
+// cartridge header and problems with the ROM. By default, os.Stdout is used.
+func WithOutput(w io.Writer) Option {
+	return func(opts *deviceOptions) {
+		opts.output = w
+	}
+}
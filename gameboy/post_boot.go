@@ -0,0 +1,165 @@
+package gameboy
+
+import "fmt"
+
+// cpuRegisterDefaults contains the values of the CPU registers after a boot ROM
+// has finished running.
+type cpuRegisterDefaults struct {
+	a, f, b, c, d, e, h, l uint8
+}
+
+// ioRegisterDefault is the value of a memory register after a boot ROM has
+// finished running.
+type ioRegisterDefault struct {
+	addr uint16
+	val  uint8
+}
+
+// postBootCPURegisters returns the values that the given model's boot ROM
+// leaves the CPU registers in. Some values depend on the cartridge header.
+func postBootCPURegisters(model Model, header romHeader) cpuRegisterDefaults {
+	// On the DMG and MGB, the half carry and carry flags are left set unless
+	// the header checksum is zero
+	dmgFlags := uint8(0xB0)
+	if header.headerChecksum == 0 {
+		dmgFlags = 0x80
+	}
+
+	switch model {
+	case ModelDMG0:
+		return cpuRegisterDefaults{
+			a: 0x01, f: 0x00, b: 0xFF, c: 0x13, d: 0x00, e: 0xC1, h: 0x84, l: 0x03}
+	case ModelDMG:
+		return cpuRegisterDefaults{
+			a: 0x01, f: dmgFlags, b: 0x00, c: 0x13, d: 0x00, e: 0xD8, h: 0x01, l: 0x4D}
+	case ModelMGB:
+		return cpuRegisterDefaults{
+			a: 0xFF, f: dmgFlags, b: 0x00, c: 0x13, d: 0x00, e: 0xD8, h: 0x01, l: 0x4D}
+	case ModelSGB:
+		return cpuRegisterDefaults{
+			a: 0x01, f: 0x00, b: 0x00, c: 0x14, d: 0x00, e: 0x00, h: 0xC0, l: 0x60}
+	case ModelCGB:
+		return cpuRegisterDefaults{
+			a: 0x11, f: 0x80, b: 0x00, c: 0x00, d: 0xFF, e: 0x56, h: 0x00, l: 0x0D}
+	default:
+		panic(fmt.Sprintf("no post-boot CPU register values for model %v", model))
+	}
+}
+
+// postBootDividers contains the value of the divider register after each
+// model's boot ROM has finished running. The SGB and CGB values depend on how
+// long the boot sequence took, so an approximate value is used.
+var postBootDividers = map[Model]uint8{
+	ModelDMG0: 0x18,
+	ModelDMG:  0xAB,
+	ModelMGB:  0xAB,
+	ModelSGB:  0x00,
+	ModelCGB:  0x1E,
+}
+
+// postBootIORegisters returns the values that the given model's boot ROM
+// leaves memory registers in.
+func postBootIORegisters(model Model) []ioRegisterDefault {
+	sc := uint8(0x7E)
+	nr52 := uint8(0xF1)
+	stat := uint8(0x85)
+	dma := uint8(0xFF)
+	switch model {
+	case ModelDMG0:
+		stat = 0x81
+	case ModelSGB:
+		// The SGB boot ROM doesn't play a sound, so Pulse A is left off
+		nr52 = 0xF0
+	case ModelCGB:
+		// The CGB has a high speed serial mode that uses bit 1
+		sc = 0x7F
+		dma = 0x00
+	}
+
+	return []ioRegisterDefault{
+		{p1Addr, 0xCF},
+		{sbAddr, 0x00},
+		{scAddr, sc},
+		{timaAddr, 0x00},
+		{tmaAddr, 0x00},
+		{tacAddr, 0xF8},
+		{ifAddr, 0xE1},
+		{nr10Addr, 0x80},
+		{nr11Addr, 0xBF},
+		{nr12Addr, 0xF3},
+		{nr13Addr, 0xFF},
+		{nr14Addr, 0xBF},
+		{nr21Addr, 0x3F},
+		{nr22Addr, 0x00},
+		{nr23Addr, 0xFF},
+		{nr24Addr, 0xBF},
+		{nr30Addr, 0x7F},
+		{nr31Addr, 0xFF},
+		{nr32Addr, 0x9F},
+		{nr33Addr, 0xFF},
+		{nr34Addr, 0xBF},
+		{nr41Addr, 0xFF},
+		{nr42Addr, 0x00},
+		{nr43Addr, 0x00},
+		{nr44Addr, 0xBF},
+		{nr50Addr, 0x77},
+		{nr51Addr, 0xF3},
+		{nr52Addr, nr52},
+		{lcdcAddr, 0x91},
+		{statAddr, stat},
+		{scrollYAddr, 0x00},
+		{scrollXAddr, 0x00},
+		{lyAddr, 0x00},
+		{lycAddr, 0x00},
+		{dmaAddr, dma},
+		{bgpAddr, 0xFC},
+		{obp0Addr, 0xFF},
+		{obp1Addr, 0xFF},
+		{windowPosYAddr, 0x00},
+		{windowPosXAddr, 0x00},
+		{ieAddr, 0x00},
+	}
+}
+
+// loadPostBootState puts the device in the state that the boot ROM leaves it
+// in, so that the game can be started without running the boot sequence.
+func (device *Device) loadPostBootState() {
+	state := device.state
+
+	regs := postBootCPURegisters(device.model, device.header)
+	state.regA.set(regs.a)
+	state.regF.set(regs.f)
+	state.regB.set(regs.b)
+	state.regC.set(regs.c)
+	state.regD.set(regs.d)
+	state.regE.set(regs.e)
+	state.regH.set(regs.h)
+	state.regL.set(regs.l)
+	state.regSP.set(0xFFFE)
+	state.regPC.set(0x0100)
+	state.instructionDone()
+
+	for _, reg := range postBootIORegisters(device.model) {
+		switch reg.addr {
+		case dmaAddr, nr14Addr, nr24Addr, nr34Addr, nr44Addr:
+			// Writes to these registers start DMA transfers or restart sound
+			// voices, so the values are placed in memory directly
+			state.mmu.memory[reg.addr] = reg.val
+		default:
+			state.mmu.set(reg.addr, reg.val)
+		}
+	}
+
+	// The divider is the upper byte of the CPU clock
+	device.timers.cpuClock = uint16(postBootDividers[device.model]) << 6
+	device.timers.divider = postBootDividers[device.model]
+
+	if device.model.isCGB() {
+		// The CGB boot ROM sets every background color to white
+		for i := range device.videoController.bgPaletteRAM {
+			device.videoController.bgPaletteRAM[i] = 0xFF
+		}
+	}
+
+	state.mmu.bootROMEnabled = false
+}
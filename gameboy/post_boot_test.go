@@ -0,0 +1,151 @@
+package gameboy
+
+import (
+	"testing"
+)
+
+// newZeroChecksumCartridge returns a ROM whose header checksum is zero.
+func newZeroChecksumCartridge() []uint8 {
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	// Lowering the checksum by one takes raising a byte in the header by one
+	rom[0x014C] += headerChecksum(rom)
+	rom[headerChecksumAddr] = headerChecksum(rom)
+	return rom
+}
+
+func TestPostBootCPURegisters(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        Model
+		zeroChecksum bool
+		expected     Registers
+	}{
+		{"DMG0", ModelDMG0, false, Registers{
+			A: 0x01, F: 0x00, B: 0xFF, C: 0x13, D: 0x00, E: 0xC1, H: 0x84, L: 0x03}},
+		// The DMG and MGB leave the half carry and carry flags set unless the
+		// header checksum is zero
+		{"DMG", ModelDMG, false, Registers{
+			A: 0x01, F: 0xB0, B: 0x00, C: 0x13, D: 0x00, E: 0xD8, H: 0x01, L: 0x4D}},
+		{"DMG with a zero checksum", ModelDMG, true, Registers{
+			A: 0x01, F: 0x80, B: 0x00, C: 0x13, D: 0x00, E: 0xD8, H: 0x01, L: 0x4D}},
+		{"MGB", ModelMGB, false, Registers{
+			A: 0xFF, F: 0xB0, B: 0x00, C: 0x13, D: 0x00, E: 0xD8, H: 0x01, L: 0x4D}},
+		{"MGB with a zero checksum", ModelMGB, true, Registers{
+			A: 0xFF, F: 0x80, B: 0x00, C: 0x13, D: 0x00, E: 0xD8, H: 0x01, L: 0x4D}},
+		{"SGB", ModelSGB, false, Registers{
+			A: 0x01, F: 0x00, B: 0x00, C: 0x14, D: 0x00, E: 0x00, H: 0xC0, L: 0x60}},
+		{"CGB", ModelCGB, false, Registers{
+			A: 0x11, F: 0x80, B: 0x00, C: 0x00, D: 0xFF, E: 0x56, H: 0x00, L: 0x0D}},
+		{"CGB with a zero checksum", ModelCGB, true, Registers{
+			A: 0x11, F: 0x80, B: 0x00, C: 0x00, D: 0xFF, E: 0x56, H: 0x00, L: 0x0D}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rom := newTestCartridge(0x00, 0x00, 0x00)
+			if test.zeroChecksum {
+				rom = newZeroChecksumCartridge()
+			}
+			if checksum := rom[headerChecksumAddr]; (checksum == 0) != test.zeroChecksum {
+				t.Fatalf("unexpected header checksum %#x", checksum)
+			}
+
+			device := newTestCartridgeDevice(t, rom, WithModel(test.model))
+
+			expected := test.expected
+			expected.SP = 0xFFFE
+			expected.PC = 0x0100
+			if actual := device.Registers(); actual != expected {
+				t.Fatalf("expected %+v, got %+v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestPostBootIORegisters(t *testing.T) {
+	// The values of registers that are the same on every model
+	common := map[uint16]uint8{
+		p1Addr:         0xCF,
+		sbAddr:         0x00,
+		timaAddr:       0x00,
+		tmaAddr:        0x00,
+		tacAddr:        0xF8,
+		ifAddr:         0xE1,
+		nr10Addr:       0x80,
+		nr11Addr:       0xBF,
+		nr12Addr:       0xF3,
+		nr21Addr:       0x3F,
+		nr22Addr:       0x00,
+		nr30Addr:       0x7F,
+		nr32Addr:       0x9F,
+		nr42Addr:       0x00,
+		nr43Addr:       0x00,
+		nr50Addr:       0x77,
+		nr51Addr:       0xF3,
+		lcdcAddr:       0x91,
+		scrollYAddr:    0x00,
+		scrollXAddr:    0x00,
+		lyAddr:         0x00,
+		lycAddr:        0x00,
+		bgpAddr:        0xFC,
+		windowPosYAddr: 0x00,
+		windowPosXAddr: 0x00,
+		ieAddr:         0x00,
+	}
+
+	tests := []struct {
+		name  string
+		model Model
+		// expected contains values that differ between models.
+		expected map[uint16]uint8
+	}{
+		{"DMG0", ModelDMG0, map[uint16]uint8{
+			scAddr: 0x7E, dividerAddr: 0x18, nr52Addr: 0xF1, statAddr: 0x81, dmaAddr: 0xFF}},
+		{"DMG", ModelDMG, map[uint16]uint8{
+			scAddr: 0x7E, dividerAddr: 0xAB, nr52Addr: 0xF1, statAddr: 0x85, dmaAddr: 0xFF}},
+		{"MGB", ModelMGB, map[uint16]uint8{
+			scAddr: 0x7E, dividerAddr: 0xAB, nr52Addr: 0xF1, statAddr: 0x85, dmaAddr: 0xFF}},
+		// The SGB boot ROM doesn't play a sound
+		{"SGB", ModelSGB, map[uint16]uint8{
+			scAddr: 0x7E, nr52Addr: 0xF0, statAddr: 0x85, dmaAddr: 0xFF}},
+		{"CGB", ModelCGB, map[uint16]uint8{
+			scAddr: 0x7F, nr52Addr: 0xF1, statAddr: 0x85, dmaAddr: 0x00,
+			key1Addr: 0x7E, svbkAddr: 0xF8, hdma5Addr: 0xFF}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := newTestCartridgeDevice(t, newTestCartridge(0x00, 0x00, 0x00), WithModel(test.model))
+
+			expected := make(map[uint16]uint8)
+			for addr, val := range common {
+				expected[addr] = val
+			}
+			for addr, val := range test.expected {
+				expected[addr] = val
+			}
+
+			for addr, val := range expected {
+				if actual := device.ReadMemory(addr); actual != val {
+					name := hardwareRegisterNames[addr]
+					t.Errorf("expected %v to be %#02x, got %#02x", name, val, actual)
+				}
+			}
+
+			if device.state.mmu.bootROMEnabled {
+				t.Errorf("expected the boot ROM to be disabled")
+			}
+		})
+	}
+}
+
+func TestPostBootCGBPalettes(t *testing.T) {
+	device := newTestCartridgeDevice(t, newTestCartridge(0x00, 0x00, 0x00), WithModel(ModelCGB))
+
+	// Every background color is white
+	for i, val := range device.videoController.bgPaletteRAM {
+		if val != 0xFF {
+			t.Fatalf("expected background palette RAM to be 0xff, got %#x at %v", val, i)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package gameboy
+
+import (
+	"image"
+	imagecolor "image/color"
+)
+
+// Printer packet commands.
+const (
+	// printerInit clears the printer's image buffer.
+	printerInit = 0x01
+	// printerPrint prints the contents of the image buffer.
+	printerPrint = 0x02
+	// printerData adds image data to the buffer. An empty data packet marks
+	// the end of the image data.
+	printerData = 0x04
+	// printerStatus asks for the printer's status without doing anything.
+	printerStatus = 0x0F
+)
+
+// Printer status flags, sent back at the end of every packet. The printer can
+// also report a paper jam (bit 5), other errors (bit 6) and low batteries
+// (bit 7), but these never happen to an emulated printer.
+const (
+	printerStatusChecksumError = 0x01
+	printerStatusBusy          = 0x02
+	printerStatusImageFull     = 0x04
+	printerStatusUnprocessed   = 0x08
+	printerStatusPacketError   = 0x10
+)
+
+const (
+	// printerMagic1 and printerMagic2 start every packet.
+	printerMagic1 = 0x88
+	printerMagic2 = 0x33
+	// printerAlive is sent back after the checksum to show that a printer is
+	// connected.
+	printerAlive = 0x81
+
+	// printerBandSize is the number of bytes in a band of image data, which
+	// is two rows of 20 tiles.
+	printerBandSize = 640
+	// printerBufferSize is the size of the printer's image buffer, which fits
+	// 9 bands or 144 rows.
+	printerBufferSize = printerBandSize * 9
+	// printerWidth is the width of printed images in pixels.
+	printerWidth = 160
+	// printerBusyPackets is the number of packets that the printer reports
+	// being busy for after a print starts.
+	printerBusyPackets = 4
+)
+
+// printerPacketStep is the part of a packet that the printer expects next.
+type printerPacketStep int
+
+const (
+	printerStepMagic1 printerPacketStep = iota
+	printerStepMagic2
+	printerStepCommand
+	printerStepCompression
+	printerStepLengthLow
+	printerStepLengthHigh
+	printerStepData
+	printerStepChecksumLow
+	printerStepChecksumHigh
+	printerStepAlive
+	printerStepStatus
+)
+
+// printerShades maps printer shades to gray levels.
+var printerShades = [4]uint8{0xFF, 0xAA, 0x55, 0x00}
+
+// Printer emulates the Game Boy Printer. It's a serial driver that games talk
+// to over the link port, and sends finished prints to a PrinterDriver.
+//
+// Games often print an image in multiple parts, with no margin between them.
+// Parts are joined together until one is printed with a bottom margin, which
+// finishes the print.
+type Printer struct {
+	driver PrinterDriver
+
+	// step is the part of a packet expected next.
+	step printerPacketStep
+	// The packet being received
+	command    uint8
+	compressed bool
+	length     uint16
+	data       []uint8
+	checksum   uint16
+	sum        uint16
+
+	// buffer is the image data waiting to be printed, in tile format.
+	buffer []uint8
+	// page contains the rows of shades printed so far for the current print.
+	page [][]uint8
+	// topMargin is the top margin of the current print.
+	topMargin int
+
+	// status is sent back at the end of every packet.
+	status uint8
+	// busyPackets is the number of packets left that the printer will report
+	// being busy for.
+	busyPackets int
+	// err is the last error returned by the driver.
+	err error
+}
+
+// NewPrinter creates a Game Boy Printer that sends finished prints to the
+// given driver. The printer should be given to the device as its serial
+// driver.
+func NewPrinter(driver PrinterDriver) *Printer {
+	return &Printer{driver: driver}
+}
+
+// Err returns the last error that the driver returned while printing, or nil
+// if there hasn't been one.
+func (p *Printer) Err() error {
+	return p.err
+}
+
+// Transfer receives a byte of a packet from the Game Boy and returns the
+// printer's response.
+func (p *Printer) Transfer(out uint8) uint8 {
+	switch p.step {
+	case printerStepMagic1:
+		if out == printerMagic1 {
+			p.step = printerStepMagic2
+		}
+	case printerStepMagic2:
+		if out == printerMagic2 {
+			p.step = printerStepCommand
+		} else {
+			p.step = printerStepMagic1
+		}
+	case printerStepCommand:
+		p.command = out
+		p.sum = uint16(out)
+		p.data = p.data[:0]
+		p.step = printerStepCompression
+	case printerStepCompression:
+		p.compressed = out&0x01 == 0x01
+		p.sum += uint16(out)
+		p.step = printerStepLengthLow
+	case printerStepLengthLow:
+		p.length = uint16(out)
+		p.sum += uint16(out)
+		p.step = printerStepLengthHigh
+	case printerStepLengthHigh:
+		p.length |= uint16(out) << 8
+		p.sum += uint16(out)
+		if p.length == 0 {
+			p.step = printerStepChecksumLow
+		} else {
+			p.step = printerStepData
+		}
+	case printerStepData:
+		p.data = append(p.data, out)
+		p.sum += uint16(out)
+		if len(p.data) >= int(p.length) {
+			p.step = printerStepChecksumLow
+		}
+	case printerStepChecksumLow:
+		p.checksum = uint16(out)
+		p.step = printerStepChecksumHigh
+	case printerStepChecksumHigh:
+		p.checksum |= uint16(out) << 8
+		p.step = printerStepAlive
+		p.handlePacket()
+	case printerStepAlive:
+		p.step = printerStepStatus
+		return printerAlive
+	case printerStepStatus:
+		p.step = printerStepMagic1
+		return p.status
+	}
+
+	return 0x00
+}
+
+// SetPending does nothing, since the printer never provides the clock.
+func (p *Printer) SetPending(out uint8, pending bool) {
+}
+
+// Receive always reports that no transfer has been started, since the printer
+// never provides the clock.
+func (p *Printer) Receive() (uint8, bool) {
+	return 0, false
+}
+
+// handlePacket runs the command in the packet that was just received and
+// updates the status to send back.
+func (p *Printer) handlePacket() {
+	if p.busyPackets > 0 {
+		p.busyPackets--
+		if p.busyPackets == 0 {
+			p.status &^= printerStatusBusy
+		}
+	}
+
+	if p.checksum != p.sum {
+		p.status |= printerStatusChecksumError
+		return
+	}
+	p.status &^= printerStatusChecksumError | printerStatusPacketError
+
+	switch p.command {
+	case printerInit:
+		p.buffer = p.buffer[:0]
+		p.status = 0
+		p.busyPackets = 0
+	case printerData:
+		if len(p.data) == 0 {
+			// The end of the image data
+			break
+		}
+
+		data := p.data
+		if p.compressed {
+			data = printerDecompress(data)
+		}
+		p.buffer = append(p.buffer, data...)
+		if len(p.buffer) > printerBufferSize {
+			p.buffer = p.buffer[:printerBufferSize]
+		}
+
+		p.status |= printerStatusUnprocessed
+		if len(p.buffer) == printerBufferSize {
+			p.status |= printerStatusImageFull
+		}
+	case printerPrint:
+		if len(p.data) != 4 {
+			p.status |= printerStatusPacketError
+			return
+		}
+		// The first byte is the number of copies, which isn't useful here.
+		// The last byte is the exposure, which isn't emulated.
+		margins := p.data[1]
+		palette := p.data[2]
+
+		p.print(int(margins>>4), int(margins&0xF), palette)
+
+		p.buffer = p.buffer[:0]
+		p.status &^= printerStatusUnprocessed | printerStatusImageFull
+		p.status |= printerStatusBusy
+		p.busyPackets = printerBusyPackets
+	case printerStatus:
+		// Nothing to do but send the status back
+	default:
+		p.status |= printerStatusPacketError
+	}
+}
+
+// print adds the image buffer to the current print using the given palette.
+// If the bottom margin is not zero, the print is finished and sent to the
+// driver.
+func (p *Printer) print(topMargin, bottomMargin int, palette uint8) {
+	if len(p.page) == 0 {
+		p.topMargin = topMargin
+	}
+
+	if palette == 0 {
+		// Some games send no palette, which is treated like the default one
+		palette = 0xE4
+	}
+
+	// Decode the tiles into rows of shades
+	bands := len(p.buffer) / printerBandSize
+	for band := 0; band < bands; band++ {
+		for tileRow := 0; tileRow < 2; tileRow++ {
+			for y := 0; y < 8; y++ {
+				row := make([]uint8, printerWidth)
+				for tileX := 0; tileX < printerWidth/8; tileX++ {
+					tileStart := band*printerBandSize + (tileRow*(printerWidth/8)+tileX)*16
+					lower := p.buffer[tileStart+y*2]
+					upper := p.buffer[tileStart+y*2+1]
+
+					for x := 0; x < 8; x++ {
+						bit := uint(7 - x)
+						index := ((upper>>bit)&0x1)<<1 | (lower>>bit)&0x1
+						row[tileX*8+x] = (palette >> (index * 2)) & 0x3
+					}
+				}
+				p.page = append(p.page, row)
+			}
+		}
+	}
+
+	if bottomMargin == 0 {
+		return
+	}
+
+	img := image.NewGray(image.Rect(0, 0, printerWidth, len(p.page)))
+	for y, row := range p.page {
+		for x, shade := range row {
+			img.SetGray(x, y, imagecolor.Gray{Y: printerShades[shade]})
+		}
+	}
+	p.page = nil
+
+	if err := p.driver.Print(img, p.topMargin, bottomMargin); err != nil {
+		p.err = err
+	}
+}
+
+// printerDecompress decompresses run-length encoded image data. Each run
+// starts with a control byte. If bit 7 is set, the next byte is repeated
+// (control & 0x7F) + 2 times. Otherwise, the next (control + 1) bytes are
+// copied as-is.
+func printerDecompress(data []uint8) []uint8 {
+	var output []uint8
+
+	for i := 0; i < len(data); {
+		control := data[i]
+		i++
+
+		if control&0x80 == 0x80 {
+			if i >= len(data) {
+				break
+			}
+			count := int(control&0x7F) + 2
+			for j := 0; j < count; j++ {
+				output = append(output, data[i])
+			}
+			i++
+		} else {
+			count := int(control) + 1
+			for j := 0; j < count && i < len(data); j++ {
+				output = append(output, data[i])
+				i++
+			}
+		}
+	}
+
+	return output
+}
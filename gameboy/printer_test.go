@@ -0,0 +1,183 @@
+package gameboy
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// testPrinterDriver records prints.
+type testPrinterDriver struct {
+	prints []testPrint
+	err    error
+}
+
+type testPrint struct {
+	img                     image.Image
+	topMargin, bottomMargin int
+}
+
+func (driver *testPrinterDriver) Print(img image.Image, topMargin, bottomMargin int) error {
+	driver.prints = append(driver.prints, testPrint{img, topMargin, bottomMargin})
+	return driver.err
+}
+
+// sendPrinterPacket sends a packet to the printer like a game would, and
+// returns the two bytes that the printer sends back at the end.
+func sendPrinterPacket(p *Printer, command uint8, compressed bool, data []uint8) (alive, status uint8) {
+	var compression uint8
+	if compressed {
+		compression = 0x01
+	}
+
+	body := []uint8{command, compression, uint8(len(data)), uint8(len(data) >> 8)}
+	body = append(body, data...)
+	var checksum uint16
+	for _, b := range body {
+		checksum += uint16(b)
+	}
+
+	p.Transfer(printerMagic1)
+	p.Transfer(printerMagic2)
+	for _, b := range body {
+		p.Transfer(b)
+	}
+	p.Transfer(uint8(checksum))
+	p.Transfer(uint8(checksum >> 8))
+
+	return p.Transfer(0x00), p.Transfer(0x00)
+}
+
+func TestPrinterDecompress(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []uint8
+		expected []uint8
+	}{
+		{"literal run", []uint8{0x02, 0x01, 0x02, 0x03}, []uint8{0x01, 0x02, 0x03}},
+		{"repeated run", []uint8{0x81, 0xAA}, []uint8{0xAA, 0xAA, 0xAA}},
+		{"mixed runs", []uint8{0x80, 0x11, 0x00, 0x22, 0x80, 0x33},
+			[]uint8{0x11, 0x11, 0x22, 0x33, 0x33}},
+		{"truncated literal run", []uint8{0x03, 0x01}, []uint8{0x01}},
+		{"truncated repeated run", []uint8{0x00, 0x01, 0x85}, []uint8{0x01}},
+		{"empty", nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := printerDecompress(test.data)
+			if !bytes.Equal(actual, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPrinterPackets(t *testing.T) {
+	p := NewPrinter(&testPrinterDriver{})
+
+	alive, status := sendPrinterPacket(p, printerStatus, false, nil)
+	if alive != printerAlive {
+		t.Errorf("expected the alive byte %#x, got %#x", printerAlive, alive)
+	}
+	if status != 0 {
+		t.Errorf("expected a status of 0, got %#x", status)
+	}
+
+	// Bytes before the magic bytes are ignored
+	p.Transfer(0x00)
+	p.Transfer(0x12)
+	_, status = sendPrinterPacket(p, printerData, false, make([]uint8, 16))
+	if status != printerStatusUnprocessed {
+		t.Errorf("expected unprocessed data after a data packet, got a status of %#x", status)
+	}
+
+	_, status = sendPrinterPacket(p, 0x7F, false, nil)
+	if status&printerStatusPacketError == 0 {
+		t.Errorf("an unknown command didn't cause a packet error, status is %#x", status)
+	}
+
+	// A packet with a bad checksum
+	for _, b := range []uint8{printerMagic1, printerMagic2, printerStatus, 0, 0, 0, 0xFF, 0xFF} {
+		p.Transfer(b)
+	}
+	p.Transfer(0x00)
+	if status := p.Transfer(0x00); status&printerStatusChecksumError == 0 {
+		t.Errorf("a bad checksum wasn't reported, status is %#x", status)
+	}
+
+	_, status = sendPrinterPacket(p, printerInit, false, nil)
+	if status != 0 {
+		t.Errorf("expected init to clear the status, got %#x", status)
+	}
+}
+
+func TestPrinterPrint(t *testing.T) {
+	driver := &testPrinterDriver{}
+	p := NewPrinter(driver)
+
+	// A band of black, with color 3 in every pixel
+	black := make([]uint8, printerBandSize)
+	for i := range black {
+		black[i] = 0xFF
+	}
+	// The same band, compressed
+	compressedBlack := []uint8{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFA, 0xFF}
+
+	sendPrinterPacket(p, printerInit, false, nil)
+	sendPrinterPacket(p, printerData, false, black)
+	sendPrinterPacket(p, printerData, false, nil)
+	// The first part has no bottom margin, so it isn't finished yet
+	sendPrinterPacket(p, printerPrint, false, []uint8{1, 0x20, 0xE4, 0x40})
+	if len(driver.prints) != 0 {
+		t.Fatalf("a print without a bottom margin was finished")
+	}
+
+	sendPrinterPacket(p, printerData, true, compressedBlack)
+	sendPrinterPacket(p, printerData, false, nil)
+	_, status := sendPrinterPacket(p, printerPrint, false, []uint8{1, 0x03, 0xE4, 0x40})
+	if status&printerStatusBusy == 0 {
+		t.Errorf("expected the printer to be busy after printing, status is %#x", status)
+	}
+
+	if len(driver.prints) != 1 {
+		t.Fatalf("expected 1 print, got %v", len(driver.prints))
+	}
+	printed := driver.prints[0]
+	if printed.topMargin != 2 || printed.bottomMargin != 3 {
+		t.Errorf("expected margins of 2 and 3, got %v and %v", printed.topMargin, printed.bottomMargin)
+	}
+	// The two parts are joined
+	bounds := printed.img.Bounds()
+	if bounds.Dx() != printerWidth || bounds.Dy() != 32 {
+		t.Fatalf("expected a %vx32 image, got %vx%v", printerWidth, bounds.Dx(), bounds.Dy())
+	}
+	for _, y := range []int{0, 31} {
+		if r, _, _, _ := printed.img.At(0, y).RGBA(); r != 0 {
+			t.Errorf("expected row %v to be black, got a brightness of %#x", y, r)
+		}
+	}
+
+	// The busy status wears off
+	for i := 0; i < printerBusyPackets; i++ {
+		_, status = sendPrinterPacket(p, printerStatus, false, nil)
+	}
+	if status&printerStatusBusy != 0 {
+		t.Errorf("the printer is still busy, status is %#x", status)
+	}
+}
+
+func TestPrinterErr(t *testing.T) {
+	driver := &testPrinterDriver{err: xerrors.New("out of paper")}
+	p := NewPrinter(driver)
+
+	sendPrinterPacket(p, printerInit, false, nil)
+	sendPrinterPacket(p, printerData, false, make([]uint8, printerBandSize))
+	sendPrinterPacket(p, printerPrint, false, []uint8{1, 0x01, 0xE4, 0x40})
+
+	if !xerrors.Is(p.Err(), driver.err) {
+		t.Fatalf("expected the driver's error, got %v", p.Err())
+	}
+}
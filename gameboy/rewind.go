@@ -0,0 +1,345 @@
+package gameboy
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// rewindCaptureInterval is the number of frames between rewind
+	// snapshots.
+	rewindCaptureInterval = 4
+	// rewindKeyframeInterval is the number of snapshots in a rewind group.
+	// The first snapshot of every group is a keyframe, and the rest are
+	// stored as the difference between themselves and the keyframe.
+	rewindKeyframeInterval = 30
+	// rewindQueueSize is the number of snapshots that can be waiting to be
+	// compressed. If the queue is full, new snapshots are dropped so that the
+	// main loop never waits on compression.
+	rewindQueueSize = 8
+)
+
+// rewindSnapshot is a compressed snapshot of the device at some frame.
+type rewindSnapshot struct {
+	// frame is the frame number that the snapshot was taken at.
+	frame int
+	// data is the flate-compressed snapshot. For keyframes, this is a save
+	// state. For all other snapshots, this is a save state XORed with the
+	// group's keyframe, which is mostly zeros and compresses well.
+	data []uint8
+}
+
+// rewindGroup is a keyframe and the snapshots that are stored relative to
+// it.
+type rewindGroup struct {
+	// snapshots are the snapshots in this group, the first of which is the
+	// keyframe.
+	snapshots []rewindSnapshot
+	// size is the total size of all compressed snapshot data in the group.
+	size int
+}
+
+// rewindCapture is a snapshot waiting to be added to the rewind buffer. It's
+// taken with a stateWriter that copies the device's memory into blocks
+// instead of writing out a save state, and is put together into a save state
+// by the compression goroutine. Captures are reused once they're compressed.
+type rewindCapture struct {
+	frame      int
+	generation int
+	// rest is the save state without the blocks.
+	rest   bytes.Buffer
+	blocks stateBlocks
+}
+
+// rewindBuffer keeps a history of compressed snapshots of the device, bounded
+// by a memory budget. Snapshots are captured by the main loop and compressed
+// in a separate goroutine.
+type rewindBuffer struct {
+	// budget is the maximum number of bytes that compressed snapshots may
+	// take up. The oldest groups are discarded to stay under the budget.
+	budget int
+
+	// captures receives snapshots to be compressed.
+	captures chan *rewindCapture
+	// free contains captures that can be reused.
+	free chan *rewindCapture
+
+	// mutex guards all values below.
+	mutex sync.Mutex
+	// groups contains all rewind history, oldest first.
+	groups []*rewindGroup
+	// size is the total size of all groups.
+	size int
+	// generation is incremented every time the device is rewound. Snapshots
+	// captured before the rewind belong to a discarded timeline and are
+	// ignored.
+	generation int
+}
+
+func newRewindBuffer(budget int) *rewindBuffer {
+	rb := &rewindBuffer{
+		budget:   budget,
+		captures: make(chan *rewindCapture, rewindQueueSize),
+		free:     make(chan *rewindCapture, rewindQueueSize),
+	}
+	for i := 0; i < rewindQueueSize; i++ {
+		rb.free <- &rewindCapture{}
+	}
+
+	go rb.compressLoop()
+
+	return rb
+}
+
+// close stops the compression goroutine.
+func (rb *rewindBuffer) close() {
+	close(rb.captures)
+}
+
+// capture takes a snapshot of the device and queues it for compression. If
+// the compression goroutine is falling behind, no snapshot is taken.
+func (rb *rewindBuffer) capture(device *Device) {
+	var capture *rewindCapture
+	select {
+	case capture = <-rb.free:
+	default:
+		return
+	}
+
+	rb.mutex.Lock()
+	capture.generation = rb.generation
+	rb.mutex.Unlock()
+	capture.frame = device.frameCount
+
+	capture.rest.Reset()
+	capture.blocks.reset()
+	device.writeState(&stateWriter{
+		w:      &capture.rest,
+		buf:    &capture.rest,
+		blocks: &capture.blocks,
+	})
+
+	rb.captures <- capture
+}
+
+// compressLoop compresses snapshots as they are captured and adds them to the
+// history.
+func (rb *rewindBuffer) compressLoop() {
+	// The uncompressed keyframe of the group currently being added to
+	var keyframe []uint8
+	keyframeGeneration := -1
+	groupSnapshots := 0
+
+	// Buffers are reused between snapshots to avoid garbage
+	var state, delta []uint8
+	var compressed bytes.Buffer
+	compressor, err := flate.NewWriter(&compressed, flate.BestSpeed)
+	if err != nil {
+		panic(err)
+	}
+	compress := func(data []uint8) []uint8 {
+		compressed.Reset()
+		compressor.Reset(&compressed)
+		// Writes to a bytes.Buffer can't fail
+		compressor.Write(data)
+		compressor.Close()
+		return append([]uint8(nil), compressed.Bytes()...)
+	}
+
+	for capture := range rb.captures {
+		rb.mutex.Lock()
+		stale := capture.generation != rb.generation
+		rb.mutex.Unlock()
+		if stale {
+			rb.free <- capture
+			continue
+		}
+
+		state = capture.blocks.assemble(state[:0], capture.rest.Bytes())
+		frame, generation := capture.frame, capture.generation
+		rb.free <- capture
+
+		isKeyframe := keyframe == nil ||
+			keyframeGeneration != generation ||
+			groupSnapshots >= rewindKeyframeInterval
+
+		var data []uint8
+		if isKeyframe {
+			data = compress(state)
+			keyframe, state = state, keyframe
+			keyframeGeneration = generation
+			groupSnapshots = 0
+		} else {
+			delta = xorBytesInto(delta[:0], state, keyframe)
+			data = compress(delta)
+		}
+		groupSnapshots++
+
+		snapshot := rewindSnapshot{frame: frame, data: data}
+
+		rb.mutex.Lock()
+		if generation == rb.generation {
+			if isKeyframe || len(rb.groups) == 0 {
+				rb.groups = append(rb.groups, &rewindGroup{})
+			}
+			group := rb.groups[len(rb.groups)-1]
+			group.snapshots = append(group.snapshots, snapshot)
+			group.size += len(data)
+			rb.size += len(data)
+
+			// Discard the oldest history to stay under budget, always keeping
+			// the group that's being added to
+			for rb.size > rb.budget && len(rb.groups) > 1 {
+				rb.size -= rb.groups[0].size
+				rb.groups = rb.groups[1:]
+			}
+		} else {
+			// The device was rewound while this snapshot was being compressed
+			keyframe = nil
+		}
+		rb.mutex.Unlock()
+	}
+}
+
+// rewindTo finds the newest snapshot taken at or before the given frame, or
+// the oldest snapshot if there are none. All history after that snapshot is
+// discarded. Returns the uncompressed snapshot and the frame it was taken at,
+// or nil if there is no history.
+func (rb *rewindBuffer) rewindTo(frame int) ([]uint8, int, error) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	// Snapshots still waiting to be compressed are from a timeline that's
+	// about to be discarded
+	rb.generation++
+
+	if len(rb.groups) == 0 {
+		return nil, 0, nil
+	}
+
+	// Search for the snapshot, starting from the newest
+	groupIndex, snapshotIndex := 0, 0
+search:
+	for i := len(rb.groups) - 1; i >= 0; i-- {
+		snapshots := rb.groups[i].snapshots
+		for j := len(snapshots) - 1; j >= 0; j-- {
+			if snapshots[j].frame <= frame {
+				groupIndex, snapshotIndex = i, j
+				break search
+			}
+		}
+	}
+
+	group := rb.groups[groupIndex]
+	snapshot := group.snapshots[snapshotIndex]
+
+	state, err := rewindDecompress(group.snapshots[0].data)
+	if err != nil {
+		return nil, 0, xerrors.Errorf("decompressing keyframe: %w", err)
+	}
+	if snapshotIndex != 0 {
+		delta, err := rewindDecompress(snapshot.data)
+		if err != nil {
+			return nil, 0, xerrors.Errorf("decompressing snapshot: %w", err)
+		}
+		state = xorBytes(delta, state)
+	}
+
+	// Discard everything newer than the snapshot
+	for _, discarded := range rb.groups[groupIndex+1:] {
+		rb.size -= discarded.size
+	}
+	rb.groups = rb.groups[:groupIndex+1]
+	for _, discarded := range group.snapshots[snapshotIndex+1:] {
+		group.size -= len(discarded.data)
+		rb.size -= len(discarded.data)
+	}
+	group.snapshots = group.snapshots[:snapshotIndex+1]
+
+	return state, snapshot.frame, nil
+}
+
+// rewindDecompress decompresses a snapshot compressed by compressLoop.
+func rewindDecompress(data []uint8) ([]uint8, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// xorBytes returns a slice the length of a, where every byte is XORed with the
+// byte at the same position in b, if there is one.
+func xorBytes(a, b []uint8) []uint8 {
+	return xorBytesInto(nil, a, b)
+}
+
+// xorBytesInto is like xorBytes, but appends the result to dst.
+func xorBytesInto(dst, a, b []uint8) []uint8 {
+	start := len(dst)
+	dst = append(dst, a...)
+	result := dst[start:]
+	for i := 0; i < len(result) && i < len(b); i++ {
+		result[i] ^= b[i]
+	}
+	return dst
+}
+
+// Rewind requests that the device go back in time by at least the given
+// number of frames. The device is rewound to the closest snapshot in the
+// rewind history, which may be further back than requested. If the history
+// doesn't go back far enough, the device is rewound to the oldest snapshot.
+//
+// Rewind is safe to call while the device is running. The device is rewound
+// at the end of the current frame. Rewinding must be enabled with the WithRewind
+// option.
+func (device *Device) Rewind(frames int) error {
+	if device.rewind == nil {
+		return xerrors.New("rewinding is not enabled")
+	}
+	if frames < 0 {
+		return xerrors.Errorf("cannot rewind by a negative number of frames %v", frames)
+	}
+
+	atomic.AddInt32(&device.pendingRewindFrames, int32(frames))
+
+	return nil
+}
+
+// onFrameBoundary is called by the main loop at the first instruction
+// boundary after a frame is finished. It applies cheats, handles any rewind
+// requests and captures rewind snapshots.
+func (device *Device) onFrameBoundary() error {
+	device.frameCount++
+
+	device.cheats.update()
+	device.cheats.writeRAM(device.state.mmu)
+
+	if device.rewind == nil {
+		return nil
+	}
+
+	if frames := atomic.SwapInt32(&device.pendingRewindFrames, 0); frames > 0 {
+		state, frame, err := device.rewind.rewindTo(device.frameCount - int(frames))
+		if err != nil {
+			return xerrors.Errorf("rewinding: %w", err)
+		}
+		if state != nil {
+			if err := device.LoadState(bytes.NewReader(state)); err != nil {
+				return xerrors.Errorf("loading rewind snapshot: %w", err)
+			}
+			device.frameCount = frame
+		}
+		return nil
+	}
+
+	if device.frameCount%rewindCaptureInterval == 0 {
+		device.rewind.capture(device)
+	}
+
+	return nil
+}
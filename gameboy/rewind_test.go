@@ -0,0 +1,142 @@
+package gameboy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// newRewindTestDevice creates a device with rewinding enabled, running a
+// program that counts up in A forever. The cartridge has RAM so that its
+// banks are part of snapshots.
+func newRewindTestDevice(t testing.TB, rewind bool) *Device {
+	t.Helper()
+
+	// MBC5+RAM+BATTERY with 128K of RAM
+	rom := newTestCartridge(0x1B, 0x00, 0x04)
+	copy(rom[0x0100:], []uint8{
+		0x3C,       // inc a
+		0x18, 0xFD, // jr -3
+	})
+
+	var opts []Option
+	if rewind {
+		opts = append(opts, WithRewind(32<<20))
+	}
+	return newTestCartridgeDevice(t, rom, opts...)
+}
+
+// waitForSnapshots waits until the rewind buffer has the given number of
+// snapshots.
+func waitForSnapshots(t *testing.T, rb *rewindBuffer, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rb.mutex.Lock()
+		snapshots := 0
+		for _, group := range rb.groups {
+			snapshots += len(group.snapshots)
+		}
+		rb.mutex.Unlock()
+
+		if snapshots >= count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v snapshots", count)
+}
+
+func TestRewindCapture(t *testing.T) {
+	device := newRewindTestDevice(t, true)
+	// Something to find in cartridge RAM
+	device.WriteMemory(0x0000, 0x0A)
+	device.WriteMemory(0xA123, 0x42)
+
+	// A keyframe, then a snapshot stored relative to it
+	var expected [2][]uint8
+	for i := range expected {
+		if err := device.RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+		device.frameCount = (i + 1) * rewindCaptureInterval
+
+		var buf bytes.Buffer
+		if err := device.SaveState(&buf); err != nil {
+			t.Fatalf("saving state: %v", err)
+		}
+		expected[i] = buf.Bytes()
+
+		device.rewind.capture(device)
+		waitForSnapshots(t, device.rewind, i+1)
+	}
+
+	// Rewinding discards newer snapshots, so go from newest to oldest
+	for i := len(expected) - 1; i >= 0; i-- {
+		frame := (i + 1) * rewindCaptureInterval
+		state, actualFrame, err := device.rewind.rewindTo(frame)
+		if err != nil {
+			t.Fatalf("rewinding: %v", err)
+		}
+		if actualFrame != frame {
+			t.Errorf("rewound to frame %v, expected %v", actualFrame, frame)
+		}
+		if !bytes.Equal(state, expected[i]) {
+			t.Errorf("snapshot %v doesn't match the save state taken at the same time", i)
+		}
+	}
+}
+
+func TestRewind(t *testing.T) {
+	device := newRewindTestDevice(t, true)
+
+	var snapshotRegisters Registers
+	for frame := 1; frame <= rewindCaptureInterval*3; frame++ {
+		if err := device.RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+		if frame == rewindCaptureInterval*2 {
+			snapshotRegisters = device.Registers()
+		}
+	}
+	waitForSnapshots(t, device.rewind, 3)
+
+	// The rewind happens at the end of the next frame, so this goes back to
+	// the second snapshot
+	if err := device.Rewind(rewindCaptureInterval + 1); err != nil {
+		t.Fatalf("rewinding: %v", err)
+	}
+	if err := device.RunFrame(); err != nil {
+		t.Fatalf("running: %v", err)
+	}
+	if device.frameCount != rewindCaptureInterval*2 {
+		t.Fatalf("expected to rewind to frame %v, got %v", rewindCaptureInterval*2, device.frameCount)
+	}
+	if actual := device.Registers(); actual != snapshotRegisters {
+		t.Fatalf("registers are %+v after rewinding, expected %+v", actual, snapshotRegisters)
+	}
+}
+
+// BenchmarkRunFrame shows how much taking rewind snapshots slows down
+// emulation.
+func BenchmarkRunFrame(b *testing.B) {
+	for _, rewind := range []bool{false, true} {
+		name := "without rewind"
+		if rewind {
+			name = "with rewind"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			device := newRewindTestDevice(b, rewind)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := device.RunFrame(); err != nil {
+					b.Fatalf("running: %v", err)
+				}
+			}
+		})
+	}
+}
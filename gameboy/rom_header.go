@@ -3,7 +3,37 @@ package gameboy
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrTruncatedROM is returned when cartridge data is too small to contain
+	// a header.
+	ErrTruncatedROM = xerrors.New("ROM is truncated")
+	// ErrBadHeaderChecksum is returned when the header checksum doesn't match
+	// the header and WithHeaderChecksumValidation is used. The boot ROM
+	// refuses to start games like this.
+	ErrBadHeaderChecksum = xerrors.New("bad header checksum")
+	// ErrUnsupportedCartridge is returned when the header describes a
+	// cartridge type, ROM size or RAM size that isn't supported.
+	ErrUnsupportedCartridge = xerrors.New("unsupported cartridge")
+)
+
+const (
+	// headerStartAddr is the start of the part of the header covered by the
+	// header checksum.
+	headerStartAddr = 0x0134
+	// headerChecksumAddr is the location of the header checksum.
+	headerChecksumAddr = 0x014D
+	// globalChecksumAddr is the location of the global checksum, a
+	// big-endian 16-bit value.
+	globalChecksumAddr = 0x014E
+	// headerEndAddr is the end of the header, and the smallest size that
+	// cartridge data can be.
+	headerEndAddr = 0x0150
 )
 
 type romHeader struct {
@@ -38,9 +68,19 @@ type romHeader struct {
 	maskROMVersionNumber uint8
 	// The checksum of all previous header info
 	headerChecksum uint8
+	// The checksum of the whole ROM, which nothing actually checks
+	globalChecksum uint16
 }
 
-func loadROMHeader(cartridgeData []byte) romHeader {
+// loadROMHeader reads the header from the given cartridge data. An error is
+// returned if the data is too small to have a header.
+func loadROMHeader(cartridgeData []byte) (romHeader, error) {
+	if len(cartridgeData) < headerEndAddr {
+		return romHeader{}, xerrors.Errorf(
+			"got %v bytes, need at least %v for the header: %w",
+			len(cartridgeData), headerEndAddr, ErrTruncatedROM)
+	}
+
 	return romHeader{
 		title:                noNullTerms(string(cartridgeData[0x0134:0x0140])),
 		manufacturerCode:     noNullTerms(string(cartridgeData[0x013F:0x0143])),
@@ -53,9 +93,115 @@ func loadROMHeader(cartridgeData []byte) romHeader {
 		destinationCode:      cartridgeData[0x014A],
 		oldLicenseeCode:      cartridgeData[0x014B],
 		maskROMVersionNumber: cartridgeData[0x014C],
-		headerChecksum:       cartridgeData[0x014D],
+		headerChecksum:       cartridgeData[headerChecksumAddr],
+		globalChecksum: combine16(
+			cartridgeData[globalChecksumAddr+1], cartridgeData[globalChecksumAddr]),
+	}, nil
+}
+
+// headerChecksum calculates the header checksum of the given cartridge data,
+// the same way the boot ROM does. The data must be large enough to have a
+// header.
+func headerChecksum(cartridgeData []uint8) uint8 {
+	var sum uint8
+	for _, val := range cartridgeData[headerStartAddr:headerChecksumAddr] {
+		sum = sum - val - 1
+	}
+	return sum
+}
+
+// globalChecksum calculates the global checksum of the given cartridge data,
+// which is the sum of every byte except for the checksum itself.
+func globalChecksum(cartridgeData []uint8) uint16 {
+	var sum uint16
+	for i, val := range cartridgeData {
+		if i != globalChecksumAddr && i != globalChecksumAddr+1 {
+			sum += uint16(val)
+		}
+	}
+	return sum
+}
+
+// romBankCounts maps ROM size types to the number of 16 KB ROM banks they
+// describe. Types 0x52 to 0x54 are used by a few unlicensed games.
+var romBankCounts = map[uint8]int{
+	0x00: 2,
+	0x01: 4,
+	0x02: 8,
+	0x03: 16,
+	0x04: 32,
+	0x05: 64,
+	0x06: 128,
+	0x07: 256,
+	0x08: 512,
+	0x52: 72,
+	0x53: 80,
+	0x54: 96,
+}
+
+// ramSizes maps RAM size types to the amount of cartridge RAM they describe,
+// in bytes.
+var ramSizes = map[uint8]int{
+	0x00: 0,
+	0x01: 0x800,
+	0x02: 0x2000,
+	0x03: 0x8000,
+	0x04: 0x20000,
+	0x05: 0x10000,
+}
+
+// fitROMToHeader checks that the header's ROM and RAM size types are
+// supported, then returns cartridge data that's the size the header says the
+// ROM is. Undersized ROMs that are a power of two in size are mirrored to fill
+// the space, like they would be on a cartridge with a smaller ROM chip.
+// Otherwise, the ROM was probably trimmed of unused space at the end, so it's
+// padded with 0xFF. Warnings about the size are printed to out.
+func fitROMToHeader(header romHeader, cartridgeData []uint8, out io.Writer) ([]uint8, error) {
+	bankCount, ok := romBankCounts[header.romSizeType]
+	if !ok {
+		return nil, xerrors.Errorf("ROM size type %#x: %w",
+			header.romSizeType, ErrUnsupportedCartridge)
+	}
+	if _, ok := ramSizes[header.ramSizeType]; !ok {
+		return nil, xerrors.Errorf("RAM size type %#x: %w",
+			header.ramSizeType, ErrUnsupportedCartridge)
 	}
 
+	size := bankCount * 0x4000
+	if len(cartridgeData) == size {
+		return cartridgeData, nil
+	}
+	if len(cartridgeData) > size {
+		fmt.Fprintf(out, "Warning: ROM is %v bytes, but the header says it should "+
+			"be %v bytes. The extra data will be ignored.\n",
+			len(cartridgeData), size)
+		return cartridgeData[:size], nil
+	}
+
+	fitted := make([]uint8, size)
+	if isPowerOfTwo(len(cartridgeData)) {
+		fmt.Fprintf(out, "Warning: ROM is %v bytes, but the header says it should "+
+			"be %v bytes. It will be mirrored.\n",
+			len(cartridgeData), size)
+		for i := 0; i < size; i += len(cartridgeData) {
+			copy(fitted[i:], cartridgeData)
+		}
+	} else {
+		fmt.Fprintf(out, "Warning: ROM is %v bytes, but the header says it should "+
+			"be %v bytes. It will be padded.\n",
+			len(cartridgeData), size)
+		copy(fitted, cartridgeData)
+		for i := len(cartridgeData); i < size; i++ {
+			fitted[i] = 0xFF
+		}
+	}
+
+	return fitted, nil
+}
+
+// isPowerOfTwo returns true if the given value is a power of two.
+func isPowerOfTwo(val int) bool {
+	return val > 0 && val&(val-1) == 0
 }
 
 // noNullTerms removes null terminators from the given string.
@@ -63,22 +209,162 @@ func noNullTerms(str string) string {
 	return strings.Trim(str, "\000")
 }
 
-func (h romHeader) String() string {
+// ROMHeader contains information about a game from its cartridge header.
+type ROMHeader struct {
+	// Title is the title of the game, in upper case.
+	Title string
+	// ManufacturerCode is a four character code found in some newer games.
+	ManufacturerCode string
+	// CGBSupported is true if the game supports CGB functions.
+	CGBSupported bool
+	// CGBOnly is true if the game only works on the CGB.
+	CGBOnly bool
+	// SGBSupported is true if the game supports SGB functions.
+	SGBSupported bool
+	// CartridgeType is the cartridge type from the header.
+	CartridgeType uint8
+	// Features describes the hardware in the cartridge.
+	Features CartridgeFeatures
+	// ROMSize is the size of the ROM in bytes, according to the header.
+	ROMSize int
+	// RAMSize is the size of the cartridge's RAM in bytes, according to the
+	// header. Controllers with RAM built in, like the MBC2, have a RAM size of
+	// 0.
+	RAMSize int
+	// Licensee is the name of the game's publisher.
+	Licensee string
+	// Japanese is true if the game was sold in Japan.
+	Japanese bool
+	// Version is the version number of the game, which is usually 0.
+	Version uint8
+	// HeaderChecksum is the checksum of the header, from the header.
+	HeaderChecksum uint8
+	// HeaderChecksumValid is true if the header checksum matches the header.
+	HeaderChecksumValid bool
+	// GlobalChecksum is the checksum of the whole ROM, from the header.
+	GlobalChecksum uint16
+	// GlobalChecksumValid is true if the global checksum matches the ROM.
+	// Unlike the header checksum, nothing checks this on real hardware.
+	GlobalChecksumValid bool
+}
+
+// ParseROMHeader reads the header from the given cartridge data. An error is
+// returned if the data is too small to have a header, but the header itself
+// isn't validated. See the header's checksum fields and cartridge features to
+// find out if it can be run.
+func ParseROMHeader(cartridgeData []uint8) (ROMHeader, error) {
+	header, cartridgeData, err := selectROMHeader(cartridgeData)
+	if err != nil {
+		return ROMHeader{}, err
+	}
+	return newROMHeader(header, cartridgeData), nil
+}
+
+// selectROMHeader reads the header that describes the whole cartridge from the
+// given cartridge data. This is usually the header at the start of the ROM,
+// but multi-game cartridges with an MMM01 have it in the menu at the end.
+// Cartridge data is returned in the layout that the cartridge's MBC expects.
+func selectROMHeader(cartridgeData []uint8) (romHeader, []uint8, error) {
+	header, err := loadROMHeader(cartridgeData)
+	if err != nil {
+		return romHeader{}, nil, err
+	}
+
+	if isMMM01(cartridgeData) {
+		// The header at the start of the ROM belongs to the first game. The
+		// one for the whole cartridge is in the menu at the end.
+		header, err = loadROMHeader(cartridgeData[len(cartridgeData)-mmm01MenuSize:])
+		if err != nil {
+			return romHeader{}, nil, err
+		}
+	} else if isMMM01Type(header.cartridgeType) {
+		// Some dumps put the menu at the start of the ROM instead of at the
+		// end, where the MMM01 expects it
+		cartridgeData = moveMMM01MenuToEnd(cartridgeData)
+	}
+
+	return header, cartridgeData, nil
+}
+
+// newROMHeader decodes the given header. The cartridge data is used to verify
+// the checksums.
+func newROMHeader(header romHeader, cartridgeData []uint8) ROMHeader {
+	headerStart := 0
+	if isMMM01(cartridgeData) {
+		headerStart = len(cartridgeData) - mmm01MenuSize
+	}
+
+	return ROMHeader{
+		Title:               header.title,
+		ManufacturerCode:    header.manufacturerCode,
+		CGBSupported:        header.cgbFlag&0x80 == 0x80,
+		CGBOnly:             header.cgbFlag == 0xC0,
+		SGBSupported:        header.sgbFlag == 0x03,
+		CartridgeType:       header.cartridgeType,
+		Features:            cartridgeFeatures(header.cartridgeType),
+		ROMSize:             romBankCounts[header.romSizeType] * 0x4000,
+		RAMSize:             ramSizes[header.ramSizeType],
+		Licensee:            licenseeName(header),
+		Japanese:            header.destinationCode == 0x00,
+		Version:             header.maskROMVersionNumber,
+		HeaderChecksum:      header.headerChecksum,
+		HeaderChecksumValid: headerChecksum(cartridgeData[headerStart:]) == header.headerChecksum,
+		GlobalChecksum:      header.globalChecksum,
+		GlobalChecksumValid: globalChecksum(cartridgeData) == header.globalChecksum,
+	}
+}
+
+func (h ROMHeader) String() string {
 	str := bytes.NewBufferString("")
 
+	checksumStatus := func(valid bool) string {
+		if valid {
+			return "OK"
+		}
+		return "BAD"
+	}
+
 	fmt.Fprintln(str, "Cartridge Header:")
-	fmt.Fprintln(str, "  Title:", h.title)
-	fmt.Fprintln(str, "  Manufacturer Code:", h.manufacturerCode)
-	fmt.Fprintln(str, "  CGB Flag:", h.cgbFlag)
-	fmt.Fprintln(str, "  Licensee Code:", h.licenseeCode)
-	fmt.Fprintln(str, "  SGB Flag:", h.sgbFlag)
-	fmt.Fprintln(str, "  Cartridge Type:", h.cartridgeType)
-	fmt.Fprintln(str, "  ROM Size Type:", h.romSizeType)
-	fmt.Fprintln(str, "  RAM Size Type:", h.ramSizeType)
-	fmt.Fprintln(str, "  Destination Code:", h.destinationCode)
-	fmt.Fprintln(str, "  Old Licensee Code:", h.oldLicenseeCode)
-	fmt.Fprintln(str, "  Mask ROM Version Number:", h.maskROMVersionNumber)
-	fmt.Fprintln(str, "  Header Checksum:", h.headerChecksum)
+	fmt.Fprintln(str, "  Title:", h.Title)
+	fmt.Fprintln(str, "  Manufacturer Code:", h.ManufacturerCode)
+	fmt.Fprintln(str, "  Licensee:", h.Licensee)
+	fmt.Fprintln(str, "  Cartridge Type:", fmt.Sprintf("0x%02X (%v)", h.CartridgeType, h.Features))
+	fmt.Fprintln(str, "  ROM Size:", h.ROMSize)
+	fmt.Fprintln(str, "  RAM Size:", h.RAMSize)
+	fmt.Fprintln(str, "  CGB Supported:", h.CGBSupported)
+	fmt.Fprintln(str, "  CGB Only:", h.CGBOnly)
+	fmt.Fprintln(str, "  SGB Supported:", h.SGBSupported)
+	fmt.Fprintln(str, "  Japanese:", h.Japanese)
+	fmt.Fprintln(str, "  Version:", h.Version)
+	fmt.Fprintln(str, "  Header Checksum:",
+		fmt.Sprintf("0x%02X (%v)", h.HeaderChecksum, checksumStatus(h.HeaderChecksumValid)))
+	fmt.Fprintln(str, "  Global Checksum:",
+		fmt.Sprintf("0x%04X (%v)", h.GlobalChecksum, checksumStatus(h.GlobalChecksumValid)))
 
 	return str.String()
 }
+
+// nintendoLogoAddr is where the Nintendo logo is stored in the header.
+const nintendoLogoAddr = 0x0104
+
+// nintendoLogo is the logo that every licensed game has in its header. The
+// boot ROM refuses to start games where it doesn't match.
+var nintendoLogo = []uint8{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B,
+	0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E,
+	0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC,
+	0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// hasNintendoLogo returns true if there's a header with the Nintendo logo in
+// the given cartridge data, starting at the given offset. Multi-game
+// cartridges have a header for each game.
+func hasNintendoLogo(cartridgeData []uint8, offset int) bool {
+	start := offset + nintendoLogoAddr
+	if start < 0 || start+len(nintendoLogo) > len(cartridgeData) {
+		return false
+	}
+	return bytes.Equal(cartridgeData[start:start+len(nintendoLogo)], nintendoLogo)
+}
@@ -0,0 +1,193 @@
+package gameboy
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestParseROMHeader(t *testing.T) {
+	// MBC3+RTC+RAM+BATTERY with 64 KB of ROM and 32 KB of RAM
+	rom := newTestCartridge(0x10, 0x01, 0x03)
+	rom[0x014B] = 0x01 // Nintendo's old licensee code
+	rom[headerChecksumAddr] = headerChecksum(rom)
+	checksum := globalChecksum(rom)
+	rom[globalChecksumAddr] = uint8(checksum >> 8)
+	rom[globalChecksumAddr+1] = uint8(checksum)
+
+	header, err := ParseROMHeader(rom)
+	if err != nil {
+		t.Fatalf("parsing the header: %v", err)
+	}
+
+	expected := ROMHeader{
+		Title: "TEST",
+		Features: CartridgeFeatures{
+			Controller: "MBC3",
+			RAM:        true,
+			Battery:    true,
+			RTC:        true,
+		},
+		CartridgeType:       0x10,
+		ROMSize:             0x10000,
+		RAMSize:             0x8000,
+		Licensee:            "Nintendo",
+		Japanese:            true,
+		HeaderChecksum:      rom[headerChecksumAddr],
+		HeaderChecksumValid: true,
+		GlobalChecksum:      checksum,
+		GlobalChecksumValid: true,
+	}
+	if header != expected {
+		t.Fatalf("expected %+v, got %+v", expected, header)
+	}
+}
+
+func TestParseROMHeaderChecksums(t *testing.T) {
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	rom[headerChecksumAddr]++
+	rom[0x2000]++
+
+	header, err := ParseROMHeader(rom)
+	if err != nil {
+		t.Fatalf("parsing the header: %v", err)
+	}
+	if header.HeaderChecksumValid {
+		t.Errorf("expected the header checksum to be bad")
+	}
+	if header.GlobalChecksumValid {
+		t.Errorf("expected the global checksum to be bad")
+	}
+}
+
+func TestParseROMHeaderTruncated(t *testing.T) {
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+
+	_, err := ParseROMHeader(rom[:headerEndAddr-1])
+	if !xerrors.Is(err, ErrTruncatedROM) {
+		t.Fatalf("expected ErrTruncatedROM, got %v", err)
+	}
+}
+
+func TestBadHeaderChecksum(t *testing.T) {
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	rom[headerChecksumAddr]++
+
+	// Games with a bad header checksum run with just a warning by default
+	newTestCartridgeDevice(t, rom)
+
+	_, err := NewDevice(
+		nil,
+		rom,
+		&testROMVideoDriver{},
+		&noopInputDriver{},
+		&testROMSaveGameDriver{},
+		DebugConfiguration{},
+		WithoutBootROM(),
+		WithHeaderChecksumValidation())
+	if !xerrors.Is(err, ErrBadHeaderChecksum) {
+		t.Fatalf("expected ErrBadHeaderChecksum, got %v", err)
+	}
+}
+
+func TestFitROMToHeader(t *testing.T) {
+	// A 128 KB ROM
+	header := romHeader{romSizeType: 0x02}
+	size := 0x20000
+
+	// sequence returns data where each byte is its index
+	sequence := func(size int) []uint8 {
+		data := make([]uint8, size)
+		for i := range data {
+			data[i] = uint8(i)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name     string
+		data     []uint8
+		expected func(i int) uint8
+	}{
+		{
+			name:     "exact",
+			data:     sequence(size),
+			expected: func(i int) uint8 { return uint8(i) },
+		},
+		{
+			name:     "truncated",
+			data:     sequence(size + 0x1234),
+			expected: func(i int) uint8 { return uint8(i) },
+		},
+		{
+			name: "mirrored",
+			data: sequence(0x8000),
+			expected: func(i int) uint8 {
+				return uint8(i % 0x8000)
+			},
+		},
+		{
+			name: "padded",
+			data: sequence(0x9000),
+			expected: func(i int) uint8 {
+				if i < 0x9000 {
+					return uint8(i)
+				}
+				return 0xFF
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fitted, err := fitROMToHeader(header, test.data, ioutil.Discard)
+			if err != nil {
+				t.Fatalf("fitting the ROM: %v", err)
+			}
+			if len(fitted) != size {
+				t.Fatalf("expected %v bytes, got %v", size, len(fitted))
+			}
+			for i, val := range fitted {
+				if expected := test.expected(i); val != expected {
+					t.Fatalf("expected %#x at %#x, got %#x", expected, i, val)
+				}
+			}
+		})
+	}
+}
+
+func TestFitROMToHeaderUnsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		header romHeader
+	}{
+		{"ROM size", romHeader{romSizeType: 0x09}},
+		{"RAM size", romHeader{ramSizeType: 0x06}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := fitROMToHeader(test.header, make([]uint8, 0x8000), ioutil.Discard)
+			if !xerrors.Is(err, ErrUnsupportedCartridge) {
+				t.Fatalf("expected ErrUnsupportedCartridge, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadUndersizedROM(t *testing.T) {
+	// A trimmed ROM that claims to be 64 KB
+	rom := newTestCartridge(0x01, 0x01, 0x00)[:0x5000]
+	rom[0x4000+bankMarkerAddr] = 0x42
+
+	device := newTestCartridgeDevice(t, rom)
+	device.WriteMemory(0x2000, 0x01)
+	if val := device.ReadMemory(bankedROMAddr + bankMarkerAddr); val != 0x42 {
+		t.Errorf("expected to read 0x42 from bank 1, got %#x", val)
+	}
+	device.WriteMemory(0x2000, 0x03)
+	if val := device.ReadMemory(bankedROMAddr); val != 0xFF {
+		t.Errorf("expected padding in bank 3, got %#x", val)
+	}
+}
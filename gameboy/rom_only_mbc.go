@@ -1,18 +1,33 @@
 package gameboy
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
 
 // romOnlyMBC is the basic memory bank controller. It can scarcely be called a
 // memory bank controller at all since there's no switching. This MBC provides
-// a single 16K ROM bank.
+// a single 16K ROM bank, and optionally a single 8K RAM bank.
 type romOnlyMBC struct {
 	// romBanks are the two available ROM banks in this basic controller.
 	romBanks [][]uint8
+	// ram is the cartridge's RAM, or nil if it doesn't have any.
+	ram []uint8
+
+	// out is where warnings are printed.
+	out io.Writer
 }
 
-func newROMOnlyMBC(header romHeader, cartridgeData []uint8) *romOnlyMBC {
+func newROMOnlyMBC(header romHeader, cartridgeData []uint8, out io.Writer) *romOnlyMBC {
 	m := &romOnlyMBC{
 		romBanks: makeROMBanks(header.romSizeType, cartridgeData),
+		out:      out,
+	}
+
+	if ramBanks := makeRAMBanks(header.ramSizeType); len(ramBanks) > 0 {
+		m.ram = ramBanks[0]
 	}
 
 	return m
@@ -26,8 +41,11 @@ func (m *romOnlyMBC) at(addr uint16) uint8 {
 	case inBankedROMArea(addr):
 		return m.romBanks[1][addr-bankedROMAddr]
 	case inBankedRAMArea(addr):
+		if int(addr-bankedRAMAddr) < len(m.ram) {
+			return m.ram[addr-bankedRAMAddr]
+		}
 		if printWarnings {
-			fmt.Printf("Warning: Read from banked RAM section at address %#x, "+
+			fmt.Fprintf(m.out, "Warning: Read from banked RAM section at address %#x, "+
 				"but the ROM-only MBC does not support banked RAM\n",
 				addr)
 		}
@@ -38,18 +56,28 @@ func (m *romOnlyMBC) at(addr uint16) uint8 {
 	}
 }
 
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF, which is
+// always bank 1 since there is no banking.
+func (m *romOnlyMBC) currentROMBank() int {
+	return 1
+}
+
 // set can update bank 0 RAM, but otherwise does not support any special
 // operations like real MBCs do.
 func (m *romOnlyMBC) set(addr uint16, val uint8) {
 	switch {
 	case inBank0ROMArea(addr) || inBankedROMArea(addr):
 		if printWarnings {
-			fmt.Printf("Warning: Ignoring write to ROM space "+
+			fmt.Fprintf(m.out, "Warning: Ignoring write to ROM space "+
 				"at %#x with ROM-only MBC\n", addr)
 		}
 	case inBankedRAMArea(addr):
+		if int(addr-bankedRAMAddr) < len(m.ram) {
+			m.ram[addr-bankedRAMAddr] = val
+			return
+		}
 		if printWarnings {
-			fmt.Printf("Warning: Ignoring write to banked RAM space "+
+			fmt.Fprintf(m.out, "Warning: Ignoring write to banked RAM space "+
 				"at %#x with ROM-only MBC\n", addr)
 		}
 	default:
@@ -57,3 +85,33 @@ func (m *romOnlyMBC) set(addr uint16, val uint8) {
 			"notified of a write to address %#x\n", addr))
 	}
 }
+
+func (m *romOnlyMBC) dumpBatteryBackedRAM() []uint8 {
+	dump := make([]uint8, len(m.ram))
+	copy(dump, m.ram)
+	return dump
+}
+
+func (m *romOnlyMBC) loadBatteryBackedRAM(dump []uint8) error {
+	if len(dump) < len(m.ram) {
+		return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+	}
+	copy(m.ram, dump)
+
+	return nil
+}
+
+// saveState writes the ROM-only MBC's RAM to a save state. If there's no RAM,
+// nothing is written.
+func (m *romOnlyMBC) saveState(sw *stateWriter) {
+	if m.ram != nil {
+		sw.writeBytes(m.ram)
+	}
+}
+
+// loadState reads the ROM-only MBC's RAM from a save state.
+func (m *romOnlyMBC) loadState(sr *stateReader) {
+	if m.ram != nil {
+		sr.readBytesInto(m.ram)
+	}
+}
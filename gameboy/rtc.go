@@ -0,0 +1,254 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Clock is a source of the current time. Cartridges with a real time clock
+// consult a Clock instead of the system time directly, so that tests and
+// frontends can supply their own time. See WithClock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// systemClock is a Clock that reports the system's wall clock time.
+type systemClock struct{}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The RTC register selection values. These are written to the RAM Bank
+// Number/RTC Register Select area of an MBC3 to map the corresponding RTC
+// register to 0xA000-0xBFFF.
+const (
+	// rtcSecondsReg is the seconds counter, from 0 to 59.
+	rtcSecondsReg = 0x08
+	// rtcMinutesReg is the minutes counter, from 0 to 59.
+	rtcMinutesReg = 0x09
+	// rtcHoursReg is the hours counter, from 0 to 23.
+	rtcHoursReg = 0x0A
+	// rtcDayLowReg holds the lower 8 bits of the day counter.
+	rtcDayLowReg = 0x0B
+	// rtcDayHighReg holds the upper bit of the day counter and some control
+	// flags.
+	//
+	// Bit 7: Day counter carry. Set when the day counter overflows past 511.
+	//        It stays set until the game clears it.
+	// Bit 6: Halt. If 1, the clock stops counting.
+	// Bits 5-1: Unused
+	// Bit 0: The most significant bit of the day counter
+	rtcDayHighReg = 0x0C
+)
+
+// rtcSaveSize is the size in bytes of the RTC data appended to battery-backed
+// RAM in a game save. The layout is compatible with the one used by BGB and
+// VBA-M: five little-endian 32-bit values for the live registers, five more
+// for the latched registers, then a 64-bit UNIX timestamp of when the save was
+// made.
+const rtcSaveSize = 48
+
+// rtcLegacySaveSize is the size of the RTC data in saves that use a 32-bit
+// timestamp instead of a 64-bit one.
+const rtcLegacySaveSize = 44
+
+// rtcRegisters is a snapshot of the values in each RTC register.
+type rtcRegisters struct {
+	seconds uint8
+	minutes uint8
+	hours   uint8
+	// days is a 9-bit day counter.
+	days     uint16
+	halted   bool
+	dayCarry bool
+}
+
+// realTimeClock emulates the real time clock found in some MBC3 cartridges.
+// The clock keeps counting while the Game Boy is off thanks to a battery in
+// the cartridge, so it is driven by a real time source rather than by the
+// emulated CPU clock.
+type realTimeClock struct {
+	clock Clock
+
+	// live contains the registers that are actively counting.
+	live rtcRegisters
+	// latched contains a copy of the live registers that was taken the last
+	// time the game latched the clock. Games read from these registers.
+	latched rtcRegisters
+
+	// lastUpdate is the time that the live registers were last brought up to
+	// date.
+	lastUpdate time.Time
+}
+
+func newRealTimeClock(c Clock) *realTimeClock {
+	return &realTimeClock{
+		clock:      c,
+		lastUpdate: c.Now(),
+	}
+}
+
+// update brings the live registers up to date with the amount of time that
+// has passed since the last update.
+func (rtc *realTimeClock) update() {
+	now := rtc.clock.Now()
+
+	if rtc.live.halted {
+		// The clock isn't counting, so time passing has no effect
+		rtc.lastUpdate = now
+		return
+	}
+
+	elapsed := int64(now.Sub(rtc.lastUpdate) / time.Second)
+	if elapsed <= 0 {
+		return
+	}
+	// Only consume whole seconds so that partial seconds are counted in the
+	// next update
+	rtc.lastUpdate = rtc.lastUpdate.Add(time.Duration(elapsed) * time.Second)
+
+	rtc.advance(elapsed)
+}
+
+// advance moves the live registers forward by the given number of seconds.
+func (rtc *realTimeClock) advance(seconds int64) {
+	seconds += int64(rtc.live.seconds)
+	rtc.live.seconds = uint8(seconds % 60)
+
+	minutes := seconds/60 + int64(rtc.live.minutes)
+	rtc.live.minutes = uint8(minutes % 60)
+
+	hours := minutes/60 + int64(rtc.live.hours)
+	rtc.live.hours = uint8(hours % 24)
+
+	days := hours/24 + int64(rtc.live.days)
+	if days > 0x1FF {
+		// The day counter overflowed. This flag stays set until the game
+		// clears it
+		rtc.live.dayCarry = true
+	}
+	rtc.live.days = uint16(days % 0x200)
+}
+
+// latch copies the live registers into the latched registers, which is where
+// games read the time from.
+func (rtc *realTimeClock) latch() {
+	rtc.update()
+	rtc.latched = rtc.live
+}
+
+// at returns the latched value of the given RTC register.
+func (rtc *realTimeClock) at(reg uint8) uint8 {
+	switch reg {
+	case rtcSecondsReg:
+		return rtc.latched.seconds & 0x3F
+	case rtcMinutesReg:
+		return rtc.latched.minutes & 0x3F
+	case rtcHoursReg:
+		return rtc.latched.hours & 0x1F
+	case rtcDayLowReg:
+		return uint8(rtc.latched.days)
+	case rtcDayHighReg:
+		return rtc.latched.dayHigh()
+	default:
+		return 0xFF
+	}
+}
+
+// set writes a new value to the given live RTC register.
+func (rtc *realTimeClock) set(reg uint8, val uint8) {
+	rtc.update()
+
+	switch reg {
+	case rtcSecondsReg:
+		rtc.live.seconds = val & 0x3F
+		// Writing to the seconds register resets the internal counter that
+		// keeps track of partial seconds
+		rtc.lastUpdate = rtc.clock.Now()
+	case rtcMinutesReg:
+		rtc.live.minutes = val & 0x3F
+	case rtcHoursReg:
+		rtc.live.hours = val & 0x1F
+	case rtcDayLowReg:
+		rtc.live.days = (rtc.live.days & 0x100) | uint16(val)
+	case rtcDayHighReg:
+		rtc.live.setDayHigh(val)
+	}
+}
+
+// dayHigh returns the value of the Day High register for these registers.
+func (regs rtcRegisters) dayHigh() uint8 {
+	val := uint8(regs.days>>8) & 0x01
+	if regs.halted {
+		val |= 0x40
+	}
+	if regs.dayCarry {
+		val |= 0x80
+	}
+	return val
+}
+
+// setDayHigh decodes the given Day High register value into these registers.
+func (regs *rtcRegisters) setDayHigh(val uint8) {
+	regs.days = (regs.days & 0xFF) | (uint16(val&0x01) << 8)
+	regs.halted = val&0x40 == 0x40
+	regs.dayCarry = val&0x80 == 0x80
+}
+
+// dump returns the state of the RTC in a form that can be saved alongside
+// battery-backed RAM.
+func (rtc *realTimeClock) dump() []uint8 {
+	rtc.update()
+
+	dump := make([]uint8, rtcSaveSize)
+
+	putRegs := func(offset int, regs rtcRegisters) {
+		binary.LittleEndian.PutUint32(dump[offset:], uint32(regs.seconds))
+		binary.LittleEndian.PutUint32(dump[offset+4:], uint32(regs.minutes))
+		binary.LittleEndian.PutUint32(dump[offset+8:], uint32(regs.hours))
+		binary.LittleEndian.PutUint32(dump[offset+12:], uint32(uint8(regs.days)))
+		binary.LittleEndian.PutUint32(dump[offset+16:], uint32(regs.dayHigh()))
+	}
+	putRegs(0, rtc.live)
+	putRegs(20, rtc.latched)
+
+	binary.LittleEndian.PutUint64(dump[40:], uint64(rtc.lastUpdate.Unix()))
+
+	return dump
+}
+
+// load restores the state of the RTC from the given save data. The clock is
+// advanced by the time that has passed since the save was made. Returns false
+// if the data is not a valid RTC save.
+func (rtc *realTimeClock) load(dump []uint8) bool {
+	if len(dump) != rtcSaveSize && len(dump) != rtcLegacySaveSize {
+		return false
+	}
+
+	getRegs := func(offset int) rtcRegisters {
+		var regs rtcRegisters
+		regs.seconds = uint8(binary.LittleEndian.Uint32(dump[offset:]))
+		regs.minutes = uint8(binary.LittleEndian.Uint32(dump[offset+4:]))
+		regs.hours = uint8(binary.LittleEndian.Uint32(dump[offset+8:]))
+		regs.days = uint16(uint8(binary.LittleEndian.Uint32(dump[offset+12:])))
+		regs.setDayHigh(uint8(binary.LittleEndian.Uint32(dump[offset+16:])))
+		return regs
+	}
+	rtc.live = getRegs(0)
+	rtc.latched = getRegs(20)
+
+	var timestamp int64
+	if len(dump) == rtcSaveSize {
+		timestamp = int64(binary.LittleEndian.Uint64(dump[40:]))
+	} else {
+		timestamp = int64(binary.LittleEndian.Uint32(dump[40:]))
+	}
+	rtc.lastUpdate = time.Unix(timestamp, 0)
+
+	// Catch up on the time that passed while the emulator was off
+	rtc.update()
+
+	return true
+}
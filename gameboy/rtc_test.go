@@ -0,0 +1,171 @@
+package gameboy
+
+import (
+	"testing"
+	"time"
+)
+
+// rtcTime is the time kept by the RTC registers.
+type rtcTime struct {
+	seconds, minutes, hours uint8
+	dayLow, dayHigh         uint8
+}
+
+// readLatched returns the latched RTC registers.
+func readLatched(rtc *realTimeClock) rtcTime {
+	return rtcTime{
+		seconds: rtc.at(rtcSecondsReg),
+		minutes: rtc.at(rtcMinutesReg),
+		hours:   rtc.at(rtcHoursReg),
+		dayLow:  rtc.at(rtcDayLowReg),
+		dayHigh: rtc.at(rtcDayHighReg),
+	}
+}
+
+func TestRTCLatch(t *testing.T) {
+	clock := newTestClock()
+	rtc := newRealTimeClock(clock)
+
+	clock.advance(61 * time.Second)
+	if actual := readLatched(rtc); actual != (rtcTime{}) {
+		t.Fatalf("registers changed before latching: %+v", actual)
+	}
+
+	rtc.latch()
+	expected := rtcTime{seconds: 1, minutes: 1}
+	if actual := readLatched(rtc); actual != expected {
+		t.Fatalf("expected %+v after latching, got %+v", expected, actual)
+	}
+
+	// The latched registers don't change until the next latch
+	clock.advance(5 * time.Second)
+	if actual := readLatched(rtc); actual != expected {
+		t.Fatalf("latched registers changed to %+v", actual)
+	}
+	rtc.latch()
+	if actual := readLatched(rtc); actual.seconds != 6 {
+		t.Fatalf("expected 6 seconds after latching again, got %v", actual.seconds)
+	}
+}
+
+func TestRTCHalt(t *testing.T) {
+	clock := newTestClock()
+	rtc := newRealTimeClock(clock)
+
+	rtc.set(rtcDayHighReg, 0x40)
+	clock.advance(time.Hour)
+	rtc.latch()
+	if actual := readLatched(rtc); actual != (rtcTime{dayHigh: 0x40}) {
+		t.Fatalf("halted clock counted to %+v", actual)
+	}
+
+	// Time spent halted isn't counted after resuming
+	rtc.set(rtcDayHighReg, 0x00)
+	clock.advance(2 * time.Second)
+	rtc.latch()
+	if actual := readLatched(rtc); actual != (rtcTime{seconds: 2}) {
+		t.Fatalf("expected 2 seconds after resuming, got %+v", actual)
+	}
+}
+
+func TestRTCDayCarry(t *testing.T) {
+	clock := newTestClock()
+	rtc := newRealTimeClock(clock)
+
+	// Day 511, 23:59:59
+	rtc.set(rtcDayLowReg, 0xFF)
+	rtc.set(rtcDayHighReg, 0x01)
+	rtc.set(rtcHoursReg, 23)
+	rtc.set(rtcMinutesReg, 59)
+	rtc.set(rtcSecondsReg, 59)
+
+	clock.advance(time.Second)
+	rtc.latch()
+	if actual := readLatched(rtc); actual != (rtcTime{dayHigh: 0x80}) {
+		t.Fatalf("expected day 0 with the carry set, got %+v", actual)
+	}
+
+	// The carry stays set until the game clears it
+	clock.advance(24 * time.Hour)
+	rtc.latch()
+	if actual := readLatched(rtc); actual != (rtcTime{dayLow: 1, dayHigh: 0x80}) {
+		t.Fatalf("expected day 1 with the carry set, got %+v", actual)
+	}
+	rtc.set(rtcDayHighReg, 0x00)
+	rtc.latch()
+	if actual := readLatched(rtc); actual.dayHigh != 0x00 {
+		t.Fatalf("carry wasn't cleared, day high is %#x", actual.dayHigh)
+	}
+}
+
+func TestRTCSaveRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"64-bit timestamp", rtcSaveSize},
+		{"32-bit timestamp", rtcLegacySaveSize},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newTestClock()
+			rtc := newRealTimeClock(clock)
+			rtc.set(rtcHoursReg, 5)
+			rtc.latch()
+
+			dump := rtc.dump()
+			if len(dump) != rtcSaveSize {
+				t.Fatalf("expected a %v byte dump, got %v bytes", rtcSaveSize, len(dump))
+			}
+			// The 32-bit timestamp is the low half of the 64-bit one
+			dump = dump[:test.size]
+
+			// Time passes while the emulator is off
+			clock.advance(90 * time.Minute)
+			loaded := newRealTimeClock(clock)
+			if !loaded.load(dump) {
+				t.Fatalf("loading a %v byte save failed", test.size)
+			}
+
+			if actual := readLatched(loaded); actual != (rtcTime{hours: 5}) {
+				t.Fatalf("expected the latched registers to be restored, got %+v", actual)
+			}
+			loaded.latch()
+			if actual := readLatched(loaded); actual != (rtcTime{minutes: 30, hours: 6}) {
+				t.Fatalf("expected the clock to catch up to 6:30, got %+v", actual)
+			}
+		})
+	}
+
+	if newRealTimeClock(newTestClock()).load(make([]uint8, 40)) {
+		t.Fatalf("a save with the wrong size was loaded")
+	}
+}
+
+func TestMBC3RTCWithClock(t *testing.T) {
+	clock := newTestClock()
+	// MBC3+RTC+RAM+BATTERY with 8K of RAM
+	device := newTestCartridgeDevice(t, newTestCartridge(0x10, 0x00, 0x02), WithClock(clock))
+
+	clock.advance(2*time.Hour + 3*time.Second)
+
+	device.WriteMemory(0x0000, 0x0A) // Enable RAM and the RTC
+	device.WriteMemory(0x6000, 0x00) // Latch
+	device.WriteMemory(0x6000, 0x01)
+
+	registers := []struct {
+		reg uint8
+		val uint8
+	}{
+		{rtcSecondsReg, 3},
+		{rtcMinutesReg, 0},
+		{rtcHoursReg, 2},
+	}
+	for _, register := range registers {
+		device.WriteMemory(0x4000, register.reg)
+		if actual := device.ReadMemory(0xA000); actual != register.val {
+			t.Errorf("RTC register %#x is %v, expected %v", register.reg, actual, register.val)
+		}
+	}
+}
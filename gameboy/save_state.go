@@ -0,0 +1,367 @@
+package gameboy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrDeviceUnusable is returned when using a device that was left in an
+// inconsistent state, because a save state failed to load and the device's
+// previous state couldn't be restored.
+var ErrDeviceUnusable = xerrors.New("device is unusable")
+
+// stateRestoreError reports that a save state failed to load, and that the
+// device's previous state couldn't be restored afterwards.
+type stateRestoreError struct {
+	loadErr    error
+	restoreErr error
+}
+
+func (err *stateRestoreError) Error() string {
+	return fmt.Sprintf("%v: loading save state failed (%v), then restoring "+
+		"the previous state failed (%v)", ErrDeviceUnusable, err.loadErr, err.restoreErr)
+}
+
+// Is reports that the device is unusable.
+func (err *stateRestoreError) Is(target error) bool {
+	return target == ErrDeviceUnusable
+}
+
+// Unwrap returns the error from loading the save state.
+func (err *stateRestoreError) Unwrap() error {
+	return err.loadErr
+}
+
+// saveStateMagic is written at the start of every save state to identify it.
+var saveStateMagic = [4]uint8{'G', 'B', 'S', 'S'}
+
+const (
+	// saveStateVersion is the version of the save state format written by
+	// SaveState. It must be incremented whenever the format changes. Loading
+	// code can check stateReader.version to migrate data from older versions.
+	saveStateVersion = 2
+	// minSaveStateVersion is the oldest save state format version that can
+	// still be loaded.
+	minSaveStateVersion = 1
+	// maxSaveStateBytes is the largest byte slice that will be read from a
+	// save state. This keeps corrupted save states from causing huge
+	// allocations.
+	maxSaveStateBytes = 1 << 24
+)
+
+// stateWriter writes the state of components to a save state. The first
+// error encountered is kept and all writes after it are ignored, so that
+// components don't need to check for errors after every value.
+type stateWriter struct {
+	w   io.Writer
+	err error
+
+	// blocks, if not nil, receives copies of byte slices instead of w, which
+	// must be buf. This is much faster than writing out a save state, so it's
+	// used to take rewind snapshots that are put together later.
+	blocks *stateBlocks
+	buf    *bytes.Buffer
+}
+
+// write writes the given fixed-size value or slice of fixed-size values.
+func (sw *stateWriter) write(data interface{}) {
+	if sw.err != nil {
+		return
+	}
+	sw.err = binary.Write(sw.w, binary.LittleEndian, data)
+}
+
+// writeInt writes an int as a 64-bit value.
+func (sw *stateWriter) writeInt(val int) {
+	sw.write(int64(val))
+}
+
+// writeBytes writes a byte slice, prefixed by its length.
+func (sw *stateWriter) writeBytes(data []uint8) {
+	sw.write(uint32(len(data)))
+	sw.writeRaw(data)
+}
+
+// writeRaw writes a byte slice without its length, for slices whose length
+// is always the same.
+func (sw *stateWriter) writeRaw(data []uint8) {
+	if sw.blocks != nil {
+		sw.blocks.add(sw.buf.Len(), data)
+		return
+	}
+	sw.write(data)
+}
+
+// writeColors writes a palette of colors.
+func (sw *stateWriter) writeColors(palette [4]color) {
+	for _, c := range palette {
+		sw.write([]uint8{c.r, c.g, c.b, c.a})
+	}
+}
+
+// stateBlocks holds copies of the byte slices written to a save state, along
+// with where they go in the rest of it.
+type stateBlocks struct {
+	// data contains the slices back to back.
+	data []uint8
+	// positions contains where each slice goes in the rest of the save
+	// state.
+	positions []int
+	// ends contains where each slice ends in data.
+	ends []int
+}
+
+// add copies a slice that goes at the given position.
+func (blocks *stateBlocks) add(position int, data []uint8) {
+	blocks.data = append(blocks.data, data...)
+	blocks.positions = append(blocks.positions, position)
+	blocks.ends = append(blocks.ends, len(blocks.data))
+}
+
+// reset empties the blocks, keeping their memory for reuse.
+func (blocks *stateBlocks) reset() {
+	blocks.data = blocks.data[:0]
+	blocks.positions = blocks.positions[:0]
+	blocks.ends = blocks.ends[:0]
+}
+
+// assemble puts the slices back in their places in the rest of the save
+// state, appending the resulting save state to dst.
+func (blocks *stateBlocks) assemble(dst, rest []uint8) []uint8 {
+	prevPosition, prevEnd := 0, 0
+	for i, position := range blocks.positions {
+		dst = append(dst, rest[prevPosition:position]...)
+		dst = append(dst, blocks.data[prevEnd:blocks.ends[i]]...)
+		prevPosition, prevEnd = position, blocks.ends[i]
+	}
+	return append(dst, rest[prevPosition:]...)
+}
+
+// stateReader reads the state of components from a save state. Like
+// stateWriter, the first error encountered is kept and all reads after it
+// are ignored.
+type stateReader struct {
+	r io.Reader
+	// version is the format version of the save state being read.
+	version uint16
+	err     error
+}
+
+// read reads into the given pointer to a fixed-size value or slice of
+// fixed-size values.
+func (sr *stateReader) read(data interface{}) {
+	if sr.err != nil {
+		return
+	}
+	sr.err = binary.Read(sr.r, binary.LittleEndian, data)
+}
+
+// readInt reads an int that was written by writeInt.
+func (sr *stateReader) readInt() int {
+	var val int64
+	sr.read(&val)
+	return int(val)
+}
+
+// readBool reads a bool.
+func (sr *stateReader) readBool() bool {
+	var val bool
+	sr.read(&val)
+	return val
+}
+
+// readUint8 reads a single byte.
+func (sr *stateReader) readUint8() uint8 {
+	var val uint8
+	sr.read(&val)
+	return val
+}
+
+// readUint16 reads a 16-bit value.
+func (sr *stateReader) readUint16() uint16 {
+	var val uint16
+	sr.read(&val)
+	return val
+}
+
+// readBytes reads a byte slice that was written by writeBytes.
+func (sr *stateReader) readBytes() []uint8 {
+	var length uint32
+	sr.read(&length)
+	if sr.err != nil {
+		return nil
+	}
+	if length > maxSaveStateBytes {
+		sr.fail("byte slice of length %v is too large", length)
+		return nil
+	}
+
+	data := make([]uint8, length)
+	sr.read(data)
+	return data
+}
+
+// readBytesInto reads a byte slice that was written by writeBytes into the
+// given slice. The lengths of the two slices must match.
+func (sr *stateReader) readBytesInto(dst []uint8) {
+	data := sr.readBytes()
+	if sr.err != nil {
+		return
+	}
+	if len(data) != len(dst) {
+		sr.fail("expected %v bytes, got %v", len(dst), len(data))
+		return
+	}
+	copy(dst, data)
+}
+
+// readColors reads a palette of colors.
+func (sr *stateReader) readColors(palette *[4]color) {
+	for i := range palette {
+		var rgba [4]uint8
+		sr.read(&rgba)
+		palette[i] = color{r: rgba[0], g: rgba[1], b: rgba[2], a: rgba[3]}
+	}
+}
+
+// fail marks the save state as invalid with the given reason, if an error
+// hasn't happened already.
+func (sr *stateReader) fail(format string, args ...interface{}) {
+	if sr.err == nil {
+		sr.err = fmt.Errorf(format, args...)
+	}
+}
+
+// SaveState writes a snapshot of the entire device to the given writer. The
+// snapshot can later be restored with LoadState. Drivers are not part of the
+// snapshot.
+//
+// Snapshots can only be taken between instructions, which is where RunFrame,
+// StepInstruction and Start leave the device. This method must not be called
+// while the device is running.
+func (device *Device) SaveState(w io.Writer) error {
+	if device.unusable != nil {
+		return device.unusable
+	}
+	if device.currentInstruction != nil {
+		return xerrors.New("save states can only be made between instructions")
+	}
+
+	sw := &stateWriter{w: w}
+	device.writeState(sw)
+
+	if sw.err != nil {
+		return xerrors.Errorf("writing save state: %w", sw.err)
+	}
+	return nil
+}
+
+// writeState writes a snapshot of the entire device.
+func (device *Device) writeState(sw *stateWriter) {
+	sw.write(saveStateMagic)
+	sw.write(uint16(saveStateVersion))
+	sw.write(uint8(device.model))
+	sw.writeBytes([]uint8(device.header.title))
+	sw.write(device.header.headerChecksum)
+
+	device.state.saveState(sw)
+	device.state.mmu.saveState(sw)
+	device.state.mmu.mbc.saveState(sw)
+	device.timers.saveState(sw)
+	device.videoController.saveState(sw)
+	device.SoundController.saveState(sw)
+	device.joypad.saveState(sw)
+	device.interruptManager.saveState(sw)
+	device.serial.saveState(sw)
+}
+
+// LoadState restores a snapshot of the device that was made with SaveState.
+// The snapshot must have been made with the same cartridge and hardware
+// model. If the snapshot can't be loaded, the device is left unchanged. In
+// the unlikely case that the device's previous state can't be restored
+// either, an error wrapping ErrDeviceUnusable is returned and the device
+// can't run until a snapshot is loaded successfully.
+//
+// This method must not be called while the device is running.
+func (device *Device) LoadState(r io.Reader) error {
+	if device.unusable != nil {
+		// There's no good state to fall back on
+		if err := device.loadState(r); err != nil {
+			return err
+		}
+		device.unusable = nil
+		return nil
+	}
+
+	// Keep a copy of the current state to fall back on in case loading fails
+	// partway through
+	var backup bytes.Buffer
+	if err := device.SaveState(&backup); err != nil {
+		return xerrors.Errorf("backing up current state: %w", err)
+	}
+
+	if err := device.loadState(r); err != nil {
+		if restoreErr := device.loadState(&backup); restoreErr != nil {
+			device.unusable = &stateRestoreError{loadErr: err, restoreErr: restoreErr}
+			return device.unusable
+		}
+		return err
+	}
+
+	return nil
+}
+
+// loadState reads a snapshot of the device without any error recovery.
+func (device *Device) loadState(r io.Reader) error {
+	sr := &stateReader{r: r}
+
+	var magic [4]uint8
+	sr.read(&magic)
+	sr.read(&sr.version)
+	if sr.err != nil {
+		return xerrors.Errorf("reading save state header: %w", sr.err)
+	}
+	if magic != saveStateMagic {
+		return xerrors.New("not a save state")
+	}
+	if sr.version < minSaveStateVersion || sr.version > saveStateVersion {
+		return xerrors.Errorf("unsupported save state version %v, expected %v to %v",
+			sr.version, minSaveStateVersion, saveStateVersion)
+	}
+
+	model := Model(sr.readUint8())
+	title := string(sr.readBytes())
+	headerChecksum := sr.readUint8()
+	if sr.err != nil {
+		return xerrors.Errorf("reading save state header: %w", sr.err)
+	}
+	if model != device.model {
+		return xerrors.Errorf("save state is for model %v, but the device is a %v",
+			model, device.model)
+	}
+	if title != device.header.title || headerChecksum != device.header.headerChecksum {
+		return xerrors.Errorf("save state is for a different game: %q", title)
+	}
+
+	device.state.loadState(sr)
+	device.state.mmu.loadState(sr)
+	device.state.mmu.mbc.loadState(sr)
+	device.timers.loadState(sr)
+	device.videoController.loadState(sr)
+	device.SoundController.loadState(sr)
+	device.joypad.loadState(sr)
+	device.interruptManager.loadState(sr)
+	device.serial.loadState(sr)
+
+	if sr.err != nil {
+		return xerrors.Errorf("reading save state: %w", sr.err)
+	}
+
+	device.currentInstruction = nil
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package gameboy
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// newCountingDevice creates a device running a program that counts up in A
+// forever.
+func newCountingDevice(t *testing.T) *Device {
+	t.Helper()
+
+	rom := newTestCartridge(0x00, 0x00, 0x00)
+	copy(rom[0x0100:], []uint8{
+		0x3C,       // inc a
+		0x18, 0xFD, // jr -3
+	})
+	return newTestCartridgeDevice(t, rom)
+}
+
+func TestLoadState(t *testing.T) {
+	device := newCountingDevice(t)
+	if err := device.RunFrame(); err != nil {
+		t.Fatalf("running: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := device.SaveState(&snapshot); err != nil {
+		t.Fatalf("saving state: %v", err)
+	}
+	saved := device.Registers()
+
+	if err := device.RunFrame(); err != nil {
+		t.Fatalf("running: %v", err)
+	}
+	running := device.Registers()
+
+	// A truncated snapshot leaves the device unchanged
+	truncated := snapshot.Bytes()[:snapshot.Len()/2]
+	if err := device.LoadState(bytes.NewReader(truncated)); err == nil {
+		t.Fatalf("loading a truncated snapshot succeeded")
+	}
+	if actual := device.Registers(); actual != running {
+		t.Fatalf("registers changed to %+v after a failed load, expected %+v", actual, running)
+	}
+
+	if err := device.LoadState(bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatalf("loading state: %v", err)
+	}
+	if actual := device.Registers(); actual != saved {
+		t.Fatalf("registers are %+v after loading, expected %+v", actual, saved)
+	}
+}
+
+func TestUnusableDevice(t *testing.T) {
+	device := newCountingDevice(t)
+
+	var snapshot bytes.Buffer
+	if err := device.SaveState(&snapshot); err != nil {
+		t.Fatalf("saving state: %v", err)
+	}
+
+	// Simulate a failed load that couldn't be undone
+	device.unusable = &stateRestoreError{
+		loadErr:    xerrors.New("load failed"),
+		restoreErr: xerrors.New("restore failed"),
+	}
+
+	if err := device.RunFrame(); !xerrors.Is(err, ErrDeviceUnusable) {
+		t.Fatalf("expected running to fail with ErrDeviceUnusable, got %v", err)
+	}
+	if err := device.SaveState(&bytes.Buffer{}); !xerrors.Is(err, ErrDeviceUnusable) {
+		t.Fatalf("expected saving state to fail with ErrDeviceUnusable, got %v", err)
+	}
+
+	// Loading a good snapshot makes the device usable again
+	if err := device.LoadState(bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatalf("loading state: %v", err)
+	}
+	if err := device.RunFrame(); err != nil {
+		t.Fatalf("running after recovering: %v", err)
+	}
+}
@@ -1,22 +1,158 @@
 package gameboy
 
+const (
+	// serialBitMCycles is the number of M-Cycles it takes to transfer one bit
+	// at normal speed, which is 8192 bits per second.
+	serialBitMCycles = cpuClockRate / ticksPerMCycle / 8192
+	// serialFastBitMCycles is the number of M-Cycles it takes to transfer one
+	// bit at the CGB's fast speed, which is 262144 bits per second.
+	serialFastBitMCycles = cpuClockRate / ticksPerMCycle / 262144
+)
+
+// syncedSerialDriver is a SerialDriver that keeps the Game Boy in step with a
+// peer running elsewhere.
+type syncedSerialDriver interface {
+	SerialDriver
+	// tick is called every M-Cycle. It may block until the peer catches up.
+	tick()
+}
+
 // serial controls transfer operations to and from the Game Boy through the
 // link cable.
-type serial struct{}
+//
+// Transfers are emulated a byte at a time. The peer receives the byte and
+// SB is updated once all eight bits would have been shifted out.
+type serial struct {
+	state            *State
+	interruptManager *interruptManager
 
-func newSerial(state *State) *serial {
-	s := &serial{}
+	driver SerialDriver
+	// syncedDriver is the driver if it needs to know how much time passes to
+	// keep in step with its peer, or nil otherwise.
+	syncedDriver syncedSerialDriver
 
+	// transferCycles is the number of M-Cycles that the current transfer
+	// using the internal clock has been running for.
+	transferCycles int
+	// pending is true if this Game Boy is waiting for the peer to start a
+	// transfer using the external clock.
+	pending bool
+}
+
+func newSerial(state *State, driver SerialDriver) *serial {
+	s := &serial{
+		state:  state,
+		driver: driver,
+	}
+	s.syncedDriver, _ = driver.(syncedSerialDriver)
+
+	state.mmu.subscribeTo(sbAddr, s.onSBWrite)
 	state.mmu.subscribeTo(scAddr, s.onSCWrite)
 
 	return s
 }
 
+// tick progresses any serial transfer by one M-Cycle.
+func (s *serial) tick() {
+	if s.syncedDriver != nil {
+		s.syncedDriver.tick()
+	}
+
+	sc := s.state.mmu.memory[scAddr]
+	if sc&0x80 == 0 {
+		// No transfer is in progress
+		return
+	}
+
+	if s.pending {
+		// Wait for the peer to start the transfer
+		in, ok := s.driver.Receive()
+		if ok {
+			s.pending = false
+			s.finishTransfer(in)
+		}
+		return
+	}
+
+	bitMCycles := serialBitMCycles
+	if s.state.mmu.cgbMode && sc&0x02 == 0x02 {
+		bitMCycles = serialFastBitMCycles
+	}
+
+	s.transferCycles++
+	if s.transferCycles >= bitMCycles*8 {
+		in := s.driver.Transfer(s.state.mmu.memory[sbAddr])
+		s.finishTransfer(in)
+	}
+}
+
+// finishTransfer puts the received byte in SB, marks the transfer as done and
+// flags a serial interrupt.
+func (s *serial) finishTransfer(in uint8) {
+	s.state.mmu.setNoNotify(sbAddr, in)
+	s.state.mmu.setNoNotify(scAddr, s.state.mmu.memory[scAddr]&^0x80)
+	s.transferCycles = 0
+
+	s.interruptManager.flagSerialIO()
+}
+
+// onSBWrite triggers when the Serial Transfer Data register is written to. If
+// a transfer using the external clock is pending, the peer is given the new
+// value to receive.
+func (s *serial) onSBWrite(addr uint16, val uint8) uint8 {
+	if s.pending {
+		s.driver.SetPending(val, true)
+	}
+
+	return val
+}
+
 // onSCWrite triggers when the SIO Control register is written to. It
 // configures or starts a serial transfer.
 func (s *serial) onSCWrite(addr uint16, val uint8) uint8 {
-	// TODO(velovix): Actually trigger some behavior
+	startTransfer := val&0x80 == 0x80
+	internalClock := val&0x01 == 0x01
+
+	s.transferCycles = 0
 
+	wasPending := s.pending
+	s.pending = startTransfer && !internalClock
+	if s.pending {
+		s.driver.SetPending(s.state.mmu.memory[sbAddr], true)
+	} else if wasPending {
+		s.driver.SetPending(0xFF, false)
+	}
+
+	if s.state.mmu.cgbMode {
+		// Unused bits 6-2 are always 1
+		return val | 0x7C
+	}
 	// Unused bits 6-1 are always 1
 	return val | 0x7E
 }
+
+// saveState writes the state of the serial port to a save state.
+func (s *serial) saveState(sw *stateWriter) {
+	sw.writeInt(s.transferCycles)
+	sw.write(s.pending)
+}
+
+// loadState reads the state of the serial port from a save state. The driver
+// is told about any pending transfer, since it may have been connected after
+// the save state was made.
+func (s *serial) loadState(sr *stateReader) {
+	if sr.version >= 2 {
+		s.transferCycles = sr.readInt()
+		s.pending = sr.readBool()
+	} else {
+		// Serial state was added in version 2. Any transfer in progress is
+		// restarted.
+		sc := s.state.mmu.memory[scAddr]
+		s.transferCycles = 0
+		s.pending = sc&0x81 == 0x80
+	}
+
+	if sr.err == nil {
+		s.driver.SetPending(s.state.mmu.memory[sbAddr], s.pending)
+	}
+}
@@ -44,6 +44,10 @@ type SoundController struct {
 	PulseB *PulseB
 	Wave   *Wave
 	Noise  *Noise
+
+	// Speaker is the cartridge's speaker, or nil if it doesn't have one. The
+	// cartridge plays it even while the rest of the controller is off.
+	Speaker *Speaker
 }
 
 type PulseA struct {
@@ -367,6 +371,19 @@ const (
 	WidthMode15Bit LFSRWidthMode = 15
 )
 
+// Speaker is a speaker built in to some cartridges, separate from the Game
+// Boy's own sound. It plays square wave beeps at a fixed volume.
+type Speaker struct {
+	On bool
+
+	frequency float64
+}
+
+// Frequency returns the frequency of the beep being played, in Hz.
+func (speaker *Speaker) Frequency() float64 {
+	return speaker.frequency
+}
+
 func newSoundController(state *State) *SoundController {
 	sc := &SoundController{
 		state:  state,
@@ -645,3 +662,125 @@ func (sc *SoundController) readWaveTable() []uint8 {
 
 	return wavePattern
 }
+
+// saveState writes the state of the sound controller and its voices to a save
+// state.
+func (sc *SoundController) saveState(sw *stateWriter) {
+	sw.writeInt(sc.frameSequencer)
+	sw.writeInt(sc.tClock)
+	sw.write(sc.Enabled)
+	sw.writeInt(sc.leftVolume)
+	sw.writeInt(sc.rightVolume)
+
+	pa := sc.PulseA
+	sw.write([]bool{pa.On, pa.RightEnabled, pa.LeftEnabled})
+	sw.writeInt(pa.volume)
+	sw.writeInt(pa.frequency)
+	sw.writeInt(pa.duration)
+	sw.write(pa.useDuration)
+	sw.writeInt(pa.volumePeriod)
+	sw.write(pa.amplify)
+	sw.writeInt(pa.dutyCycle)
+	sw.writeInt(pa.lastFrequency)
+	sw.writeInt(pa.frequencyPeriod)
+	sw.write(pa.attenuate)
+	sw.write(uint8(pa.sweepShift))
+	sw.write(pa.useFrequencySweep)
+
+	pb := sc.PulseB
+	sw.write([]bool{pb.On, pb.RightEnabled, pb.LeftEnabled})
+	sw.writeInt(pb.volume)
+	sw.writeInt(pb.frequency)
+	sw.writeInt(pb.duration)
+	sw.write(pb.useDuration)
+	sw.writeInt(pb.volumePeriod)
+	sw.write(pb.amplify)
+	sw.writeInt(pb.dutyCycle)
+
+	w := sc.Wave
+	sw.write([]bool{w.On, w.RightEnabled, w.LeftEnabled})
+	sw.writeInt(w.volume)
+	sw.writeInt(w.frequency)
+	sw.writeBytes(w.pattern)
+	sw.writeInt(w.duration)
+	sw.write(w.useDuration)
+	sw.writeInt(w.rightShiftCode)
+
+	n := sc.Noise
+	sw.write([]bool{n.On, n.LeftEnabled, n.RightEnabled})
+	sw.writeInt(n.duration)
+	sw.write(n.useDuration)
+	sw.writeInt(n.volume)
+	sw.writeInt(n.volumePeriod)
+	sw.write(n.amplify)
+	sw.writeInt(n.shiftClockFrequency)
+	sw.writeInt(n.dividingRatio)
+	sw.write(n.lfsr)
+	sw.writeInt(int(n.widthMode))
+}
+
+// loadState reads the state of the sound controller and its voices from a
+// save state.
+func (sc *SoundController) loadState(sr *stateReader) {
+	sc.frameSequencer = sr.readInt()
+	sc.tClock = sr.readInt()
+	sc.Enabled = sr.readBool()
+	sc.leftVolume = sr.readInt()
+	sc.rightVolume = sr.readInt()
+
+	var flags [3]bool
+
+	pa := sc.PulseA
+	sr.read(&flags)
+	pa.On, pa.RightEnabled, pa.LeftEnabled = flags[0], flags[1], flags[2]
+	pa.volume = sr.readInt()
+	pa.frequency = sr.readInt()
+	pa.duration = sr.readInt()
+	pa.useDuration = sr.readBool()
+	pa.volumePeriod = sr.readInt()
+	pa.amplify = sr.readBool()
+	pa.dutyCycle = sr.readInt()
+	pa.lastFrequency = sr.readInt()
+	pa.frequencyPeriod = sr.readInt()
+	pa.attenuate = sr.readBool()
+	pa.sweepShift = uint(sr.readUint8())
+	pa.useFrequencySweep = sr.readBool()
+
+	pb := sc.PulseB
+	sr.read(&flags)
+	pb.On, pb.RightEnabled, pb.LeftEnabled = flags[0], flags[1], flags[2]
+	pb.volume = sr.readInt()
+	pb.frequency = sr.readInt()
+	pb.duration = sr.readInt()
+	pb.useDuration = sr.readBool()
+	pb.volumePeriod = sr.readInt()
+	pb.amplify = sr.readBool()
+	pb.dutyCycle = sr.readInt()
+
+	w := sc.Wave
+	sr.read(&flags)
+	w.On, w.RightEnabled, w.LeftEnabled = flags[0], flags[1], flags[2]
+	w.volume = sr.readInt()
+	w.frequency = sr.readInt()
+	w.pattern = sr.readBytes()
+	w.duration = sr.readInt()
+	w.useDuration = sr.readBool()
+	w.rightShiftCode = sr.readInt()
+
+	n := sc.Noise
+	sr.read(&flags)
+	n.On, n.LeftEnabled, n.RightEnabled = flags[0], flags[1], flags[2]
+	n.duration = sr.readInt()
+	n.useDuration = sr.readBool()
+	n.volume = sr.readInt()
+	n.volumePeriod = sr.readInt()
+	n.amplify = sr.readBool()
+	n.shiftClockFrequency = sr.readInt()
+	n.dividingRatio = sr.readInt()
+	sr.read(&n.lfsr)
+	n.widthMode = LFSRWidthMode(sr.readInt())
+
+	if pa.dutyCycle < 0 || pa.dutyCycle > 3 || pb.dutyCycle < 0 || pb.dutyCycle > 3 {
+		sr.fail("invalid duty cycle")
+	}
+}
@@ -34,6 +34,10 @@ type State struct {
 	// halted and the screen is turned white. This mode is exited when a button
 	// is pressed.
 	stopped bool
+	// If true, the CPU is running in the CGB's double speed mode. The CPU and
+	// timers run twice as fast, while the video and sound controllers keep
+	// running at normal speed.
+	doubleSpeed bool
 
 	// A program counter value pointing to the start of the current
 	// instruction.
@@ -77,7 +81,8 @@ func NewState(mmu *mmu) *State {
 // incrementPC increments the program counter by 1 and returns the value that
 // was at its previous location.
 func (state *State) incrementPC() uint8 {
-	poppedVal := state.mmu.at(state.regPC.get())
+	// Instruction fetches aren't data reads, so watchpoints don't see them
+	poppedVal := state.mmu.peek(state.regPC.get())
 	state.regPC.set(state.regPC.get() + 1)
 
 	return poppedVal
@@ -206,3 +211,49 @@ const (
 	// from the most significant bit.
 	carryFlag = 0x10
 )
+
+// saveState writes the CPU state to a save state.
+func (state *State) saveState(sw *stateWriter) {
+	sw.write([]uint8{
+		state.regA.get(),
+		state.regF.get(),
+		state.regB.get(),
+		state.regC.get(),
+		state.regD.get(),
+		state.regE.get(),
+		state.regH.get(),
+		state.regL.get(),
+	})
+	sw.write(state.regSP.get())
+	sw.write(state.regPC.get())
+
+	sw.writeInt(state.enableInterruptsTimer)
+	sw.write(state.interruptsEnabled)
+	sw.write(state.halted)
+	sw.write(state.stopped)
+	sw.write(state.doubleSpeed)
+	sw.write(state.instructionStart)
+}
+
+// loadState reads the CPU state from a save state.
+func (state *State) loadState(sr *stateReader) {
+	var regs [8]uint8
+	sr.read(&regs)
+	state.regA.set(regs[0])
+	state.regF.set(regs[1])
+	state.regB.set(regs[2])
+	state.regC.set(regs[3])
+	state.regD.set(regs[4])
+	state.regE.set(regs[5])
+	state.regH.set(regs[6])
+	state.regL.set(regs[7])
+	state.regSP.set(sr.readUint16())
+	state.regPC.set(sr.readUint16())
+
+	state.enableInterruptsTimer = sr.readInt()
+	state.interruptsEnabled = sr.readBool()
+	state.halted = sr.readBool()
+	state.stopped = sr.readBool()
+	state.doubleSpeed = sr.readBool()
+	state.instructionStart = sr.readUint16()
+}
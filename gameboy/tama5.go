@@ -0,0 +1,359 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// TAMA5 registers. Games select a register by writing its index to 0xA001,
+// then write a nibble to it through 0xA000 or read it back from 0xA000.
+const (
+	// tama5RegROMBankLow is the lower 4 bits of the ROM bank.
+	tama5RegROMBankLow = 0x0
+	// tama5RegROMBankHigh is bit 4 of the ROM bank.
+	tama5RegROMBankHigh = 0x1
+	// tama5RegDataLow and tama5RegDataHigh are the nibbles of the byte that
+	// the next command writes.
+	tama5RegDataLow  = 0x4
+	tama5RegDataHigh = 0x5
+	// tama5RegCommand holds the next command in bits 1-3 and bit 4 of the
+	// command's address in bit 0.
+	tama5RegCommand = 0x6
+	// tama5RegAddrLow holds the lower 4 bits of the command's address.
+	// Writing to it runs the command.
+	tama5RegAddrLow = 0x7
+	// tama5RegReady reads as 1 when the TAMA5 is ready to take commands.
+	tama5RegReady = 0xA
+	// tama5RegResultLow and tama5RegResultHigh are the nibbles of the byte
+	// that the last command read.
+	tama5RegResultLow  = 0xC
+	tama5RegResultHigh = 0xD
+)
+
+// TAMA5 commands, written to bits 1-3 of the command register.
+const (
+	// tama5CommandWriteEEPROM writes the data byte to the EEPROM.
+	tama5CommandWriteEEPROM = 0x0
+	// tama5CommandReadEEPROM reads a byte from the EEPROM.
+	tama5CommandReadEEPROM = 0x1
+	// tama5CommandWriteRTC writes the lower nibble of the data byte to an RTC
+	// register.
+	tama5CommandWriteRTC = 0x2
+	// tama5CommandReadRTC reads an RTC register.
+	tama5CommandReadRTC = 0x4
+)
+
+// TAMA5 RTC registers. Each one holds a decimal digit of the current date and
+// time.
+const (
+	tama5RTCSecondsOnes = 0x0
+	tama5RTCSecondsTens = 0x1
+	tama5RTCMinutesOnes = 0x2
+	tama5RTCMinutesTens = 0x3
+	tama5RTCHoursOnes   = 0x4
+	tama5RTCHoursTens   = 0x5
+	tama5RTCWeekday     = 0x6
+	tama5RTCDayOnes     = 0x7
+	tama5RTCDayTens     = 0x8
+	tama5RTCMonthOnes   = 0x9
+	tama5RTCMonthTens   = 0xA
+	tama5RTCYearOnes    = 0xB
+	tama5RTCYearTens    = 0xC
+)
+
+const (
+	// tama5EEPROMSize is the size of the TAMA5's EEPROM in bytes.
+	tama5EEPROMSize = 0x20
+	// tama5RTCSaveSize is the size in bytes of the RTC data appended to the
+	// EEPROM in a game save. It's the RTC's time as a little-endian 64-bit
+	// UNIX timestamp, followed by another one of when the save was made.
+	tama5RTCSaveSize = 16
+)
+
+// tama5 implements Bandai's TAMA5 memory bank controller, used by Tamagotchi 3.
+// Instead of mapping RAM, it exposes a window of two addresses that games use
+// to talk to its registers one nibble at a time. Through these registers, it
+// switches ROM banks and runs commands that access a small EEPROM and a real
+// time clock.
+type tama5 struct {
+	// romBanks contains cartridge ROM banks, indexed by their bank number.
+	romBanks [][]uint8
+
+	// registers holds the last nibble written to each register.
+	registers [0x10]uint8
+	// currRegister is the register selected by writing to 0xA001.
+	currRegister uint8
+	// result is the byte read by the last command.
+	result uint8
+
+	// eeprom is the battery-backed EEPROM where games store their saves.
+	eeprom [tama5EEPROMSize]uint8
+
+	clock Clock
+	// rtcTime is the RTC's time as of lastUpdate. The RTC's time is set
+	// separately from the system time, so this keeps track of the difference.
+	rtcTime time.Time
+	// lastUpdate is the system time that rtcTime was last set.
+	lastUpdate time.Time
+
+	// out is where warnings are printed.
+	out io.Writer
+}
+
+func newTAMA5(header romHeader, cartridgeData []uint8, clock Clock, out io.Writer) *tama5 {
+	var m tama5
+
+	m.out = out
+
+	m.romBanks = makeROMBanks(header.romSizeType, cartridgeData)
+
+	m.clock = clock
+	m.lastUpdate = m.clock.Now()
+	m.rtcTime = m.lastUpdate
+
+	return &m
+}
+
+// at provides access to the TAMA5 banked ROM and register window.
+func (m *tama5) at(addr uint16) uint8 {
+	switch {
+	case inBank0ROMArea(addr):
+		return m.romBanks[0][addr]
+	case inBankedROMArea(addr):
+		return m.romBanks[m.currentROMBank()][addr-bankedROMAddr]
+	case inBankedRAMArea(addr):
+		if addr&0x1 == 0x1 {
+			// The register select address can't be read
+			return 0xFF
+		}
+
+		// Only the upper nibble of the register window is unused
+		switch m.currRegister {
+		case tama5RegReady:
+			// Commands finish right away, so the TAMA5 is always ready
+			return 0xF1
+		case tama5RegResultLow:
+			return 0xF0 | m.result&0x0F
+		case tama5RegResultHigh:
+			return 0xF0 | m.result>>4
+		default:
+			return 0xFF
+		}
+	default:
+		panic(fmt.Sprintf("TAMA5 is unable to handle reads to address %#x", addr))
+	}
+}
+
+// currentROMBank returns the ROM bank mapped to 0x4000-0x7FFF.
+func (m *tama5) currentROMBank() int {
+	// If an out-of-bounds ROM bank is selected, the value will "wrap around"
+	return int(m.romBank()) % len(m.romBanks)
+}
+
+// set writes to the TAMA5 register window. The rest of the address space
+// doesn't do anything when written to.
+func (m *tama5) set(addr uint16, val uint8) {
+	switch {
+	case inBank0ROMArea(addr) || inBankedROMArea(addr):
+		// The TAMA5 is only controlled through the register window
+	case inBankedRAMArea(addr):
+		if addr&0x1 == 0x1 {
+			m.currRegister = val & 0x0F
+			return
+		}
+
+		m.registers[m.currRegister] = val & 0x0F
+		if m.currRegister == tama5RegAddrLow {
+			m.runCommand()
+		}
+	default:
+		panic(fmt.Sprintf("TAMA5 is unable to handle writes to address %#x", addr))
+	}
+}
+
+// romBank returns the currently selected ROM bank.
+func (m *tama5) romBank() uint8 {
+	return m.registers[tama5RegROMBankLow] | (m.registers[tama5RegROMBankHigh]&0x1)<<4
+}
+
+// runCommand runs the command set up in the registers.
+func (m *tama5) runCommand() {
+	command := m.registers[tama5RegCommand] >> 1
+	addr := (m.registers[tama5RegCommand]&0x1)<<4 | m.registers[tama5RegAddrLow]
+	data := m.registers[tama5RegDataHigh]<<4 | m.registers[tama5RegDataLow]
+
+	switch command {
+	case tama5CommandWriteEEPROM:
+		m.eeprom[addr] = data
+	case tama5CommandReadEEPROM:
+		m.result = m.eeprom[addr]
+	case tama5CommandWriteRTC:
+		m.setRTCDigit(addr, data&0x0F)
+	case tama5CommandReadRTC:
+		m.result = m.rtcDigit(addr)
+	default:
+		fmt.Fprintf(m.out, "Unknown TAMA5 command %#x\n", command)
+	}
+}
+
+// now returns the RTC's current time.
+func (m *tama5) now() time.Time {
+	return m.rtcTime.Add(m.clock.Now().Sub(m.lastUpdate))
+}
+
+// rtcDigit returns the value of the given RTC register.
+func (m *tama5) rtcDigit(reg uint8) uint8 {
+	now := m.now()
+
+	switch reg {
+	case tama5RTCSecondsOnes:
+		return uint8(now.Second() % 10)
+	case tama5RTCSecondsTens:
+		return uint8(now.Second() / 10)
+	case tama5RTCMinutesOnes:
+		return uint8(now.Minute() % 10)
+	case tama5RTCMinutesTens:
+		return uint8(now.Minute() / 10)
+	case tama5RTCHoursOnes:
+		return uint8(now.Hour() % 10)
+	case tama5RTCHoursTens:
+		return uint8(now.Hour() / 10)
+	case tama5RTCWeekday:
+		return uint8(now.Weekday())
+	case tama5RTCDayOnes:
+		return uint8(now.Day() % 10)
+	case tama5RTCDayTens:
+		return uint8(now.Day() / 10)
+	case tama5RTCMonthOnes:
+		return uint8(int(now.Month()) % 10)
+	case tama5RTCMonthTens:
+		return uint8(int(now.Month()) / 10)
+	case tama5RTCYearOnes:
+		return uint8(now.Year() % 10)
+	case tama5RTCYearTens:
+		return uint8(now.Year() / 10 % 10)
+	default:
+		return 0x0
+	}
+}
+
+// setRTCDigit sets the value of the given RTC register. Dates that don't
+// exist, like February 30th, roll over into the next month.
+func (m *tama5) setRTCDigit(reg uint8, digit uint8) {
+	now := m.now()
+
+	year, month, day := now.Year(), int(now.Month()), now.Day()
+	hour, minute, second := now.Hour(), now.Minute(), now.Second()
+	setOnes := func(val *int, digit uint8) {
+		*val = *val - *val%10 + int(digit)
+	}
+	setTens := func(val *int, digit uint8) {
+		*val = *val/100*100 + int(digit)*10 + *val%10
+	}
+
+	switch reg {
+	case tama5RTCSecondsOnes:
+		setOnes(&second, digit)
+	case tama5RTCSecondsTens:
+		setTens(&second, digit)
+	case tama5RTCMinutesOnes:
+		setOnes(&minute, digit)
+	case tama5RTCMinutesTens:
+		setTens(&minute, digit)
+	case tama5RTCHoursOnes:
+		setOnes(&hour, digit)
+	case tama5RTCHoursTens:
+		setTens(&hour, digit)
+	case tama5RTCDayOnes:
+		setOnes(&day, digit)
+	case tama5RTCDayTens:
+		setTens(&day, digit)
+	case tama5RTCMonthOnes:
+		setOnes(&month, digit)
+	case tama5RTCMonthTens:
+		setTens(&month, digit)
+	case tama5RTCYearOnes:
+		setOnes(&year, digit)
+	case tama5RTCYearTens:
+		setTens(&year, digit)
+	default:
+		// The weekday is calculated from the date
+		return
+	}
+
+	m.rtcTime = time.Date(year, time.Month(month), day, hour, minute, second, 0, now.Location())
+	m.lastUpdate = m.clock.Now()
+}
+
+// dumpBatteryBackedRAM returns a dump of the EEPROM, followed by the state of
+// the RTC.
+func (m *tama5) dumpBatteryBackedRAM() []uint8 {
+	dump := make([]uint8, tama5EEPROMSize+tama5RTCSaveSize)
+
+	copy(dump, m.eeprom[:])
+	binary.LittleEndian.PutUint64(dump[tama5EEPROMSize:], uint64(m.now().Unix()))
+	binary.LittleEndian.PutUint64(dump[tama5EEPROMSize+8:], uint64(m.clock.Now().Unix()))
+
+	return dump
+}
+
+// loadBatteryBackedRAM loads the EEPROM and, if present, the state of the RTC
+// from the given dump. The RTC is advanced by the time that has passed since
+// the save was made.
+func (m *tama5) loadBatteryBackedRAM(dump []uint8) error {
+	if len(dump) < tama5EEPROMSize {
+		return xerrors.Errorf("%v bytes: %w", len(dump), ErrSaveTooSmall)
+	}
+	copy(m.eeprom[:], dump)
+
+	if len(dump) > tama5EEPROMSize {
+		if !m.loadRTC(dump[tama5EEPROMSize:]) {
+			fmt.Fprintln(m.out, "Warning: Ignoring RTC save data with an unexpected size")
+		}
+	}
+
+	return nil
+}
+
+// loadRTC restores the state of the RTC from the given save data. Returns
+// false if the data is not a valid RTC save.
+func (m *tama5) loadRTC(dump []uint8) bool {
+	if len(dump) != tama5RTCSaveSize {
+		return false
+	}
+
+	m.rtcTime = time.Unix(int64(binary.LittleEndian.Uint64(dump)), 0)
+	m.lastUpdate = time.Unix(int64(binary.LittleEndian.Uint64(dump[8:])), 0)
+
+	return true
+}
+
+// saveState writes the TAMA5's registers, EEPROM and RTC to a save state.
+func (m *tama5) saveState(sw *stateWriter) {
+	sw.write(m.registers)
+	sw.write(m.currRegister)
+	sw.write(m.result)
+	sw.writeBytes(m.dumpBatteryBackedRAM())
+}
+
+// loadState reads the TAMA5's registers, EEPROM and RTC from a save state.
+func (m *tama5) loadState(sr *stateReader) {
+	sr.read(&m.registers)
+	m.currRegister = sr.readUint8()
+	m.result = sr.readUint8()
+
+	dump := sr.readBytes()
+	if sr.err != nil {
+		return
+	}
+	if len(dump) != tama5EEPROMSize+tama5RTCSaveSize {
+		sr.fail("invalid TAMA5 EEPROM and RTC data")
+		return
+	}
+	copy(m.eeprom[:], dump)
+	m.loadRTC(dump[tama5EEPROMSize:])
+}
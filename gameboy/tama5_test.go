@@ -0,0 +1,125 @@
+package gameboy
+
+import (
+	"testing"
+	"time"
+)
+
+// tama5Write writes a nibble to a TAMA5 register.
+func tama5Write(device *Device, reg, val uint8) {
+	device.WriteMemory(0xA001, reg)
+	device.WriteMemory(0xA000, val)
+}
+
+// tama5Read reads a TAMA5 register.
+func tama5Read(device *Device, reg uint8) uint8 {
+	device.WriteMemory(0xA001, reg)
+	return device.ReadMemory(0xA000)
+}
+
+// tama5Command runs a TAMA5 command on the given address with the given data,
+// and returns the result.
+func tama5Command(device *Device, command, addr, data uint8) uint8 {
+	tama5Write(device, tama5RegDataLow, data&0x0F)
+	tama5Write(device, tama5RegDataHigh, data>>4)
+	tama5Write(device, tama5RegCommand, command<<1|addr>>4)
+	tama5Write(device, tama5RegAddrLow, addr&0x0F)
+
+	return tama5Read(device, tama5RegResultHigh)<<4 | tama5Read(device, tama5RegResultLow)&0x0F
+}
+
+func newTAMA5TestDevice(t *testing.T, clock Clock) *Device {
+	t.Helper()
+
+	// TAMA5 with 512 KB of ROM
+	rom := newTestCartridge(0xFD, 0x04, 0x00)
+	for bank := 1; bank < 32; bank++ {
+		rom[bank*0x4000+bankMarkerAddr] = uint8(bank)
+	}
+	return newTestCartridgeDevice(t, rom, WithClock(clock))
+}
+
+func TestTAMA5Registers(t *testing.T) {
+	device := newTAMA5TestDevice(t, newTestClock())
+
+	if val := tama5Read(device, tama5RegReady); val != 0xF1 {
+		t.Errorf("expected the ready register to read 0xF1, got %#x", val)
+	}
+	if val := device.ReadMemory(0xA001); val != 0xFF {
+		t.Errorf("expected the register select to read 0xFF, got %#x", val)
+	}
+	if val := tama5Read(device, tama5RegDataLow); val != 0xFF {
+		t.Errorf("expected a write-only register to read 0xFF, got %#x", val)
+	}
+
+	tama5Write(device, tama5RegROMBankLow, 0x3)
+	tama5Write(device, tama5RegROMBankHigh, 0x1)
+	if bank := device.ReadMemory(bankedROMAddr + bankMarkerAddr); bank != 0x13 {
+		t.Errorf("expected ROM bank 0x13, got %#x", bank)
+	}
+
+	// Writes to ROM don't switch banks
+	device.WriteMemory(0x2000, 0x05)
+	if bank := device.ReadMemory(bankedROMAddr + bankMarkerAddr); bank != 0x13 {
+		t.Errorf("expected ROM bank 0x13 after writing to ROM, got %#x", bank)
+	}
+}
+
+func TestTAMA5EEPROM(t *testing.T) {
+	device := newTAMA5TestDevice(t, newTestClock())
+
+	// The last address uses the address bit in the command register
+	for _, addr := range []uint8{0x00, 0x0F, 0x1F} {
+		tama5Command(device, tama5CommandWriteEEPROM, addr, 0xA0|addr)
+	}
+	for _, addr := range []uint8{0x00, 0x0F, 0x1F} {
+		if val := tama5Command(device, tama5CommandReadEEPROM, addr, 0); val != 0xA0|addr {
+			t.Errorf("expected %#x at EEPROM address %#x, got %#x", 0xA0|addr, addr, val)
+		}
+	}
+}
+
+func TestTAMA5RTC(t *testing.T) {
+	clock := newTestClock()
+	device := newTAMA5TestDevice(t, clock)
+
+	// Set the time to 23:59:58 on December 31st, 2099
+	digits := []struct {
+		reg   uint8
+		digit uint8
+	}{
+		{tama5RTCYearTens, 9},
+		{tama5RTCYearOnes, 9},
+		{tama5RTCMonthTens, 1},
+		{tama5RTCMonthOnes, 2},
+		{tama5RTCDayTens, 3},
+		{tama5RTCDayOnes, 1},
+		{tama5RTCHoursTens, 2},
+		{tama5RTCHoursOnes, 3},
+		{tama5RTCMinutesTens, 5},
+		{tama5RTCMinutesOnes, 9},
+		{tama5RTCSecondsTens, 5},
+		{tama5RTCSecondsOnes, 8},
+	}
+	for _, d := range digits {
+		tama5Command(device, tama5CommandWriteRTC, d.reg, d.digit)
+	}
+	for _, d := range digits {
+		if val := tama5Command(device, tama5CommandReadRTC, d.reg, 0); val != d.digit {
+			t.Errorf("expected RTC register %#x to be %v, got %v", d.reg, d.digit, val)
+		}
+	}
+
+	// The clock rolls over into the next year
+	clock.advance(3 * time.Second)
+	if val := tama5Command(device, tama5CommandReadRTC, tama5RTCYearOnes, 0); val != 0 {
+		t.Errorf("expected the year to roll over, got a ones digit of %v", val)
+	}
+	if val := tama5Command(device, tama5CommandReadRTC, tama5RTCSecondsOnes, 0); val != 1 {
+		t.Errorf("expected 1 second past midnight, got %v", val)
+	}
+	// January 1st, 2100 is a Friday
+	if val := tama5Command(device, tama5CommandReadRTC, tama5RTCWeekday, 0); val != uint8(time.Friday) {
+		t.Errorf("expected a weekday of %v, got %v", uint8(time.Friday), val)
+	}
+}
@@ -0,0 +1,451 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Types of messages sent over a TCP link. Each message is followed by the
+// sender's link time, a byte of flags and a data byte.
+const (
+	// tcpLinkReport reports how far the sender has run.
+	tcpLinkReport = 'C'
+	// tcpLinkTransfer starts a transfer using the sender's clock. The data
+	// byte is the byte transferred to the peer.
+	tcpLinkTransfer = 'T'
+	// tcpLinkReply answers a transfer. The time is the time of the transfer
+	// being answered, and the data byte is the byte transferred back.
+	tcpLinkReply = 'R'
+
+	// tcpLinkMessageSize is the size of a message in bytes.
+	tcpLinkMessageSize = 1 + 8 + 1 + 1
+)
+
+// tcpLinkReplyPending is set in a reply if the peer was waiting for a
+// transfer, and so received the transferred byte.
+const tcpLinkReplyPending = 0x01
+
+const (
+	// tcpLinkPollMCycles is how many M-Cycles each side runs between checks
+	// for transfers started by the peer. It's the length of a transfer at
+	// normal speed.
+	tcpLinkPollMCycles = serialBitMCycles * 8
+	// tcpLinkReportMCycles is how many M-Cycles each side runs between
+	// reports to the peer.
+	tcpLinkReportMCycles = tcpLinkPollMCycles * 16
+	// tcpLinkWindowMCycles is how far ahead of the peer each side may run
+	// before waiting for it. This is about a quarter of a second.
+	tcpLinkWindowMCycles = tcpLinkReportMCycles * 16
+
+	// tcpLinkIdleTimeout is how long to wait for the peer before considering
+	// it idle, like when it's paused or stopped in the debugger.
+	tcpLinkIdleTimeout = time.Second
+)
+
+// tcpLinkMessage is a message received from the peer.
+type tcpLinkMessage struct {
+	kind  uint8
+	time  int64
+	flags uint8
+	data  uint8
+}
+
+// TCPSerialDriver connects the link port to another emulator over a TCP
+// connection, allowing two Game Boys on different machines to talk to each
+// other.
+//
+// Each side counts the M-Cycles it has run as its link time, and regularly
+// reports it to the peer without waiting for an answer. A side only waits
+// for its peer when it gets more than tcpLinkWindowMCycles ahead, so latency
+// doesn't slow down emulation as long as it's shorter than the window.
+//
+// Either side may act as the master by providing the clock. When the master
+// finishes a transfer, it sends its byte along with its link time and waits
+// for an answer. The peer answers once its own link time reaches the
+// master's, sending back the byte it was waiting to send, or 0xFF if it
+// wasn't waiting. This way, both sides see a transfer happen at the same
+// point in emulated time no matter the latency, and only transfers wait on a
+// round trip.
+//
+// A peer that doesn't report for tcpLinkIdleTimeout, like one that's paused
+// or in the debugger, is treated as idle. This side keeps running and picks
+// up from wherever the peer is once it reports again, and transfers to an
+// idle peer act as if it wasn't waiting for one.
+type TCPSerialDriver struct {
+	// The values below are accessed atomically, and come first to be
+	// aligned for 64-bit atomic operations on 32-bit platforms.
+
+	// peerTime is the link time last reported by the peer.
+	peerTime int64
+	// reports is the number of reports received from the peer.
+	reports uint64
+
+	conn net.Conn
+
+	// transfers receives transfers started by the peer.
+	transfers chan tcpLinkMessage
+	// replies receives the peer's answers to transfers.
+	replies chan tcpLinkMessage
+	// reported receives a value when the peer reports its link time.
+	reported chan struct{}
+	// closed is closed when the driver is closed.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// The values below are only used by the emulation goroutine.
+
+	// cycles is the number of M-Cycles run since the last poll.
+	cycles int
+	// now is the number of M-Cycles run since the driver was created.
+	now int64
+	// skew is subtracted from now to get the link time. It's increased when
+	// catching up with a peer that was idle.
+	skew int64
+	// idle is true if the peer is idle.
+	idle bool
+	// idleReports is the number of reports that had been received when the
+	// peer became idle.
+	idleReports uint64
+	// held is a transfer from a peer that's ahead of this side. It's
+	// answered once this side catches up.
+	held *tcpLinkMessage
+	// pending is true if this side is waiting for the peer to start a
+	// transfer.
+	pending bool
+	// out is the byte to send when the peer starts a transfer.
+	out uint8
+	// received is true if the peer has started a transfer that this side
+	// hasn't received yet.
+	received bool
+	// in is the byte sent by the peer.
+	in uint8
+
+	// mutex guards err.
+	mutex sync.Mutex
+	// err is the error that broke the link, if any.
+	err error
+}
+
+// NewTCPSerialDriver creates a serial driver that talks to a peer over the
+// given connection. The driver takes ownership of the connection.
+func NewTCPSerialDriver(conn net.Conn) *TCPSerialDriver {
+	driver := &TCPSerialDriver{
+		conn:      conn,
+		transfers: make(chan tcpLinkMessage, 16),
+		replies:   make(chan tcpLinkMessage, 16),
+		reported:  make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+
+	go driver.readLoop()
+
+	return driver
+}
+
+// ListenTCPLink waits for a peer to connect on the given address and returns
+// a serial driver connected to it.
+func ListenTCPLink(address string) (*TCPSerialDriver, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, xerrors.Errorf("listening for link connection: %w", err)
+	}
+	defer listener.Close()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, xerrors.Errorf("accepting link connection: %w", err)
+	}
+
+	return NewTCPSerialDriver(conn), nil
+}
+
+// DialTCPLink connects to a peer waiting at the given address and returns a
+// serial driver connected to it.
+func DialTCPLink(address string) (*TCPSerialDriver, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, xerrors.Errorf("connecting to link peer: %w", err)
+	}
+
+	return NewTCPSerialDriver(conn), nil
+}
+
+// Transfer sends a byte to the peer and waits for the byte the peer was
+// waiting to send. If the peer wasn't waiting, is idle, or the link is
+// broken, 0xFF is returned as if the cable was unplugged.
+func (driver *TCPSerialDriver) Transfer(out uint8) uint8 {
+	if driver.Err() != nil {
+		return 0xFF
+	}
+
+	if driver.held != nil {
+		// The peer started a transfer first
+		driver.answer(*driver.held)
+		driver.held = nil
+	}
+
+	transferTime := driver.linkTime()
+	if err := driver.send(tcpLinkTransfer, transferTime, 0, out); err != nil {
+		driver.fail(err)
+		return 0xFF
+	}
+
+	timeout := time.NewTimer(tcpLinkIdleTimeout)
+	defer timeout.Stop()
+
+	transfers := driver.transfers
+	for {
+		select {
+		case reply, ok := <-driver.replies:
+			if !ok {
+				return 0xFF
+			}
+			if reply.time != transferTime {
+				// An answer to a transfer that was given up on
+				continue
+			}
+			if reply.flags&tcpLinkReplyPending == 0 {
+				return 0xFF
+			}
+			return reply.data
+		case msg, ok := <-transfers:
+			if !ok {
+				transfers = nil
+				continue
+			}
+			// Both sides are masters, so neither is waiting
+			driver.answer(msg)
+		case <-timeout.C:
+			return 0xFF
+		}
+	}
+}
+
+// SetPending sets the byte that will be sent back when the peer starts a
+// transfer.
+func (driver *TCPSerialDriver) SetPending(out uint8, pending bool) {
+	if !pending {
+		driver.received = false
+	}
+	driver.pending = pending && !driver.received
+	driver.out = out
+}
+
+// Receive returns the byte sent by the peer, if it has started a transfer.
+func (driver *TCPSerialDriver) Receive() (uint8, bool) {
+	if !driver.received {
+		return 0, false
+	}
+
+	driver.received = false
+	return driver.in, true
+}
+
+// tick counts an M-Cycle, answering transfers from the peer and reporting to
+// it when it's time to.
+func (driver *TCPSerialDriver) tick() {
+	driver.now++
+	driver.cycles++
+	if driver.cycles < tcpLinkPollMCycles {
+		return
+	}
+	driver.cycles = 0
+
+	if driver.Err() != nil {
+		return
+	}
+
+	driver.poll()
+
+	if driver.now%tcpLinkReportMCycles == 0 {
+		if err := driver.send(tcpLinkReport, driver.linkTime(), 0, 0); err != nil {
+			driver.fail(err)
+			return
+		}
+		driver.throttle()
+	}
+}
+
+// poll answers transfers started by the peer at or before this side's link
+// time.
+func (driver *TCPSerialDriver) poll() {
+	for {
+		if driver.held == nil {
+			select {
+			case msg, ok := <-driver.transfers:
+				if !ok {
+					return
+				}
+				driver.held = &msg
+			default:
+				return
+			}
+		}
+
+		if driver.held.time > driver.linkTime() {
+			// Wait until this side gets to the time of the transfer
+			return
+		}
+		driver.answer(*driver.held)
+		driver.held = nil
+	}
+}
+
+// answer finishes a transfer started by the peer, sending back the byte this
+// side is waiting to send.
+func (driver *TCPSerialDriver) answer(msg tcpLinkMessage) {
+	var flags uint8
+	out := uint8(0xFF)
+	if driver.pending {
+		flags |= tcpLinkReplyPending
+		out = driver.out
+
+		driver.pending = false
+		driver.received = true
+		driver.in = msg.data
+	}
+
+	if err := driver.send(tcpLinkReply, msg.time, flags, out); err != nil {
+		driver.fail(err)
+	}
+}
+
+// throttle waits for the peer if this side is too far ahead of it. An idle
+// peer isn't waited for until it reports again.
+func (driver *TCPSerialDriver) throttle() {
+	if driver.idle {
+		if atomic.LoadUint64(&driver.reports) == driver.idleReports {
+			return
+		}
+		// Pick up from where the peer is now
+		driver.idle = false
+		driver.skew = driver.now - atomic.LoadInt64(&driver.peerTime)
+	}
+
+	var timeout *time.Timer
+	for driver.linkTime()-atomic.LoadInt64(&driver.peerTime) > tcpLinkWindowMCycles {
+		if timeout == nil {
+			timeout = time.NewTimer(tcpLinkIdleTimeout)
+			defer timeout.Stop()
+		}
+
+		select {
+		case _, ok := <-driver.reported:
+			if !ok {
+				return
+			}
+			// The peer is still running
+			if !timeout.Stop() {
+				<-timeout.C
+			}
+			timeout.Reset(tcpLinkIdleTimeout)
+		case msg, ok := <-driver.transfers:
+			if ok {
+				// This side is ahead, so the transfer can be answered now
+				driver.answer(msg)
+			}
+		case <-timeout.C:
+			driver.idle = true
+			driver.idleReports = atomic.LoadUint64(&driver.reports)
+			return
+		}
+	}
+}
+
+// linkTime returns this side's link time.
+func (driver *TCPSerialDriver) linkTime() int64 {
+	return driver.now - driver.skew
+}
+
+// send sends a message to the peer.
+func (driver *TCPSerialDriver) send(kind uint8, linkTime int64, flags, data uint8) error {
+	var msg [tcpLinkMessageSize]uint8
+	msg[0] = kind
+	binary.BigEndian.PutUint64(msg[1:], uint64(linkTime))
+	msg[9] = flags
+	msg[10] = data
+
+	if _, err := driver.conn.Write(msg[:]); err != nil {
+		return xerrors.Errorf("writing to link peer: %w", err)
+	}
+	return nil
+}
+
+// Err returns the error that broke the link, or nil if the link is working.
+func (driver *TCPSerialDriver) Err() error {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+
+	return driver.err
+}
+
+// Close closes the connection to the peer.
+func (driver *TCPSerialDriver) Close() error {
+	driver.closeOnce.Do(func() {
+		close(driver.closed)
+	})
+	return driver.conn.Close()
+}
+
+// readLoop receives messages from the peer until the connection is closed.
+func (driver *TCPSerialDriver) readLoop() {
+	defer close(driver.transfers)
+	defer close(driver.replies)
+	defer close(driver.reported)
+
+	var buf [tcpLinkMessageSize]uint8
+	for {
+		if _, err := io.ReadFull(driver.conn, buf[:]); err != nil {
+			driver.fail(xerrors.Errorf("reading from link peer: %w", err))
+			return
+		}
+		msg := tcpLinkMessage{
+			kind:  buf[0],
+			time:  int64(binary.BigEndian.Uint64(buf[1:])),
+			flags: buf[9],
+			data:  buf[10],
+		}
+
+		var messages chan tcpLinkMessage
+		switch msg.kind {
+		case tcpLinkReport:
+			atomic.StoreInt64(&driver.peerTime, msg.time)
+			atomic.AddUint64(&driver.reports, 1)
+			select {
+			case driver.reported <- struct{}{}:
+			default:
+			}
+			continue
+		case tcpLinkTransfer:
+			messages = driver.transfers
+		case tcpLinkReply:
+			messages = driver.replies
+		default:
+			driver.fail(xerrors.Errorf("unknown link message type %#x", msg.kind))
+			return
+		}
+
+		select {
+		case messages <- msg:
+		case <-driver.closed:
+			return
+		}
+	}
+}
+
+// fail marks the link as broken with the given error, if it isn't broken
+// already.
+func (driver *TCPSerialDriver) fail(err error) {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+
+	if driver.err == nil {
+		driver.err = err
+	}
+}
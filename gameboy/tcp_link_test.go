@@ -0,0 +1,264 @@
+package gameboy
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// latencyConn delays everything written to it, like a connection to a
+// faraway machine.
+type latencyConn struct {
+	net.Conn
+	delay  time.Duration
+	writes chan latencyWrite
+	closed chan struct{}
+}
+
+// latencyWrite is data waiting to be written to the underlying connection.
+type latencyWrite struct {
+	data []uint8
+	due  time.Time
+}
+
+func newLatencyConn(conn net.Conn, delay time.Duration) *latencyConn {
+	c := &latencyConn{
+		Conn:   conn,
+		delay:  delay,
+		writes: make(chan latencyWrite, 4096),
+		closed: make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case w := <-c.writes:
+				time.Sleep(time.Until(w.due))
+				if _, err := c.Conn.Write(w.data); err != nil {
+					return
+				}
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func (c *latencyConn) Write(data []uint8) (int, error) {
+	c.writes <- latencyWrite{
+		data: append([]uint8(nil), data...),
+		due:  time.Now().Add(c.delay),
+	}
+	return len(data), nil
+}
+
+func (c *latencyConn) Close() error {
+	close(c.closed)
+	return c.Conn.Close()
+}
+
+// newTCPLinkPair creates two serial drivers connected to each other over
+// loopback. Everything sent in either direction is delayed by the given
+// latency.
+func newTCPLinkPair(t *testing.T, latency time.Duration) [2]*TCPSerialDriver {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("accepting: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	listened := <-accepted
+	if listened == nil {
+		dialed.Close()
+		t.FailNow()
+	}
+
+	conns := [2]net.Conn{listened, dialed}
+	if latency > 0 {
+		for i, conn := range conns {
+			conns[i] = newLatencyConn(conn, latency)
+		}
+	}
+
+	return [2]*TCPSerialDriver{
+		NewTCPSerialDriver(conns[0]),
+		NewTCPSerialDriver(conns[1]),
+	}
+}
+
+// serialTransferDoneAddr is where the program run by newSerialTransferDevice
+// loops once its transfer is done.
+const serialTransferDoneAddr = 0x0112
+
+// runLinkedDevices runs each device on its own goroutine, starting after the
+// given delay, for at least the given number of frames and until both
+// devices have finished their transfers. The drivers are closed afterwards.
+func runLinkedDevices(t *testing.T, devices [2]*Device, drivers [2]*TCPSerialDriver, delays [2]time.Duration, frames int) {
+	t.Helper()
+
+	var finished int32
+	errs := make(chan error, len(devices))
+	for i := range devices {
+		go func(i int) {
+			time.Sleep(delays[i])
+
+			done := false
+			for frame := 0; frame < frames+600; frame++ {
+				if err := devices[i].RunFrame(); err != nil {
+					errs <- err
+					return
+				}
+				if !done && devices[i].Registers().PC == serialTransferDoneAddr {
+					done = true
+					atomic.AddInt32(&finished, 1)
+				}
+				// Keep running until the peer is done too, so that it
+				// isn't left waiting
+				if frame+1 >= frames && atomic.LoadInt32(&finished) == 2 {
+					errs <- nil
+					return
+				}
+			}
+			errs <- xerrors.Errorf("end %v didn't finish its transfer", i)
+		}(i)
+	}
+
+	var err error
+	for range devices {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	for _, driver := range drivers {
+		driver.Close()
+	}
+	if err != nil {
+		t.Fatalf("running: %v", err)
+	}
+}
+
+func TestTCPLink(t *testing.T) {
+	tests := []struct {
+		name string
+		// delays is how long to wait before starting each device.
+		delays [2]time.Duration
+	}{
+		{"together", [2]time.Duration{0, 0}},
+		// The slave answers the transfer once it gets to the time the
+		// master finished it, even though the master got there first
+		{"slave starts late", [2]time.Duration{0, 50 * time.Millisecond}},
+		{"master starts late", [2]time.Duration{50 * time.Millisecond, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drivers := newTCPLinkPair(t, 0)
+			out := [2]uint8{0x56, 0x78}
+
+			// The first device is the master
+			var devices [2]*Device
+			for i := range devices {
+				devices[i] = newSerialTransferDevice(t, drivers[i], out[i], i == 0)
+			}
+
+			runLinkedDevices(t, devices, drivers, test.delays, 1)
+
+			for i, device := range devices {
+				expected := out[1-i]
+				if actual := device.Registers().B; actual != expected {
+					t.Errorf("end %v received %#x, expected %#x", i, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTCPLinkLatency(t *testing.T) {
+	// runLinked runs two linked devices for two seconds of emulated time and
+	// returns how long it took.
+	runLinked := func(latency time.Duration) time.Duration {
+		drivers := newTCPLinkPair(t, latency)
+		out := [2]uint8{0x9A, 0xBC}
+
+		var devices [2]*Device
+		for i := range devices {
+			devices[i] = newSerialTransferDevice(t, drivers[i], out[i], i == 0)
+		}
+
+		start := time.Now()
+		runLinkedDevices(t, devices, drivers, [2]time.Duration{}, 120)
+		elapsed := time.Since(start)
+
+		for i, device := range devices {
+			expected := out[1-i]
+			if actual := device.Registers().B; actual != expected {
+				t.Errorf("end %v received %#x, expected %#x", i, actual, expected)
+			}
+		}
+		return elapsed
+	}
+
+	// A round trip takes 50ms, so waiting on one every transfer length would
+	// take over a minute and a half longer than without latency. Only the
+	// transfer itself should have to wait.
+	baseline := runLinked(0)
+	if elapsed := runLinked(25 * time.Millisecond); elapsed > baseline+time.Second {
+		t.Errorf("running two seconds took %v with latency, and %v without", elapsed, baseline)
+	}
+}
+
+func TestTCPLinkIdlePeer(t *testing.T) {
+	drivers := newTCPLinkPair(t, 0)
+	out := [2]uint8{0xDE, 0xF0}
+
+	// The second device is the master
+	var devices [2]*Device
+	for i := range devices {
+		devices[i] = newSerialTransferDevice(t, drivers[i], out[i], i == 1)
+	}
+
+	// The slave runs a second on its own while the master is paused, which
+	// is further ahead than it's allowed to get
+	start := time.Now()
+	for frame := 0; frame < 60; frame++ {
+		if err := devices[0].RunFrame(); err != nil {
+			t.Fatalf("running: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < tcpLinkIdleTimeout {
+		t.Errorf("expected the slave to wait for the master, but it ran in %v", elapsed)
+	}
+	if err := drivers[0].Err(); err != nil {
+		t.Fatalf("link broke while the master was paused: %v", err)
+	}
+
+	// Once the master resumes, the slave picks up from where it is
+	runLinkedDevices(t, devices, drivers, [2]time.Duration{}, 1)
+
+	for i, device := range devices {
+		expected := out[1-i]
+		if actual := device.Registers().B; actual != expected {
+			t.Errorf("end %v received %#x, expected %#x", i, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package gameboy
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"text/tabwriter"
+)
+
+// The test ROM harness runs community test ROMs and checks that they pass.
+// The ROMs aren't included in the repository. Instead, the
+// GOPHERBOY_TEST_ROMS environment variable should point to a directory with
+// the following layout:
+//
+//	blargg/     Blargg's test ROMs, in any directory structure
+//	mooneye/    Mooneye test suite ROMs, in any directory structure
+//	dmg-acid2/  dmg-acid2.gb and its reference image, reference-dmg.png
+//
+// Missing directories are skipped. If GOPHERBOY_TEST_REPORT is set, a pass/fail
+// matrix of every ROM is written to that path in addition to the test log.
+
+const (
+	// testROMsEnv is the environment variable pointing to the test ROM
+	// directory.
+	testROMsEnv = "GOPHERBOY_TEST_ROMS"
+	// testReportEnv is the environment variable pointing to where the
+	// pass/fail report should be written.
+	testReportEnv = "GOPHERBOY_TEST_REPORT"
+
+	// blarggFrameLimit is the number of frames a Blargg ROM has to finish.
+	// Some of the full test suites take close to a minute to run.
+	blarggFrameLimit = 60 * 120
+	// mooneyeFrameLimit is the number of frames a Mooneye ROM has to finish.
+	mooneyeFrameLimit = 60 * 20
+	// acid2FrameLimit is the number of frames dmg-acid2 has to finish.
+	acid2FrameLimit = 60 * 10
+
+	// ldBB is the opcode for LD B,B, which Mooneye ROMs and dmg-acid2 use as a
+	// software breakpoint to signal that they're finished.
+	ldBB = 0x40
+)
+
+// testROMResult is the outcome of running a single test ROM.
+type testROMResult struct {
+	suite  string
+	name   string
+	passed bool
+	// details explains why the ROM failed, or is empty if it passed.
+	details string
+}
+
+// testROMVideoDriver keeps the most recently rendered frame.
+type testROMVideoDriver struct {
+	frame []uint8
+}
+
+func (driver *testROMVideoDriver) Render(frameData []uint8) error {
+	if driver.frame == nil {
+		driver.frame = make([]uint8, len(frameData))
+	}
+	copy(driver.frame, frameData)
+	return nil
+}
+
+func (driver *testROMVideoDriver) Close() {
+}
+
+// testROMSaveGameDriver is a save game driver that never has any saves and
+// discards all saves made.
+type testROMSaveGameDriver struct{}
+
+func (driver *testROMSaveGameDriver) Save(name string, data []uint8) error {
+	return nil
+}
+
+func (driver *testROMSaveGameDriver) Load(name string) ([]uint8, error) {
+	return nil, fmt.Errorf("no save game %v", name)
+}
+
+func (driver *testROMSaveGameDriver) Has(name string) (bool, error) {
+	return false, nil
+}
+
+func TestROMs(t *testing.T) {
+	romDir := os.Getenv(testROMsEnv)
+	if romDir == "" {
+		t.Skipf("%v is not set", testROMsEnv)
+	}
+
+	var resultsMutex sync.Mutex
+	var results []testROMResult
+	record := func(t *testing.T, result testROMResult) {
+		resultsMutex.Lock()
+		results = append(results, result)
+		resultsMutex.Unlock()
+
+		if !result.passed {
+			t.Error(result.details)
+		}
+	}
+
+	suites := []struct {
+		name string
+		run  func(t *testing.T, path string) testROMResult
+	}{
+		{"blargg", runBlarggROM},
+		{"mooneye", runMooneyeROM},
+	}
+
+	// Run all ROMs in parallel, grouped so that the report can be made once
+	// they're all done
+	t.Run("group", func(t *testing.T) {
+		for _, suite := range suites {
+			suite := suite
+
+			paths, err := findTestROMs(filepath.Join(romDir, suite.name))
+			if err != nil {
+				t.Fatalf("finding %v ROMs: %v", suite.name, err)
+			}
+
+			for _, path := range paths {
+				path := path
+				t.Run(suite.name+"/"+filepath.Base(path), func(t *testing.T) {
+					t.Parallel()
+					result := suite.run(t, path)
+					result.suite = suite.name
+					result.name = testROMName(romDir, suite.name, path)
+					record(t, result)
+				})
+			}
+		}
+
+		acid2Path := filepath.Join(romDir, "dmg-acid2", "dmg-acid2.gb")
+		if _, err := os.Stat(acid2Path); err == nil {
+			t.Run("dmg-acid2", func(t *testing.T) {
+				t.Parallel()
+				result := runAcid2ROM(t, acid2Path,
+					filepath.Join(romDir, "dmg-acid2", "reference-dmg.png"))
+				result.suite = "dmg-acid2"
+				result.name = "dmg-acid2.gb"
+				record(t, result)
+			})
+		}
+	})
+
+	report := testROMReport(results)
+	t.Log("\n" + report)
+
+	if reportPath := os.Getenv(testReportEnv); reportPath != "" {
+		err := ioutil.WriteFile(reportPath, []byte(report), 0644)
+		if err != nil {
+			t.Errorf("writing report: %v", err)
+		}
+	}
+}
+
+// findTestROMs returns the paths of all ROMs in the given directory and its
+// subdirectories, in a consistent order. If the directory doesn't exist, no
+// paths are returned.
+func findTestROMs(dir string) ([]string, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if !info.IsDir() && (ext == ".gb" || ext == ".gbc") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// testROMName returns the path of the ROM relative to its suite's directory.
+func testROMName(romDir, suite, path string) string {
+	name, err := filepath.Rel(filepath.Join(romDir, suite), path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(name)
+}
+
+// testROMReport creates a pass/fail matrix of the given results.
+func testROMReport(results []testROMResult) string {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].suite != results[j].suite {
+			return results[i].suite < results[j].suite
+		}
+		return results[i].name < results[j].name
+	})
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "SUITE\tROM\tRESULT\tDETAILS")
+	passCount := make(map[string]int)
+	totalCount := make(map[string]int)
+	var suiteNames []string
+	for _, result := range results {
+		status := "FAIL"
+		if result.passed {
+			status = "PASS"
+			passCount[result.suite]++
+		}
+		if totalCount[result.suite] == 0 {
+			suiteNames = append(suiteNames, result.suite)
+		}
+		totalCount[result.suite]++
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n",
+			result.suite, result.name, status, result.details)
+	}
+	w.Flush()
+
+	buf.WriteString("\n")
+	for _, suite := range suiteNames {
+		fmt.Fprintf(&buf, "%v: %v/%v passed\n",
+			suite, passCount[suite], totalCount[suite])
+	}
+
+	return buf.String()
+}
+
+// testROMSerialDriver collects bytes sent over serial. It acts like a
+// disconnected link cable otherwise.
+type testROMSerialDriver struct {
+	output strings.Builder
+}
+
+func (driver *testROMSerialDriver) Transfer(out uint8) uint8 {
+	driver.output.WriteByte(out)
+	return 0xFF
+}
+
+func (driver *testROMSerialDriver) SetPending(out uint8, pending bool) {
+}
+
+func (driver *testROMSerialDriver) Receive() (uint8, bool) {
+	return 0, false
+}
+
+// newTestROMDevice creates a device that runs the given ROM with the boot
+// sequence skipped.
+func newTestROMDevice(t *testing.T, path string, opts ...Option) (*Device, *testROMVideoDriver) {
+	cartridgeData, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading ROM: %v", err)
+	}
+
+	video := &testROMVideoDriver{}
+	device, err := NewDevice(
+		nil,
+		cartridgeData,
+		video,
+		&noopInputDriver{},
+		&testROMSaveGameDriver{},
+		DebugConfiguration{},
+		append(opts, WithoutBootROM())...)
+	if err != nil {
+		t.Fatalf("creating device: %v", err)
+	}
+
+	return device, video
+}
+
+// runBlarggROM runs one of Blargg's test ROMs. These ROMs print their results
+// over the serial port, and some also write them to cartridge RAM starting at
+// 0xA000.
+func runBlarggROM(t *testing.T, path string) testROMResult {
+	serialOutput := &testROMSerialDriver{}
+	device, _ := newTestROMDevice(t, path, WithModel(ModelDMG), WithSerial(serialOutput))
+	defer device.Close()
+
+	for frame := 0; frame < blarggFrameLimit; frame++ {
+		if err := device.RunFrame(); err != nil {
+			return testROMResult{details: fmt.Sprintf("running: %v", err)}
+		}
+
+		// Check for results on the serial port
+		output := serialOutput.output.String()
+		if strings.Contains(output, "Passed") {
+			return testROMResult{passed: true}
+		} else if strings.Contains(output, "Failed") {
+			return testROMResult{details: blarggSummary(output)}
+		}
+
+		// Check for results in cartridge RAM. The signature is written once
+		// the ROM starts reporting there, and the status byte is 0x80 while
+		// the test is still running.
+		if device.ReadMemory(0xA001) == 0xDE &&
+			device.ReadMemory(0xA002) == 0xB0 &&
+			device.ReadMemory(0xA003) == 0x61 {
+
+			status := device.ReadMemory(0xA000)
+			if status != 0x80 {
+				if status == 0x00 {
+					return testROMResult{passed: true}
+				}
+				return testROMResult{details: fmt.Sprintf(
+					"status %#02x: %v", status, blarggSummary(blarggRAMText(device)))}
+			}
+		}
+	}
+
+	output := serialOutput.output.String()
+	if output == "" {
+		output = blarggRAMText(device)
+	}
+	return testROMResult{details: fmt.Sprintf(
+		"timed out after %v frames: %v", blarggFrameLimit, blarggSummary(output))}
+}
+
+// blarggRAMText returns the null-terminated text that Blargg ROMs write to
+// cartridge RAM starting at 0xA004.
+func blarggRAMText(device *Device) string {
+	var text strings.Builder
+	for addr := uint16(0xA004); addr < 0xC000; addr++ {
+		val := device.ReadMemory(addr)
+		if val == 0 {
+			break
+		}
+		text.WriteByte(val)
+	}
+	return text.String()
+}
+
+// blarggSummary condenses a Blargg ROM's output to a single line.
+func blarggSummary(output string) string {
+	return strings.Join(strings.Fields(output), " ")
+}
+
+// runMooneyeROM runs one of the Mooneye test suite ROMs. These ROMs run LD B,B
+// when they're finished and leave the Fibonacci numbers 3, 5, 8, 13, 21, 34 in
+// registers B, C, D, E, H and L if they passed.
+func runMooneyeROM(t *testing.T, path string) testROMResult {
+	device, _ := newTestROMDevice(t, path, WithModel(mooneyeModel(path)))
+	defer device.Close()
+
+	done, err := runUntilLDBB(device, mooneyeFrameLimit)
+	if err != nil {
+		return testROMResult{details: fmt.Sprintf("running: %v", err)}
+	}
+	if !done {
+		return testROMResult{details: fmt.Sprintf(
+			"timed out after %v frames", mooneyeFrameLimit)}
+	}
+
+	regs := device.Registers()
+	if regs.B == 3 && regs.C == 5 && regs.D == 8 &&
+		regs.E == 13 && regs.H == 21 && regs.L == 34 {
+		return testROMResult{passed: true}
+	}
+	return testROMResult{details: fmt.Sprintf(
+		"B=%#02x C=%#02x D=%#02x E=%#02x H=%#02x L=%#02x",
+		regs.B, regs.C, regs.D, regs.E, regs.H, regs.L)}
+}
+
+// mooneyeModel picks the model to run a Mooneye ROM on based on the model
+// suffix in its file name, like "-dmgABC", "-mgb", "-S" or "-cgb". ROMs
+// without a suffix are run on the DMG.
+func mooneyeModel(path string) Model {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	dash := strings.LastIndex(name, "-")
+	if dash == -1 {
+		return ModelDMG
+	}
+	suffix := name[dash+1:]
+
+	switch {
+	case strings.HasPrefix(suffix, "dmg0"):
+		return ModelDMG0
+	case strings.HasPrefix(suffix, "dmg"), strings.HasPrefix(suffix, "G"):
+		return ModelDMG
+	case strings.HasPrefix(suffix, "mgb"):
+		return ModelMGB
+	case strings.HasPrefix(suffix, "sgb"), strings.HasPrefix(suffix, "S"):
+		return ModelSGB
+	case strings.HasPrefix(suffix, "cgb"), strings.HasPrefix(suffix, "C"):
+		return ModelCGB
+	default:
+		return ModelDMG
+	}
+}
+
+// runAcid2ROM runs dmg-acid2 and compares the screen to the reference image
+// once it's finished.
+func runAcid2ROM(t *testing.T, path, referencePath string) testROMResult {
+	referenceFile, err := os.Open(referencePath)
+	if err != nil {
+		return testROMResult{details: fmt.Sprintf("opening reference image: %v", err)}
+	}
+	defer referenceFile.Close()
+	reference, err := png.Decode(referenceFile)
+	if err != nil {
+		return testROMResult{details: fmt.Sprintf("decoding reference image: %v", err)}
+	}
+
+	device, video := newTestROMDevice(t, path, WithModel(ModelDMG))
+	defer device.Close()
+
+	done, err := runUntilLDBB(device, acid2FrameLimit)
+	if err != nil {
+		return testROMResult{details: fmt.Sprintf("running: %v", err)}
+	}
+	if !done {
+		return testROMResult{details: fmt.Sprintf(
+			"timed out after %v frames", acid2FrameLimit)}
+	}
+
+	// Make sure that the finished frame has been rendered
+	if err := device.RunFrame(); err != nil {
+		return testROMResult{details: fmt.Sprintf("running: %v", err)}
+	}
+	if video.frame == nil {
+		return testROMResult{details: "no frame was rendered"}
+	}
+
+	mismatches := compareToReference(video.frame, reference)
+	if mismatches != 0 {
+		return testROMResult{details: fmt.Sprintf(
+			"%v pixels differ from the reference image", mismatches)}
+	}
+	return testROMResult{passed: true}
+}
+
+// runUntilLDBB runs the device until an LD B,B instruction is run or the
+// frame limit is reached. Returns true if LD B,B was run.
+func runUntilLDBB(device *Device, frameLimit int) (bool, error) {
+	startFrame := device.frameCount
+
+	for device.frameCount-startFrame < frameLimit {
+		isLDBB := !device.state.halted && !device.state.stopped &&
+			device.ReadMemory(device.state.regPC.get()) == ldBB
+
+		if err := device.StepInstruction(); err != nil {
+			return false, err
+		}
+
+		if isLDBB {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// compareToReference returns the number of pixels in the given frame that
+// have a different shade than the same pixel in the reference image. Shades
+// are compared instead of exact colors so that the reference image's palette
+// doesn't need to match the emulator's.
+func compareToReference(frame []uint8, reference image.Image) int {
+	bounds := reference.Bounds()
+	if bounds.Dx() != ScreenWidth || bounds.Dy() != ScreenHeight {
+		return ScreenWidth * ScreenHeight
+	}
+
+	mismatches := 0
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x++ {
+			i := (y*ScreenWidth + x) * 4
+			frameShade := shadeOf(uint32(frame[i]), uint32(frame[i+1]), uint32(frame[i+2]))
+
+			r, g, b, _ := reference.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			referenceShade := shadeOf(r>>8, g>>8, b>>8)
+
+			if frameShade != referenceShade {
+				mismatches++
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// shadeOf returns which of the four DMG shades the given 8-bit color is
+// closest to, from 0 for the lightest to 3 for the darkest.
+func shadeOf(r, g, b uint32) int {
+	luminance := (r*299 + g*587 + b*114) / 1000
+
+	switch {
+	case luminance >= 213:
+		return 0
+	case luminance >= 128:
+		return 1
+	case luminance >= 43:
+		return 2
+	default:
+		return 3
+	}
+}
@@ -217,3 +217,24 @@ func (t *timers) incrementTIMA() {
 		t.timaOverflowing = true
 	}
 }
+
+// saveState writes the state of the timers to a save state.
+func (t *timers) saveState(sw *stateWriter) {
+	sw.write(t.cpuClock)
+	sw.write(t.fallingEdgeDetectorDelay)
+	sw.write(t.timaOverflowing)
+	sw.write(t.tmaToTIMATransferring)
+	sw.write([]uint8{t.divider, t.tima, t.tac, t.tma})
+}
+
+// loadState reads the state of the timers from a save state.
+func (t *timers) loadState(sr *stateReader) {
+	t.cpuClock = sr.readUint16()
+	t.fallingEdgeDetectorDelay = sr.readUint8()
+	t.timaOverflowing = sr.readBool()
+	t.tmaToTIMATransferring = sr.readBool()
+
+	var regs [4]uint8
+	sr.read(&regs)
+	t.divider, t.tima, t.tac, t.tma = regs[0], regs[1], regs[2], regs[3]
+}
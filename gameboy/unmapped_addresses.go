@@ -5,15 +5,23 @@ package gameboy
 // unmappedAddresses slice at program initialization.
 var isUnmappedAddress [0x10000]bool
 
+// isUnmappedAddressCGB is like isUnmappedAddress, but for a device running in
+// CGB mode. CGB-only registers are mapped in this mode.
+var isUnmappedAddressCGB [0x10000]bool
+
 func init() {
 	for _, addr := range unmappedAddresses {
 		isUnmappedAddress[addr] = true
+		isUnmappedAddressCGB[addr] = true
+	}
+	for _, addr := range cgbOnlyAddresses {
+		isUnmappedAddress[addr] = true
 	}
 }
 
-// unmappedAddresses is a list of all unmapped addresses in the DMG.
-var unmappedAddresses = []uint16{
-	// Unused CGB registers
+// cgbOnlyAddresses is a list of all registers that are only mapped when the
+// device is running in CGB mode.
+var cgbOnlyAddresses = []uint16{
 	key1Addr,
 	vbkAddr,
 	hdma1Addr,
@@ -21,12 +29,17 @@ var unmappedAddresses = []uint16{
 	hdma3Addr,
 	hdma4Addr,
 	hdma5Addr,
-	rpAddr,
 	bcpsAddr,
 	bcpdAddr,
 	ocpsAddr,
 	ocpdAddr,
 	svbkAddr,
+}
+
+// unmappedAddresses is a list of all unmapped addresses in the DMG.
+var unmappedAddresses = []uint16{
+	// Unused CGB registers
+	rpAddr,
 	pcm12Ch2Addr,
 	pcm34Ch4Addr,
 	// Misc unused addresses
@@ -41,6 +41,12 @@ const (
 	// maxOAMEntries is the maximum amount of OAM entries that can fit in OAM
 	// memory.
 	maxOAMEntries = 40
+
+	// cgbPaletteRAMSize is the size in bytes of the CGB's background palette
+	// RAM and of its sprite palette RAM.
+	cgbPaletteRAMSize = 64
+	// cgbPaletteBytes is the size in bytes of a single CGB palette.
+	cgbPaletteBytes = 8
 )
 
 type drawStep int
@@ -97,8 +103,41 @@ type videoController struct {
 	// maps dot data to its corresponding color.
 	spritePalette1 [4]color
 
+	// bgPaletteRAM contains the CGB's 8 background palettes. It is accessed
+	// through the BCPS and BCPD registers.
+	bgPaletteRAM [cgbPaletteRAMSize]uint8
+	// bgPaletteIndex is the byte in background palette RAM that BCPD accesses.
+	bgPaletteIndex uint8
+	// bgPaletteAutoIncrement is true if bgPaletteIndex should be incremented
+	// after every write to BCPD.
+	bgPaletteAutoIncrement bool
+	// spritePaletteRAM contains the CGB's 8 sprite palettes. It is accessed
+	// through the OCPS and OCPD registers.
+	spritePaletteRAM [cgbPaletteRAMSize]uint8
+	// spritePaletteIndex is the byte in sprite palette RAM that OCPD accesses.
+	spritePaletteIndex uint8
+	// spritePaletteAutoIncrement is true if spritePaletteIndex should be
+	// incremented after every write to OCPD.
+	spritePaletteAutoIncrement bool
+
 	// Raw frame data in 8-bit RGBA format.
 	currFrame []uint8
+	// frameDone is set to true when a frame is finished. The device clears
+	// it.
+	frameDone bool
+
+	// spritesAtCache is a pre-allocated array of OAM data that is used by
+	// spritesAt to avoid memory allocations at runtime.
+	spritesAtCache [maxSpritesPerScanLine]oam
+	// bgScanLineCache and bgAttrScanLineCache are pre-allocated arrays used
+	// by makeBGScanLine to reduce memory allocations at runtime.
+	bgScanLineCache     [ScreenWidth]uint8
+	bgAttrScanLineCache [ScreenWidth]uint8
+	// windowScanLineCache and windowAttrScanLineCache are pre-allocated
+	// arrays used by makeWindowScanLine to reduce memory allocations at
+	// runtime.
+	windowScanLineCache     [ScreenWidth]uint8
+	windowAttrScanLineCache [ScreenWidth]uint8
 
 	state            *State
 	interruptManager *interruptManager
@@ -132,6 +171,16 @@ func newVideoController(state *State, driver VideoDriver) *videoController {
 	vc.state.mmu.subscribeTo(scrollYAddr, vc.onScrollYWrite)
 	vc.state.mmu.subscribeTo(windowPosYAddr, vc.onWindowPosYWrite)
 
+	if vc.state.mmu.cgbMode {
+		vc.state.mmu.subscribeTo(bcpsAddr, vc.onBCPSWrite)
+		vc.state.mmu.subscribeTo(bcpdAddr, vc.onBCPDWrite)
+		vc.state.mmu.subscribeTo(ocpsAddr, vc.onOCPSWrite)
+		vc.state.mmu.subscribeTo(ocpdAddr, vc.onOCPDWrite)
+
+		vc.state.mmu.memory[bcpsAddr] = 0x40
+		vc.state.mmu.memory[ocpsAddr] = 0x40
+	}
+
 	return vc
 }
 
@@ -210,6 +259,11 @@ func (vc *videoController) tick() {
 
 				// TODO(velovix): Unlock things
 				vc.drawScanLine(uint8(currScanLine))
+
+				if vc.state.mmu.cgbMode {
+					// H-Blank DMA transfers happen at this time
+					vc.state.mmu.hblankHDMA()
+				}
 			}
 		} else {
 			if vc.frameTick == scanLineFullClocks*ScreenHeight {
@@ -228,14 +282,15 @@ func (vc *videoController) tick() {
 				}
 
 				vc.driver.Render(vc.currFrame)
+				vc.frameDone = true
 
 				vc.frameCnt++
 				if time.Since(vc.lastSecond) >= time.Second {
 					vc.fpsQueryCount++
 					vc.fpsTotal += vc.frameCnt
 
-					fmt.Println("Average FPS:", vc.fpsTotal/vc.fpsQueryCount)
-					fmt.Println("FPS:", vc.frameCnt)
+					fmt.Fprintln(vc.state.mmu.out, "Average FPS:", vc.fpsTotal/vc.fpsQueryCount)
+					fmt.Fprintln(vc.state.mmu.out, "FPS:", vc.frameCnt)
 
 					vc.frameCnt = 0
 					vc.lastSecond = time.Now()
@@ -253,27 +308,39 @@ func (vc *videoController) tick() {
 
 // drawScanLine draws a scan line at the given height position.
 func (vc *videoController) drawScanLine(line uint8) {
-	bgDotCodes := vc.makeBGScanLine(line)
+	cgbMode := vc.state.mmu.cgbMode
+
+	bgDotCodes, bgAttrs := vc.makeBGScanLine(line)
 
 	// Get window dot codes if the window is enabled and this scan line is
 	// within the window
-	var windowDotCodes *[ScreenWidth]uint8
+	var windowDotCodes, windowAttrs *[ScreenWidth]uint8
 	if vc.lcdc.windowOn && line >= vc.windowY {
-		windowDotCodes = vc.makeWindowScanLine(line)
+		windowDotCodes, windowAttrs = vc.makeWindowScanLine(line)
 	}
 
+	// On the DMG, the background and window can be turned off completely. On
+	// the CGB, they are always drawn and this LCDC bit instead controls
+	// whether or not they can be drawn over sprites.
+	bgOn := cgbMode || vc.lcdc.windowBGOn
+
 	for x := uint8(0); x < ScreenWidth; x++ {
 		var pixelColor color
 		pixelDrawn := false
 
+		// Find the dot data under any sprites at this position, which is the
+		// window if it's here or the background otherwise
+		bgDotCode := bgDotCodes[x]
+		bgAttr := bgAttrs[x]
+		if windowDotCodes != nil && vc.coordInWindow(x, line) {
+			bgDotCode = windowDotCodes[x]
+			bgAttr = windowAttrs[x]
+		}
+
 		if vc.lcdc.spritesOn && vc.spriteCount > 0 {
 			// Look for a sprite to draw at this position
 			for _, sprite := range vc.spritesAt(x) {
-				// If the sprite has priority 1 and the background dot data is
-				// other than zero, that part of the sprite will not be drawn
-				// and the background will be seen instead, assuming it is
-				// enabled.
-				if sprite.priority && bgDotCodes[x] != 0 {
+				if bgOn && vc.bgHasPriority(sprite, bgDotCode, bgAttr) {
 					continue
 				}
 
@@ -296,13 +363,15 @@ func (vc *videoController) drawScanLine(line uint8) {
 				}
 
 				spriteDotCode := vc.dotCodeInSprite(
-					sprite.spriteNumber, int(xOffset), int(yOffset))
+					sprite.spriteNumber, sprite.vramBank, int(xOffset), int(yOffset))
 
 				// The dot code zero in sprites represents transparency
 				if spriteDotCode != 0 {
 					// Use the selected sprite palette
 					pixelDrawn = true
-					if sprite.paletteNumber == 0 {
+					if cgbMode {
+						pixelColor = cgbColor(&vc.spritePaletteRAM, sprite.cgbPaletteNumber, spriteDotCode)
+					} else if sprite.paletteNumber == 0 {
 						pixelColor = vc.spritePalette0[spriteDotCode]
 					} else if sprite.paletteNumber == 1 {
 						pixelColor = vc.spritePalette1[spriteDotCode]
@@ -318,15 +387,13 @@ func (vc *videoController) drawScanLine(line uint8) {
 			}
 		}
 
-		// Draw the window if a sprite hasn't already been drawn
-		if !pixelDrawn && vc.lcdc.windowBGOn && vc.lcdc.windowOn && vc.coordInWindow(x, line) {
-			pixelColor = vc.bgPalette[windowDotCodes[x]]
-			pixelDrawn = true
-		}
-
-		// Draw the background if a sprite or window hasn't already been drawn
-		if !pixelDrawn && vc.lcdc.windowBGOn {
-			pixelColor = vc.bgPalette[bgDotCodes[x]]
+		// Draw the window or background if a sprite hasn't already been drawn
+		if !pixelDrawn && bgOn {
+			if cgbMode {
+				pixelColor = cgbColor(&vc.bgPaletteRAM, bgAttr&tileAttrPalette, bgDotCode)
+			} else {
+				pixelColor = vc.bgPalette[bgDotCode]
+			}
 			pixelDrawn = true
 		}
 
@@ -339,6 +406,31 @@ func (vc *videoController) drawScanLine(line uint8) {
 	}
 }
 
+// bgHasPriority returns true if the background or window pixel with the given
+// dot code and tile attributes should be drawn over the given sprite.
+func (vc *videoController) bgHasPriority(sprite oam, bgDotCode, bgAttr uint8) bool {
+	// Sprites are always drawn over background dot data zero
+	if bgDotCode == 0 {
+		return false
+	}
+
+	if vc.state.mmu.cgbMode {
+		// On the CGB, turning off this LCDC bit gives sprites priority over
+		// everything
+		if !vc.lcdc.windowBGOn {
+			return false
+		}
+		// Either the tile or the sprite can ask for the background to be on
+		// top
+		return bgAttr&tileAttrPriority == tileAttrPriority || sprite.priority
+	}
+
+	// If the sprite has priority 1 and the background dot data is other than
+	// zero, that part of the sprite will not be drawn and the background will
+	// be seen instead
+	return sprite.priority
+}
+
 // onStatWrite is called when the STAT register is written to.
 func (vc *videoController) onSTATWrite(addr uint16, val uint8) uint8 {
 	// TODO(velovix): Consider only reloading this register when this method is
@@ -356,10 +448,6 @@ func (vc *videoController) coordInWindow(x, y uint8) bool {
 	return x >= vc.windowX-7 && y >= vc.windowY
 }
 
-// spritesAtCache is a pre-allocated array of OAM data that is used by
-// spritesAt to avoid memory allocations at runtime.
-var spritesAtCache [maxSpritesPerScanLine]oam
-
 // spritesAt returns all sprites that are at the given X value, sorted by their
 // drawing priority.  Sprites are loaded on a per-scan-line basis, so there's
 // no need to check if sprites are at the current Y position.
@@ -373,20 +461,18 @@ func (vc *videoController) spritesAt(x uint8) []oam {
 		// point. Remember that a sprite's X and Y position is relative to the
 		// bottom right of the sprite.
 		if x < spriteX && int(x) >= int(spriteX)-spriteWidth {
-			spritesAtCache[spriteCount] = vc.spritesOnScanLine[i]
+			vc.spritesAtCache[spriteCount] = vc.spritesOnScanLine[i]
 			spriteCount++
 		}
 	}
 
-	return spritesAtCache[:spriteCount]
+	return vc.spritesAtCache[:spriteCount]
 }
 
-// bgScanLineCache is a pre-allocated array used by makeBGScanLine to reduce
-// memory allocations at runtime.
-var bgScanLineCache [ScreenWidth]uint8
-
-// makeBGScanLine returns a rendered scan line of the background layer.
-func (vc *videoController) makeBGScanLine(line uint8) *[ScreenWidth]uint8 {
+// makeBGScanLine returns a rendered scan line of the background layer, along
+// with the CGB tile attributes of each pixel. On the DMG, the tile attributes
+// are always zero.
+func (vc *videoController) makeBGScanLine(line uint8) (*[ScreenWidth]uint8, *[ScreenWidth]uint8) {
 	// Get the Y coordinate relative to the background and wrap it if necessary
 	bgY := int(line) + int(vc.scrollY)
 	if bgY < 0 {
@@ -408,46 +494,18 @@ func (vc *videoController) makeBGScanLine(line uint8) *[ScreenWidth]uint8 {
 		// Get the tile this point is inside of
 		tileOffset := (bgY/bgTileHeight)*bgWidthInTiles + (bgX / bgTileWidth)
 		tileAddr := vc.lcdc.bgTileMapAddr + uint16(tileOffset)
-		tile := vc.state.mmu.memory[tileAddr]
-
-		// Find the address of the tile data
-		var tileDataAddr uint16
-		switch vc.lcdc.windowBGTileDataTableAddr {
-		case tileDataTable0:
-			// Tile indexes at this data table are signed from -128 to 127
-			tileDataAddr = uint16(tileDataTable0 + int(int8(tile))*tileBytes)
-		case tileDataTable1:
-			tileDataAddr = tileDataTable1 + (uint16(tile) * tileBytes)
-		default:
-			panic(fmt.Sprintf("unknown tile data table %#x", tileDataAddr))
-		}
 
-		// Find the offset within the tile
-		inTileX := bgX % bgTileWidth
-		inTileY := bgY % bgTileHeight
-
-		// Read pixel data for this tile
-		lowerByte := vc.state.mmu.memory[tileDataAddr+uint16(inTileY*2)]
-		upperByte := vc.state.mmu.memory[tileDataAddr+uint16((inTileY*2)+1)]
-		for i := uint(inTileX); i < bgTileWidth && x < ScreenWidth; i++ {
-			lowerBit := (lowerByte << i) >> 7
-			upperBit := (upperByte << i) >> 7
-			bgScanLineCache[x] = (upperBit << 1) | lowerBit
-			x++
-		}
+		x = vc.drawTileRow(tileAddr, bgX%bgTileWidth, bgY%bgTileHeight, x,
+			&vc.bgScanLineCache, &vc.bgAttrScanLineCache)
 	}
 
-	return &bgScanLineCache
+	return &vc.bgScanLineCache, &vc.bgAttrScanLineCache
 }
 
-// windowScanLineCache is a pre-allocated array used by makeWindowScanLine to
-// reduce memory allocations at runtime.
-var windowScanLineCache [ScreenWidth]uint8
-
-// makeWindowScanLine returns a rendered scan line of the window layer. For
-// pixels where the window isn't present, the dot codes for those positions
-// will likely be garbage.
-func (vc *videoController) makeWindowScanLine(line uint8) *[ScreenWidth]uint8 {
+// makeWindowScanLine returns a rendered scan line of the window layer, along
+// with the CGB tile attributes of each pixel. For pixels where the window
+// isn't present, the dot codes for those positions will likely be garbage.
+func (vc *videoController) makeWindowScanLine(line uint8) (*[ScreenWidth]uint8, *[ScreenWidth]uint8) {
 	// Get the Y coordinate in window space
 	winY := int(line - vc.windowY)
 
@@ -458,41 +516,74 @@ func (vc *videoController) makeWindowScanLine(line uint8) *[ScreenWidth]uint8 {
 		// Get the current window tile this coordinate is in
 		tileOffset := (winY/windowTileHeight)*windowWidthInTiles + (winX / windowTileWidth)
 		tileAddr := vc.lcdc.windowTileMapAddr + uint16(tileOffset)
-		tile := vc.state.mmu.memory[tileAddr]
-
-		// Get the coordinates within this tile
-		inTileX := winX % windowTileWidth
-		inTileY := winY % windowTileHeight
-
-		// Find the address of the tile data
-		var tileDataAddr uint16
-		switch vc.lcdc.windowBGTileDataTableAddr {
-		case tileDataTable0:
-			// Tile indexes at this data table are signed from -128 to 127
-			tileDataAddr = uint16(tileDataTable0 + int(int8(tile))*tileBytes)
-		case tileDataTable1:
-			tileDataAddr = tileDataTable1 + (uint16(tile) * tileBytes)
-		default:
-			panic(fmt.Sprintf("unknown tile data table %#x", tileDataAddr))
-		}
 
-		// Read pixel data for this tile
-		lowerByte := vc.state.mmu.memory[tileDataAddr+uint16(inTileY*2)]
-		upperByte := vc.state.mmu.memory[tileDataAddr+uint16((inTileY*2)+1)]
-		for i := uint(inTileX); i < bgTileWidth && x < ScreenWidth; i++ {
-			lowerBit := (lowerByte << i) >> 7
-			upperBit := (upperByte << i) >> 7
-			windowScanLineCache[x] = (upperBit << 1) | lowerBit
-			x++
+		x = vc.drawTileRow(tileAddr, winX%windowTileWidth, winY%windowTileHeight, x,
+			&vc.windowScanLineCache, &vc.windowAttrScanLineCache)
+	}
+
+	return &vc.windowScanLineCache, &vc.windowAttrScanLineCache
+}
+
+// drawTileRow reads the tile in the tile map at the given address and writes
+// its dot codes and attributes to the given scan line, starting at the given X
+// position in the tile and the scan line. Returns the X position in the scan
+// line after the last pixel that was written.
+func (vc *videoController) drawTileRow(
+	tileAddr uint16,
+	inTileX, inTileY int,
+	x int,
+	dotCodes, attrs *[ScreenWidth]uint8) int {
+
+	tile := vc.state.mmu.videoRAM[0][tileAddr-videoRAMAddr]
+
+	// On the CGB, each tile in the tile map has attributes in VRAM bank 1
+	var attr uint8
+	if vc.state.mmu.cgbMode {
+		attr = vc.state.mmu.videoRAM[1][tileAddr-videoRAMAddr]
+	}
+
+	if attr&tileAttrYFlip == tileAttrYFlip {
+		inTileY = (bgTileHeight - 1) - inTileY
+	}
+
+	// Read pixel data for this tile
+	tileData := vc.state.mmu.videoRAM[(attr&tileAttrBank)>>3]
+	tileDataOffset := vc.tileDataAddr(tile) - videoRAMAddr
+	lowerByte := tileData[tileDataOffset+uint16(inTileY*2)]
+	upperByte := tileData[tileDataOffset+uint16((inTileY*2)+1)]
+	for i := uint(inTileX); i < bgTileWidth && x < ScreenWidth; i++ {
+		bit := i
+		if attr&tileAttrXFlip == tileAttrXFlip {
+			bit = (bgTileWidth - 1) - i
 		}
+		lowerBit := (lowerByte << bit) >> 7
+		upperBit := (upperByte << bit) >> 7
+		dotCodes[x] = (upperBit << 1) | lowerBit
+		attrs[x] = attr
+		x++
 	}
 
-	return &windowScanLineCache
+	return x
+}
+
+// tileDataAddr returns the address of the data for the given tile number in
+// the tile data table that the window and background are using.
+func (vc *videoController) tileDataAddr(tile uint8) uint16 {
+	switch vc.lcdc.windowBGTileDataTableAddr {
+	case tileDataTable0:
+		// Tile indexes at this data table are signed from -128 to 127
+		return uint16(tileDataTable0 + int(int8(tile))*tileBytes)
+	case tileDataTable1:
+		return tileDataTable1 + (uint16(tile) * tileBytes)
+	default:
+		panic(fmt.Sprintf("unknown tile data table %#x", vc.lcdc.windowBGTileDataTableAddr))
+	}
 }
 
 // dotCodeInSprite finds the dot code for a place in a sprite given the
-// sprite's ID and the coordinates within the sprite to look at.
-func (vc *videoController) dotCodeInSprite(spriteID uint8, inSpriteX, inSpriteY int) uint8 {
+// sprite's ID, the VRAM bank its data is in, and the coordinates within the
+// sprite to look at.
+func (vc *videoController) dotCodeInSprite(spriteID, vramBank uint8, inSpriteX, inSpriteY int) uint8 {
 	if vc.lcdc.spriteSize == spriteSize8x16 {
 		// The first bit of the sprite ID is ignored in this mode. This is
 		// because sprites in this mode take up twice the space, making only
@@ -502,9 +593,10 @@ func (vc *videoController) dotCodeInSprite(spriteID uint8, inSpriteX, inSpriteY
 
 	// Find the address of the tile data
 	spriteDataAddr := spriteDataTable + uint16(spriteID)*spriteBytes8x8
+	spriteData := vc.state.mmu.videoRAM[vramBank]
 
-	lower := vc.state.mmu.memory[spriteDataAddr+uint16(inSpriteY*2)]
-	upper := vc.state.mmu.memory[spriteDataAddr+uint16((inSpriteY*2)+1)]
+	lower := spriteData[spriteDataAddr-videoRAMAddr+uint16(inSpriteY*2)]
+	upper := spriteData[spriteDataAddr-videoRAMAddr+uint16((inSpriteY*2)+1)]
 
 	lower <<= uint(inSpriteX)
 	upper <<= uint(inSpriteX)
@@ -551,6 +643,23 @@ const (
 	bgHeight = 32 * bgTileHeight
 )
 
+// Bits in the CGB tile attributes that are stored in VRAM bank 1 for each tile
+// in a tile map.
+const (
+	_ uint8 = 0x00
+	// tileAttrPriority is set if the tile should be drawn over sprites.
+	tileAttrPriority = 0x80
+	// tileAttrYFlip is set if the tile should be flipped vertically.
+	tileAttrYFlip = 0x40
+	// tileAttrXFlip is set if the tile should be flipped horizontally.
+	tileAttrXFlip = 0x20
+	// tileAttrBank selects which VRAM bank the tile's data is in.
+	tileAttrBank = 0x08
+	// tileAttrPalette selects which of the 8 background palettes the tile
+	// uses.
+	tileAttrPalette = 0x07
+)
+
 type vcMode uint8
 
 const (
@@ -689,18 +798,21 @@ func (vc *videoController) onWindowPosYWrite(addr uint16, val uint8) uint8 {
 //     Bit 6: Y Flip. If 1, the sprite will be flipped vertically.
 //     Bit 5: X Flip. If 1, the sprite will be flipped horizontally.
 //     Bit 4: Palette number. If 1, the sprite will use object palette 1, if 0,
-//            the sprite will use object palette 0.
-//     Bits 3-0: Unused
+//            the sprite will use object palette 0. (DMG only)
+//     Bit 3: VRAM bank that the sprite data is in. (CGB only)
+//     Bits 2-0: Palette number from 0-7. (CGB only)
 type oam struct {
 	// The memory address where this OAM entry was retrieved from
-	address       uint16
-	yPos          uint8
-	xPos          uint8
-	spriteNumber  uint8
-	priority      bool
-	yFlip         bool
-	xFlip         bool
-	paletteNumber uint8
+	address          uint16
+	yPos             uint8
+	xPos             uint8
+	spriteNumber     uint8
+	priority         bool
+	yFlip            bool
+	xFlip            bool
+	paletteNumber    uint8
+	vramBank         uint8
+	cgbPaletteNumber uint8
 }
 
 // loadSpritesOnScanLine loads all OAM entries from memory that are visible on
@@ -750,6 +862,11 @@ func (vc *videoController) loadSpritesOnScanLine(scanLine uint8) {
 			newOAM.paletteNumber = 0
 		}
 
+		if vc.state.mmu.cgbMode {
+			newOAM.vramBank = (flags & 0x08) >> 3
+			newOAM.cgbPaletteNumber = flags & 0x07
+		}
+
 		vc.spritesOnScanLine[vc.spriteCount] = newOAM
 		vc.spriteCount++
 
@@ -760,6 +877,12 @@ func (vc *videoController) loadSpritesOnScanLine(scanLine uint8) {
 		}
 	}
 
+	if vc.state.mmu.cgbMode {
+		// On the CGB, sprites are drawn in order of their position in OAM
+		// memory, which is the order they were loaded in
+		return
+	}
+
 	// Sort sprites based on their drawing priority. Sprites with lower X
 	// positions are drawn on top of sprites with higher X positions. If two
 	// sprites are in the same X position, then the sprite with lower address
@@ -864,3 +987,132 @@ func (vc *videoController) loadSpritePalettes() {
 type color struct {
 	r, g, b, a uint8
 }
+
+// cgbColor returns the color for the given dot code in the given palette of
+// CGB palette RAM.
+func cgbColor(paletteRAM *[cgbPaletteRAMSize]uint8, palette, dotCode uint8) color {
+	offset := int(palette)*cgbPaletteBytes + int(dotCode)*2
+
+	// Colors are stored as little-endian 15-bit RGB values
+	rgb := combine16(paletteRAM[offset], paletteRAM[offset+1])
+
+	return color{
+		r: expand5BitColor(uint8(rgb & 0x1F)),
+		g: expand5BitColor(uint8((rgb >> 5) & 0x1F)),
+		b: expand5BitColor(uint8((rgb >> 10) & 0x1F)),
+		a: 255,
+	}
+}
+
+// expand5BitColor converts a 5-bit color channel value to an 8-bit one.
+func expand5BitColor(val uint8) uint8 {
+	return (val << 3) | (val >> 2)
+}
+
+// onBCPSWrite is called when the Background Palette Index register is written
+// to. It selects the byte in background palette RAM that BCPD accesses.
+func (vc *videoController) onBCPSWrite(addr uint16, val uint8) uint8 {
+	vc.bgPaletteIndex = val & 0x3F
+	vc.bgPaletteAutoIncrement = val&0x80 == 0x80
+
+	// Bit 6 is unused and always 1
+	return val | 0x40
+}
+
+// onBCPDWrite is called when the Background Palette Data register is written
+// to. It writes to the selected byte in background palette RAM.
+func (vc *videoController) onBCPDWrite(addr uint16, val uint8) uint8 {
+	vc.bgPaletteRAM[vc.bgPaletteIndex] = val
+
+	if vc.bgPaletteAutoIncrement {
+		vc.bgPaletteIndex = (vc.bgPaletteIndex + 1) & 0x3F
+		vc.state.mmu.memory[bcpsAddr] = 0xC0 | vc.bgPaletteIndex
+	}
+
+	return val
+}
+
+// onOCPSWrite is called when the Sprite Palette Index register is written to.
+// It selects the byte in sprite palette RAM that OCPD accesses.
+func (vc *videoController) onOCPSWrite(addr uint16, val uint8) uint8 {
+	vc.spritePaletteIndex = val & 0x3F
+	vc.spritePaletteAutoIncrement = val&0x80 == 0x80
+
+	// Bit 6 is unused and always 1
+	return val | 0x40
+}
+
+// onOCPDWrite is called when the Sprite Palette Data register is written to.
+// It writes to the selected byte in sprite palette RAM.
+func (vc *videoController) onOCPDWrite(addr uint16, val uint8) uint8 {
+	vc.spritePaletteRAM[vc.spritePaletteIndex] = val
+
+	if vc.spritePaletteAutoIncrement {
+		vc.spritePaletteIndex = (vc.spritePaletteIndex + 1) & 0x3F
+		vc.state.mmu.memory[ocpsAddr] = 0xC0 | vc.spritePaletteIndex
+	}
+
+	return val
+}
+
+// saveState writes the state of the video controller, including the
+// in-progress frame, to a save state.
+func (vc *videoController) saveState(sw *stateWriter) {
+	sw.write(vc.lcdOn)
+	sw.writeInt(vc.frameTick)
+	sw.writeInt(vc.drawnScanLines)
+	sw.write(vc.scrollX)
+	sw.write(vc.scrollY)
+	sw.write(vc.windowX)
+	sw.write(vc.windowY)
+	sw.write(vc.ly)
+	sw.write(vc.lyc)
+
+	sw.writeColors(vc.bgPalette)
+	sw.writeColors(vc.spritePalette0)
+	sw.writeColors(vc.spritePalette1)
+
+	sw.write(vc.bgPaletteRAM[:])
+	sw.write(vc.bgPaletteIndex)
+	sw.write(vc.bgPaletteAutoIncrement)
+	sw.write(vc.spritePaletteRAM[:])
+	sw.write(vc.spritePaletteIndex)
+	sw.write(vc.spritePaletteAutoIncrement)
+
+	sw.writeBytes(vc.currFrame)
+}
+
+// loadState reads the state of the video controller from a save state. The
+// MMU must be loaded first.
+func (vc *videoController) loadState(sr *stateReader) {
+	vc.lcdOn = sr.readBool()
+	vc.frameTick = sr.readInt()
+	vc.drawnScanLines = sr.readInt()
+	sr.read(&vc.scrollX)
+	sr.read(&vc.scrollY)
+	vc.windowX = sr.readUint8()
+	vc.windowY = sr.readUint8()
+	vc.ly = sr.readUint8()
+	vc.lyc = sr.readUint8()
+
+	sr.readColors(&vc.bgPalette)
+	sr.readColors(&vc.spritePalette0)
+	sr.readColors(&vc.spritePalette1)
+
+	sr.read(vc.bgPaletteRAM[:])
+	vc.bgPaletteIndex = sr.readUint8() & 0x3F
+	vc.bgPaletteAutoIncrement = sr.readBool()
+	sr.read(vc.spritePaletteRAM[:])
+	vc.spritePaletteIndex = sr.readUint8() & 0x3F
+	vc.spritePaletteAutoIncrement = sr.readBool()
+
+	sr.readBytesInto(vc.currFrame)
+
+	if vc.frameTick < 0 || vc.frameTick >= fullFrameClocks {
+		sr.fail("invalid video controller frame tick %v", vc.frameTick)
+	}
+
+	// These values are derived from memory, so they don't need to be stored
+	vc.decodeLCDC(vc.state.mmu.memory[lcdcAddr])
+	vc.loadSpritesOnScanLine(uint8(vc.frameTick / scanLineFullClocks))
+}
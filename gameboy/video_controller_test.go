@@ -0,0 +1,62 @@
+package gameboy
+
+import (
+	"testing"
+)
+
+func TestCGBPaletteRAM(t *testing.T) {
+	tests := []struct {
+		name      string
+		indexAddr uint16
+		dataAddr  uint16
+		ram       func(vc *videoController) *[cgbPaletteRAMSize]uint8
+	}{
+		{"background", bcpsAddr, bcpdAddr, func(vc *videoController) *[cgbPaletteRAMSize]uint8 {
+			return &vc.bgPaletteRAM
+		}},
+		{"sprite", ocpsAddr, ocpdAddr, func(vc *videoController) *[cgbPaletteRAMSize]uint8 {
+			return &vc.spritePaletteRAM
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := newCGBTestDevice(t)
+			ram := test.ram(device.videoController)
+
+			// Auto-increment wraps around from the last byte to the first
+			device.WriteMemory(test.indexAddr, 0x80|0x3E)
+			if index := device.ReadMemory(test.indexAddr); index != 0xFE {
+				t.Fatalf("expected the index to read back as 0xfe, got %#x", index)
+			}
+			for _, val := range []uint8{0x11, 0x22, 0x33} {
+				device.WriteMemory(test.dataAddr, val)
+			}
+			if ram[0x3E] != 0x11 || ram[0x3F] != 0x22 || ram[0x00] != 0x33 {
+				t.Fatalf("expected 0x11, 0x22 and 0x33 to be written, got %#x, %#x and %#x",
+					ram[0x3E], ram[0x3F], ram[0x00])
+			}
+			if index := device.ReadMemory(test.indexAddr); index != 0xC1 {
+				t.Fatalf("expected the index to be incremented to 0xc1, got %#x", index)
+			}
+
+			// Without auto-increment, the same byte is written every time
+			next := ram[0x09]
+			device.WriteMemory(test.indexAddr, 0x08)
+			device.WriteMemory(test.dataAddr, 0x44)
+			device.WriteMemory(test.dataAddr, 0x55)
+			if ram[0x08] != 0x55 || ram[0x09] != next {
+				t.Fatalf("expected only 0x08 to be written, got %#x and %#x", ram[0x08], ram[0x09])
+			}
+			if index := device.ReadMemory(test.indexAddr); index != 0x48 {
+				t.Fatalf("expected the index to stay at 0x48, got %#x", index)
+			}
+
+			// Reads come from the selected byte
+			device.WriteMemory(test.indexAddr, 0x3F)
+			if val := device.ReadMemory(test.dataAddr); val != 0x22 {
+				t.Fatalf("expected to read 0x22 from 0x3f, got %#x", val)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package patch
+
+import (
+	"bytes"
+
+	"golang.org/x/xerrors"
+)
+
+var bpsMagic = []uint8("BPS1")
+
+// Actions that make up a BPS patch. Each one writes some data to the patched
+// ROM, picking up where the last one left off.
+const (
+	// bpsSourceRead copies data from the same position in the original ROM.
+	bpsSourceRead = iota
+	// bpsTargetRead copies data from the patch itself.
+	bpsTargetRead
+	// bpsSourceCopy copies data from anywhere in the original ROM.
+	bpsSourceCopy
+	// bpsTargetCopy copies data from earlier in the patched ROM.
+	bpsTargetCopy
+)
+
+// ApplyBPS applies a BPS patch to the ROM. A BPS patch is a list of actions
+// that build the patched ROM from pieces of the original ROM and data in the
+// patch, followed by checksums of the original ROM, the patched ROM and the
+// patch itself.
+func ApplyBPS(rom, patch []uint8) ([]uint8, error) {
+	if !bytes.HasPrefix(patch, bpsMagic) {
+		return nil, ErrUnknownFormat
+	}
+
+	checksums, err := readChecksumFooter(patch)
+	if err != nil {
+		return nil, xerrors.Errorf("reading BPS checksums: %w", err)
+	}
+
+	r := &patchReader{data: patch[:len(patch)-checksumFooterSize], pos: len(bpsMagic)}
+
+	sourceSize, err := r.readNumber()
+	if err != nil {
+		return nil, xerrors.Errorf("reading BPS source size: %w", err)
+	}
+	targetSize, err := r.readNumber()
+	if err != nil {
+		return nil, xerrors.Errorf("reading BPS target size: %w", err)
+	}
+	metadataSize, err := r.readNumber()
+	if err != nil {
+		return nil, xerrors.Errorf("reading BPS metadata size: %w", err)
+	}
+	// The metadata is usually XML describing the patch, which we don't need
+	if _, err := r.readBytes(metadataSize); err != nil {
+		return nil, xerrors.Errorf("reading BPS metadata: %w", err)
+	}
+
+	if len(rom) != sourceSize {
+		return nil, xerrors.Errorf("ROM is %v bytes, but the patch is for a "+
+			"%v byte ROM: %w", len(rom), sourceSize, ErrChecksumMismatch)
+	}
+	if err := verifyChecksum("ROM", rom, checksums.source); err != nil {
+		return nil, err
+	}
+	if targetSize > maxOutputSize {
+		return nil, xerrors.Errorf("patched ROM size %v is too large: %w",
+			targetSize, ErrMalformedPatch)
+	}
+
+	output := make([]uint8, targetSize)
+	outputOffset := 0
+	sourceRelativeOffset := 0
+	targetRelativeOffset := 0
+
+	for r.remaining() > 0 {
+		data, err := r.readNumber()
+		if err != nil {
+			return nil, xerrors.Errorf("reading BPS action: %w", err)
+		}
+		action := data & 0x3
+		length := (data >> 2) + 1
+
+		if outputOffset+length > len(output) {
+			return nil, xerrors.Errorf("action goes past the end of the "+
+				"patched ROM: %w", ErrMalformedPatch)
+		}
+
+		switch action {
+		case bpsSourceRead:
+			if outputOffset+length > len(rom) {
+				return nil, xerrors.Errorf("source read goes past the end of "+
+					"the ROM: %w", ErrMalformedPatch)
+			}
+			copy(output[outputOffset:], rom[outputOffset:outputOffset+length])
+		case bpsTargetRead:
+			patchData, err := r.readBytes(length)
+			if err != nil {
+				return nil, xerrors.Errorf("reading BPS target read: %w", err)
+			}
+			copy(output[outputOffset:], patchData)
+		case bpsSourceCopy, bpsTargetCopy:
+			offset, err := r.readSignedNumber()
+			if err != nil {
+				return nil, xerrors.Errorf("reading BPS copy offset: %w", err)
+			}
+
+			if action == bpsSourceCopy {
+				sourceRelativeOffset += offset
+				if sourceRelativeOffset < 0 || sourceRelativeOffset+length > len(rom) {
+					return nil, xerrors.Errorf("source copy goes outside of "+
+						"the ROM: %w", ErrMalformedPatch)
+				}
+				copy(output[outputOffset:], rom[sourceRelativeOffset:sourceRelativeOffset+length])
+				sourceRelativeOffset += length
+			} else {
+				targetRelativeOffset += offset
+				if targetRelativeOffset < 0 || targetRelativeOffset >= outputOffset {
+					return nil, xerrors.Errorf("target copy reads data that "+
+						"hasn't been written yet: %w", ErrMalformedPatch)
+				}
+				// The copied data may overlap with the data being written,
+				// which repeats it, so this has to go one byte at a time
+				for i := 0; i < length; i++ {
+					output[outputOffset+i] = output[targetRelativeOffset]
+					targetRelativeOffset++
+				}
+			}
+		}
+
+		outputOffset += length
+	}
+
+	if err := verifyChecksum("patched ROM", output, checksums.target); err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// readSignedNumber reads a variable-length number whose lowest bit is the
+// sign, as used by BPS copy actions.
+func (r *patchReader) readSignedNumber() (int, error) {
+	val, err := r.readNumber()
+	if err != nil {
+		return 0, err
+	}
+
+	if val&0x1 != 0 {
+		return -(val >> 1), nil
+	}
+	return val >> 1, nil
+}
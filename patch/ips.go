@@ -0,0 +1,84 @@
+package patch
+
+import (
+	"bytes"
+
+	"golang.org/x/xerrors"
+)
+
+var ipsMagic = []uint8("PATCH")
+
+// ipsEOF marks the end of an IPS patch's records. It's read where the offset
+// of the next record would be.
+const ipsEOF = 0x454F46
+
+// ApplyIPS applies an IPS patch to the ROM. An IPS patch is a list of records
+// that each overwrite part of the ROM, optionally followed by a size to
+// truncate the ROM to. IPS patches have no checksums, so there's no way to
+// tell if the patch was made for this ROM.
+func ApplyIPS(rom, patch []uint8) ([]uint8, error) {
+	if !bytes.HasPrefix(patch, ipsMagic) {
+		return nil, ErrUnknownFormat
+	}
+
+	r := &patchReader{data: patch, pos: len(ipsMagic)}
+
+	output := make([]uint8, len(rom))
+	copy(output, rom)
+
+	for {
+		offset, err := r.readBigEndian(3)
+		if err != nil {
+			return nil, xerrors.Errorf("reading IPS record: %w", err)
+		}
+		if offset == ipsEOF {
+			break
+		}
+
+		size, err := r.readBigEndian(2)
+		if err != nil {
+			return nil, xerrors.Errorf("reading IPS record: %w", err)
+		}
+
+		var data []uint8
+		if size == 0 {
+			// This is an RLE record, which repeats a single byte
+			size, err = r.readBigEndian(2)
+			if err != nil {
+				return nil, xerrors.Errorf("reading IPS RLE record: %w", err)
+			}
+			val, err := r.readByte()
+			if err != nil {
+				return nil, xerrors.Errorf("reading IPS RLE record: %w", err)
+			}
+			data = make([]uint8, size)
+			for i := range data {
+				data[i] = val
+			}
+		} else {
+			data, err = r.readBytes(size)
+			if err != nil {
+				return nil, xerrors.Errorf("reading IPS record: %w", err)
+			}
+		}
+
+		// Records may extend the ROM
+		if offset+len(data) > len(output) {
+			output = append(output, make([]uint8, offset+len(data)-len(output))...)
+		}
+		copy(output[offset:], data)
+	}
+
+	// Some patches end with the size that the ROM should be truncated to
+	if r.remaining() >= 3 {
+		size, err := r.readBigEndian(3)
+		if err != nil {
+			return nil, xerrors.Errorf("reading IPS truncation size: %w", err)
+		}
+		if size < len(output) {
+			output = output[:size]
+		}
+	}
+
+	return output, nil
+}
@@ -0,0 +1,163 @@
+// Package patch applies IPS, UPS and BPS patches to ROMs. Patches are
+// applied in memory, so the original ROM is never modified.
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrUnknownFormat is returned when the patch isn't in a supported format.
+	ErrUnknownFormat = xerrors.New("unknown patch format")
+	// ErrMalformedPatch is returned when the patch is truncated or refers to
+	// data that doesn't exist.
+	ErrMalformedPatch = xerrors.New("malformed patch")
+	// ErrChecksumMismatch is returned when one of the CRC32 checksums in a UPS
+	// or BPS patch doesn't match. This usually means the patch was made for a
+	// different version of the ROM.
+	ErrChecksumMismatch = xerrors.New("checksum mismatch")
+)
+
+// maxOutputSize is the largest patched ROM that a UPS or BPS patch is allowed
+// to create. This is much larger than any Game Boy ROM, and stops a malformed
+// patch from allocating huge amounts of memory.
+const maxOutputSize = 64 * 1024 * 1024
+
+// Apply applies the given patch to the ROM and returns the patched ROM. The
+// patch format is detected from the patch's header. The given ROM is not
+// modified.
+func Apply(rom, patch []uint8) ([]uint8, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return ApplyIPS(rom, patch)
+	case bytes.HasPrefix(patch, upsMagic):
+		return ApplyUPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return ApplyBPS(rom, patch)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// patchReader reads values from a patch, keeping track of the current
+// position.
+type patchReader struct {
+	data []uint8
+	pos  int
+}
+
+// remaining returns the number of bytes left to read.
+func (r *patchReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+// readByte reads a single byte.
+func (r *patchReader) readByte() (uint8, error) {
+	if r.remaining() < 1 {
+		return 0, xerrors.Errorf("unexpected end of patch: %w", ErrMalformedPatch)
+	}
+	val := r.data[r.pos]
+	r.pos++
+	return val, nil
+}
+
+// readBytes reads the given number of bytes.
+func (r *patchReader) readBytes(count int) ([]uint8, error) {
+	if count < 0 || r.remaining() < count {
+		return nil, xerrors.Errorf("unexpected end of patch: %w", ErrMalformedPatch)
+	}
+	val := r.data[r.pos : r.pos+count]
+	r.pos += count
+	return val, nil
+}
+
+// readBigEndian reads an unsigned big-endian value of the given size in bytes.
+func (r *patchReader) readBigEndian(size int) (int, error) {
+	data, err := r.readBytes(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var val int
+	for _, b := range data {
+		val = val<<8 | int(b)
+	}
+	return val, nil
+}
+
+// readNumber reads a variable-length number, as used by UPS and BPS patches.
+// Each byte provides 7 bits of the number, least significant first, and the
+// top bit is set on the last byte. Every byte but the first also adds one to
+// the encoded bits, so that each number has only one encoding.
+func (r *patchReader) readNumber() (int, error) {
+	var val uint64
+	var shift uint64 = 1
+
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+
+		val += uint64(b&0x7F) * shift
+		if b&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		val += shift
+
+		if shift > 1<<49 {
+			return 0, xerrors.Errorf("number is too large: %w", ErrMalformedPatch)
+		}
+	}
+
+	return int(val), nil
+}
+
+// checksumFooter contains the checksums at the end of UPS and BPS patches.
+type checksumFooter struct {
+	source uint32
+	target uint32
+	patch  uint32
+}
+
+// checksumFooterSize is the size of the checksums at the end of UPS and BPS
+// patches.
+const checksumFooterSize = 12
+
+// readChecksumFooter reads the checksums at the end of a UPS or BPS patch and
+// verifies the patch's own checksum.
+func readChecksumFooter(patch []uint8) (checksumFooter, error) {
+	if len(patch) < checksumFooterSize {
+		return checksumFooter{}, xerrors.Errorf("missing checksums: %w", ErrMalformedPatch)
+	}
+	footer := patch[len(patch)-checksumFooterSize:]
+
+	checksums := checksumFooter{
+		source: binary.LittleEndian.Uint32(footer[0:4]),
+		target: binary.LittleEndian.Uint32(footer[4:8]),
+		patch:  binary.LittleEndian.Uint32(footer[8:12]),
+	}
+
+	if actual := crc32.ChecksumIEEE(patch[:len(patch)-4]); actual != checksums.patch {
+		return checksumFooter{}, xerrors.Errorf(
+			"patch CRC32 is %08X, expected %08X: %w",
+			actual, checksums.patch, ErrChecksumMismatch)
+	}
+
+	return checksums, nil
+}
+
+// verifyChecksum returns an error if the given data doesn't have the expected
+// CRC32 checksum. The name describes the data in the error.
+func verifyChecksum(name string, data []uint8, expected uint32) error {
+	if actual := crc32.ChecksumIEEE(data); actual != expected {
+		return xerrors.Errorf("%v CRC32 is %08X, expected %08X: %w",
+			name, actual, expected, ErrChecksumMismatch)
+	}
+	return nil
+}
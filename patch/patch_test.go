@@ -0,0 +1,425 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// encodeNumber encodes a variable-length number the way UPS and BPS patches
+// do. See patchReader.readNumber.
+func encodeNumber(val int) []uint8 {
+	var data []uint8
+	for {
+		b := uint8(val & 0x7F)
+		val >>= 7
+		if val == 0 {
+			return append(data, b|0x80)
+		}
+		data = append(data, b)
+		val--
+	}
+}
+
+// withFooter appends the checksums of the given original and patched ROMs to
+// a UPS or BPS patch, followed by the checksum of the patch itself.
+func withFooter(patch, source, target []uint8) []uint8 {
+	patch = append([]uint8{}, patch...)
+	patch = appendUint32(patch, crc32.ChecksumIEEE(source))
+	patch = appendUint32(patch, crc32.ChecksumIEEE(target))
+	return appendUint32(patch, crc32.ChecksumIEEE(patch))
+}
+
+func appendUint32(data []uint8, val uint32) []uint8 {
+	var buf [4]uint8
+	binary.LittleEndian.PutUint32(buf[:], val)
+	return append(data, buf[:]...)
+}
+
+func TestApplyIPS(t *testing.T) {
+	rom := []uint8("0123456789")
+
+	tests := []struct {
+		name     string
+		patch    string
+		expected string
+		err      error
+	}{
+		{
+			name:     "record",
+			patch:    "PATCH\x00\x00\x02\x00\x03abcEOF",
+			expected: "01abc56789",
+		},
+		{
+			name:     "RLE record",
+			patch:    "PATCH\x00\x00\x01\x00\x00\x00\x04zEOF",
+			expected: "0zzzz56789",
+		},
+		{
+			name:     "extends the ROM",
+			patch:    "PATCH\x00\x00\x0C\x00\x02abEOF",
+			expected: "0123456789\x00\x00ab",
+		},
+		{
+			name:     "truncate",
+			patch:    "PATCH\x00\x00\x00\x00\x01aEOF\x00\x00\x04",
+			expected: "a123",
+		},
+		{
+			name:     "truncate larger than the ROM",
+			patch:    "PATCH\x00\x00\x00\x00\x01aEOF\x00\x00\x20",
+			expected: "a123456789",
+		},
+		{
+			name:     "EOF marker ends the records",
+			patch:    "PATCHEOF\x00\x00",
+			expected: "0123456789",
+		},
+		{
+			name:  "missing EOF marker",
+			patch: "PATCH\x00\x00\x02\x00\x03abc",
+			err:   ErrMalformedPatch,
+		},
+		{
+			name:  "truncated record",
+			patch: "PATCH\x00\x00\x02\x00\x03ab",
+			err:   ErrMalformedPatch,
+		},
+		{
+			name:  "truncated RLE record",
+			patch: "PATCH\x00\x00\x01\x00\x00\x00\x04",
+			err:   ErrMalformedPatch,
+		},
+		{
+			name:  "not IPS",
+			patch: "PATCX\x00\x00\x02\x00\x03abcEOF",
+			err:   ErrUnknownFormat,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := ApplyIPS(rom, []uint8(test.patch))
+			if test.err != nil {
+				if !xerrors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applying the patch: %v", err)
+			}
+			if string(output) != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, output)
+			}
+		})
+	}
+
+	if string(rom) != "0123456789" {
+		t.Fatalf("the ROM was modified: %q", rom)
+	}
+}
+
+// makeUPS creates a UPS patch that turns the source into the target.
+func makeUPS(source, target []uint8) []uint8 {
+	patch := append([]uint8{}, upsMagic...)
+	patch = append(patch, encodeNumber(len(source))...)
+	patch = append(patch, encodeNumber(len(target))...)
+
+	size := len(source)
+	if len(target) > size {
+		size = len(target)
+	}
+	at := func(data []uint8, i int) uint8 {
+		if i < len(data) {
+			return data[i]
+		}
+		return 0
+	}
+
+	offset := 0
+	for i := 0; i < size; i++ {
+		if at(source, i) == at(target, i) {
+			continue
+		}
+		patch = append(patch, encodeNumber(i-offset)...)
+		for ; i < size && at(source, i) != at(target, i); i++ {
+			patch = append(patch, at(source, i)^at(target, i))
+		}
+		patch = append(patch, 0)
+		offset = i + 1
+	}
+
+	return withFooter(patch, source, target)
+}
+
+func TestApplyUPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		target string
+	}{
+		{"same size", "0123456789", "0ab34567z9"},
+		{"larger", "0123", "0x23456"},
+		{"smaller", "0123456", "01y3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := makeUPS([]uint8(test.source), []uint8(test.target))
+
+			output, err := ApplyUPS([]uint8(test.source), patch)
+			if err != nil {
+				t.Fatalf("applying the patch: %v", err)
+			}
+			if string(output) != test.target {
+				t.Fatalf("expected %q, got %q", test.target, output)
+			}
+
+			// Applying the patch to the patched ROM undoes it
+			output, err = ApplyUPS([]uint8(test.target), patch)
+			if err != nil {
+				t.Fatalf("reversing the patch: %v", err)
+			}
+			if string(output) != test.source {
+				t.Fatalf("expected %q after reversing, got %q", test.source, output)
+			}
+		})
+	}
+}
+
+func TestApplyUPSErrors(t *testing.T) {
+	source := []uint8("0123456789")
+	target := []uint8("0ab3456789")
+	patch := makeUPS(source, target)
+
+	if _, err := ApplyUPS([]uint8("0123456780"), patch); !xerrors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch for the wrong ROM, got %v", err)
+	}
+
+	corrupted := append([]uint8{}, patch...)
+	corrupted[len(upsMagic)+2] ^= 0x01
+	if _, err := ApplyUPS(source, corrupted); !xerrors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch for a corrupted patch, got %v", err)
+	}
+
+	// A hunk without its terminating zero
+	body := patch[:len(patch)-checksumFooterSize-1]
+	if _, err := ApplyUPS(source, withFooter(body, source, target)); !xerrors.Is(err, ErrMalformedPatch) {
+		t.Errorf("expected ErrMalformedPatch for a truncated hunk, got %v", err)
+	}
+
+	if _, err := ApplyUPS(source, []uint8("UPS1")); !xerrors.Is(err, ErrMalformedPatch) {
+		t.Errorf("expected ErrMalformedPatch without checksums, got %v", err)
+	}
+}
+
+// bpsAction encodes a BPS action. Copy actions are followed by their offset.
+func bpsAction(action, length int) []uint8 {
+	return encodeNumber((length-1)<<2 | action)
+}
+
+// bpsOffset encodes the signed offset of a BPS copy action.
+func bpsOffset(offset int) []uint8 {
+	if offset < 0 {
+		return encodeNumber(-offset<<1 | 1)
+	}
+	return encodeNumber(offset << 1)
+}
+
+// makeBPS creates a BPS patch with the given actions.
+func makeBPS(source []uint8, targetSize int, actions ...[]uint8) []uint8 {
+	patch := append([]uint8{}, bpsMagic...)
+	patch = append(patch, encodeNumber(len(source))...)
+	patch = append(patch, encodeNumber(targetSize)...)
+	// Metadata
+	patch = append(patch, encodeNumber(3)...)
+	patch = append(patch, "<x>"...)
+	for _, action := range actions {
+		patch = append(patch, action...)
+	}
+	return patch
+}
+
+func concat(parts ...[]uint8) []uint8 {
+	var data []uint8
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func TestApplyBPS(t *testing.T) {
+	source := []uint8("ABCDEFGH")
+	target := []uint8("ABxyzGHABBBBBEF")
+
+	body := makeBPS(source, len(target),
+		// "AB" from the same place in the ROM
+		bpsAction(bpsSourceRead, 2),
+		// "xyz" from the patch
+		concat(bpsAction(bpsTargetRead, 3), []uint8("xyz")),
+		// "GH" from the end of the ROM
+		concat(bpsAction(bpsSourceCopy, 2), bpsOffset(6)),
+		// "AB" from the start of the patched ROM
+		concat(bpsAction(bpsTargetCopy, 2), bpsOffset(0)),
+		// Overlapping copies repeat the data, so this is "BBBB"
+		concat(bpsAction(bpsTargetCopy, 4), bpsOffset(6)),
+		// "EF", going back from the last source copy
+		concat(bpsAction(bpsSourceCopy, 2), bpsOffset(-4)),
+	)
+
+	output, err := ApplyBPS(source, withFooter(body, source, target))
+	if err != nil {
+		t.Fatalf("applying the patch: %v", err)
+	}
+	if !bytes.Equal(output, target) {
+		t.Fatalf("expected %q, got %q", target, output)
+	}
+	if string(source) != "ABCDEFGH" {
+		t.Fatalf("the ROM was modified: %q", source)
+	}
+
+	// Every part of the patch is needed, so it's malformed when any of it is
+	// missing. The checksums are fixed up so that parsing is what fails.
+	for size := len(bpsMagic); size < len(body); size++ {
+		_, err := ApplyBPS(source, withFooter(body[:size], source, target))
+		if err == nil {
+			t.Fatalf("applying a patch truncated to %v bytes succeeded", size)
+		}
+	}
+}
+
+func TestApplyBPSErrors(t *testing.T) {
+	source := []uint8("ABCDEFGH")
+
+	tests := []struct {
+		name       string
+		targetSize int
+		actions    [][]uint8
+		err        error
+	}{
+		{
+			name:       "source read past the ROM",
+			targetSize: 10,
+			actions:    [][]uint8{bpsAction(bpsSourceRead, 10)},
+			err:        ErrMalformedPatch,
+		},
+		{
+			name:       "action past the patched ROM",
+			targetSize: 2,
+			actions:    [][]uint8{bpsAction(bpsSourceRead, 3)},
+			err:        ErrMalformedPatch,
+		},
+		{
+			name:       "target read past the patch",
+			targetSize: 4,
+			actions:    [][]uint8{concat(bpsAction(bpsTargetRead, 4), []uint8("ab"))},
+			err:        ErrMalformedPatch,
+		},
+		{
+			name:       "source copy before the ROM",
+			targetSize: 2,
+			actions:    [][]uint8{concat(bpsAction(bpsSourceCopy, 2), bpsOffset(-1))},
+			err:        ErrMalformedPatch,
+		},
+		{
+			name:       "source copy past the ROM",
+			targetSize: 2,
+			actions:    [][]uint8{concat(bpsAction(bpsSourceCopy, 2), bpsOffset(7))},
+			err:        ErrMalformedPatch,
+		},
+		{
+			name:       "target copy of unwritten data",
+			targetSize: 4,
+			actions: [][]uint8{
+				bpsAction(bpsSourceRead, 2),
+				concat(bpsAction(bpsTargetCopy, 2), bpsOffset(2)),
+			},
+			err: ErrMalformedPatch,
+		},
+		{
+			name:       "number too large",
+			targetSize: 2,
+			actions:    [][]uint8{bytes.Repeat([]uint8{0x7F}, 10)},
+			err:        ErrMalformedPatch,
+		},
+		{
+			name:       "patched ROM too large",
+			targetSize: maxOutputSize + 1,
+			err:        ErrMalformedPatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := makeBPS(source, test.targetSize, test.actions...)
+			// The patched ROM's checksum isn't checked if the patch fails
+			patch := withFooter(body, source, nil)
+			if _, err := ApplyBPS(source, patch); !xerrors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestApplyBPSChecksums(t *testing.T) {
+	source := []uint8("ABCDEFGH")
+	body := makeBPS(source, 2, bpsAction(bpsSourceRead, 2))
+
+	tests := []struct {
+		name  string
+		rom   []uint8
+		patch []uint8
+	}{
+		{"wrong ROM", []uint8("ABCDEFGX"), withFooter(body, source, []uint8("AB"))},
+		{"wrong ROM size", []uint8("ABCDEFG"), withFooter(body, source, []uint8("AB"))},
+		{"wrong patched ROM", source, withFooter(body, source, []uint8("AX"))},
+		{"corrupted patch", source, withFooter(body, source, []uint8("AB"))},
+	}
+	// Corrupt the patch after its checksum was calculated
+	corrupted := tests[len(tests)-1].patch
+	corrupted[len(corrupted)-checksumFooterSize-1] ^= 0x01
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ApplyBPS(test.rom, test.patch); !xerrors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	rom := []uint8("0123")
+
+	tests := []struct {
+		name  string
+		patch []uint8
+	}{
+		{"IPS", []uint8("PATCH\x00\x00\x00\x00\x01xEOF")},
+		{"UPS", makeUPS(rom, []uint8("x123"))},
+		{"BPS", withFooter(makeBPS(rom, 4,
+			concat(bpsAction(bpsTargetRead, 1), []uint8("x")),
+			concat(bpsAction(bpsSourceCopy, 3), bpsOffset(1)),
+		), rom, []uint8("x123"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := Apply(rom, test.patch)
+			if err != nil {
+				t.Fatalf("applying the patch: %v", err)
+			}
+			if string(output) != "x123" {
+				t.Fatalf("expected \"x123\", got %q", output)
+			}
+		})
+	}
+
+	if _, err := Apply(rom, []uint8("NOPE")); !xerrors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package patch
+
+import (
+	"bytes"
+	"hash/crc32"
+
+	"golang.org/x/xerrors"
+)
+
+var upsMagic = []uint8("UPS1")
+
+// ApplyUPS applies a UPS patch to the ROM. A UPS patch is a list of hunks
+// that are XORed with the ROM, followed by checksums of the original ROM, the
+// patched ROM and the patch itself. Since XOR is reversible, a UPS patch can
+// also be applied to a patched ROM to get the original back.
+func ApplyUPS(rom, patch []uint8) ([]uint8, error) {
+	if !bytes.HasPrefix(patch, upsMagic) {
+		return nil, ErrUnknownFormat
+	}
+
+	checksums, err := readChecksumFooter(patch)
+	if err != nil {
+		return nil, xerrors.Errorf("reading UPS checksums: %w", err)
+	}
+
+	r := &patchReader{data: patch[:len(patch)-checksumFooterSize], pos: len(upsMagic)}
+
+	sourceSize, err := r.readNumber()
+	if err != nil {
+		return nil, xerrors.Errorf("reading UPS source size: %w", err)
+	}
+	targetSize, err := r.readNumber()
+	if err != nil {
+		return nil, xerrors.Errorf("reading UPS target size: %w", err)
+	}
+
+	romChecksum := crc32.ChecksumIEEE(rom)
+	if romChecksum != checksums.source && romChecksum == checksums.target {
+		// The ROM has already been patched, so the patch will undo itself
+		sourceSize, targetSize = targetSize, sourceSize
+		checksums.source, checksums.target = checksums.target, checksums.source
+	}
+
+	if len(rom) != sourceSize {
+		return nil, xerrors.Errorf("ROM is %v bytes, but the patch is for a "+
+			"%v byte ROM: %w", len(rom), sourceSize, ErrChecksumMismatch)
+	}
+	if err := verifyChecksum("ROM", rom, checksums.source); err != nil {
+		return nil, err
+	}
+	if targetSize > maxOutputSize {
+		return nil, xerrors.Errorf("patched ROM size %v is too large: %w",
+			targetSize, ErrMalformedPatch)
+	}
+
+	output := make([]uint8, targetSize)
+	copy(output, rom)
+
+	offset := 0
+	for r.remaining() > 0 {
+		skip, err := r.readNumber()
+		if err != nil {
+			return nil, xerrors.Errorf("reading UPS hunk: %w", err)
+		}
+		offset += skip
+
+		// XOR the ROM with the hunk until a zero byte is found
+		for {
+			val, err := r.readByte()
+			if err != nil {
+				return nil, xerrors.Errorf("reading UPS hunk: %w", err)
+			}
+			// Hunks can go past the end of the ROM when it's being shrunk,
+			// which happens when undoing a patch that made it larger
+			if offset < len(output) {
+				output[offset] ^= val
+			}
+			offset++
+			if val == 0 {
+				break
+			}
+		}
+	}
+
+	if err := verifyChecksum("patched ROM", output, checksums.target); err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}